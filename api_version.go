@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API versions. A version's behavior is frozen once released; response
+// shape changes go into a new version and handlers branch on apiVersion.
+const (
+	apiV1 = 1
+	apiV2 = 2
+
+	latestAPIVersion = apiV2
+)
+
+// apiVersionPolicy describes the lifecycle of an API version. A zero
+// DeprecatedAt means the version is current.
+type apiVersionPolicy struct {
+	DeprecatedAt time.Time
+	SunsetAt     time.Time
+}
+
+// apiVersions lists every version served under /api/v{N}/.
+var apiVersions = map[int]apiVersionPolicy{
+	apiV1: {},
+	apiV2: {},
+}
+
+// The unversioned /api/ routes predate versioning. They behave like v1
+// (or the version named in an API-Version header) and are going away.
+var legacyAPIPolicy = apiVersionPolicy{
+	DeprecatedAt: time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC),
+	SunsetAt:     time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC),
+}
+
+type apiVersionKey struct{}
+
+// apiVersion is the API version the request is being served as.
+func apiVersion(r *http.Request) int {
+	if v, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+		return v
+	}
+	return apiV1
+}
+
+// apiPath builds a path to another endpoint in the same API version as r,
+// e.g. apiPath(r, "/jobs/123").
+func apiPath(r *http.Request, path string) string {
+	return fmt.Sprintf("/api/v%d%s", apiVersion(r), path)
+}
+
+// versionedMux serves every "/api/..." pattern registered on it under each
+// version prefix as well as at its legacy unversioned path. Other patterns
+// are registered as-is.
+type versionedMux struct {
+	*http.ServeMux
+}
+
+func newVersionedMux() versionedMux {
+	return versionedMux{http.NewServeMux()}
+}
+
+func (m versionedMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	method, path, hasMethod := strings.Cut(pattern, " ")
+	if !hasMethod {
+		method, path = "", pattern
+	}
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		m.ServeMux.HandleFunc(pattern, handler)
+		return
+	}
+
+	join := func(p string) string {
+		if method == "" {
+			return p
+		}
+		return method + " " + p
+	}
+	for version, policy := range apiVersions {
+		m.ServeMux.HandleFunc(join(fmt.Sprintf("/api/v%d/%s", version, rest)), withAPIVersion(version, policy, handler))
+	}
+	m.ServeMux.HandleFunc(join(path), legacyAPI(rest, handler))
+}
+
+func withAPIVersion(version int, policy apiVersionPolicy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		setDeprecationHeaders(w, policy)
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+}
+
+// legacyAPI serves an unversioned route. Clients can pick a version with
+// an API-Version header; without one they get v1. Either way they're told
+// the path is deprecated and where it moved.
+func legacyAPI(rest string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := apiV1
+		if requested := r.Header.Get("API-Version"); requested != "" {
+			v, err := strconv.Atoi(requested)
+			if _, known := apiVersions[v]; err != nil || !known {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported API-Version, the latest is %d", latestAPIVersion), err)
+				return
+			}
+			version = v
+		}
+
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		setDeprecationHeaders(w, legacyAPIPolicy)
+		w.Header().Add("Link", fmt.Sprintf("</api/v%d/%s>; rel=\"successor-version\"", version, rest))
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+}
+
+// setDeprecationHeaders advertises a deprecated version with the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers.
+func setDeprecationHeaders(w http.ResponseWriter, policy apiVersionPolicy) {
+	if policy.DeprecatedAt.IsZero() {
+		return
+	}
+	w.Header().Set("Deprecation", fmt.Sprintf("@%d", policy.DeprecatedAt.Unix()))
+	if !policy.SunsetAt.IsZero() {
+		w.Header().Set("Sunset", policy.SunsetAt.Format(http.TimeFormat))
+	}
+}
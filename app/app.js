@@ -1,4 +1,5 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  readOAuthResult();
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -8,9 +9,49 @@ document.addEventListener('DOMContentLoaded', async () => {
   } else {
     document.getElementById('auth-section').style.display = 'block';
     document.getElementById('video-section').style.display = 'none';
+    await showOAuthProviders();
   }
 });
 
+// OAuth logins come back to the app with the tokens, or an error, in the
+// URL fragment
+function readOAuthResult() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  if (!params.has('token') && !params.has('oauth_error')) {
+    return;
+  }
+  history.replaceState(null, '', window.location.pathname + window.location.search);
+  if (params.has('token')) {
+    localStorage.setItem('token', params.get('token'));
+  } else {
+    alert(`Error: ${params.get('oauth_error')}`);
+  }
+}
+
+async function showOAuthProviders() {
+  const container = document.getElementById('oauth-providers');
+  container.innerHTML = '';
+  try {
+    const res = await fetch('/api/v1/oauth/providers');
+    if (!res.ok) {
+      return;
+    }
+    const providers = await res.json();
+    const names = { google: 'Google', github: 'GitHub' };
+    for (const provider of providers) {
+      const button = document.createElement('button');
+      button.type = 'button';
+      button.textContent = `Login with ${names[provider.name] || provider.name}`;
+      button.onclick = () => {
+        window.location.href = provider.login_url;
+      };
+      container.appendChild(button);
+    }
+  } catch (error) {
+    console.error('Error loading login providers:', error);
+  }
+}
+
 document.getElementById('video-draft-form').addEventListener('submit', async (event) => {
   event.preventDefault();
   await createVideoDraft();
@@ -26,7 +67,7 @@ async function createVideoDraft() {
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await fetch('/api/v1/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -54,7 +95,7 @@ async function login() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/login', {
+    const res = await fetch('/api/v1/login', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -84,7 +125,7 @@ async function signup() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/users', {
+    const res = await fetch('/api/v1/users', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -106,6 +147,7 @@ function logout() {
   localStorage.removeItem('token');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
+  showOAuthProviders();
 }
 
 function setUploadButtonState(uploading, selector) {
@@ -130,7 +172,7 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await fetch(`/api/v1/thumbnail_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -163,7 +205,7 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await fetch(`/api/v1/video_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -172,7 +214,7 @@ async function uploadVideoFile(videoID) {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to upload video file. Error: ${data.error}`);
+      throw new Error(`Failed to upload video file. Error: ${data.error} (request ID: ${data.request_id})`);
     }
 
     console.log('Video uploaded!');
@@ -188,18 +230,25 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
-    if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
-    }
+    const videos = [];
+    let cursor = null;
+    do {
+      const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+      const res = await fetch(`/api/v1/videos${query}`, {
+        method: 'GET',
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+
+      videos.push(...(await res.json()));
+      cursor = res.headers.get('X-Next-Cursor');
+    } while (cursor);
 
-    const videos = await res.json();
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -231,7 +280,7 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await fetch(`/api/v1/videos/${videoID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -270,9 +319,53 @@ function viewVideo(video) {
       videoPlayer.style.display = 'none';
     } else {
       videoPlayer.style.display = 'block';
-      videoPlayer.src = video.video_url;
-      videoPlayer.load();
+      playbackURL(video).then((url) => {
+        if (currentVideo === video) {
+          videoPlayer.src = url;
+          videoPlayer.load();
+          // plays streamed through the API are counted there
+          if (!url.includes('/api/')) {
+            videoPlayer.addEventListener('play', () => sendViewBeacon(video), { once: true });
+          }
+        }
+      });
+    }
+  }
+}
+
+function sendViewBeacon(video) {
+  if (currentVideo !== video) {
+    return;
+  }
+  fetch(`/api/v1/videos/${video.id}/views`, {
+    method: 'POST',
+    headers: {
+      Authorization: `Bearer ${localStorage.getItem('token')}`,
+    },
+  }).catch((error) => console.error('Error recording view:', error));
+}
+
+// private videos are streamed through the playback proxy, so the player
+// never sees the S3 URL
+async function playbackURL(video) {
+  if (video.visibility !== 'private') {
+    return video.video_url;
+  }
+  try {
+    const res = await fetch(`/api/v1/videos/${video.id}/playback-token`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to get playback token: ${data.error}`);
     }
+    return data.url;
+  } catch (error) {
+    console.error(error);
+    return video.video_url;
   }
 }
 
@@ -283,7 +376,7 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await fetch(`/api/v1/videos/${currentVideo.id}`, {
       method: 'DELETE',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
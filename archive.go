@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	archiveSweepInterval = time.Hour
+	restorePollInterval  = 10 * time.Minute
+	archiveBatchSize     = 100
+	// restoredCopyDays is how long S3 keeps the readable copy a restore
+	// makes. It's only needed until the file is copied back to regular
+	// storage.
+	restoredCopyDays = 3
+	// errorCodeVideoArchived marks responses refused because the video's
+	// file is archived, so clients can offer to restore it
+	errorCodeVideoArchived = "video_archived"
+)
+
+// errVideoArchived is behind the ingestError for a file that would
+// replace an archived one.
+var errVideoArchived = errors.New("video is archived")
+
+// archiveSettings control the archival of videos no one watches.
+type archiveSettings struct {
+	// After is how long a video goes unviewed before its file is
+	// archived, zero turns archival off
+	After time.Duration
+	// StorageClass is where archived files go, GLACIER or DEEP_ARCHIVE
+	StorageClass types.StorageClass
+	// RestoreTier is how fast, and how expensively, archived files are
+	// restored
+	RestoreTier types.Tier
+}
+
+// parseArchiveSettings parses ARCHIVE_AFTER_DAYS, a whole number of days,
+// ARCHIVE_STORAGE_CLASS, GLACIER (the default) or DEEP_ARCHIVE, and
+// ARCHIVE_RESTORE_TIER, Standard (the default), Bulk or Expedited.
+func parseArchiveSettings(afterDays, storageClass, restoreTier string) (archiveSettings, error) {
+	settings := archiveSettings{StorageClass: types.StorageClassGlacier, RestoreTier: types.TierStandard}
+	if afterDays != "" {
+		days, err := strconv.Atoi(afterDays)
+		if err != nil || days < 1 {
+			return archiveSettings{}, fmt.Errorf("ARCHIVE_AFTER_DAYS must be a whole number of days of at least 1, got %q", afterDays)
+		}
+		settings.After = time.Duration(days) * 24 * time.Hour
+	}
+	switch class := types.StorageClass(storageClass); class {
+	case "":
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+		settings.StorageClass = class
+	default:
+		return archiveSettings{}, fmt.Errorf("ARCHIVE_STORAGE_CLASS must be %s or %s, got %q", types.StorageClassGlacier, types.StorageClassDeepArchive, storageClass)
+	}
+	switch tier := types.Tier(restoreTier); tier {
+	case "":
+	case types.TierStandard, types.TierBulk, types.TierExpedited:
+		settings.RestoreTier = tier
+	default:
+		return archiveSettings{}, fmt.Errorf("ARCHIVE_RESTORE_TIER must be %s, %s or %s, got %q", types.TierStandard, types.TierBulk, types.TierExpedited, restoreTier)
+	}
+	if settings.StorageClass == types.StorageClassDeepArchive && settings.RestoreTier == types.TierExpedited {
+		return archiveSettings{}, fmt.Errorf("ARCHIVE_RESTORE_TIER %s isn't available for %s", types.TierExpedited, types.StorageClassDeepArchive)
+	}
+	return settings, nil
+}
+
+// respondIfArchived answers 409 for a video whose file is archived, and
+// so can't be read until it's restored, reporting whether it did.
+func respondIfArchived(w http.ResponseWriter, video database.Video) bool {
+	if video.ArchiveStatus == "" {
+		return false
+	}
+	respondWithErrorCode(w, http.StatusConflict, errorCodeVideoArchived, "Video is archived, restore it first", nil)
+	return true
+}
+
+// runArchiver archives the files of videos no one viewed for the
+// configured time, checking every archiveSweepInterval until ctx is done.
+func (cfg *apiConfig) runArchiver(ctx context.Context) {
+	ticker := time.NewTicker(archiveSweepInterval)
+	defer ticker.Stop()
+	for {
+		cfg.archiveIdleVideos(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) archiveIdleVideos(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	idleSince := time.Now().Add(-cfg.archive.After)
+	after := uuid.Nil
+	for ctx.Err() == nil {
+		videos, err := cfg.db.GetVideosToArchive(idleSince, after, archiveBatchSize)
+		if err != nil {
+			logger.Error("couldn't look up videos to archive", "error", err)
+			return
+		}
+		if len(videos) == 0 {
+			return
+		}
+		for _, video := range videos {
+			after = video.ID
+			archived, err := cfg.archiveVideo(ctx, video)
+			if err != nil {
+				logger.Error("couldn't archive video", "video_id", video.ID, "error", err)
+				continue
+			}
+			if archived {
+				logger.Info("archived video", "video_id", video.ID, "storage_class", cfg.archive.StorageClass)
+			}
+		}
+	}
+}
+
+// archiveVideo moves the video's file, with its renditions, to archival
+// storage. A video sharing an object with another is left alone, since
+// archiving it would take the other's file away too. The video is marked
+// archived first, so it's never marked readable while part of its file
+// isn't; if moving an object fails the video stays archived, and restoring
+// it brings back whatever was moved.
+func (cfg *apiConfig) archiveVideo(ctx context.Context, video database.Video) (bool, error) {
+	bucket := cfg.videoBucket(video)
+	keys, err := cfg.archiveObjectKeys(video)
+	if err != nil || len(keys) == 0 {
+		return false, err
+	}
+	for _, key := range keys {
+		shared, err := cfg.db.ObjectURLSharedWith(cfg.getObjectURL(bucket, key), video.ID)
+		if err != nil || shared {
+			return false, err
+		}
+	}
+
+	err = cfg.db.ArchiveVideo(video.ID, *video.VideoURL)
+	if errors.Is(err, database.ErrVideoFileChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if err := cfg.transitionObject(ctx, bucket, key, cfg.archive.StorageClass); err != nil {
+			return false, fmt.Errorf("%s: %w", key, err)
+		}
+		// an upload of the same content mustn't reuse an archived object
+		if err := cfg.db.DeleteContentObjectsByKey(key); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// archiveObjectKeys are the objects in the video's bucket archiving it
+// moves: its file and its renditions. Captions and kept versions stay.
+func (cfg *apiConfig) archiveObjectKeys(video database.Video) ([]string, error) {
+	if video.VideoURL == nil {
+		return nil, nil
+	}
+	bucket := cfg.videoBucket(video)
+	keys := []string{}
+	if key, ok := cfg.keyInBucket(bucket, *video.VideoURL); ok {
+		keys = append(keys, key)
+	}
+	renditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rendition := range renditions {
+		if key, ok := cfg.keyInBucket(bucket, rendition.VideoURL); ok && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// isArchivalClass reports whether objects of storage class have to be
+// restored before they can be read.
+func isArchivalClass(class types.StorageClass) bool {
+	return class == types.StorageClassGlacier || class == types.StorageClassDeepArchive
+}
+
+// transitionObject moves key in bucket to storage class by copying it onto
+// itself, keeping its content type and tags. An archived object has to be
+// restored before it can be moved back.
+func (cfg *apiConfig) transitionObject(ctx context.Context, bucket, key string, class types.StorageClass) error {
+	head, err := cfg.headObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	current := head.StorageClass
+	if current == "" {
+		current = types.StorageClassStandard
+	}
+	if current == class {
+		return nil
+	}
+
+	copySource := bucket + "/" + url.PathEscape(key)
+	size := aws.ToInt64(head.ContentLength)
+	if size <= maxSingleCopySize {
+		return withS3Retry(ctx, s3UploadRetry, "CopyObject "+key, func(ctx context.Context) error {
+			_, err := cfg.buckets.client(bucket).CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:            aws.String(bucket),
+				Key:               aws.String(key),
+				CopySource:        aws.String(copySource),
+				StorageClass:      class,
+				MetadataDirective: types.MetadataDirectiveCopy,
+				TaggingDirective:  types.TaggingDirectiveCopy,
+				// the copy is a new object, keep its whole-object checksum
+				ChecksumAlgorithm:   types.ChecksumAlgorithmSha256,
+				ACL:                 cfg.s3ObjectSettings.ACL,
+				ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			})
+			return err
+		})
+	}
+
+	// part copies don't carry the tags over, so they're set on the upload
+	var tagging *s3.GetObjectTaggingOutput
+	err = withS3Retry(ctx, s3DefaultRetry, "GetObjectTagging "+key, func(ctx context.Context) error {
+		var err error
+		tagging, err = cfg.buckets.client(bucket).GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tags := url.Values{}
+	for _, tag := range tagging.TagSet {
+		tags.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+	return cfg.copyObjectParts(ctx, copySource, size, &s3.CreateMultipartUploadInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(key),
+		ContentType:         head.ContentType,
+		StorageClass:        class,
+		Tagging:             aws.String(tags.Encode()),
+		ACL:                 cfg.s3ObjectSettings.ACL,
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	})
+}
+
+// requestObjectRestore asks S3 for a readable copy of an archived object.
+// An object that's already being restored, or isn't archived, needs
+// nothing.
+func (cfg *apiConfig) requestObjectRestore(ctx context.Context, bucket, key string) error {
+	err := withS3Retry(ctx, s3DefaultRetry, "RestoreObject "+key, func(ctx context.Context) error {
+		_, err := cfg.buckets.client(bucket).RestoreObject(ctx, &s3.RestoreObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			RestoreRequest: &types.RestoreRequest{
+				Days:                 aws.Int32(restoredCopyDays),
+				GlacierJobParameters: &types.GlacierJobParameters{Tier: cfg.archive.RestoreTier},
+			},
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "RestoreAlreadyInProgress" || apiErr.ErrorCode() == "InvalidObjectState") {
+		return nil
+	}
+	return err
+}
+
+// objectRestored reports whether key in bucket can be read: it isn't
+// archived, or its restore has finished.
+func (cfg *apiConfig) objectRestored(ctx context.Context, bucket, key string) (bool, error) {
+	head, err := cfg.headObject(ctx, bucket, key)
+	if err != nil {
+		return false, err
+	}
+	if !isArchivalClass(head.StorageClass) {
+		return true, nil
+	}
+	return strings.Contains(aws.ToString(head.Restore), `ongoing-request="false"`), nil
+}
+
+// restoreProgress counts the video's archived objects, and how many of
+// them can be read again.
+func (cfg *apiConfig) restoreProgress(ctx context.Context, video database.Video) (restored, total int, err error) {
+	keys, err := cfg.archiveObjectKeys(video)
+	if err != nil {
+		return 0, 0, err
+	}
+	bucket := cfg.videoBucket(video)
+	for _, key := range keys {
+		ok, err := cfg.objectRestored(ctx, bucket, key)
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			restored++
+		}
+	}
+	return restored, len(keys), nil
+}
+
+// finishRestore moves a restoring video's file back to regular storage
+// once every object of it can be read, and marks the video readable. It
+// reports whether it did.
+func (cfg *apiConfig) finishRestore(ctx context.Context, video database.Video) (bool, error) {
+	restored, total, err := cfg.restoreProgress(ctx, video)
+	if err != nil || restored < total {
+		return false, err
+	}
+	keys, err := cfg.archiveObjectKeys(video)
+	if err != nil {
+		return false, err
+	}
+	bucket := cfg.videoBucket(video)
+	for _, key := range keys {
+		if err := cfg.transitionObject(ctx, bucket, key, types.StorageClassStandard); err != nil {
+			return false, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if err := cfg.db.UnarchiveVideo(video.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// wakeRestoreWorker has the worker check on restores now rather than at
+// its next poll.
+func (cfg *apiConfig) wakeRestoreWorker() {
+	select {
+	case cfg.restoreWake <- struct{}{}:
+	default:
+	}
+}
+
+// runRestoreWorker finishes the restores of archived videos whose files
+// can be read again, checking every restorePollInterval until ctx is done.
+func (cfg *apiConfig) runRestoreWorker(ctx context.Context) {
+	ticker := time.NewTicker(restorePollInterval)
+	defer ticker.Stop()
+	for {
+		cfg.finishRestores(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.restoreWake:
+		}
+	}
+}
+
+func (cfg *apiConfig) finishRestores(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	videos, err := cfg.db.GetRestoringVideos(archiveBatchSize)
+	if err != nil {
+		logger.Error("couldn't look up videos being restored", "error", err)
+		return
+	}
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			return
+		}
+		done, err := cfg.finishRestore(ctx, video)
+		if err != nil {
+			logger.Error("couldn't finish restoring video", "video_id", video.ID, "error", err)
+			continue
+		}
+		if done {
+			logger.Info("restored archived video", "video_id", video.ID)
+		}
+	}
+}
+
+// archiveStatusResponse is where a video's file is in archival.
+type archiveStatusResponse struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Status is "", archived or restoring
+	Status     string     `json:"archive_status"`
+	ArchivedAt *time.Time `json:"archived_at"`
+	// Objects and ObjectsRestored count the file's objects, and those that
+	// can be read again, while it's restoring
+	Objects         int `json:"objects,omitempty"`
+	ObjectsRestored int `json:"objects_restored,omitempty"`
+	// RestoreTier is how fast the restore was asked to be
+	RestoreTier types.Tier `json:"restore_tier,omitempty"`
+}
+
+// archiveStatus reports where video is in archival. A restore that's
+// ready to finish wakes the worker, so it isn't left to its next poll.
+func (cfg *apiConfig) archiveStatus(ctx context.Context, video database.Video) (archiveStatusResponse, error) {
+	resp := archiveStatusResponse{VideoID: video.ID, Status: video.ArchiveStatus, ArchivedAt: video.ArchivedAt}
+	if video.ArchiveStatus != database.ArchiveRestoring {
+		return resp, nil
+	}
+	restored, total, err := cfg.restoreProgress(ctx, video)
+	if err != nil {
+		return archiveStatusResponse{}, err
+	}
+	resp.Objects, resp.ObjectsRestored, resp.RestoreTier = total, restored, cfg.archive.RestoreTier
+	if restored == total {
+		cfg.wakeRestoreWorker()
+	}
+	return resp, nil
+}
+
+// handlerVideoArchiveGet reports whether the video's file is archived, and
+// how far along its restore is.
+func (cfg *apiConfig) handlerVideoArchiveGet(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	resp, err := cfg.archiveStatus(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check restore progress", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerVideoArchiveRestore starts restoring an archived video's file.
+// Restores take minutes to hours depending on ARCHIVE_RESTORE_TIER; the
+// video can be played again once its archive_status is "".
+func (cfg *apiConfig) handlerVideoArchiveRestore(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ArchiveStatus == "" {
+		respondWithError(w, http.StatusConflict, "Video isn't archived", nil)
+		return
+	}
+
+	if video.ArchiveStatus == database.ArchiveArchived {
+		keys, err := cfg.archiveObjectKeys(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+			return
+		}
+		bucket := cfg.videoBucket(video)
+		for _, key := range keys {
+			if err := cfg.requestObjectRestore(r.Context(), bucket, key); err != nil {
+				respondWithError(w, http.StatusBadGateway, "Couldn't start restore", err)
+				return
+			}
+		}
+		if _, err := cfg.db.SetArchiveRestoring(video.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		video.ArchiveStatus = database.ArchiveRestoring
+		loggerFrom(r.Context()).Info("restore of archived video requested", "video_id", video.ID, "tier", cfg.archive.RestoreTier)
+	}
+
+	resp, err := cfg.archiveStatus(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check restore progress", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, resp)
+}
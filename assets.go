@@ -27,6 +27,63 @@ func (cfg apiConfig) getAssetURL(assetPath string) string {
 	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
 }
 
+// assetPathFromURL is the inverse of getAssetURL.
+func (cfg apiConfig) assetPathFromURL(assetURL string) (string, bool) {
+	prefix := cfg.getAssetURL("")
+	if !strings.HasPrefix(assetURL, prefix) {
+		return "", false
+	}
+	assetPath := strings.TrimPrefix(assetURL, prefix)
+	if assetPath == "" || strings.Contains(assetPath, "/") {
+		return "", false
+	}
+	return assetPath, true
+}
+
+// getObjectURL is the URL stored for key in bucket.
+func (cfg apiConfig) getObjectURL(bucket, key string) string {
+	if cfg.sandbox {
+		return fmt.Sprintf("%s/%s/%s", sandboxS3Endpoint(cfg.port), bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, cfg.buckets.region(bucket), key)
+}
+
+// clientObjectURL is the URL clients are given for key in bucket. It's
+// getObjectURL, the URL stored for the object, unless Transfer
+// Acceleration is on, which serves it from the edge location nearest the
+// client.
+func (cfg apiConfig) clientObjectURL(bucket, key string) string {
+	if !cfg.s3Accelerate {
+		return cfg.getObjectURL(bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3-accelerate.amazonaws.com/%s", bucket, key)
+}
+
+// objectFromURL returns the bucket and key of an object URL previously
+// produced by getObjectURL, or false if the URL doesn't point at one of
+// the buckets videos are stored in.
+func (cfg apiConfig) objectFromURL(objectURL string) (bucket, key string, ok bool) {
+	for _, b := range cfg.buckets.Buckets {
+		prefix := cfg.getObjectURL(b.Name, "")
+		if !strings.HasPrefix(objectURL, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(objectURL, prefix)
+		if key == "" {
+			return "", "", false
+		}
+		return b.Name, key, true
+	}
+	return "", "", false
+}
+
+// keyInBucket returns the key of objectURL if it points into bucket, as
+// the URLs of a video's objects do into the video's bucket.
+func (cfg apiConfig) keyInBucket(bucket, objectURL string) (string, bool) {
+	b, key, ok := cfg.objectFromURL(objectURL)
+	return key, ok && b == bucket
+}
+
 func mediaTypeToExt(mediaType string) string {
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter is a token bucket per key, such as a client's IP: each
+// key may make burst attempts at once, then one more every interval.
+type attemptLimiter struct {
+	burst    float64
+	interval time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*attemptBucket
+	// pruned is when buckets that had filled up again were last dropped
+	pruned time.Time
+}
+
+type attemptBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newAttemptLimiter(burst int, interval time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		burst:    float64(burst),
+		interval: interval,
+		buckets:  map[string]*attemptBucket{},
+		pruned:   time.Now(),
+	}
+}
+
+// allow takes an attempt for key. If it has none left it reports false,
+// with how long until it has one again.
+func (l *attemptLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &attemptBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+float64(now.Sub(bucket.updated))/float64(l.interval))
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(l.interval))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have filled up again, which are the same
+// as no bucket, once per time it takes one to fill.
+func (l *attemptLimiter) prune(now time.Time) {
+	refill := time.Duration(l.burst * float64(l.interval))
+	if now.Sub(l.pruned) < refill {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	l := newAttemptLimiter(3, time.Hour)
+	for i := range 3 {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("attempt %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok {
+		t.Fatal("attempt past the burst allowed")
+	}
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("wait = %v, want up to an hour", wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Error("another key was refused")
+	}
+
+	// a bucket refills at one attempt per interval
+	l.buckets["a"].updated = l.buckets["a"].updated.Add(-time.Hour)
+	if ok, _ := l.allow("a"); !ok {
+		t.Error("attempt refused after an interval")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Error("second attempt allowed after a single interval")
+	}
+}
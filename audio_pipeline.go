@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// audioObjectKey is where version n of an audio upload is stored, next to
+// the videos/ tree rather than in it.
+func audioObjectKey(videoID uuid.UUID, version int, ext string) string {
+	return fmt.Sprintf("audio/%s/v%d%s", videoID, version, ext)
+}
+
+// processAudio stores an audio upload, such as a podcast episode, as it
+// is unless profile asks for its loudness to be normalized. There's no
+// picture to size or frame rate to handle, so it's only probed for its
+// format, duration and bit rate.
+func (cfg *apiConfig) processAudio(ctx context.Context, video database.Video, src ingestSource, profile processingProfile) (database.Video, error) {
+	logger := loggerFrom(ctx)
+	previous := video
+	video.OriginalFilename = src.Filename
+	video.MediaKind = database.MediaKindAudio
+	video.LoudnessTarget = nil
+
+	_, probeSpan := tracer.Start(ctx, "ffprobe")
+	probe, err := cfg.media.probeAudio(ctx, src.Path)
+	endSpan(probeSpan, err)
+	if err != nil {
+		return database.Video{}, mediaIngestError("Failed to probe audio", fmt.Errorf("%w: %w", errProbeFailed, err))
+	}
+	format, err := probe.validateAudio()
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusBadRequest, "Invalid audio: " + err.Error(), fmt.Errorf("%w: %w", errUnsupportedMedia, err)}
+	}
+	logger.Debug("probed audio", "format", format.ContentType, "duration_seconds", probe.duration(), "bitrate", probe.bitrate())
+
+	uploadPath, uploadSize, uploadSHA256 := src.Path, src.Size, src.SHA256
+	if profile.NormalizeLoudness {
+		normalizeStarted := time.Now()
+		audio, _ := probe.audioStream()
+		_, normalizeSpan := tracer.Start(ctx, "normalize loudness")
+		normalizedPath, err := cfg.media.normalizeAudioLoudness(ctx, src.Path, format, audio, probe.bitrate())
+		endSpan(normalizeSpan, err)
+		if errors.Is(err, errUnsupportedAudioCodec) {
+			return database.Video{}, &ingestError{http.StatusBadRequest, "Can't normalize the loudness of this audio: " + err.Error(), err}
+		}
+		if err != nil {
+			return database.Video{}, mediaIngestError("Failed to normalize loudness", err)
+		}
+		defer os.Remove(normalizedPath)
+		uploadPath = normalizedPath
+		target := loudnessTarget
+		video.LoudnessTarget = &target
+		logger.Info("normalized loudness", "lufs", target, "duration", time.Since(normalizeStarted))
+	}
+
+	version, err := cfg.nextVideoVersion(previous)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't reserve a version", err}
+	}
+
+	// an untouched upload this user already stored can reuse the object
+	key := audioObjectKey(video.ID, version, format.Ext)
+	bucket := cfg.videoBucket(video)
+	newKeys := []string{}
+	var existing *database.ContentObject
+	if uploadPath == src.Path && src.SHA256 != "" {
+		existing, err = cfg.reusableContent(video, src.SHA256, src.Size)
+		if err != nil {
+			logger.Warn("couldn't look up content hash", "sha256", src.SHA256, "error", err)
+			existing = nil
+		}
+	}
+	if existing != nil {
+		key = existing.ObjectKey
+		logger.Info("reusing identical stored content", "key", key, "bytes", existing.Size)
+	} else {
+		file, err := os.Open(uploadPath)
+		if err != nil {
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to open uploaded file", err}
+		}
+		defer file.Close()
+		// the upload's size and hash were taken as it streamed in, a normalized file needs its own
+		if uploadPath != src.Path {
+			info, err := file.Stat()
+			if err != nil {
+				return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to read normalized audio", err}
+			}
+			uploadSize = info.Size()
+			uploadSHA256, err = hashFile(file)
+			if err != nil {
+				return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to hash normalized audio", err}
+			}
+		}
+
+		uploadStarted := time.Now()
+		err = cfg.putPendingObject(ctx, video, "", key, file, format.ContentType, uploadSHA256)
+		if err != nil {
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to upload file to S3", err}
+		}
+		newKeys = append(newKeys, key)
+		logger.Info("uploaded audio to S3",
+			"bucket", bucket,
+			"key", key,
+			"bytes", uploadSize,
+			"duration", time.Since(uploadStarted),
+		)
+	}
+
+	video, err = cfg.commitVideoObjects(ctx, video, version, probe, []database.CreateRenditionParams{{
+		VideoID:  video.ID,
+		Kind:     "primary",
+		VideoURL: cfg.getObjectURL(bucket, key),
+		Size:     uploadSize,
+		SHA256:   uploadSHA256,
+	}}, newKeys)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	if uploadPath == src.Path && existing == nil && src.SHA256 != "" {
+		err = cfg.db.SaveContentObject(database.CreateContentObjectParams{
+			UserID:      video.UserID,
+			SHA256:      src.SHA256,
+			Size:        src.Size,
+			Bucket:      bucket,
+			ObjectKey:   key,
+			ContentType: format.ContentType,
+		})
+		if err != nil {
+			logger.Warn("couldn't record content hash", "key", key, "error", err)
+		}
+	}
+	return video, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// clientIP is the address a request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// audit records an action taken by a request on the audit log, filling in
+// the request's details. Unless entry names them, the actor is the admin
+// behind an impersonation token, or else the token's own user, and the
+// user acted on is the token's user. The action
+// has already happened by the time it is audited, so failures are logged
+// rather than failing the request.
+func (cfg *apiConfig) audit(r *http.Request, entry database.CreateAuditEntryParams) {
+	if entry.ActorID == uuid.Nil || entry.UserID == uuid.Nil {
+		if token, err := auth.GetBearerToken(r.Header); err == nil {
+			if claims, err := auth.ParseAccessToken(token, cfg.jwtSecret); err == nil {
+				actorID := claims.UserID
+				if claims.ImpersonatorID != uuid.Nil {
+					actorID = claims.ImpersonatorID
+				}
+				if entry.ActorID == uuid.Nil {
+					entry.ActorID = actorID
+				}
+				if entry.UserID == uuid.Nil {
+					entry.UserID = claims.UserID
+				}
+			}
+		}
+	}
+	entry.Method = r.Method
+	entry.Path = redactPath(r.URL.Path)
+	entry.RequestID = requestID(r)
+	entry.IP = clientIP(r)
+
+	if err := cfg.db.CreateAuditEntry(entry); err != nil {
+		loggerFrom(r.Context()).Error("couldn't write audit entry",
+			"action", entry.Action,
+			"actor_id", entry.ActorID,
+			"video_id", entry.VideoID,
+			"error", err,
+		)
+	}
+}
+
+// videoObjectKey is the key of video's file in its bucket, or "" if it has
+// none there.
+func (cfg *apiConfig) videoObjectKey(video database.Video) string {
+	if video.VideoURL == nil {
+		return ""
+	}
+	key, _ := cfg.keyInBucket(cfg.videoBucket(video), *video.VideoURL)
+	return key
+}
+
+// auditUpload records that a file was stored for video. It counts as a
+// replacement if the video had a file before.
+func (cfg *apiConfig) auditUpload(r *http.Request, hadFile bool, video database.Video, detail string) {
+	action := database.AuditVideoUploaded
+	if hadFile {
+		action = database.AuditVideoReplaced
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		UserID:    video.UserID,
+		Action:    action,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+		Detail:    detail,
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindAutoThumbnail = "auto_thumbnail"
+	// autoThumbnailTimeout bounds picking and storing a video's thumbnail
+	autoThumbnailTimeout = 5 * time.Minute
+	// autoThumbnailStart is how far into a video, as a fraction of its
+	// length, frames are considered from, past intros that open on black
+	autoThumbnailStart = 0.1
+	// autoThumbnailMaxWindow caps how many seconds of the video ffmpeg
+	// decodes to compare frames
+	autoThumbnailMaxWindow = 60.0
+	// autoThumbnailSamples is how many frames spread over the window are
+	// compared
+	autoThumbnailSamples = 30
+	// minFrameBrightness is the average luma, out of 255, below which a
+	// frame is too dark to be a thumbnail
+	minFrameBrightness = 20
+	// minFrameSharpness is the average edge strength below which a frame
+	// is too blurry, from motion or a focus pull, to be a thumbnail
+	minFrameSharpness = 2
+	// frameCheckSize is the most pixels along each side of a frame
+	// examined for its brightness and sharpness
+	frameCheckSize = 256
+)
+
+// autoThumbnailOffsets are where in a video, as fractions of its length,
+// frames are tried if the representative one can't be used
+var autoThumbnailOffsets = []float64{0.25, 0.5, 0.1, 0.75}
+
+// queueAutoThumbnail starts picking a thumbnail for a video whose file was
+// just stored, if it has none. Audio has no frames. Failures are logged,
+// the upload stands either way.
+func (cfg *apiConfig) queueAutoThumbnail(ctx context.Context, video database.Video) {
+	// the video passed in is from before its file was stored
+	videoID := video.ID
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue thumbnail", "video_id", videoID, "error", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.MediaKind != database.MediaKindVideo || video.ThumbnailURL != nil {
+		return
+	}
+	job, err := cfg.db.CreateJob(video.UserID, video.ID, jobKindAutoThumbnail, "")
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue thumbnail", "video_id", video.ID, "error", err)
+		return
+	}
+	cfg.startJob(ctx, job)
+}
+
+func (cfg *apiConfig) runAutoThumbnailJob(ctx context.Context, job database.Job) error {
+	return cfg.autoThumbnail(ctx, job.VideoID)
+}
+
+// autoThumbnail sets the thumbnail of a video without one to a frame
+// picked from its file. A thumbnail set meanwhile is kept.
+func (cfg *apiConfig) autoThumbnail(ctx context.Context, videoID uuid.UUID) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return errors.New("video no longer has a file")
+	}
+	if video.ThumbnailURL != nil {
+		return nil
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		return errors.New("video file isn't stored in this bucket")
+	}
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return fmt.Errorf("couldn't presign video URL: %w", err)
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+	assetPath := getAssetPath(base64.RawURLEncoding.EncodeToString(randomBytes), "image/jpeg")
+	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	duration := 0.0
+	if video.Duration != nil {
+		duration = *video.Duration
+	}
+	if err := cfg.pickThumbnailFrame(ctx, sourceURL, duration, assetDiskPath); err != nil {
+		return err
+	}
+
+	// reload so edits made while the frame was picked aren't overwritten
+	video, err = cfg.db.GetVideo(videoID)
+	if err == nil && video.ID == uuid.Nil {
+		err = errors.New("video was deleted")
+	}
+	if err != nil || video.ThumbnailURL != nil {
+		os.Remove(assetDiskPath)
+		return err
+	}
+	url := cfg.getAssetURL(assetPath)
+	video.ThumbnailURL = &url
+	video.ThumbnailBlurhash = thumbnailBlurhash(ctx, assetDiskPath)
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		os.Remove(assetDiskPath)
+		return err
+	}
+	return nil
+}
+
+// pickThumbnailFrame writes a frame of input fit to be its thumbnail to
+// outputPath: the one most representative of a window early in the video,
+// or else the first usable one at autoThumbnailOffsets. If none is usable,
+// the frame at the first offset is taken anyway.
+func (cfg *apiConfig) pickThumbnailFrame(ctx context.Context, input string, duration float64, outputPath string) error {
+	logger := loggerFrom(ctx)
+	if duration <= 0 {
+		return cfg.media.extractFrame(ctx, input, 0, outputPath)
+	}
+
+	start := duration * autoThumbnailStart
+	window := min(autoThumbnailMaxWindow, duration-2*start)
+	err := cfg.media.extractRepresentativeFrame(ctx, input, start, window, autoThumbnailSamples, outputPath)
+	if errors.Is(err, errMediaToolsUnavailable) || ctx.Err() != nil {
+		return err
+	}
+	if err == nil {
+		usable, reason := frameUsable(outputPath)
+		if usable {
+			logger.Info("picked representative frame for thumbnail")
+			return nil
+		}
+		logger.Info("representative frame can't be the thumbnail", "reason", reason)
+	} else {
+		logger.Warn("couldn't pick representative frame for thumbnail", "error", err)
+	}
+
+	for _, offset := range autoThumbnailOffsets {
+		err := cfg.media.extractFrame(ctx, input, offset*duration, outputPath)
+		if errors.Is(err, errFrameOutOfRange) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if usable, _ := frameUsable(outputPath); usable {
+			logger.Info("picked frame for thumbnail", "offset", offset)
+			return nil
+		}
+	}
+	logger.Info("no frame looks fit for a thumbnail, taking one anyway", "offset", autoThumbnailOffsets[0])
+	return cfg.media.extractFrame(ctx, input, autoThumbnailOffsets[0]*duration, outputPath)
+}
+
+// frameUsable reports whether the frame in the JPEG at path is bright and
+// sharp enough to be a thumbnail, and if not why.
+func frameUsable(path string) (bool, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err.Error()
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return false, err.Error()
+	}
+	brightness, sharpness := frameQuality(img)
+	switch {
+	case brightness < minFrameBrightness:
+		return false, "too dark"
+	case sharpness < minFrameSharpness:
+		return false, "too blurry"
+	}
+	return true, ""
+}
+
+// frameQuality measures img's average luma, out of 255, and its sharpness,
+// the average strength of the Laplacian of its luma. Large images are
+// sampled at up to frameCheckSize pixels per side.
+func frameQuality(img image.Image) (brightness, sharpness float64) {
+	bounds := img.Bounds()
+	width, height := min(bounds.Dx(), frameCheckSize), min(bounds.Dy(), frameCheckSize)
+	if width < 3 || height < 3 {
+		return 0, 0
+	}
+	luma := make([][]float64, height)
+	total := 0.0
+	for y := range height {
+		luma[y] = make([]float64, width)
+		sy := bounds.Min.Y + y*bounds.Dy()/height
+		for x := range width {
+			sx := bounds.Min.X + x*bounds.Dx()/width
+			r, g, b, _ := img.At(sx, sy).RGBA()
+			luma[y][x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			total += luma[y][x]
+		}
+	}
+	edges := 0.0
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			edges += math.Abs(4*luma[y][x] - luma[y-1][x] - luma[y+1][x] - luma[y][x-1] - luma[y][x+1])
+		}
+	}
+	return total / float64(width*height), edges / float64((width-2)*(height-2))
+}
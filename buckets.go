@@ -0,0 +1,129 @@
+package main
+
+import (
+	"hash/fnv"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// bucketResolver knows the buckets videos are stored in: which one a new
+// video goes to, and the region and client of each. Everything that reads
+// or writes a video's objects asks it rather than assuming one bucket.
+type bucketResolver struct {
+	bucketSettings
+	// clients has a client for each region a bucket is in
+	clients map[string]*s3.Client
+}
+
+// newBucketResolver makes a resolver for settings, with a client from
+// newClient for each region the buckets are in.
+func newBucketResolver(settings bucketSettings, newClient func(region string) *s3.Client) *bucketResolver {
+	r := &bucketResolver{bucketSettings: settings, clients: map[string]*s3.Client{}}
+	for _, bucket := range settings.Buckets {
+		if _, ok := r.clients[bucket.Region]; !ok {
+			r.clients[bucket.Region] = newClient(bucket.Region)
+		}
+	}
+	return r
+}
+
+// defaultBucket is S3_BUCKET, the bucket of every video stored before
+// sharding.
+func (r *bucketResolver) defaultBucket() string {
+	return r.Buckets[0].Name
+}
+
+// orDefault returns name, or the default bucket for records that predate
+// sharding and have none.
+func (r *bucketResolver) orDefault(name string) string {
+	if name == "" {
+		return r.defaultBucket()
+	}
+	return name
+}
+
+// lookup returns the bucket called name, false if videos aren't stored in
+// it.
+func (r *bucketResolver) lookup(name string) (storageBucket, bool) {
+	i := slices.IndexFunc(r.Buckets, func(b storageBucket) bool { return b.Name == name })
+	if i < 0 {
+		return storageBucket{}, false
+	}
+	return r.Buckets[i], true
+}
+
+// region returns the region of bucket, S3_REGION's for one videos aren't
+// stored in.
+func (r *bucketResolver) region(bucket string) string {
+	if b, ok := r.lookup(bucket); ok {
+		return b.Region
+	}
+	return r.Buckets[0].Region
+}
+
+// client returns the client requests to bucket are sent with.
+func (r *bucketResolver) client(bucket string) *s3.Client {
+	return r.clients[r.region(bucket)]
+}
+
+// hasRegion reports whether a bucket is in region.
+func (r *bucketResolver) hasRegion(region string) bool {
+	return slices.ContainsFunc(r.Buckets, func(b storageBucket) bool { return b.Region == region })
+}
+
+// forUser picks the bucket for a new video of userID. Users are hashed
+// over the buckets they can be stored in, so each user's videos stay
+// together: all buckets, or with region sharding those in region, the
+// user's storage region. A user without one, or whose region no bucket is
+// in any more, gets the default bucket.
+func (r *bucketResolver) forUser(userID uuid.UUID, region string) string {
+	candidates := r.Buckets
+	switch r.Sharding {
+	case "":
+		return r.defaultBucket()
+	case shardByRegion:
+		candidates = slices.DeleteFunc(slices.Clone(candidates), func(b storageBucket) bool { return b.Region != region })
+		if region == "" || len(candidates) == 0 {
+			return r.defaultBucket()
+		}
+	}
+	h := fnv.New32a()
+	h.Write(userID[:])
+	return candidates[h.Sum32()%uint32(len(candidates))].Name
+}
+
+// videoBucket returns the bucket video's objects are stored in.
+func (cfg *apiConfig) videoBucket(video database.Video) string {
+	return cfg.buckets.orDefault(video.StorageBucket)
+}
+
+// newVideoBucket picks the bucket a new video of userID is stored in, to
+// be recorded on it when it's created.
+func (cfg *apiConfig) newVideoBucket(userID uuid.UUID) (string, error) {
+	region := ""
+	if cfg.buckets.Sharding == shardByRegion {
+		var err error
+		region, err = cfg.db.GetUserStorageRegion(userID)
+		if err != nil {
+			return "", err
+		}
+	}
+	return cfg.buckets.forUser(userID, region), nil
+}
+
+// reusableContent returns the object with the given content the owner of
+// video already stored, if video can reuse it: only one in the video's
+// own bucket can be, so all of a video's objects stay in its bucket.
+func (cfg *apiConfig) reusableContent(video database.Video, sha256 string, size int64) (*database.ContentObject, error) {
+	content, err := cfg.db.GetContentObject(video.UserID, sha256, size)
+	if err != nil || content == nil {
+		return nil, err
+	}
+	if cfg.buckets.orDefault(content.Bucket) != cfg.videoBucket(video) {
+		return nil, nil
+	}
+	return content, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// captions are small text files, anything bigger isn't one
+const maxCaptionSize = 2 << 20 // 2 MB
+
+// captionTiming matches a cue's timing line in SRT or WebVTT. Hours are
+// optional in WebVTT, SRT separates milliseconds with a comma, and WebVTT
+// allows cue settings after the end time.
+var captionTiming = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}[.,]\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}[.,]\d{3})(\s.*)?$`)
+
+// toWebVTT validates an SRT or WebVTT caption file and returns it as
+// WebVTT. Errors are safe to show to the client.
+func toWebVTT(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		return nil, errors.New("captions must be UTF-8 text")
+	}
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+	blocks := splitCaptionBlocks(text)
+	if len(blocks) == 0 {
+		return nil, errors.New("caption file is empty")
+	}
+
+	vtt := false
+	if header := blocks[0][0]; header == "WEBVTT" || strings.HasPrefix(header, "WEBVTT ") || strings.HasPrefix(header, "WEBVTT\t") {
+		vtt = true
+		blocks = blocks[1:]
+	}
+
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+	cues := 0
+	for _, block := range blocks {
+		// WebVTT comments, styles and regions pass through untouched
+		if vtt && (block[0] == "NOTE" || strings.HasPrefix(block[0], "NOTE ") || block[0] == "STYLE" || block[0] == "REGION") {
+			out.WriteString("\n" + strings.Join(block, "\n") + "\n")
+			continue
+		}
+		// a cue starts with an optional identifier, SRT's cue number
+		timing := 0
+		if !captionTiming.MatchString(block[0]) {
+			timing = 1
+		}
+		if timing >= len(block) || !captionTiming.MatchString(block[timing]) {
+			return nil, fmt.Errorf("cue %d has no valid timing line", cues+1)
+		}
+		match := captionTiming.FindStringSubmatch(block[timing])
+		start, end := captionSeconds(match[1]), captionSeconds(match[2])
+		if end < start {
+			return nil, fmt.Errorf("cue %d ends before it starts", cues+1)
+		}
+		cues++
+
+		out.WriteString("\n")
+		if timing == 1 && vtt {
+			out.WriteString(block[0] + "\n")
+		}
+		if vtt {
+			out.WriteString(block[timing] + "\n")
+		} else {
+			out.WriteString(strings.ReplaceAll(match[1], ",", ".") + " --> " + strings.ReplaceAll(match[2], ",", ".") + "\n")
+		}
+		for _, line := range block[timing+1:] {
+			out.WriteString(line + "\n")
+		}
+	}
+	if cues == 0 {
+		return nil, errors.New("caption file has no cues")
+	}
+	return []byte(out.String()), nil
+}
+
+// splitCaptionBlocks splits text into its blank-line separated blocks of
+// lines.
+func splitCaptionBlocks(text string) [][]string {
+	blocks := [][]string{}
+	var block []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = nil
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// captionSeconds converts a timestamp captionTiming matched to seconds.
+func captionSeconds(timestamp string) float64 {
+	parts := strings.Split(strings.ReplaceAll(timestamp, ",", "."), ":")
+	seconds := 0.0
+	for _, part := range parts {
+		n, _ := strconv.ParseFloat(part, 64)
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/google/uuid"
+)
+
+const (
+	// cdnInvalidationTimeout bounds each call to CloudFront, which may run
+	// after the request that caused it has finished
+	cdnInvalidationTimeout = 30 * time.Second
+	// cloudFrontMaxPaths is the most paths one invalidation can list
+	cloudFrontMaxPaths = 3000
+)
+
+// cdnInvalidator drops a CDN's cached copies of paths, so viewers get
+// the current object instead of a stale one until it expires.
+type cdnInvalidator interface {
+	invalidate(ctx context.Context, paths []string) error
+}
+
+// newCDNInvalidator returns the invalidator for distributionID, nil if
+// there's none. Sandbox mode has no CloudFront and only logs the paths.
+func (cfg *apiConfig) newCDNInvalidator(distributionID string, awsCfg aws.Config) cdnInvalidator {
+	switch {
+	case distributionID == "":
+		return nil
+	case cfg.sandbox:
+		return sandboxCDN{}
+	default:
+		// CloudFront is global, its API is in us-east-1
+		client := cloudfront.NewFromConfig(awsCfg, func(o *cloudfront.Options) {
+			o.Region = "us-east-1"
+			o.APIOptions = append(o.APIOptions, traceAWSCalls)
+		})
+		return cloudFrontInvalidator{distributionID: distributionID, client: client}
+	}
+}
+
+// objectCDNPath is where the distribution serves key, from whichever
+// bucket it's in.
+func objectCDNPath(key string) string {
+	return (&url.URL{Path: "/" + key}).EscapedPath()
+}
+
+// assetCDNPath is where the distribution serves the asset at assetPath,
+// under /assets like the server.
+func assetCDNPath(assetPath string) string {
+	return (&url.URL{Path: "/assets/" + assetPath}).EscapedPath()
+}
+
+// invalidateCDN drops the CDN's cached copies of paths. It does nothing
+// without a distribution.
+func (cfg *apiConfig) invalidateCDN(ctx context.Context, paths []string) error {
+	if cfg.cdn == nil || len(paths) == 0 {
+		return nil
+	}
+	for start := 0; start < len(paths); start += cloudFrontMaxPaths {
+		if err := cfg.cdn.invalidate(ctx, paths[start:min(start+cloudFrontMaxPaths, len(paths))]); err != nil {
+			return err
+		}
+	}
+	loggerFrom(ctx).Info("invalidated CDN paths", "paths", paths)
+	return nil
+}
+
+// invalidateCDNLater invalidates paths in the background, so the request
+// replacing them doesn't wait on CloudFront or fail with it. Failures are
+// logged; the stale copies expire on their own eventually.
+func (cfg *apiConfig) invalidateCDNLater(ctx context.Context, paths []string) {
+	if cfg.cdn == nil || len(paths) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	cfg.goBackground(func() {
+		if err := cfg.invalidateCDN(ctx, paths); err != nil {
+			loggerFrom(ctx).Error("couldn't invalidate CDN paths", "paths", paths, "error", err)
+		}
+	})
+}
+
+// cloudFrontInvalidator creates invalidations on a CloudFront
+// distribution. The server's AWS credentials need the
+// cloudfront:CreateInvalidation permission.
+type cloudFrontInvalidator struct {
+	distributionID string
+	client         *cloudfront.Client
+}
+
+func (c cloudFrontInvalidator) invalidate(ctx context.Context, paths []string) error {
+	ctx, cancel := context.WithTimeout(ctx, cdnInvalidationTimeout)
+	defer cancel()
+	// the reference is made once per batch, so the SDK's retries of it
+	// can't start a second invalidation
+	_, err := c.client.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(c.distributionID),
+		InvalidationBatch: &types.InvalidationBatch{
+			CallerReference: aws.String(uuid.NewString()),
+			Paths: &types.Paths{
+				Quantity: aws.Int32(int32(len(paths))),
+				Items:    paths,
+			},
+		},
+	})
+	return err
+}
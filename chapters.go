@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// maxChapters caps how many chapters a video can have
+	maxChapters = 100
+	// chapterTitleMaxLength caps a chapter's title, in characters
+	chapterTitleMaxLength = 100
+)
+
+// validateChapters checks chapters a client sent and returns them in
+// order. Chapters must start within the video, if its duration is known,
+// and no two at the same time. Errors are safe to show to the client.
+func validateChapters(chapters []database.Chapter, duration *float64) ([]database.Chapter, error) {
+	if len(chapters) > maxChapters {
+		return nil, fmt.Errorf("a video can have at most %d chapters", maxChapters)
+	}
+	valid := make([]database.Chapter, 0, len(chapters))
+	for i, chapter := range chapters {
+		chapter.Title = strings.TrimSpace(chapter.Title)
+		if chapter.Title == "" {
+			return nil, fmt.Errorf("chapter %d needs a title", i+1)
+		}
+		if utf8.RuneCountInString(chapter.Title) > chapterTitleMaxLength {
+			return nil, fmt.Errorf("chapter titles can be at most %d characters", chapterTitleMaxLength)
+		}
+		if chapter.Start < 0 || math.IsNaN(chapter.Start) || math.IsInf(chapter.Start, 0) {
+			return nil, fmt.Errorf("chapter %d needs a non-negative start", i+1)
+		}
+		if duration != nil && chapter.Start >= *duration {
+			return nil, fmt.Errorf("chapter %d starts after the video ends", i+1)
+		}
+		valid = append(valid, chapter)
+	}
+	slices.SortStableFunc(valid, func(a, b database.Chapter) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	for i := 1; i < len(valid); i++ {
+		if valid[i].Start == valid[i-1].Start {
+			return nil, fmt.Errorf("two chapters start at %gs", valid[i].Start)
+		}
+	}
+	return valid, nil
+}
+
+// chapters returns the chapters embedded in the probed file, in order.
+// Untitled ones are numbered, and ones the API wouldn't accept are left
+// out rather than failing the upload.
+func (p probeResult) chapters() []database.Chapter {
+	chapters := []database.Chapter{}
+	for _, probed := range p.Chapters {
+		start, err := strconv.ParseFloat(probed.StartTime, 64)
+		if err != nil || start < 0 || math.IsNaN(start) || math.IsInf(start, 0) {
+			continue
+		}
+		if slices.ContainsFunc(chapters, func(c database.Chapter) bool { return c.Start == start }) {
+			continue
+		}
+		title := strings.TrimSpace(probed.Tags.Title)
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", len(chapters)+1)
+		}
+		if utf8.RuneCountInString(title) > chapterTitleMaxLength {
+			title = string([]rune(title)[:chapterTitleMaxLength])
+		}
+		chapters = append(chapters, database.Chapter{Start: start, Title: title})
+		if len(chapters) == maxChapters {
+			break
+		}
+	}
+	slices.SortStableFunc(chapters, func(a, b database.Chapter) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return chapters
+}
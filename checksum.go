@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+)
+
+// contentChecksums are the digests a client declared for an uploaded file.
+type contentChecksums struct {
+	SHA256 []byte
+	MD5    []byte
+}
+
+// parseContentChecksums reads X-Content-SHA256 (hex or base64) and
+// Content-MD5 (base64, as in RFC 1864) for an uploaded file. Headers on the
+// multipart file part take precedence over the request's own headers.
+func parseContentChecksums(part textproto.MIMEHeader, request http.Header) (contentChecksums, error) {
+	get := func(name string) string {
+		if v := part.Get(name); v != "" {
+			return v
+		}
+		return request.Get(name)
+	}
+
+	var sums contentChecksums
+	if v := get("X-Content-SHA256"); v != "" {
+		sum, err := decodeDigest(v, sha256.Size)
+		if err != nil {
+			return contentChecksums{}, fmt.Errorf("invalid X-Content-SHA256: %w", err)
+		}
+		sums.SHA256 = sum
+	}
+	if v := get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(sum) != md5.Size {
+			return contentChecksums{}, errors.New("invalid Content-MD5: must be a base64 encoded MD5 digest")
+		}
+		sums.MD5 = sum
+	}
+	return sums, nil
+}
+
+func decodeDigest(v string, size int) ([]byte, error) {
+	if len(v) == hex.EncodedLen(size) {
+		if sum, err := hex.DecodeString(v); err == nil {
+			return sum, nil
+		}
+	}
+	if sum, err := base64.StdEncoding.DecodeString(v); err == nil && len(sum) == size {
+		return sum, nil
+	}
+	return nil, fmt.Errorf("must be a hex or base64 encoded %d byte digest", size)
+}
+
+// hashFile returns the hex SHA-256 of f's contents and rewinds it.
+func hashFile(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultCLIServer = "http://localhost:8091"
+	// failed upload attempts are retried after 2s, doubling up to a minute
+	cliRetryBaseBackoff = 2 * time.Second
+	cliRetryMaxBackoff  = time.Minute
+	// cliJobPollInterval is how often an upload handed to a worker is
+	// checked on
+	cliJobPollInterval = 2 * time.Second
+	// cliProgressInterval is how often the progress bar is redrawn
+	cliProgressInterval = 200 * time.Millisecond
+)
+
+// cliUploadState remembers the videos created for files whose upload
+// didn't finish, so running the same upload again resumes with the same
+// video instead of creating another. It's kept in the user's cache
+// directory, keyed by server and the file's SHA-256.
+type cliUploadState map[string]string
+
+func cliUploadStatePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tubely", "uploads.json"), nil
+}
+
+func loadCLIUploadState() (cliUploadState, error) {
+	state := cliUploadState{}
+	path, err := cliUploadStatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid upload state in %s: %w", path, err)
+	}
+	return state, nil
+}
+
+func (s cliUploadState) save() error {
+	path, err := cliUploadStatePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// runUploadCommand runs "tubely upload", which creates a video and
+// uploads a file to it, retrying failed attempts. It returns the exit
+// code: 1 if the upload failed, 2 for bad usage.
+func runUploadCommand(args []string) int {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: tubely upload [flags] FILE")
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", envOr("TUBELY_SERVER", defaultCLIServer), "the server's base URL, or set TUBELY_SERVER")
+	title := flags.String("title", "", "the video's title, the file name by default")
+	description := flags.String("description", "", "the video's description")
+	visibility := flags.String("visibility", "", "public, unlisted or private, the server's default if unset")
+	profile := flags.String("profile", "", "the processing profile to upload with")
+	mediaType := flags.String("type", "", "the file's media type, guessed from its extension if unset")
+	videoID := flags.String("video", "", "upload to this existing video instead of creating one")
+	retries := flags.Int("retries", 5, "how many times a failed upload attempt is retried")
+	quiet := flags.Bool("quiet", false, "don't show a progress bar")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+	if *mediaType == "" {
+		*mediaType = client.MediaTypeByExtension(path)
+		if *mediaType == "" {
+			fmt.Fprintf(os.Stderr, "Can't tell the media type of %s, set it with -type\n", path)
+			return 2
+		}
+	}
+	var existing uuid.UUID
+	if *videoID != "" {
+		var err error
+		existing, err = uuid.Parse(*videoID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid video ID %q\n", *videoID)
+			return 2
+		}
+	}
+
+	upload := cliUpload{
+		server:      strings.TrimSuffix(*server, "/"),
+		email:       os.Getenv("TUBELY_EMAIL"),
+		password:    os.Getenv("TUBELY_PASSWORD"),
+		path:        path,
+		mediaType:   *mediaType,
+		title:       *title,
+		description: *description,
+		visibility:  *visibility,
+		profile:     *profile,
+		videoID:     existing,
+		retries:     max(*retries, 0),
+		progress:    !*quiet && isTerminal(os.Stderr),
+	}
+	if apiKey := os.Getenv("TUBELY_API_KEY"); apiKey != "" {
+		upload.client = client.NewWithAPIKey(upload.server, apiKey)
+	} else {
+		if upload.email == "" {
+			fmt.Fprintln(os.Stderr, "Set TUBELY_API_KEY, or TUBELY_EMAIL and TUBELY_PASSWORD, to authenticate")
+			return 2
+		}
+		if upload.password == "" {
+			fmt.Fprintf(os.Stderr, "Password for %s: ", upload.email)
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Fprintln(os.Stderr, "\nNo password given")
+				return 2
+			}
+			upload.password = strings.TrimRight(line, "\r\n")
+		}
+		upload.client = client.New(upload.server, "")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	videoURL, err := upload.run(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Upload failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Uploaded %s as video %s\n%s\n", path, upload.videoID, videoURL)
+	return 0
+}
+
+// cliUpload is one run of "tubely upload".
+type cliUpload struct {
+	client *client.Client
+	server string
+	// email and password log in again if they're set and the access token
+	// runs out
+	email       string
+	password    string
+	path        string
+	mediaType   string
+	title       string
+	description string
+	visibility  string
+	profile     string
+	// videoID is the video uploaded to, set once it's created if it wasn't
+	// given
+	videoID  uuid.UUID
+	retries  int
+	progress bool
+}
+
+// login gets a fresh access token, unless the upload uses an API key.
+func (u *cliUpload) login(ctx context.Context) error {
+	if u.email == "" {
+		return nil
+	}
+	if _, err := u.client.Login(ctx, u.email, u.password); err != nil {
+		return fmt.Errorf("couldn't log in: %w", err)
+	}
+	return nil
+}
+
+// run uploads the file, returning the video's URL.
+func (u *cliUpload) run(ctx context.Context) (string, error) {
+	file, err := os.Open(u.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	sha, err := client.HashFile(file)
+	if err != nil {
+		return "", fmt.Errorf("couldn't hash file: %w", err)
+	}
+	if err := u.login(ctx); err != nil {
+		return "", err
+	}
+
+	// a video made for this file by an earlier run is reused
+	state, err := loadCLIUploadState()
+	if err != nil {
+		return "", err
+	}
+	stateKey := u.server + " " + sha
+	if previous, err := uuid.Parse(state[stateKey]); u.videoID == uuid.Nil && err == nil {
+		video, err := u.client.GetVideo(ctx, previous)
+		var apiErr *client.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			// deleted since, start over
+		case err != nil:
+			return "", fmt.Errorf("couldn't check on the earlier upload: %w", err)
+		case video.VideoURL != nil:
+			u.videoID = previous
+			delete(state, stateKey)
+			return *video.VideoURL, state.save()
+		default:
+			u.videoID = previous
+			fmt.Fprintf(os.Stderr, "Resuming the upload to video %s\n", u.videoID)
+		}
+	}
+
+	if u.videoID == uuid.Nil {
+		title := u.title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(u.path), filepath.Ext(u.path))
+		}
+
+		// the server may have the file already, then there's nothing to send
+		precheck, err := u.client.Precheck(ctx, client.PrecheckParams{
+			SHA256:      sha,
+			Size:        info.Size(),
+			Filename:    filepath.Base(u.path),
+			Title:       title,
+			Description: u.description,
+			Visibility:  u.visibility,
+		})
+		if err != nil {
+			return "", fmt.Errorf("couldn't check for the file on the server: %w", err)
+		}
+		if precheck.Exists && precheck.Video != nil && precheck.Video.VideoURL != nil {
+			u.videoID = precheck.Video.ID
+			return *precheck.Video.VideoURL, nil
+		}
+
+		video, err := u.client.CreateVideo(ctx, client.CreateVideoParams{
+			Title:       title,
+			Description: u.description,
+			Visibility:  u.visibility,
+		})
+		if err != nil {
+			return "", fmt.Errorf("couldn't create video: %w", err)
+		}
+		u.videoID = video.ID
+		state[stateKey] = video.ID.String()
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't save upload state, an interrupted upload won't resume: %v\n", err)
+		}
+	}
+
+	videoURL, err := u.send(ctx, file, client.UploadParams{
+		Filename:  filepath.Base(u.path),
+		MediaType: u.mediaType,
+		Size:      info.Size(),
+		SHA256:    sha,
+		Profile:   u.profile,
+		// a retry after a lost response gets that response instead of
+		// uploading again
+		IdempotencyKey: "tubely-cli-" + u.videoID.String() + "-" + sha,
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, ok := state[stateKey]; ok {
+		delete(state, stateKey)
+		if err := state.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't save upload state: %v\n", err)
+		}
+	}
+	return videoURL, nil
+}
+
+// send uploads the file to the video, retrying failed attempts with
+// backoff.
+func (u *cliUpload) send(ctx context.Context, file *os.File, params client.UploadParams) (string, error) {
+	relogged := false
+	for attempt := 0; ; attempt++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		var bar *progressBar
+		if u.progress {
+			bar = &progressBar{total: params.Size, started: time.Now()}
+			params.Progress = bar.update
+		}
+		result, err := u.client.Upload(ctx, u.videoID, file, params)
+		bar.finish()
+		if err == nil && result.JobID != uuid.Nil {
+			return u.waitForJob(ctx, result.JobID)
+		}
+		if err == nil {
+			return result.VideoURL, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && u.email != "" && !relogged {
+			// the access token ran out during a long upload
+			relogged = true
+			if err := u.login(ctx); err != nil {
+				return "", err
+			}
+		} else if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			return "", err
+		}
+		if attempt >= u.retries {
+			return "", err
+		}
+		delay := min(cliRetryMaxBackoff, cliRetryBaseBackoff<<attempt)
+		fmt.Fprintf(os.Stderr, "Upload attempt %d failed: %v, retrying in %s\n", attempt+1, err, delay)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// waitForJob waits for the job processing an upload a server handed to
+// its workers, returning the video's URL once it's done.
+func (u *cliUpload) waitForJob(ctx context.Context, jobID uuid.UUID) (string, error) {
+	fmt.Fprintf(os.Stderr, "Uploaded, waiting for job %s to process it\n", jobID)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(cliJobPollInterval):
+		}
+		job, err := u.client.GetJob(ctx, jobID)
+		if err != nil {
+			return "", fmt.Errorf("couldn't check on job %s: %w", jobID, err)
+		}
+		switch job.Status {
+		case "succeeded":
+			video, err := u.client.GetVideo(ctx, u.videoID)
+			if err != nil {
+				return "", err
+			}
+			if video.VideoURL == nil {
+				return "", errors.New("the video has no file once processed")
+			}
+			return *video.VideoURL, nil
+		case "failed":
+			reason := "unknown error"
+			if job.Error != nil {
+				reason = *job.Error
+			}
+			return "", fmt.Errorf("processing failed: %s", reason)
+		}
+	}
+}
+
+// progressBar draws an upload's progress on stderr.
+type progressBar struct {
+	total   int64
+	done    int64
+	started time.Time
+	drawn   time.Time
+}
+
+// update records that sent bytes have been sent, redrawing the bar now
+// and then.
+func (p *progressBar) update(sent int64) {
+	p.done = sent
+	if time.Since(p.drawn) >= cliProgressInterval {
+		p.draw()
+	}
+}
+
+func (p *progressBar) draw() {
+	p.drawn = time.Now()
+	const width = 30
+	fraction := 1.0
+	if p.total > 0 {
+		fraction = float64(p.done) / float64(p.total)
+	}
+	filled := int(fraction * width)
+	rate := float64(p.done) / max(time.Since(p.started).Seconds(), 0.001)
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %s / %s %s/s ",
+		strings.Repeat("#", filled), strings.Repeat(" ", width-filled),
+		fraction*100, formatBytes(p.done), formatBytes(p.total), formatBytes(int64(rate)))
+}
+
+// finish draws the final state and ends the line. It's a no-op on a nil
+// bar, for uploads without one.
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+// formatBytes formats n bytes for people, such as 12.3 MB.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMGTP"[exp])
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// envOr returns the environment variable name, or fallback if it's unset.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// BatchFile is one file of a batch upload. Every field but Path is
+// optional; the title defaults to the file's name.
+type BatchFile struct {
+	Path        string
+	Title       string
+	Description string
+	Tags        []string
+}
+
+// BatchParams are the settings every file of a batch upload gets.
+type BatchParams struct {
+	// Visibility is public, unlisted or private, the default if ""
+	Visibility string
+	// Profile is the processing profile, the default if ""
+	Profile string
+	// NormalizeLoudness has the files' audio normalized
+	NormalizeLoudness bool
+}
+
+// BatchResult is the server's report on a batch upload.
+type BatchResult struct {
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Skipped int               `json:"skipped"`
+	Results []BatchFileResult `json:"results"`
+	// Stopped is why the server didn't read the rest of the upload, if it
+	// didn't. Files without a result weren't uploaded.
+	Stopped string `json:"stopped"`
+}
+
+// BatchFileResult is how one file of a batch upload went.
+type BatchFileResult struct {
+	Filename string `json:"filename"`
+	// Status is "created", "failed" or "skipped"
+	Status  string     `json:"status"`
+	VideoID *uuid.UUID `json:"video_id"`
+	// JobID is the job processing the file, when the server hands uploads
+	// to workers
+	JobID *uuid.UUID `json:"job_id"`
+	// Error is why the file failed or was skipped
+	Error string `json:"error"`
+}
+
+// UploadBatch creates a video for each of the files in one request. The
+// server processes the files as they arrive, and a file that fails
+// doesn't stop the ones after it, so check each result.
+func (c *Client) UploadBatch(ctx context.Context, files []BatchFile, params BatchParams) (BatchResult, error) {
+	type manifestEntry struct {
+		Filename    string   `json:"filename"`
+		Title       string   `json:"title,omitempty"`
+		Description string   `json:"description,omitempty"`
+		Tags        []string `json:"tags,omitempty"`
+	}
+	var manifest []manifestEntry
+	for _, f := range files {
+		if MediaTypeByExtension(f.Path) == "" {
+			return BatchResult{}, fmt.Errorf("tubely: the media type of %q isn't known", f.Path)
+		}
+		if f.Title != "" || f.Description != "" || len(f.Tags) > 0 {
+			manifest = append(manifest, manifestEntry{
+				Filename:    filepath.Base(f.Path),
+				Title:       f.Title,
+				Description: f.Description,
+				Tags:        f.Tags,
+			})
+		}
+	}
+	fields := map[string]string{}
+	if params.Visibility != "" {
+		fields["visibility"] = params.Visibility
+	}
+	if params.Profile != "" {
+		fields["profile"] = params.Profile
+	}
+	if params.NormalizeLoudness {
+		fields["normalize_loudness"] = "true"
+	}
+	if len(manifest) > 0 {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		fields["manifest"] = string(data)
+	}
+
+	// stream the form, so no file is held in memory
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeBatchForm(mw, fields, files))
+	}()
+	defer pr.Close()
+
+	resp, err := c.do(ctx, http.MethodPost, "/api/videos/batch", pr, -1, mw.FormDataContentType(), nil)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer resp.Body.Close()
+	var result BatchResult
+	return result, decodeJSON(resp, &result)
+}
+
+// writeBatchForm writes the fields, then the files, to mw and closes it.
+func writeBatchForm(mw *multipart.Writer, fields map[string]string, files []BatchFile) error {
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := writeBatchFile(mw, f.Path); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func writeBatchFile(mw *multipart.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum, err := HashFile(file)
+	if err != nil {
+		return err
+	}
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     "video",
+		"filename": filepath.Base(path),
+	}))
+	partHeader.Set("Content-Type", MediaTypeByExtension(path))
+	partHeader.Set("X-Content-SHA256", sum)
+	part, err := mw.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
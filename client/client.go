@@ -0,0 +1,190 @@
+// Package client calls a Tubely server's HTTP API, so Go programs can
+// create, list, update, delete and upload videos without building the
+// requests themselves.
+//
+//	c := client.New("https://tubely.example.com", token)
+//	video, err := c.CreateVideo(ctx, client.CreateVideoParams{Title: "Launch"})
+//	...
+//	result, err := c.UploadFile(ctx, video.ID, "launch.mp4", client.UploadParams{})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client calls one Tubely server as one user. It's safe for concurrent
+// use, except for Login and SetToken.
+type Client struct {
+	baseURL string
+	// authorization is the Authorization header sent with every request
+	authorization string
+	// HTTPClient sends the requests, http.DefaultClient unless it's set
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, such as
+// https://tubely.example.com, authenticated with an access token. The
+// token can be empty, for public endpoints or to call Login.
+func New(baseURL, token string) *Client {
+	c := &Client{baseURL: strings.TrimSuffix(baseURL, "/")}
+	c.SetToken(token)
+	return c
+}
+
+// NewWithAPIKey returns a client authenticated with an API key instead of
+// an access token. API keys don't expire, so they suit services.
+func NewWithAPIKey(baseURL, key string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), authorization: "ApiKey " + key}
+}
+
+// SetToken replaces the access token the client sends, such as with one
+// refreshed after the last ran out.
+func (c *Client) SetToken(token string) {
+	c.authorization = ""
+	if token != "" {
+		c.authorization = "Bearer " + token
+	}
+}
+
+// Tokens are what logging in returns. The refresh token gets new access
+// tokens from POST /api/refresh once the access token runs out.
+type Tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Login logs in with an email and password and has the client use the
+// access token it gets.
+func (c *Client) Login(ctx context.Context, email, password string) (Tokens, error) {
+	var tokens Tokens
+	err := c.call(ctx, http.MethodPost, "/api/login", map[string]string{
+		"email":    email,
+		"password": password,
+	}, &tokens)
+	if err != nil {
+		return Tokens{}, err
+	}
+	c.SetToken(tokens.Token)
+	return tokens, nil
+}
+
+// The codes APIError.Code can hold.
+const (
+	CodeVideoNotFound            = "video_not_found"
+	CodeUnsupportedMediaType     = "unsupported_media_type"
+	CodeUploadTooLarge           = "upload_too_large"
+	CodeQuotaExceeded            = "quota_exceeded"
+	CodeProbeFailed              = "probe_failed"
+	CodeProcessingUnavailable    = "processing_unavailable"
+	CodeMalwareDetected          = "malware_detected"
+	CodeVideoArchived            = "video_archived"
+	CodeHotlinkBlocked           = "hotlink_blocked"
+	CodePasswordAttemptsExceeded = "password_attempts_exceeded"
+)
+
+// APIError is an error response from the server.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// Code tells apart errors that share a status, such as
+	// CodeMalwareDetected, if the server gave one
+	Code string
+	// RequestID identifies the request in the server's logs
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("tubely: %d %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if it's sent again
+// later.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= 500 ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout
+}
+
+// call sends in as JSON, unless it's nil, and decodes the JSON response
+// into out, unless it's nil.
+func (c *Client) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	var size int64
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, size = bytes.NewReader(data), int64(len(data))
+	}
+	resp, err := c.do(ctx, method, path, body, size, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return decodeJSON(resp, out)
+}
+
+// decodeJSON decodes a successful response's JSON body into out.
+func decodeJSON(resp *http.Response, out any) error {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("tubely: invalid response: %w", err)
+	}
+	return nil
+}
+
+// do sends a request with a body of size bytes, -1 if unknown, returning
+// an *APIError for an error response. The caller closes the response
+// body.
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, size int64, contentType string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var errResp struct {
+		Error     string `json:"error"`
+		Code      string `json:"code"`
+		RequestID string `json:"request_id"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&errResp) != nil || errResp.Error == "" {
+		errResp.Error = http.StatusText(resp.StatusCode)
+	}
+	return nil, &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    errResp.Error,
+		Code:       errResp.Code,
+		RequestID:  errResp.RequestID,
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job is a background job as the API returns it. Status is "pending",
+// "running", "succeeded" or "failed".
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Kind      string    `json:"kind"`
+	Status    string    `json:"status"`
+	// Error is why the job last failed, nil if it hasn't
+	Error *string `json:"error"`
+	// Attempts counts the times the job was started, retries included
+	Attempts int `json:"attempts"`
+}
+
+// GetJob returns one of the user's jobs.
+func (c *Client) GetJob(ctx context.Context, id uuid.UUID) (Job, error) {
+	var job Job
+	err := c.call(ctx, http.MethodGet, "/api/jobs/"+id.String(), nil, &job)
+	return job, err
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// mediaTypes are the file types the server accepts, by extension.
+var mediaTypes = map[string]string{
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+	".mp3": "audio/mpeg",
+	".aac": "audio/aac",
+	".ogg": "audio/ogg",
+}
+
+// MediaTypeByExtension returns the media type the server accepts for
+// files with the extension of path, "" if it accepts none.
+func MediaTypeByExtension(path string) string {
+	return mediaTypes[strings.ToLower(filepath.Ext(path))]
+}
+
+// UploadParams describe a file being uploaded.
+type UploadParams struct {
+	// Filename is the name the file is stored with
+	Filename string
+	// MediaType is the file's type, such as video/mp4
+	MediaType string
+	// Size is the file's length in bytes, 0 if it isn't known. A known
+	// size lets the server turn away a file that's too large before it's
+	// sent.
+	Size int64
+	// SHA256 is the file's hex encoded SHA-256, checked by the server if
+	// it's set
+	SHA256 string
+	// Profile is the processing profile, the default if ""
+	Profile string
+	// NormalizeLoudness has the file's audio normalized
+	NormalizeLoudness bool
+	// IdempotencyKey makes retries of the upload safe: an upload with the
+	// key of one that succeeded gets its response again instead of being
+	// stored twice
+	IdempotencyKey string
+	// Progress, if set, is called with the number of bytes of the file
+	// sent so far as they're sent
+	Progress func(sent int64)
+}
+
+// UploadResult is the server's response to an upload.
+type UploadResult struct {
+	Message  string `json:"message"`
+	VideoURL string `json:"video_url"`
+	// JobID is the job processing the file, when the server hands uploads
+	// to workers; VideoURL is empty until it's done
+	JobID uuid.UUID `json:"-"`
+}
+
+// Upload streams the file read from r to the video, replacing its file if
+// it had one. The server processes the file before it responds, so this
+// returns once the video is ready to play, unless it hands uploads to
+// workers, in which case it returns the job processing the file.
+func (c *Client) Upload(ctx context.Context, videoID uuid.UUID, r io.Reader, params UploadParams) (UploadResult, error) {
+	if params.MediaType == "" {
+		return UploadResult{}, fmt.Errorf("tubely: the media type of %q isn't set", params.Filename)
+	}
+	fields := map[string]string{}
+	if params.Profile != "" {
+		fields["profile"] = params.Profile
+	}
+	if params.NormalizeLoudness {
+		fields["normalize_loudness"] = "true"
+	}
+	head, tail, contentType, err := multipartEnvelope(fields, "video", params.Filename, params.MediaType)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if params.Progress != nil {
+		r = io.TeeReader(r, &progressWriter{report: params.Progress})
+	}
+	size := int64(-1)
+	if params.Size > 0 {
+		size = int64(len(head)) + params.Size + int64(len(tail))
+	}
+	header := http.Header{}
+	if params.SHA256 != "" {
+		header.Set("X-Content-SHA256", params.SHA256)
+	}
+	if params.IdempotencyKey != "" {
+		header.Set("Idempotency-Key", params.IdempotencyKey)
+	}
+
+	body := io.MultiReader(bytes.NewReader(head), r, bytes.NewReader(tail))
+	resp, err := c.do(ctx, http.MethodPost, "/api/video_upload/"+videoID.String(), body, size, contentType, header)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer resp.Body.Close()
+	var result UploadResult
+	if resp.StatusCode == http.StatusAccepted {
+		var job struct {
+			ID uuid.UUID `json:"id"`
+		}
+		err := decodeJSON(resp, &job)
+		result.JobID = job.ID
+		return result, err
+	}
+	return result, decodeJSON(resp, &result)
+}
+
+// UploadFile uploads the file at path to the video. The parameters'
+// filename, media type, size and SHA-256 are filled in from the file when
+// they're unset.
+func (c *Client) UploadFile(ctx context.Context, videoID uuid.UUID, path string, params UploadParams) (UploadResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer file.Close()
+	if err := fillFileParams(file, &params); err != nil {
+		return UploadResult{}, err
+	}
+	return c.Upload(ctx, videoID, file, params)
+}
+
+// fillFileParams fills in what params leave unset from file, leaving it
+// at its start.
+func fillFileParams(file *os.File, params *UploadParams) error {
+	if params.Filename == "" {
+		params.Filename = filepath.Base(file.Name())
+	}
+	if params.MediaType == "" {
+		params.MediaType = MediaTypeByExtension(file.Name())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	params.Size = info.Size()
+	if params.SHA256 == "" {
+		sum, err := HashFile(file)
+		if err != nil {
+			return err
+		}
+		params.SHA256 = sum
+	}
+	return nil
+}
+
+// HashFile returns the hex encoded SHA-256 of the file, which is what
+// UploadParams.SHA256 and Precheck want, leaving it at its start.
+func HashFile(file *os.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PrecheckParams describe a file about to be uploaded, and the video to
+// create for it if the server has it already.
+type PrecheckParams struct {
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	Filename    string `json:"filename,omitempty"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Visibility  string `json:"visibility,omitempty"`
+}
+
+// PrecheckResult says whether the server had the file. If it did, Video
+// is a new video already pointing at it, and there's nothing to upload.
+type PrecheckResult struct {
+	Exists bool   `json:"exists"`
+	Video  *Video `json:"video"`
+}
+
+// Precheck asks the server whether it stores a file with the same SHA-256
+// and size for the user already, to skip uploading it again.
+func (c *Client) Precheck(ctx context.Context, params PrecheckParams) (PrecheckResult, error) {
+	var result PrecheckResult
+	err := c.call(ctx, http.MethodPost, "/api/videos/precheck", params, &result)
+	return result, err
+}
+
+// multipartEnvelope returns what goes before and after a file in a
+// multipart form with fields and the file as fileField, so the file can
+// be streamed in between with a known Content-Length.
+func multipartEnvelope(fields map[string]string, fileField, filename, mediaType string) (head, tail []byte, contentType string, err error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     fileField,
+		"filename": filename,
+	}))
+	partHeader.Set("Content-Type", mediaType)
+	if _, err := mw.CreatePart(partHeader); err != nil {
+		return nil, nil, "", err
+	}
+	headLen := buf.Len()
+	if err := mw.Close(); err != nil {
+		return nil, nil, "", err
+	}
+	data := buf.Bytes()
+	return data[:headLen], data[headLen:], mw.FormDataContentType(), nil
+}
+
+// progressWriter reports the running total of the bytes written to it.
+type progressWriter struct {
+	sent   int64
+	report func(sent int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.sent += int64(len(b))
+	p.report(p.sent)
+	return len(b), nil
+}
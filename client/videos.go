@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Video is a video as the API returns it. Fields the server doesn't know
+// yet, such as the size of a video without a file, are nil.
+type Video struct {
+	ID              uuid.UUID `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	UserID          uuid.UUID `json:"user_id"`
+	Title           string    `json:"title"`
+	Description     string    `json:"description"`
+	Visibility      string    `json:"visibility"`
+	DefaultLanguage string    `json:"default_language"`
+	ThumbnailURL    *string   `json:"thumbnail_url"`
+	// ThumbnailBlurhash is a BlurHash of the thumbnail to show while it
+	// loads
+	ThumbnailBlurhash *string `json:"thumbnail_blurhash"`
+	// VideoURL is where the video's file plays from, nil until one is
+	// uploaded
+	VideoURL *string `json:"video_url"`
+	// MediaKind is "video", or "audio" for audio files
+	MediaKind string `json:"media_kind"`
+	Width     *int   `json:"width"`
+	Height    *int   `json:"height"`
+	// Duration is in seconds
+	Duration         *float64   `json:"duration"`
+	ReadyAt          *time.Time `json:"ready_at"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	OriginalFilename string     `json:"original_filename"`
+	ViewCount        int64      `json:"view_count"`
+	Version          int        `json:"version"`
+	ModerationStatus string     `json:"moderation_status"`
+}
+
+// CreateVideoParams describe a new video. Visibility defaults to the
+// server's, or the user's organization's, default.
+type CreateVideoParams struct {
+	Title           string   `json:"title"`
+	Description     string   `json:"description,omitempty"`
+	Visibility      string   `json:"visibility,omitempty"`
+	DefaultLanguage string   `json:"default_language,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+}
+
+// CreateVideo creates a video without a file, to upload one to.
+func (c *Client) CreateVideo(ctx context.Context, params CreateVideoParams) (Video, error) {
+	var video Video
+	err := c.call(ctx, http.MethodPost, "/api/videos", params, &video)
+	return video, err
+}
+
+// GetVideo returns a video the user can see.
+func (c *Client) GetVideo(ctx context.Context, id uuid.UUID) (Video, error) {
+	var video Video
+	err := c.call(ctx, http.MethodGet, "/api/videos/"+id.String(), nil, &video)
+	return video, err
+}
+
+// ListVideosParams select a page of videos. The zero value is the first
+// page of the user's own videos.
+type ListVideosParams struct {
+	// UserID lists another user's public videos instead
+	UserID uuid.UUID
+	// Tag only lists videos with the tag
+	Tag string
+	// Limit is the page size, the server's default if zero
+	Limit int
+	// Cursor is the NextCursor of the page before
+	Cursor string
+}
+
+// VideosPage is one page of videos, newest first.
+type VideosPage struct {
+	Videos []Video
+	// NextCursor fetches the next page, "" on the last one
+	NextCursor string
+}
+
+// ListVideos returns a page of videos.
+func (c *Client) ListVideos(ctx context.Context, params ListVideosParams) (VideosPage, error) {
+	query := url.Values{}
+	if params.UserID != uuid.Nil {
+		query.Set("user_id", params.UserID.String())
+	}
+	if params.Tag != "" {
+		query.Set("tag", params.Tag)
+	}
+	if params.Limit > 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Cursor != "" {
+		query.Set("cursor", params.Cursor)
+	}
+	path := "/api/videos"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, path, nil, 0, "", nil)
+	if err != nil {
+		return VideosPage{}, err
+	}
+	defer resp.Body.Close()
+	page := VideosPage{NextCursor: resp.Header.Get("X-Next-Cursor")}
+	if err := decodeJSON(resp, &page.Videos); err != nil {
+		return VideosPage{}, err
+	}
+	return page, nil
+}
+
+// UpdateVideoParams change a video's details. Nil fields are left as they
+// are.
+type UpdateVideoParams struct {
+	Title           *string `json:"title,omitempty"`
+	Description     *string `json:"description,omitempty"`
+	Visibility      *string `json:"visibility,omitempty"`
+	DefaultLanguage *string `json:"default_language,omitempty"`
+}
+
+// UpdateVideo changes a video's details, returning the video as updated.
+func (c *Client) UpdateVideo(ctx context.Context, id uuid.UUID, params UpdateVideoParams) (Video, error) {
+	var video Video
+	err := c.call(ctx, http.MethodPatch, "/api/videos/"+id.String(), params, &video)
+	return video, err
+}
+
+// DeleteVideo deletes a video along with its files.
+func (c *Client) DeleteVideo(ctx context.Context, id uuid.UUID) error {
+	return c.call(ctx, http.MethodDelete, "/api/videos/"+id.String(), nil, nil)
+}
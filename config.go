@@ -0,0 +1,1093 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	"github.com/redis/go-redis/v9"
+)
+
+// parseByteSize parses sizes like "512MB", "2GB" or a plain byte count.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseTempVolumes parses TEMP_VOLUMES, a comma separated list of
+// DIR[:MAX_FILE_SIZE[:CAPACITY]] entries, e.g.
+// "/mnt/nvme:2GB:20GB,/mnt/scratch::500GB". Empty sizes mean no limit.
+// Without a spec the OS temp dir is used with no limits.
+func parseTempVolumes(spec string) ([]tempstore.Volume, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []tempstore.Volume{{Dir: os.TempDir()}}, nil
+	}
+
+	volumes := []tempstore.Volume{}
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid temp volume %q", entry)
+		}
+		vol := tempstore.Volume{Dir: parts[0]}
+		if len(parts) > 1 && parts[1] != "" {
+			size, err := parseByteSize(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("temp volume %s: max file size: %w", vol.Dir, err)
+			}
+			vol.MaxFileSize = size
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			size, err := parseByteSize(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("temp volume %s: capacity: %w", vol.Dir, err)
+			}
+			vol.Capacity = size
+		}
+		volumes = append(volumes, vol)
+	}
+	return volumes, nil
+}
+
+const (
+	defaultDBMaxOpenConns    = 10
+	defaultDBMaxIdleConns    = 5
+	defaultDBConnMaxLifetime = 30 * time.Minute
+)
+
+const (
+	defaultMaxUploadSize   = 1 << 30 // 1 GB
+	defaultMultipartMemory = 32 << 20
+)
+
+// supportedMediaTypes are the content types the pipeline can process, and
+// what's accepted unless ALLOWED_MEDIA_TYPES narrows it.
+var supportedMediaTypes = append([]string{"video/mp4"}, audioUploadTypes...)
+
+// uploadLimits bounds video uploads. Tiers override the deployment-wide
+// maximum for users on that tier.
+type uploadLimits struct {
+	MaxUploadSize     int64
+	MultipartMemory   int64
+	TierMaxUploadSize map[string]int64
+	// MediaTypes are the content types files are accepted as, with the
+	// largest file of each, 0 for no limit beyond the tier's
+	MediaTypes map[string]int64
+}
+
+// maxUploadSizeFor returns the upload cap for a user tier.
+func (l uploadLimits) maxUploadSizeFor(tier string) int64 {
+	if size, ok := l.TierMaxUploadSize[tier]; ok {
+		return size
+	}
+	return l.MaxUploadSize
+}
+
+// maxUploadSizeForType returns the upload cap for a file of mediaType from
+// a user on tier, and false if that type isn't accepted at all.
+func (l uploadLimits) maxUploadSizeForType(tier, mediaType string) (int64, bool) {
+	typeLimit, ok := l.MediaTypes[mediaType]
+	if !ok {
+		return 0, false
+	}
+	limit := l.maxUploadSizeFor(tier)
+	if typeLimit > 0 {
+		limit = min(limit, typeLimit)
+	}
+	return limit, true
+}
+
+// acceptedMediaTypes lists the accepted content types, for error messages.
+func (l uploadLimits) acceptedMediaTypes() string {
+	return strings.Join(slices.Sorted(maps.Keys(l.MediaTypes)), ", ")
+}
+
+// parseUploadLimits reads MAX_UPLOAD_SIZE, MULTIPART_MEMORY,
+// UPLOAD_TIER_LIMITS (comma separated TIER:SIZE pairs, e.g.
+// "free:1GB,pro:10GB") and ALLOWED_MEDIA_TYPES (comma separated types,
+// each with an optional maximum size, e.g. "video/mp4:5GB,audio/mpeg").
+// Unset values keep the defaults.
+func parseUploadLimits(maxUploadSize, multipartMemory, tierLimits, mediaTypes string) (uploadLimits, error) {
+	limits := uploadLimits{
+		MaxUploadSize:   defaultMaxUploadSize,
+		MultipartMemory: defaultMultipartMemory,
+	}
+	if maxUploadSize != "" {
+		size, err := parseByteSize(maxUploadSize)
+		if err != nil {
+			return uploadLimits{}, fmt.Errorf("MAX_UPLOAD_SIZE: %w", err)
+		}
+		limits.MaxUploadSize = size
+	}
+	if multipartMemory != "" {
+		size, err := parseByteSize(multipartMemory)
+		if err != nil {
+			return uploadLimits{}, fmt.Errorf("MULTIPART_MEMORY: %w", err)
+		}
+		limits.MultipartMemory = size
+	}
+	tiers, err := parseTierSizes(tierLimits)
+	if err != nil {
+		return uploadLimits{}, fmt.Errorf("UPLOAD_TIER_LIMITS: %w", err)
+	}
+	limits.TierMaxUploadSize = tiers
+	limits.MediaTypes, err = parseMediaTypes(mediaTypes)
+	if err != nil {
+		return uploadLimits{}, fmt.Errorf("ALLOWED_MEDIA_TYPES: %w", err)
+	}
+	return limits, nil
+}
+
+// parseMediaTypes parses comma separated TYPE[:SIZE] entries, each of
+// which has to be a type the pipeline supports. An empty spec accepts
+// every supported type.
+func parseMediaTypes(spec string) (map[string]int64, error) {
+	types := map[string]int64{}
+	if strings.TrimSpace(spec) == "" {
+		for _, mediaType := range supportedMediaTypes {
+			types[mediaType] = 0
+		}
+		return types, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		mediaType, sizeString, hasSize := strings.Cut(strings.TrimSpace(entry), ":")
+		mediaType = strings.ToLower(mediaType)
+		if !slices.Contains(supportedMediaTypes, mediaType) {
+			return nil, fmt.Errorf("%q isn't a type Tubely can process, it supports %s", mediaType, strings.Join(supportedMediaTypes, ", "))
+		}
+		var size int64
+		if hasSize {
+			var err error
+			size, err = parseByteSize(sizeString)
+			if err != nil {
+				return nil, fmt.Errorf("type %s: %w", mediaType, err)
+			}
+		}
+		types[mediaType] = size
+	}
+	return types, nil
+}
+
+// parseTierSizes parses comma separated TIER:SIZE pairs.
+func parseTierSizes(spec string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	if strings.TrimSpace(spec) == "" {
+		return sizes, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		tier, sizeString, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || tier == "" {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		size, err := parseByteSize(sizeString)
+		if err != nil {
+			return nil, fmt.Errorf("tier %s: %w", tier, err)
+		}
+		sizes[tier] = size
+	}
+	return sizes, nil
+}
+
+// storageQuota caps how much a user can store. Uploads are refused once
+// they'd go past Limit+Grace; between Limit and that, they still succeed
+// so work in progress isn't cut off, but the user has been warned.
+type storageQuota struct {
+	Limit int64
+	Grace int64
+}
+
+// parseStorageQuotas reads STORAGE_TIER_QUOTAS and STORAGE_TIER_GRACE, both
+// comma separated TIER:SIZE pairs. Tiers without a quota are unlimited.
+func parseStorageQuotas(quotaSpec, graceSpec string) (map[string]storageQuota, error) {
+	limits, err := parseTierSizes(quotaSpec)
+	if err != nil {
+		return nil, fmt.Errorf("STORAGE_TIER_QUOTAS: %w", err)
+	}
+	grace, err := parseTierSizes(graceSpec)
+	if err != nil {
+		return nil, fmt.Errorf("STORAGE_TIER_GRACE: %w", err)
+	}
+	quotas := map[string]storageQuota{}
+	for tier, limit := range limits {
+		quotas[tier] = storageQuota{Limit: limit, Grace: grace[tier]}
+	}
+	for tier := range grace {
+		if _, ok := limits[tier]; !ok {
+			return nil, fmt.Errorf("STORAGE_TIER_GRACE: tier %s has no quota", tier)
+		}
+	}
+	return quotas, nil
+}
+
+// parseAdminEmails parses ADMIN_EMAILS, a comma separated list of the
+// accounts that get the admin role.
+func parseAdminEmails(spec string) []string {
+	return splitList(spec)
+}
+
+// parseS3ImportBuckets parses S3_IMPORT_BUCKETS, a comma separated list of
+// the buckets users may import videos from. Tubely's own buckets hold
+// other users' videos, so they can't be among them.
+func parseS3ImportBuckets(spec string, ownBuckets []string) ([]string, error) {
+	buckets := splitList(spec)
+	for _, bucket := range buckets {
+		if slices.Contains(ownBuckets, bucket) {
+			return nil, fmt.Errorf("S3_IMPORT_BUCKETS can't include %s, which videos are stored in", bucket)
+		}
+	}
+	return buckets, nil
+}
+
+const (
+	// shardByUser spreads users over the buckets by a hash of their ID
+	shardByUser = "user"
+	// shardByRegion stores a user's videos in a bucket in the storage
+	// region an admin set for them
+	shardByRegion = "region"
+)
+
+// storageBucket is one of the buckets videos are stored in.
+type storageBucket struct {
+	Name   string
+	Region string
+}
+
+// bucketSettings are the buckets videos are stored in and how new videos
+// are spread across them.
+type bucketSettings struct {
+	// Buckets are S3_BUCKET, the default, followed by S3_BUCKETS
+	Buckets []storageBucket
+	// Sharding is shardByUser or shardByRegion, "" with only one bucket
+	Sharding string
+}
+
+// parseBucketSettings parses S3_BUCKETS, a comma separated list of more
+// buckets to store videos in, each a name in S3_REGION or name:region,
+// and S3_SHARDING, user (the default) or region.
+func parseBucketSettings(defaultBucket, defaultRegion, spec, sharding string) (bucketSettings, error) {
+	settings := bucketSettings{Buckets: []storageBucket{{Name: defaultBucket, Region: defaultRegion}}}
+	for _, item := range splitList(spec) {
+		name, region, _ := strings.Cut(item, ":")
+		name, region = strings.TrimSpace(name), strings.TrimSpace(region)
+		if name == "" {
+			return bucketSettings{}, fmt.Errorf("S3_BUCKETS entry %q has no bucket name", item)
+		}
+		if region == "" {
+			region = defaultRegion
+		}
+		if slices.Contains(settings.names(), name) {
+			return bucketSettings{}, fmt.Errorf("S3_BUCKETS lists %s twice, or along with S3_BUCKET", name)
+		}
+		settings.Buckets = append(settings.Buckets, storageBucket{Name: name, Region: region})
+	}
+
+	switch sharding {
+	case "":
+		if len(settings.Buckets) > 1 {
+			settings.Sharding = shardByUser
+		}
+	case shardByUser, shardByRegion:
+		settings.Sharding = sharding
+	default:
+		return bucketSettings{}, fmt.Errorf("S3_SHARDING must be %s or %s, got %q", shardByUser, shardByRegion, sharding)
+	}
+	return settings, nil
+}
+
+// names returns the names of the buckets, the default first.
+func (s bucketSettings) names() []string {
+	names := make([]string, len(s.Buckets))
+	for i, bucket := range s.Buckets {
+		names[i] = bucket.Name
+	}
+	return names
+}
+
+const (
+	// fixityChecksum compares the checksum S3 stored with the object
+	fixityChecksum = "checksum"
+	// fixityHash downloads the object and hashes it
+	fixityHash = "hash"
+)
+
+// fixitySettings control the scheduled re-verification of stored objects.
+type fixitySettings struct {
+	// Interval is how often each object is checked, zero turns checks off
+	Interval time.Duration
+	Mode     string
+}
+
+// parseFixitySettings parses FIXITY_INTERVAL, a duration such as 720h,
+// and FIXITY_MODE, checksum (the default) or hash.
+func parseFixitySettings(interval, mode string) (fixitySettings, error) {
+	settings := fixitySettings{Mode: fixityChecksum}
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < time.Hour {
+			return fixitySettings{}, fmt.Errorf("FIXITY_INTERVAL must be a duration of at least 1h, got %q", interval)
+		}
+		settings.Interval = d
+	}
+	switch mode {
+	case "":
+	case fixityChecksum, fixityHash:
+		settings.Mode = mode
+	default:
+		return fixitySettings{}, fmt.Errorf("FIXITY_MODE must be %s or %s, got %q", fixityChecksum, fixityHash, mode)
+	}
+	return settings, nil
+}
+
+// parseOrphanSettings parses ORPHAN_SCAN_INTERVAL, a duration such as 24h,
+// and ORPHAN_SCAN_MODE, report (the default) or delete.
+func parseOrphanSettings(interval, mode string) (orphanSettings, error) {
+	settings := orphanSettings{Mode: orphanReport}
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < time.Hour {
+			return orphanSettings{}, fmt.Errorf("ORPHAN_SCAN_INTERVAL must be a duration of at least 1h, got %q", interval)
+		}
+		settings.Interval = d
+	}
+	switch mode {
+	case "":
+	case orphanReport, orphanDelete:
+		settings.Mode = mode
+	default:
+		return orphanSettings{}, fmt.Errorf("ORPHAN_SCAN_MODE must be %s or %s, got %q", orphanReport, orphanDelete, mode)
+	}
+	return settings, nil
+}
+
+// parseTransferAcceleration parses S3_TRANSFER_ACCELERATION, true to send
+// uploads and client downloads through the buckets' Transfer Acceleration
+// endpoints. Buckets with dots in their names can't use it.
+func parseTransferAcceleration(spec string, buckets []string) (bool, error) {
+	if spec == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(spec)
+	if err != nil {
+		return false, fmt.Errorf("S3_TRANSFER_ACCELERATION must be true or false, got %q", spec)
+	}
+	for _, bucket := range buckets {
+		if on && strings.Contains(bucket, ".") {
+			return false, fmt.Errorf("bucket %s has dots in its name, which Transfer Acceleration doesn't support", bucket)
+		}
+	}
+	return on, nil
+}
+
+const (
+	defaultS3UploadPartSize          = 64 << 20
+	minS3UploadPartSize              = 5 << 20 // S3's minimum, except for the last part
+	defaultS3UploadConcurrency       = 4
+	defaultS3UploadGlobalConcurrency = 16
+)
+
+// s3UploadSettings control how files are sent to S3. Files larger than a
+// part are sent as multipart uploads, parts in parallel.
+type s3UploadSettings struct {
+	PartSize int64
+	// Concurrency is how many parts of one upload are sent at once
+	Concurrency int
+	// GlobalConcurrency is how many requests sending files to S3 run at
+	// once across every upload
+	GlobalConcurrency int
+	// Bandwidth caps each upload and GlobalBandwidth all of them
+	// together, in bytes per second; zero means no cap
+	Bandwidth       int64
+	GlobalBandwidth int64
+}
+
+// parseS3UploadSettings parses S3_UPLOAD_PART_SIZE, S3_UPLOAD_CONCURRENCY,
+// S3_UPLOAD_GLOBAL_CONCURRENCY and the bandwidth caps S3_UPLOAD_BANDWIDTH
+// and S3_UPLOAD_GLOBAL_BANDWIDTH, sizes per second such as 20MB.
+func parseS3UploadSettings(partSize, concurrency, globalConcurrency, bandwidth, globalBandwidth string) (s3UploadSettings, error) {
+	settings := s3UploadSettings{
+		PartSize:          defaultS3UploadPartSize,
+		Concurrency:       defaultS3UploadConcurrency,
+		GlobalConcurrency: defaultS3UploadGlobalConcurrency,
+	}
+	if partSize != "" {
+		size, err := parseByteSize(partSize)
+		if err != nil || size < minS3UploadPartSize || size > maxSingleCopySize {
+			return s3UploadSettings{}, fmt.Errorf("S3_UPLOAD_PART_SIZE must be a size from 5MB to 5GB, got %q", partSize)
+		}
+		settings.PartSize = size
+	}
+	if concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n < 1 {
+			return s3UploadSettings{}, fmt.Errorf("S3_UPLOAD_CONCURRENCY must be a whole number of at least 1, got %q", concurrency)
+		}
+		settings.Concurrency = n
+	}
+	if globalConcurrency != "" {
+		n, err := strconv.Atoi(globalConcurrency)
+		if err != nil || n < 1 {
+			return s3UploadSettings{}, fmt.Errorf("S3_UPLOAD_GLOBAL_CONCURRENCY must be a whole number of at least 1, got %q", globalConcurrency)
+		}
+		settings.GlobalConcurrency = n
+	}
+	if bandwidth != "" {
+		rate, err := parseByteSize(bandwidth)
+		if err != nil {
+			return s3UploadSettings{}, fmt.Errorf("S3_UPLOAD_BANDWIDTH must be a size per second such as 20MB, got %q", bandwidth)
+		}
+		settings.Bandwidth = rate
+	}
+	if globalBandwidth != "" {
+		rate, err := parseByteSize(globalBandwidth)
+		if err != nil {
+			return s3UploadSettings{}, fmt.Errorf("S3_UPLOAD_GLOBAL_BANDWIDTH must be a size per second such as 100MB, got %q", globalBandwidth)
+		}
+		settings.GlobalBandwidth = rate
+	}
+	return settings, nil
+}
+
+const defaultProbeTimeout = 30 * time.Second
+
+// parseMediaTools returns the binaries to run, looked up on PATH by
+// default, and how long ffprobe may take.
+func parseMediaTools(ffprobe, ffmpeg, probeTimeout string) (mediaTools, error) {
+	tools := mediaTools{FFprobe: ffprobe, FFmpeg: ffmpeg, ProbeTimeout: defaultProbeTimeout}
+	if tools.FFprobe == "" {
+		tools.FFprobe = "ffprobe"
+	}
+	if tools.FFmpeg == "" {
+		tools.FFmpeg = "ffmpeg"
+	}
+	if probeTimeout != "" {
+		d, err := time.ParseDuration(probeTimeout)
+		if err != nil || d <= 0 {
+			return mediaTools{}, fmt.Errorf("FFPROBE_TIMEOUT must be a positive duration such as 30s, got %q", probeTimeout)
+		}
+		tools.ProbeTimeout = d
+	}
+	return tools, nil
+}
+
+// parseTranscription parses TRANSCRIPTION, whisper, aws or unset for no
+// transcription, along with WHISPER_PATH, WHISPER_MODEL and
+// TRANSCRIPTION_LANGUAGE.
+func parseTranscription(provider, whisperPath, whisperModel, language string) (transcriptionSettings, error) {
+	settings := transcriptionSettings{Provider: provider, WhisperPath: whisperPath, WhisperModel: whisperModel}
+	switch provider {
+	case "", transcriptionWhisper, transcriptionAWS:
+	default:
+		return transcriptionSettings{}, fmt.Errorf("TRANSCRIPTION must be %s or %s, got %q", transcriptionWhisper, transcriptionAWS, provider)
+	}
+	if settings.WhisperPath == "" {
+		settings.WhisperPath = "whisper"
+	}
+	if settings.WhisperModel == "" {
+		settings.WhisperModel = "base"
+	}
+	if language != "" {
+		normalized, err := normalizeLanguage(language)
+		if err != nil {
+			return transcriptionSettings{}, fmt.Errorf("TRANSCRIPTION_LANGUAGE must be a language tag like en or en-us, got %q", language)
+		}
+		settings.Language = normalized
+	}
+	return settings, nil
+}
+
+const (
+	// codecPolicyTranscode re-encodes videos browsers can't play
+	codecPolicyTranscode = "transcode"
+	// codecPolicyReject refuses them
+	codecPolicyReject = "reject"
+)
+
+// parseCodecPolicy parses INCOMPATIBLE_CODECS, transcode by default or
+// reject.
+func parseCodecPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return codecPolicyTranscode, nil
+	case codecPolicyTranscode, codecPolicyReject:
+		return policy, nil
+	}
+	return "", fmt.Errorf("INCOMPATIBLE_CODECS must be %s or %s, got %q", codecPolicyTranscode, codecPolicyReject, policy)
+}
+
+// parseVirusScan parses VIRUS_SCAN, clamav to scan uploads with clamd or
+// unset for no scanning.
+func parseVirusScan(provider string) (string, error) {
+	switch provider {
+	case "", virusScanClamAV:
+		return provider, nil
+	}
+	return "", fmt.Errorf("VIRUS_SCAN must be %s or unset, got %q", virusScanClamAV, provider)
+}
+
+// parseModeration parses MODERATION, rekognition, command or unset for no
+// moderation, along with MODERATION_COMMAND and MODERATION_MIN_CONFIDENCE.
+func parseModeration(provider, command, minConfidence string) (moderationSettings, error) {
+	settings := moderationSettings{Provider: provider, Command: command, MinConfidence: defaultModerationMinConfidence}
+	switch provider {
+	case "", moderationRekognition:
+	case moderationCommand:
+		if command == "" {
+			return moderationSettings{}, errors.New("MODERATION_COMMAND is required with MODERATION=command")
+		}
+	default:
+		return moderationSettings{}, fmt.Errorf("MODERATION must be %s or %s, got %q", moderationRekognition, moderationCommand, provider)
+	}
+	if minConfidence != "" {
+		n, err := strconv.ParseFloat(minConfidence, 64)
+		if err != nil || n < 0 || n > 100 {
+			return moderationSettings{}, fmt.Errorf("MODERATION_MIN_CONFIDENCE must be a number from 0 to 100, got %q", minConfidence)
+		}
+		settings.MinConfidence = n
+	}
+	return settings, nil
+}
+
+// parsePoolSettings parses DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME, which size the Postgres connection pool.
+func parsePoolSettings(maxOpen, maxIdle, maxLifetime string) (database.PoolSettings, error) {
+	pool := database.PoolSettings{
+		MaxOpenConns:    defaultDBMaxOpenConns,
+		MaxIdleConns:    defaultDBMaxIdleConns,
+		ConnMaxLifetime: defaultDBConnMaxLifetime,
+	}
+	if maxOpen != "" {
+		n, err := strconv.Atoi(maxOpen)
+		if err != nil || n < 1 {
+			return database.PoolSettings{}, fmt.Errorf("DB_MAX_OPEN_CONNS must be a whole number of at least 1, got %q", maxOpen)
+		}
+		pool.MaxOpenConns = n
+	}
+	if maxIdle != "" {
+		n, err := strconv.Atoi(maxIdle)
+		if err != nil || n < 0 {
+			return database.PoolSettings{}, fmt.Errorf("DB_MAX_IDLE_CONNS must be a whole number, got %q", maxIdle)
+		}
+		pool.MaxIdleConns = n
+	}
+	pool.MaxIdleConns = min(pool.MaxIdleConns, pool.MaxOpenConns)
+	if maxLifetime != "" {
+		d, err := time.ParseDuration(maxLifetime)
+		if err != nil || d <= 0 {
+			return database.PoolSettings{}, fmt.Errorf("DB_CONN_MAX_LIFETIME must be a positive duration such as 30m, got %q", maxLifetime)
+		}
+		pool.ConnMaxLifetime = d
+	}
+	return pool, nil
+}
+
+// parseShutdownTimeout parses SHUTDOWN_TIMEOUT, how long to wait for
+// in-flight requests when the server is stopped.
+func parseShutdownTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultShutdownTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("SHUTDOWN_TIMEOUT must be a positive duration such as 2m, got %q", s)
+	}
+	return d, nil
+}
+
+const (
+	// deliveryDirect gives players the S3 URL of public and unlisted videos,
+	// which needs a publicly readable bucket
+	deliveryDirect = "direct"
+	// deliveryProxy streams them through the API, so the bucket can be private
+	deliveryProxy = "proxy"
+)
+
+// parseVideoVersionLimit parses VIDEO_VERSION_LIMIT, how many versions of
+// each video are kept, counting the current one.
+func parseVideoVersionLimit(spec string) (int, error) {
+	if spec == "" {
+		return defaultVideoVersionLimit, nil
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("VIDEO_VERSION_LIMIT must be a whole number of at least 1, got %q", spec)
+	}
+	return n, nil
+}
+
+// parseVideoDelivery parses VIDEO_DELIVERY, direct (the default) or proxy.
+func parseVideoDelivery(spec string) (string, error) {
+	switch spec {
+	case "":
+		return deliveryDirect, nil
+	case deliveryDirect, deliveryProxy:
+		return spec, nil
+	}
+	return "", fmt.Errorf("VIDEO_DELIVERY must be %s or %s, got %q", deliveryDirect, deliveryProxy, spec)
+}
+
+// parseCDNDistributionID parses CLOUDFRONT_DISTRIBUTION_ID, the
+// distribution whose cache is invalidated when objects are replaced or
+// deleted. "" turns invalidation off.
+func parseCDNDistributionID(spec string) (string, error) {
+	if strings.Trim(spec, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+		return "", fmt.Errorf("CLOUDFRONT_DISTRIBUTION_ID must be a distribution ID like E2QWRUHAPOMQZL, got %q", spec)
+	}
+	return spec, nil
+}
+
+// parsePublicURL parses PUBLIC_URL, where browsers reach the server. It
+// defaults to localhost.
+func parsePublicURL(spec, port string) (string, error) {
+	if spec == "" {
+		return "http://localhost:" + port, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("PUBLIC_URL must be an http or https URL, got %q", spec)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// hotlinkSettings keep other sites from embedding videos wholesale.
+type hotlinkSettings struct {
+	// AllowedOrigins are the origins, like https://tubely.dev, whose pages
+	// may play videos through the server; none turns the check off
+	AllowedOrigins []string
+	// AllowNoReferer lets through requests that name no page, such as
+	// direct visits, apps and browsers that don't send a referer
+	AllowNoReferer bool
+	// URLExpiry caps how long the video URLs handed to clients last, zero
+	// for the defaults
+	URLExpiry time.Duration
+}
+
+// parseHotlinkSettings parses HOTLINK_ALLOWED_ORIGINS, a comma separated
+// list of origins, HOTLINK_ALLOW_NO_REFERER, true by default, and
+// HOTLINK_URL_EXPIRY, a duration such as 5m. The server's own origin,
+// from publicURL, is always allowed.
+func parseHotlinkSettings(origins, allowNoReferer, urlExpiry, publicURL string) (hotlinkSettings, error) {
+	settings := hotlinkSettings{AllowNoReferer: true}
+	if origins != "" {
+		self, err := url.Parse(publicURL)
+		if err != nil {
+			return hotlinkSettings{}, err
+		}
+		settings.AllowedOrigins = []string{self.Scheme + "://" + self.Host}
+		for _, origin := range strings.Split(origins, ",") {
+			origin = strings.TrimSpace(origin)
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+				return hotlinkSettings{}, fmt.Errorf("HOTLINK_ALLOWED_ORIGINS must list origins like https://example.com, got %q", origin)
+			}
+			settings.AllowedOrigins = append(settings.AllowedOrigins, u.Scheme+"://"+u.Host)
+		}
+	}
+	if allowNoReferer != "" {
+		allow, err := strconv.ParseBool(allowNoReferer)
+		if err != nil {
+			return hotlinkSettings{}, fmt.Errorf("HOTLINK_ALLOW_NO_REFERER must be true or false, got %q", allowNoReferer)
+		}
+		settings.AllowNoReferer = allow
+	}
+	if urlExpiry != "" {
+		d, err := time.ParseDuration(urlExpiry)
+		if err != nil || d < time.Minute || d > maxPlaybackExpiry {
+			return hotlinkSettings{}, fmt.Errorf("HOTLINK_URL_EXPIRY must be a duration between 1m and %s, got %q", maxPlaybackExpiry, urlExpiry)
+		}
+		settings.URLExpiry = d
+	}
+	return settings, nil
+}
+
+// corsSettings let browser apps served from other origins call the API.
+type corsSettings struct {
+	// AllowedOrigins are the origins, like https://app.tubely.dev, whose
+	// pages may call the API, or just "*" for any; none turns CORS off
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders are what a cross-origin request
+	// may use
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers its page may read
+	ExposedHeaders []string
+}
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "Range", "If-None-Match", "Idempotency-Key", "Api-Version", requestIDHeader, videoAccessHeader}
+	// defaultCORSExposedHeaders are every header the API sets that clients
+	// act on, like the job URL in Location after an upload
+	defaultCORSExposedHeaders = []string{
+		requestIDHeader, "Location", "Retry-After", "ETag", "Idempotent-Replayed", "X-Next-Cursor",
+		"Api-Version", "Deprecation", "Sunset",
+		"X-Impersonated-By", "X-Impersonated-User", "X-Impersonation-Expires",
+	}
+)
+
+// parseTrustedProxies parses TRUSTED_PROXIES, a comma separated list of
+// IP addresses or CIDR ranges of the proxies in front of the server.
+func parseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	proxies := []netip.Prefix{}
+	if strings.TrimSpace(spec) == "" {
+		return proxies, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES must list IP addresses or CIDR ranges, got %q", entry)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// parseCORSSettings parses CORS_ALLOWED_ORIGINS, a comma separated list
+// of origins or "*", and CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS and
+// CORS_EXPOSED_HEADERS, comma separated lists that replace the defaults.
+func parseCORSSettings(origins, methods, headers, exposedHeaders string) (corsSettings, error) {
+	if origins == "" {
+		return corsSettings{}, nil
+	}
+	settings := corsSettings{
+		AllowedMethods: defaultCORSMethods,
+		AllowedHeaders: defaultCORSHeaders,
+		ExposedHeaders: defaultCORSExposedHeaders,
+	}
+	if strings.TrimSpace(origins) == "*" {
+		settings.AllowedOrigins = []string{"*"}
+	} else {
+		for _, origin := range strings.Split(origins, ",") {
+			origin = strings.TrimSpace(origin)
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+				return corsSettings{}, fmt.Errorf("CORS_ALLOWED_ORIGINS must be * or list origins like https://example.com, got %q", origin)
+			}
+			settings.AllowedOrigins = append(settings.AllowedOrigins, u.Scheme+"://"+u.Host)
+		}
+	}
+	if methods != "" {
+		settings.AllowedMethods = nil
+		for _, method := range strings.Split(methods, ",") {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if !validHeaderToken(method) {
+				return corsSettings{}, fmt.Errorf("CORS_ALLOWED_METHODS must list HTTP methods, got %q", method)
+			}
+			settings.AllowedMethods = append(settings.AllowedMethods, method)
+		}
+	}
+	for _, list := range []struct {
+		name string
+		spec string
+		dest *[]string
+	}{
+		{"CORS_ALLOWED_HEADERS", headers, &settings.AllowedHeaders},
+		{"CORS_EXPOSED_HEADERS", exposedHeaders, &settings.ExposedHeaders},
+	} {
+		if list.spec == "" {
+			continue
+		}
+		*list.dest = nil
+		for _, header := range strings.Split(list.spec, ",") {
+			header = strings.TrimSpace(header)
+			if !validHeaderToken(header) {
+				return corsSettings{}, fmt.Errorf("%s must list header names, got %q", list.name, header)
+			}
+			*list.dest = append(*list.dest, http.CanonicalHeaderKey(header))
+		}
+	}
+	return settings, nil
+}
+
+// validHeaderToken reports whether s can be a header name or method.
+func validHeaderToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOAuthProviders sets up the OAuth login providers that have a client
+// ID in OAUTH_<PROVIDER>_CLIENT_ID and OAUTH_<PROVIDER>_CLIENT_SECRET.
+// Their callbacks are under publicURL.
+func parseOAuthProviders(getenv func(string) string, publicURL string) (map[string]*auth.OAuthProvider, error) {
+	constructors := map[string]func(clientID, clientSecret, redirectURL string) *auth.OAuthProvider{
+		"google": auth.NewGoogleProvider,
+		"github": auth.NewGitHubProvider,
+	}
+	providers := map[string]*auth.OAuthProvider{}
+	for name, newProvider := range constructors {
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+		clientID := strings.TrimSpace(getenv(prefix + "CLIENT_ID"))
+		clientSecret := strings.TrimSpace(getenv(prefix + "CLIENT_SECRET"))
+		if clientID == "" && clientSecret == "" {
+			continue
+		}
+		if clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("%sCLIENT_ID and %sCLIENT_SECRET must be set together", prefix, prefix)
+		}
+		providers[name] = newProvider(clientID, clientSecret, publicURL+"/api/v1/oauth/"+name+"/callback")
+	}
+	return providers, nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(spec string) []string {
+	items := []string{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// watchFolderSettings control ingesting files dropped into a directory.
+type watchFolderSettings struct {
+	// Dir is the directory watched, "" turns watching off
+	Dir string
+	// Owner is the email of the user the videos are created for
+	Owner    string
+	Interval time.Duration
+}
+
+const defaultWatchFolderInterval = 30 * time.Second
+
+// parseWatchFolder parses WATCH_FOLDER, the directory to ingest files
+// from, WATCH_FOLDER_OWNER, the email of the user who owns what's
+// ingested, and WATCH_FOLDER_INTERVAL, how often the directory is scanned.
+// Without WATCH_FOLDER nothing is watched.
+func parseWatchFolder(dir, owner, interval string) (watchFolderSettings, error) {
+	if dir == "" {
+		return watchFolderSettings{}, nil
+	}
+	settings := watchFolderSettings{Dir: dir, Owner: owner, Interval: defaultWatchFolderInterval}
+	if owner == "" {
+		return watchFolderSettings{}, errors.New("WATCH_FOLDER_OWNER is required with WATCH_FOLDER")
+	}
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < time.Second {
+			return watchFolderSettings{}, fmt.Errorf("WATCH_FOLDER_INTERVAL must be a duration of at least 1s, got %q", interval)
+		}
+		settings.Interval = d
+	}
+	return settings, nil
+}
+
+// jobQueueSettings is where jobs are sent for worker processes to run,
+// rather than running them in the process that starts them.
+type jobQueueSettings struct {
+	// URL is the SQS queue's URL, or the Redis server's, empty to run
+	// jobs in process
+	URL string
+	// Backend is jobQueueSQS or jobQueueRedis, going by the URL's scheme
+	Backend string
+	// Endpoint is where the queue's API is called, the URL's scheme and
+	// host, so a local SQS emulator can stand in
+	Endpoint string
+	Region   string
+	// Redis is the Redis server's connection options
+	Redis *redis.Options
+	// Concurrency is how many jobs a worker runs at once
+	Concurrency int
+}
+
+const (
+	defaultWorkerConcurrency = 2
+
+	jobQueueSQS   = "sqs"
+	jobQueueRedis = "redis"
+)
+
+// parseJobQueueSettings parses JOB_QUEUE_URL, the URL of an SQS queue jobs
+// are sent through, or a redis:// or rediss:// URL to queue them in Redis
+// instead, and WORKER_CONCURRENCY, how many jobs each "tubely worker" runs
+// at once, 1 to 10. An SQS queue's region is taken from its URL, or is
+// defaultRegion for a URL that doesn't name one.
+func parseJobQueueSettings(queueURL, concurrency, defaultRegion string) (jobQueueSettings, error) {
+	settings := jobQueueSettings{Concurrency: defaultWorkerConcurrency}
+	if concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n < 1 || n > 10 {
+			return jobQueueSettings{}, fmt.Errorf("WORKER_CONCURRENCY must be a number from 1 to 10, got %q", concurrency)
+		}
+		settings.Concurrency = n
+	}
+	if queueURL == "" {
+		return settings, nil
+	}
+	if strings.HasPrefix(queueURL, "redis://") || strings.HasPrefix(queueURL, "rediss://") {
+		options, err := redis.ParseURL(queueURL)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// that error repeats the URL, which can hold a password
+			err = urlErr.Err
+		}
+		if err != nil {
+			return jobQueueSettings{}, fmt.Errorf("JOB_QUEUE_URL isn't a valid Redis URL: %w", err)
+		}
+		settings.URL = queueURL
+		settings.Backend = jobQueueRedis
+		settings.Redis = options
+		return settings, nil
+	}
+	u, err := url.Parse(queueURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return jobQueueSettings{}, fmt.Errorf("JOB_QUEUE_URL must be an SQS queue URL such as https://sqs.us-east-1.amazonaws.com/123456789012/tubely-jobs, or a Redis URL such as redis://localhost:6379/0, got %q", queueURL)
+	}
+	settings.URL = queueURL
+	settings.Backend = jobQueueSQS
+	settings.Endpoint = u.Scheme + "://" + u.Host + "/"
+	settings.Region = defaultRegion
+	// sqs.<region>.amazonaws.com
+	if parts := strings.Split(u.Hostname(), "."); len(parts) == 4 && parts[0] == "sqs" && strings.Join(parts[2:], ".") == "amazonaws.com" {
+		settings.Region = parts[1]
+	}
+	return settings, nil
+}
+
+// eventSettings is where video events are published for other services to
+// subscribe to.
+type eventSettings struct {
+	// SNSTopicARN is the topic events are published to, empty for none
+	SNSTopicARN string
+	// EventBus is the name or ARN of the EventBridge bus events are put
+	// on, empty for none
+	EventBus string
+	// Source is the source of the events put on EventBridge, to match
+	// them in rules
+	Source string
+	// Region is the region of the topic and bus
+	Region string
+	// KafkaRESTURL is the Kafka REST Proxy events are produced through,
+	// empty for none
+	KafkaRESTURL string
+	KafkaTopic   string
+	// Log logs every event
+	Log bool
+}
+
+const (
+	defaultEventSource = "tubely"
+	defaultKafkaTopic  = "tubely.video-events"
+)
+
+// validKafkaTopic matches the names Kafka allows for topics.
+var validKafkaTopic = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// parseEventSettings parses EVENTS_SNS_TOPIC_ARN, an SNS topic to publish
+// video events to, EVENTS_EVENTBRIDGE_BUS, the name or ARN of an
+// EventBridge bus to put them on, EVENTS_SOURCE, their source on
+// EventBridge, EVENTS_KAFKA_REST_URL, a Kafka REST Proxy to produce them
+// through, EVENTS_KAFKA_TOPIC, the topic they're produced to, and
+// EVENTS_LOG, whether they're logged. Events go wherever is set, besides
+// integrations. The region is the one in the ARNs, or else defaultRegion.
+func parseEventSettings(snsTopicARN, eventBus, source, kafkaRESTURL, kafkaTopic, logEvents, defaultRegion string) (eventSettings, error) {
+	settings := eventSettings{
+		SNSTopicARN:  snsTopicARN,
+		EventBus:     eventBus,
+		Source:       source,
+		Region:       defaultRegion,
+		KafkaRESTURL: strings.TrimSuffix(kafkaRESTURL, "/"),
+		KafkaTopic:   kafkaTopic,
+	}
+	if settings.Source == "" {
+		settings.Source = defaultEventSource
+	}
+	if settings.KafkaTopic == "" {
+		settings.KafkaTopic = defaultKafkaTopic
+	}
+	if !validKafkaTopic.MatchString(settings.KafkaTopic) {
+		return eventSettings{}, fmt.Errorf("EVENTS_KAFKA_TOPIC must be a Kafka topic name, got %q", kafkaTopic)
+	}
+	if kafkaRESTURL != "" {
+		u, err := url.Parse(kafkaRESTURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return eventSettings{}, fmt.Errorf("EVENTS_KAFKA_REST_URL must be the http(s) URL of a Kafka REST Proxy, got %q", kafkaRESTURL)
+		}
+	}
+	if logEvents != "" {
+		log, err := strconv.ParseBool(logEvents)
+		if err != nil {
+			return eventSettings{}, fmt.Errorf("EVENTS_LOG must be true or false, got %q", logEvents)
+		}
+		settings.Log = log
+	}
+	regions := []string{}
+	if snsTopicARN != "" {
+		region, ok := arnRegion(snsTopicARN, "sns")
+		if !ok {
+			return eventSettings{}, fmt.Errorf("EVENTS_SNS_TOPIC_ARN must be an SNS topic ARN such as arn:aws:sns:us-east-1:123456789012:tubely-events, got %q", snsTopicARN)
+		}
+		regions = append(regions, region)
+	}
+	if strings.HasPrefix(eventBus, "arn:") {
+		region, ok := arnRegion(eventBus, "events")
+		if !ok {
+			return eventSettings{}, fmt.Errorf("EVENTS_EVENTBRIDGE_BUS must be an event bus name or ARN, got %q", eventBus)
+		}
+		regions = append(regions, region)
+	}
+	if len(regions) == 2 && regions[0] != regions[1] {
+		return eventSettings{}, errors.New("EVENTS_SNS_TOPIC_ARN and EVENTS_EVENTBRIDGE_BUS must be in the same region")
+	}
+	if len(regions) > 0 {
+		settings.Region = regions[0]
+	}
+	return settings, nil
+}
+
+// arnRegion returns the region of an ARN of a resource of service.
+func arnRegion(arn, service string) (string, bool) {
+	// arn:partition:service:region:account:resource
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != service || parts[3] == "" || parts[5] == "" {
+		return "", false
+	}
+	return parts[3], true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseJobQueueSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		backend  string
+		region   string
+		endpoint string
+		addr     string
+		db       int
+		wantErr  bool
+	}{
+		{name: "in process", url: ""},
+		{
+			name:     "SQS",
+			url:      "https://sqs.eu-west-1.amazonaws.com/123456789012/tubely-jobs",
+			backend:  jobQueueSQS,
+			region:   "eu-west-1",
+			endpoint: "https://sqs.eu-west-1.amazonaws.com/",
+		},
+		{
+			name:     "SQS emulator",
+			url:      "http://localhost:9324/000000000000/tubely-jobs",
+			backend:  jobQueueSQS,
+			region:   "us-east-1",
+			endpoint: "http://localhost:9324/",
+		},
+		{name: "Redis", url: "redis://:secret@redis.internal:6379/2", backend: jobQueueRedis, addr: "redis.internal:6379", db: 2},
+		{name: "Redis over TLS", url: "rediss://redis.internal/0", backend: jobQueueRedis, addr: "redis.internal:6379"},
+		{name: "SQS without a queue", url: "https://sqs.eu-west-1.amazonaws.com/", wantErr: true},
+		{name: "other scheme", url: "amqp://rabbit.internal/jobs", wantErr: true},
+		{name: "Redis with a bad database", url: "redis://:secret@redis.internal/jobs", wantErr: true},
+		{name: "Redis with a bad port", url: "redis://:secret@redis.internal:port/0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := parseJobQueueSettings(tt.url, "", "us-east-1")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseJobQueueSettings(%q) = %+v, want an error", tt.url, settings)
+				}
+				if strings.Contains(err.Error(), "secret") {
+					t.Errorf("error %q gives away the URL's password", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseJobQueueSettings(%q): %v", tt.url, err)
+			}
+			if settings.Backend != tt.backend || settings.Region != tt.region || settings.Endpoint != tt.endpoint {
+				t.Errorf("parseJobQueueSettings(%q) = %+v, want backend %q, region %q, endpoint %q", tt.url, settings, tt.backend, tt.region, tt.endpoint)
+			}
+			if tt.backend == jobQueueRedis && (settings.Redis.Addr != tt.addr || settings.Redis.DB != tt.db) {
+				t.Errorf("parseJobQueueSettings(%q) connects to %s, database %d, want %s, database %d", tt.url, settings.Redis.Addr, settings.Redis.DB, tt.addr, tt.db)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// videos are read from the database this many at a time
+	consistencyPageSize = 200
+
+	problemMissing      = "missing"
+	problemSizeMismatch = "size_mismatch"
+
+	repairRestoreVersion = "restore_version"
+	repairReupload       = "reupload"
+	repairDropRendition  = "drop_rendition"
+	repairForgetVersion  = "forget_version"
+)
+
+// consistencyIssue is an object the database refers to that the bucket
+// doesn't have as recorded.
+type consistencyIssue struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Version is the kept version the object belongs to, zero for the
+	// video's current file
+	Version int    `json:"version,omitempty"`
+	Kind    string `json:"kind"`
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+	// ExpectedSize and ActualSize are set for a size mismatch
+	ExpectedSize int64 `json:"expected_size,omitempty"`
+	ActualSize   int64 `json:"actual_size,omitempty"`
+}
+
+// repairStep is one suggested fix for a video with issues. Nothing is
+// changed by the check itself, the plan is for an operator to act on.
+type repairStep struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Action  string    `json:"action"`
+	// Version is the version to restore or forget
+	Version int    `json:"version,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// consistencyReport is the outcome of checking the database against the
+// bucket.
+type consistencyReport struct {
+	Videos int `json:"videos"`
+	// Objects counts the distinct objects looked up
+	Objects int `json:"objects"`
+	// External counts references outside Tubely's bucket, which aren't checked
+	External int                `json:"external"`
+	Issues   []consistencyIssue `json:"issues"`
+	Plan     []repairStep       `json:"plan"`
+}
+
+// objectState is what HeadObject found for a key.
+type objectState struct {
+	exists bool
+	size   int64
+}
+
+// checkConsistency verifies that every object a video refers to, for its
+// current file and its kept versions, exists in the bucket at the size
+// recorded, and plans repairs for the videos that don't.
+func (cfg *apiConfig) checkConsistency(ctx context.Context) (consistencyReport, error) {
+	report := consistencyReport{Issues: []consistencyIssue{}, Plan: []repairStep{}}
+	// versions share objects with each other and the current file
+	seen := map[string]objectState{}
+	lookup := func(bucket, key string) (objectState, error) {
+		if state, ok := seen[bucket+"/"+key]; ok {
+			return state, nil
+		}
+		head, err := cfg.headObject(ctx, bucket, key)
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+			err = nil
+		}
+		if err != nil {
+			return objectState{}, err
+		}
+		state := objectState{}
+		if head != nil {
+			state = objectState{exists: true, size: aws.ToInt64(head.ContentLength)}
+		}
+		seen[bucket+"/"+key] = state
+		report.Objects++
+		return state, nil
+	}
+
+	params := database.GetVideosPageParams{Limit: consistencyPageSize}
+	for {
+		videos, err := cfg.db.GetVideosPage(params)
+		if err != nil {
+			return consistencyReport{}, err
+		}
+		for _, video := range videos {
+			if err := ctx.Err(); err != nil {
+				return consistencyReport{}, err
+			}
+			issues, kept, err := cfg.checkVideoConsistency(video, lookup, &report.External)
+			if err != nil {
+				return consistencyReport{}, fmt.Errorf("video %s: %w", video.ID, err)
+			}
+			report.Videos++
+			report.Issues = append(report.Issues, issues...)
+			report.Plan = append(report.Plan, planVideoRepair(video, kept, issues)...)
+		}
+		if len(videos) < consistencyPageSize {
+			return report, nil
+		}
+		last := videos[len(videos)-1]
+		params.BeforeCreatedAt = last.CreatedAt
+		params.BeforeID = last.ID
+	}
+}
+
+// checkVideoConsistency looks up the objects behind video's current file
+// and kept versions, returning the issues found and the numbers of the
+// versions kept besides the current one, newest first.
+func (cfg *apiConfig) checkVideoConsistency(video database.Video, lookup func(bucket, key string) (objectState, error), external *int) ([]consistencyIssue, []int, error) {
+	renditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := []database.CreateRenditionParams{}
+	for _, rendition := range renditions {
+		current = append(current, rendition.CreateRenditionParams)
+	}
+	if len(current) == 0 && video.VideoURL != nil {
+		// stored before renditions were recorded, so there's no size to compare
+		current = append(current, database.CreateRenditionParams{Kind: "primary", VideoURL: *video.VideoURL})
+	}
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issues := []consistencyIssue{}
+	check := func(version int, rendition database.CreateRenditionParams) error {
+		bucket, key, ok := cfg.objectFromURL(rendition.VideoURL)
+		if !ok {
+			*external++
+			return nil
+		}
+		state, err := lookup(bucket, key)
+		if err != nil {
+			return err
+		}
+		issue := consistencyIssue{VideoID: video.ID, Version: version, Kind: rendition.Kind, Key: key}
+		switch {
+		case !state.exists:
+			issue.Problem = problemMissing
+		case rendition.Size > 0 && state.size != rendition.Size:
+			issue.Problem = problemSizeMismatch
+			issue.ExpectedSize = rendition.Size
+			issue.ActualSize = state.size
+		default:
+			return nil
+		}
+		issues = append(issues, issue)
+		return nil
+	}
+	for _, rendition := range current {
+		if err := check(0, rendition); err != nil {
+			return nil, nil, err
+		}
+	}
+	kept := []int{}
+	for _, version := range versions {
+		if version.Version == video.Version {
+			continue // the current file, already checked
+		}
+		kept = append(kept, version.Version)
+		for _, rendition := range version.Renditions {
+			if err := check(version.Version, rendition); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return issues, kept, nil
+}
+
+// planVideoRepair suggests how to fix video's issues. kept lists the
+// numbers of its kept versions other than the current one, newest first. A
+// broken current file is best rolled back to the newest intact kept
+// version, failing that the owner has to upload it again. A broken extra
+// rendition can be dropped, and a broken kept version forgotten.
+func planVideoRepair(video database.Video, kept []int, issues []consistencyIssue) []repairStep {
+	broken := map[int]bool{}
+	primaryBroken := false
+	brokenKinds := []string{}
+	for _, issue := range issues {
+		switch {
+		case issue.Version != 0:
+			broken[issue.Version] = true
+		case issue.Kind == "primary":
+			primaryBroken = true
+		default:
+			brokenKinds = append(brokenKinds, issue.Kind)
+		}
+	}
+
+	plan := []repairStep{}
+	if primaryBroken {
+		step := repairStep{
+			VideoID: video.ID,
+			Action:  repairReupload,
+			Reason:  "the current file is missing or damaged and no intact version is kept",
+		}
+		for _, version := range kept {
+			if !broken[version] {
+				step.Action = repairRestoreVersion
+				step.Version = version
+				step.Reason = fmt.Sprintf("the current file is missing or damaged, version %d is intact", version)
+				break
+			}
+		}
+		plan = append(plan, step)
+	} else {
+		// replacing the current file replaces these too
+		for _, kind := range brokenKinds {
+			plan = append(plan, repairStep{
+				VideoID: video.ID,
+				Action:  repairDropRendition,
+				Reason:  fmt.Sprintf("the %s rendition is missing or damaged", kind),
+			})
+		}
+	}
+	for _, version := range kept {
+		if broken[version] {
+			plan = append(plan, repairStep{
+				VideoID: video.ID,
+				Action:  repairForgetVersion,
+				Version: version,
+				Reason:  fmt.Sprintf("kept version %d is missing or damaged", version),
+			})
+		}
+	}
+	return plan
+}
+
+// runCheckCommand runs "tubely check", printing the consistency report to
+// stdout. It returns the exit code: 0 when the database and bucket agree,
+// 1 when issues were found and 2 when the check couldn't run.
+func (cfg *apiConfig) runCheckCommand(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	plan := flags.Bool("plan", false, "print a repair plan for the issues found")
+	asJSON := flags.Bool("json", false, "print the report, plan included, as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := cfg.checkConsistency(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Consistency check failed: %v\n", err)
+		return 2
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return 2
+		}
+	} else {
+		printConsistencyReport(os.Stdout, report, *plan)
+	}
+	if len(report.Issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printConsistencyReport(w io.Writer, report consistencyReport, plan bool) {
+	fmt.Fprintf(w, "Checked %d objects of %d videos, %d references outside the bucket skipped\n", report.Objects, report.Videos, report.External)
+	for _, issue := range report.Issues {
+		where := "current"
+		if issue.Version != 0 {
+			where = fmt.Sprintf("v%d", issue.Version)
+		}
+		switch issue.Problem {
+		case problemMissing:
+			fmt.Fprintf(w, "%s %s %s: %s is missing\n", issue.VideoID, where, issue.Kind, issue.Key)
+		case problemSizeMismatch:
+			fmt.Fprintf(w, "%s %s %s: %s is %d bytes, expected %d\n", issue.VideoID, where, issue.Kind, issue.Key, issue.ActualSize, issue.ExpectedSize)
+		}
+	}
+	if len(report.Issues) == 0 {
+		fmt.Fprintln(w, "No issues found")
+		return
+	}
+	fmt.Fprintf(w, "%d issues found\n", len(report.Issues))
+	if !plan {
+		return
+	}
+	fmt.Fprintln(w, "Repair plan:")
+	for _, step := range report.Plan {
+		action := step.Action
+		if step.Version != 0 {
+			action = fmt.Sprintf("%s %d", action, step.Version)
+		}
+		fmt.Fprintf(w, "  %s %s: %s\n", step.VideoID, action, step.Reason)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMaxAge is how long browsers may cache a preflight's answer.
+const corsMaxAge = 10 * time.Minute
+
+// handleCORS lets pages on CORS_ALLOWED_ORIGINS call the API from the
+// browser. It answers preflight requests itself and marks the responses
+// to allowed origins so their pages can read them and the headers in
+// ExposedHeaders. It does nothing without CORS_ALLOWED_ORIGINS.
+func (cfg *apiConfig) handleCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(cfg.cors.AllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (cfg.cors.AllowedOrigins[0] == "*" || slices.Contains(cfg.cors.AllowedOrigins, origin))
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			if allowed {
+				header.Set("Access-Control-Allow-Origin", origin)
+				header.Set("Access-Control-Allow-Methods", strings.Join(cfg.cors.AllowedMethods, ", "))
+				header.Set("Access-Control-Allow-Headers", strings.Join(cfg.cors.AllowedHeaders, ", "))
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			}
+			// without the allow headers the browser refuses the request
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Expose-Headers", strings.Join(cfg.cors.ExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
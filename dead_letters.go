@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxDeadLetterOutput is how much of a failed tool's output a dead letter
+// keeps, from the end, where ffmpeg reports what went wrong
+const maxDeadLetterOutput = 16 << 10
+
+// failJob records that job failed with err. A job that has used up its
+// attempts is also moved to the dead letters, so it doesn't fail silently.
+func (cfg *apiConfig) failJob(ctx context.Context, job database.Job, err error) {
+	logger := loggerFrom(ctx)
+	if err := cfg.db.UpdateJobStatus(job.ID, database.JobFailed, err.Error()); err != nil {
+		logger.Error("couldn't record job outcome", "error", err)
+		return
+	}
+	if jobRetryAt(job).IsZero() {
+		cfg.deadLetterJob(ctx, job, err)
+	}
+}
+
+// deadLetterJob keeps a job that failed for good with its error, the
+// output of the tool that failed, if one did, and what it worked from, for
+// an admin to look into and requeue.
+func (cfg *apiConfig) deadLetterJob(ctx context.Context, job database.Job, err error) {
+	logger := loggerFrom(ctx)
+	message := err.Error()
+	output := toolOutput(err)
+	if output != "" {
+		// the error carries the output too, it's kept once
+		message = strings.Replace(message, ": "+output, "", 1)
+		output = strings.TrimSpace(output)
+		if len(output) > maxDeadLetterOutput {
+			output = output[len(output)-maxDeadLetterOutput:]
+		}
+	}
+	err = cfg.db.CreateDeadLetter(database.DeadLetter{
+		JobID:      job.ID,
+		UserID:     job.UserID,
+		VideoID:    job.VideoID,
+		Kind:       job.Kind,
+		Attempts:   job.Attempts,
+		Error:      message,
+		ToolOutput: output,
+		InputKey:   cfg.jobInputKey(job),
+	})
+	if err != nil {
+		logger.Error("couldn't record dead letter", "error", err)
+		return
+	}
+	logger.Warn("job failed for good, moved to dead letters", "attempts", job.Attempts)
+}
+
+// jobInputKey is what job worked from: the URL, minus its query, a file
+// was ingested from, the staged copy of an upload, or else the object key
+// of the file of the video it worked on, the source video for a clip. It's
+// "" if that's gone.
+func (cfg *apiConfig) jobInputKey(job database.Job) string {
+	videoID := job.VideoID
+	switch job.Kind {
+	case jobKindIngestURL:
+		var params urlIngestParams
+		if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+			return ""
+		}
+		source, err := url.Parse(params.URL)
+		if err != nil {
+			return ""
+		}
+		return redactURL(source)
+	case jobKindIngestUpload:
+		var params uploadIngestParams
+		if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+			return ""
+		}
+		return params.Key
+	case jobKindClip:
+		var params clipParams
+		if err := json.Unmarshal([]byte(job.Params), &params); err == nil {
+			videoID = params.SourceVideoID
+		}
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		return ""
+	}
+	return cfg.videoObjectKey(video)
+}
+
+// handlerAdminDeadLettersGet lists the jobs that failed every attempt,
+// newest first.
+func (cfg *apiConfig) handlerAdminDeadLettersGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+	deadLetters, err := cfg.db.GetDeadLetters()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dead letters", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deadLetters)
+}
+
+// handlerAdminDeadLetterRequeue runs a dead letter's job again, once, as
+// its next attempt. If that fails too, it's back in the dead letters.
+func (cfg *apiConfig) handlerAdminDeadLetterRequeue(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	deadLetter, err := cfg.db.GetDeadLetter(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dead letter", err)
+		return
+	}
+	if deadLetter.JobID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return
+	}
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.Params == "" {
+		respondWithError(w, http.StatusConflict, "Job was created before jobs recorded their parameters and can't be run again", nil)
+		return
+	}
+	ok, err = cfg.db.RequeueDeadLetter(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't requeue job", err)
+		return
+	}
+	if !ok {
+		// another request got to it first
+		respondWithError(w, http.StatusConflict, "Job was already requeued", nil)
+		return
+	}
+	job, err = cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		ActorID: admin.ID,
+		UserID:  job.UserID,
+		Action:  database.AuditJobRequeued,
+		VideoID: job.VideoID,
+		Detail:  job.Kind + " job " + job.ID.String(),
+	})
+	cfg.startJob(r.Context(), job)
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// handlerAdminDeadLetterDiscard gives up on a dead letter's job: the dead
+// letter is dropped, the job stays failed, and the staged copy of an
+// upload it would have ingested is deleted.
+func (cfg *apiConfig) handlerAdminDeadLetterDiscard(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return
+	}
+	ok, err = cfg.db.DeleteDeadLetter(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't discard dead letter", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return
+	}
+	if job.Kind == jobKindIngestUpload {
+		cfg.removeStagedUpload(r.Context(), job)
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		ActorID: admin.ID,
+		UserID:  job.UserID,
+		Action:  database.AuditDeadLetterDiscarded,
+		VideoID: job.VideoID,
+		Detail:  job.Kind + " job " + job.ID.String(),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
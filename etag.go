@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// notModified tags a response listing videos, as they'll be sent, with an
+// ETag, and answers 304 if the client's If-None-Match already has it, so
+// clients polling for changes don't download the same metadata again.
+// extra is anything else in the response the tag must cover. It reports
+// whether it answered.
+func (cfg *apiConfig) notModified(w http.ResponseWriter, r *http.Request, videos []database.Video, extra ...any) bool {
+	etag := cfg.videosETag(videos, extra...)
+	w.Header().Set("ETag", etag)
+	// the response depends on who asks, and clients should check it's
+	// still current before using a cached copy
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// videosETag is a weak ETag of videos and extra. Video and caption URLs
+// can be signed anew for every request, so they're left out, and the tag
+// changes instead every half of their lifetime: a cached response the
+// tag still matches has URLs with at least half their life left.
+func (cfg *apiConfig) videosETag(videos []database.Video, extra ...any) string {
+	urlPeriod := cfg.clientURLExpiry(privateURLExpiry) / 2
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	enc.Encode(time.Now().Truncate(urlPeriod).Unix())
+	for _, video := range videos {
+		if video.VideoURL != nil {
+			video.VideoURL = new(string)
+		}
+		captions := make([]database.Caption, len(video.Captions))
+		for i, caption := range video.Captions {
+			caption.URL = ""
+			captions[i] = caption
+		}
+		video.Captions = captions
+		enc.Encode(video)
+	}
+	for _, e := range extra {
+		enc.Encode(e)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, with the
+// weak comparison it calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/google/uuid"
+)
+
+const (
+	// eventVideoUploaded is published when a file is received for a video,
+	// before it's processed
+	eventVideoUploaded = "video.uploaded"
+	// eventVideoReady is published when a video's file has been processed
+	// and can be played
+	eventVideoReady = "video.ready"
+	// eventVideoProcessingFailed is published when a video's file
+	// couldn't be processed, with why
+	eventVideoProcessingFailed = "video.processing_failed"
+	// eventVideoDeleted is published when a video is deleted, by its owner,
+	// an admin or the retention policy
+	eventVideoDeleted = "video.deleted"
+
+	// eventPublishTimeout bounds publishing one event to one destination
+	eventPublishTimeout = 15 * time.Second
+)
+
+// videoEvent is what's published about a video, as JSON, for other
+// services to react to.
+type videoEvent struct {
+	// ID is unique to the event, for subscribers to drop duplicates
+	ID         uuid.UUID `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	VideoID    uuid.UUID `json:"video_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Title      string    `json:"title"`
+	// Replaced is set when the file uploaded or ready replaces the one
+	// the video had
+	Replaced  bool     `json:"replaced,omitempty"`
+	MediaKind string   `json:"media_kind,omitempty"`
+	Duration  *float64 `json:"duration,omitempty"`
+	// Error is why processing failed
+	Error string `json:"error,omitempty"`
+}
+
+func newVideoEvent(eventType string, video database.Video) videoEvent {
+	return videoEvent{
+		ID:         uuid.New(),
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		VideoID:    video.ID,
+		UserID:     video.UserID,
+		Title:      video.Title,
+		MediaKind:  video.MediaKind,
+		Duration:   video.Duration,
+	}
+}
+
+// eventPublisher delivers video events to one destination.
+type eventPublisher interface {
+	// name names the destination in logs
+	name() string
+	publish(ctx context.Context, event videoEvent) error
+}
+
+// newEventPublishers returns the destinations settings choose for video
+// events. Integrations always get the events they subscribe to. Sandbox
+// mode logs events rather than sending them to AWS.
+func (cfg *apiConfig) newEventPublishers(settings eventSettings, awsCfg aws.Config) []eventPublisher {
+	publishers := []eventPublisher{webhookEventPublisher{db: cfg.db, notifier: cfg.notifier}}
+	if settings.Log || cfg.sandbox {
+		publishers = append(publishers, logEventPublisher{})
+	}
+	if settings.SNSTopicARN != "" && !cfg.sandbox {
+		client := sns.NewFromConfig(awsCfg, func(o *sns.Options) {
+			o.Region = settings.Region
+			o.APIOptions = append(o.APIOptions, traceAWSCalls)
+		})
+		publishers = append(publishers, snsEventPublisher{settings.SNSTopicARN, client})
+	}
+	if settings.EventBus != "" && !cfg.sandbox {
+		client := eventbridge.NewFromConfig(awsCfg, func(o *eventbridge.Options) {
+			o.Region = settings.Region
+			o.APIOptions = append(o.APIOptions, traceAWSCalls)
+		})
+		publishers = append(publishers, eventBridgePublisher{settings.EventBus, settings.Source, client})
+	}
+	if settings.KafkaRESTURL != "" {
+		publishers = append(publishers, kafkaEventPublisher{
+			restURL: settings.KafkaRESTURL,
+			topic:   settings.KafkaTopic,
+			client:  &http.Client{Timeout: eventPublishTimeout},
+		})
+	}
+	return publishers
+}
+
+// logEventPublisher only logs events, to see what would be published.
+type logEventPublisher struct{}
+
+func (logEventPublisher) name() string {
+	return "log"
+}
+
+func (logEventPublisher) publish(ctx context.Context, event videoEvent) error {
+	// the request's logger may already name a user, not always the owner
+	attrs := []any{"owner_id", event.UserID}
+	if event.Replaced {
+		attrs = append(attrs, "replaced", true)
+	}
+	if event.Error != "" {
+		attrs = append(attrs, "error", event.Error)
+	}
+	loggerFrom(ctx).Info("event", attrs...)
+	return nil
+}
+
+// webhookEventPublisher posts events to the owner's integrations
+// subscribed to them, as the chat messages integrations expect. Events
+// integrations can't subscribe to are skipped.
+type webhookEventPublisher struct {
+	db       database.Client
+	notifier *notify.Notifier
+}
+
+func (webhookEventPublisher) name() string {
+	return "webhooks"
+}
+
+func (p webhookEventPublisher) publish(ctx context.Context, event videoEvent) error {
+	notifyEvent := notify.Event{
+		VideoID:    event.VideoID,
+		VideoTitle: event.Title,
+		Error:      event.Error,
+	}
+	switch event.Type {
+	case eventVideoReady:
+		notifyEvent.Type = notify.EventUploadComplete
+	case eventVideoProcessingFailed:
+		notifyEvent.Type = notify.EventProcessingFailed
+	default:
+		return nil
+	}
+	return notifyIntegrations(ctx, p.db, p.notifier, event.UserID, notifyEvent)
+}
+
+// snsEventPublisher publishes events to an SNS topic with the server's
+// AWS credentials, which need the sns:Publish permission.
+type snsEventPublisher struct {
+	topicARN string
+	client   *sns.Client
+}
+
+func (snsEventPublisher) name() string {
+	return "sns"
+}
+
+func (p snsEventPublisher) publish(ctx context.Context, event videoEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// the type is an attribute too, for subscriptions' filter policies
+	_, err = p.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+		},
+	})
+	return err
+}
+
+// eventBridgePublisher puts events on an EventBridge bus with the
+// server's AWS credentials, which need the events:PutEvents permission.
+type eventBridgePublisher struct {
+	bus    string
+	source string
+	client *eventbridge.Client
+}
+
+func (eventBridgePublisher) name() string {
+	return "eventbridge"
+}
+
+func (p eventBridgePublisher) publish(ctx context.Context, event videoEvent) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	out, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{{
+			EventBusName: aws.String(p.bus),
+			Source:       aws.String(p.source),
+			DetailType:   aws.String(event.Type),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(event.OccurredAt),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// a rejected entry doesn't fail the call
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		return fmt.Errorf("%s %s", aws.ToString(out.Entries[0].ErrorCode), aws.ToString(out.Entries[0].ErrorMessage))
+	}
+	return nil
+}
+
+// kafkaEventPublisher produces events to a Kafka topic through a Kafka
+// REST Proxy, keyed by video so a video's events keep their order.
+type kafkaEventPublisher struct {
+	restURL string
+	topic   string
+	client  *http.Client
+}
+
+func (kafkaEventPublisher) name() string {
+	return "kafka"
+}
+
+func (p kafkaEventPublisher) publish(ctx context.Context, event videoEvent) error {
+	body, err := json.Marshal(map[string]any{"records": []map[string]any{{
+		"key":   event.VideoID,
+		"value": event,
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.restURL+"/topics/"+url.PathEscape(p.topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Message string `json:"message"`
+		Offsets []struct {
+			Error *string `json:"error"`
+		} `json:"offsets"`
+	}
+	json.Unmarshal(data, &out)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST Proxy responded with %s: %s", resp.Status, out.Message)
+	}
+	for _, offset := range out.Offsets {
+		if offset.Error != nil {
+			return fmt.Errorf("Kafka REST Proxy couldn't produce event: %s", *offset.Error)
+		}
+	}
+	return nil
+}
+
+// publishUploaded publishes that a file of kind was received for video,
+// as it was before the file.
+func (cfg *apiConfig) publishUploaded(ctx context.Context, video database.Video, kind string) {
+	event := newVideoEvent(eventVideoUploaded, video)
+	event.Replaced = video.VideoURL != nil
+	event.MediaKind = kind
+	event.Duration = nil
+	cfg.publishEvent(ctx, event)
+}
+
+// publishReady publishes that video, as it was before its new file was
+// stored, now has it ready to play.
+func (cfg *apiConfig) publishReady(ctx context.Context, video database.Video) {
+	replaced := video.VideoURL != nil
+	if current, err := cfg.db.GetVideo(video.ID); err == nil && current.ID != uuid.Nil {
+		video = current
+	}
+	event := newVideoEvent(eventVideoReady, video)
+	event.Replaced = replaced
+	cfg.publishEvent(ctx, event)
+}
+
+// publishProcessingFailed publishes that video's new file couldn't be
+// processed, err being why.
+func (cfg *apiConfig) publishProcessingFailed(ctx context.Context, video database.Video, err error) {
+	event := newVideoEvent(eventVideoProcessingFailed, video)
+	event.Error = err.Error()
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		event.Error = ingestErr.Message
+	}
+	cfg.publishEvent(ctx, event)
+}
+
+// publishEvent publishes event to every destination in the background,
+// so a slow one never holds up the request that caused it. Video
+// lifecycle events go through here, and only here. Failures are logged,
+// the action stands either way.
+func (cfg *apiConfig) publishEvent(ctx context.Context, event videoEvent) {
+	logger := loggerFrom(ctx).With("event_id", event.ID, "event_type", event.Type, "video_id", event.VideoID)
+	cfg.goBackground(func() {
+		for _, publisher := range cfg.events {
+			ctx, cancel := context.WithTimeout(withLogger(cfg.inflight.ctx, logger), eventPublishTimeout)
+			err := publisher.publish(ctx, event)
+			cancel()
+			if err != nil {
+				logger.Error("couldn't publish event", "destination", publisher.name(), "error", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// feedSize is how many of a channel's latest videos its feed lists
+const feedSize = 50
+
+type (
+	rssFeed struct {
+		XMLName  xml.Name   `xml:"rss"`
+		Version  string     `xml:"version,attr"`
+		ITunesNS string     `xml:"xmlns:itunes,attr"`
+		AtomNS   string     `xml:"xmlns:atom,attr"`
+		Channel  rssChannel `xml:"channel"`
+	}
+	rssChannel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		Self          rssLink   `xml:"atom:link"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	}
+	rssLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	}
+	rssItem struct {
+		Title       string        `xml:"title"`
+		Description string        `xml:"description"`
+		Link        string        `xml:"link"`
+		GUID        rssGUID       `xml:"guid"`
+		PubDate     string        `xml:"pubDate"`
+		Enclosure   rssEnclosure  `xml:"enclosure"`
+		Duration    string        `xml:"itunes:duration,omitempty"`
+		Image       *rssImageLink `xml:"itunes:image"`
+	}
+	rssGUID struct {
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	}
+	rssEnclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	}
+	rssImageLink struct {
+		Href string `xml:"href,attr"`
+	}
+)
+
+// enclosureType is the media type of the file at video's URL, going by
+// its extension.
+func (cfg *apiConfig) enclosureType(video database.Video) string {
+	if _, key, ok := cfg.objectFromURL(*video.VideoURL); ok {
+		if mediaType := mime.TypeByExtension(path.Ext(key)); mediaType != "" {
+			return mediaType
+		}
+	}
+	if video.MediaKind == database.MediaKindAudio {
+		return "audio/mpeg"
+	}
+	return "video/mp4"
+}
+
+// handlerUserFeed serves an RSS feed of a user's latest public videos, with
+// their files as enclosures, for podcast apps and feed readers.
+func (cfg *apiConfig) handlerUserFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetVideosPage(database.GetVideosPageParams{
+		UserID:     userID,
+		Limit:      feedSize,
+		PublicOnly: true,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	// only files clients can fetch are enclosed
+	playable := videos[:0]
+	ids := []uuid.UUID{}
+	for _, video := range videos {
+		if video.VideoURL != nil && video.ArchiveStatus == "" {
+			playable = append(playable, video)
+			ids = append(ids, video.ID)
+		}
+	}
+	sizes, err := cfg.db.GetPrimarySizes(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get file sizes", err)
+		return
+	}
+	mediaTypes := map[uuid.UUID]string{}
+	for _, video := range playable {
+		mediaTypes[video.ID] = cfg.enclosureType(video)
+	}
+	playable, err = cfg.presentVideos(r.Context(), playable, uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URLs", err)
+		return
+	}
+
+	selfURL := cfg.publicURL + apiPath(r, "/users/"+userID.String()+"/feed")
+	feed := rssFeed{
+		Version:  "2.0",
+		ITunesNS: "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		AtomNS:   "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         "Tubely channel " + userID.String()[:8],
+			Link:          selfURL,
+			Description:   "The latest public videos of a Tubely channel",
+			Self:          rssLink{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: time.Now().UTC().Format(time.RFC1123Z),
+			Items:         []rssItem{},
+		},
+	}
+	for _, video := range playable {
+		published := video.CreatedAt
+		if video.ReadyAt != nil {
+			published = *video.ReadyAt
+		}
+		item := rssItem{
+			Title:       video.Title,
+			Description: video.Description,
+			Link:        cfg.embedPageURL(video.ID),
+			GUID:        rssGUID{Value: video.ID.String()},
+			PubDate:     published.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:    *video.VideoURL,
+				Length: sizes[video.ID],
+				Type:   mediaTypes[video.ID],
+			},
+		}
+		if video.Duration != nil {
+			item.Duration = strconv.Itoa(int(*video.Duration))
+		}
+		if video.ThumbnailURL != nil {
+			item.Image = &rssImageLink{Href: *video.ThumbnailURL}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/phash"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindFingerprint = "fingerprint"
+	// fingerprintTimeout bounds grabbing and hashing a video's frames
+	fingerprintTimeout = 15 * time.Minute
+	// fingerprintFrames is how many frames, spread evenly over a video,
+	// are hashed. Frames are taken at the same fractions of every video,
+	// so the same content matches frame for frame whatever its length.
+	fingerprintFrames = 8
+	// duplicateMaxDistance is how many of the 64 bits of each frame's hash
+	// may differ on average for two videos to count as duplicates.
+	// Re-encoding or scaling changes a few; different scenes change about
+	// half.
+	duplicateMaxDistance = 10
+)
+
+// queueFingerprint starts hashing the frames of a video whose file was
+// just stored, so it can be matched against others. Audio has no frames.
+// Failures are logged, the upload stands either way.
+func (cfg *apiConfig) queueFingerprint(ctx context.Context, video database.Video) {
+	// the video passed in is from before its file was stored
+	videoID := video.ID
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue fingerprinting", "video_id", videoID, "error", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.MediaKind != database.MediaKindVideo {
+		return
+	}
+	job, err := cfg.db.CreateJob(video.UserID, video.ID, jobKindFingerprint, "")
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue fingerprinting", "video_id", video.ID, "error", err)
+		return
+	}
+	cfg.startJob(ctx, job)
+}
+
+func (cfg *apiConfig) runFingerprintJob(ctx context.Context, job database.Job) error {
+	return cfg.fingerprintVideo(ctx, job.VideoID)
+}
+
+// fingerprintVideo hashes frames sampled across the video's current file
+// and stores their hashes.
+func (cfg *apiConfig) fingerprintVideo(ctx context.Context, videoID uuid.UUID) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return errors.New("video no longer has a file")
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		return errors.New("video file isn't stored in this bucket")
+	}
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return fmt.Errorf("couldn't presign video URL: %w", err)
+	}
+
+	timestamps := []float64{0}
+	if video.Duration != nil && *video.Duration > 0 {
+		timestamps = make([]float64, fingerprintFrames)
+		for i := range timestamps {
+			timestamps[i] = (float64(i) + 0.5) * *video.Duration / fingerprintFrames
+		}
+	}
+
+	hashes := []uint64{}
+	for _, at := range timestamps {
+		hash, err := cfg.hashFrame(ctx, sourceURL, at)
+		if errors.Is(err, errFrameOutOfRange) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return errors.New("no frames could be taken from the video")
+	}
+
+	err = cfg.db.CommitFingerprint(videoID, *video.VideoURL, hashes)
+	if errors.Is(err, database.ErrVideoFileChanged) {
+		return errors.New("video's file was replaced while it was fingerprinted")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't save fingerprint: %w", err)
+	}
+	loggerFrom(ctx).Info("fingerprinted video", "video_id", videoID, "frames", len(hashes))
+	return nil
+}
+
+// hashFrame grabs the frame at `at` seconds into input and returns its
+// perceptual hash.
+func (cfg *apiConfig) hashFrame(ctx context.Context, input string, at float64) (uint64, error) {
+	frame, err := cfg.tempStore.Create(0, "fingerprint-*.jpg")
+	if err != nil {
+		return 0, fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer frame.Release()
+	if err := cfg.media.extractFrame(ctx, input, at, frame.Name()); err != nil {
+		return 0, err
+	}
+	file, err := os.Open(frame.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't decode frame: %w", err)
+	}
+	return phash.Hash(img), nil
+}
+
+// fingerprintDistance is how many bits of two videos' frame hashes differ
+// on average, false if they can't be compared because a different number
+// of frames was taken from each.
+func fingerprintDistance(a, b database.Fingerprint) (float64, bool) {
+	if len(a.FrameHashes) == 0 || len(a.FrameHashes) != len(b.FrameHashes) {
+		return 0, false
+	}
+	total := 0
+	for i := range a.FrameHashes {
+		total += phash.Distance(a.FrameHashes[i], b.FrameHashes[i])
+	}
+	return float64(total) / float64(len(a.FrameHashes)), true
+}
+
+// isDuplicate reports whether two videos look the same, and how far apart
+// their fingerprints are.
+func isDuplicate(a, b database.Fingerprint) (float64, bool) {
+	distance, ok := fingerprintDistance(a, b)
+	return math.Round(distance*100) / 100, ok && distance <= duplicateMaxDistance
+}
+
+// duplicateGroup is videos that look the same, directly or through
+// others of the group they look like.
+type duplicateGroup struct {
+	fingerprints []database.Fingerprint
+	// maxDistance is the largest distance between two of the videos
+	// found duplicates
+	maxDistance float64
+}
+
+// duplicateGroups groups the videos that look the same, largest group
+// first. Videos without a duplicate are left out.
+func duplicateGroups(fingerprints []database.Fingerprint) []duplicateGroup {
+	// union-find over the fingerprints' indexes
+	parent := make([]int, len(fingerprints))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	maxDistance := map[int]float64{}
+	for i := range fingerprints {
+		for j := i + 1; j < len(fingerprints); j++ {
+			distance, ok := isDuplicate(fingerprints[i], fingerprints[j])
+			if !ok {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			parent[rj] = ri
+			maxDistance[ri] = max(maxDistance[ri], maxDistance[rj], distance)
+		}
+	}
+
+	byRoot := map[int]*duplicateGroup{}
+	roots := []int{}
+	for i, fingerprint := range fingerprints {
+		root := find(i)
+		if byRoot[root] == nil {
+			byRoot[root] = &duplicateGroup{maxDistance: maxDistance[root]}
+			roots = append(roots, root)
+		}
+		byRoot[root].fingerprints = append(byRoot[root].fingerprints, fingerprint)
+	}
+	groups := []duplicateGroup{}
+	for _, root := range roots {
+		if len(byRoot[root].fingerprints) > 1 {
+			groups = append(groups, *byRoot[root])
+		}
+	}
+	slices.SortStableFunc(groups, func(a, b duplicateGroup) int {
+		return len(b.fingerprints) - len(a.fingerprints)
+	})
+	return groups
+}
+
+// handlerVideoDuplicatesGet lists the owner's other videos that look the
+// same as this one, closest first.
+func (cfg *apiConfig) handlerVideoDuplicatesGet(w http.ResponseWriter, r *http.Request) {
+	type duplicate struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		Title     string    `json:"title"`
+		CreatedAt time.Time `json:"created_at"`
+		// Distance is how many of the 64 bits of each frame's hash differ
+		// on average, 0 for the same frames
+		Distance float64 `json:"distance"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	fingerprints, err := cfg.db.GetFingerprints(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get fingerprints", err)
+		return
+	}
+	i := slices.IndexFunc(fingerprints, func(f database.Fingerprint) bool { return f.VideoID == videoID })
+	if i < 0 {
+		respondWithError(w, http.StatusConflict, "Video hasn't been fingerprinted yet", nil)
+		return
+	}
+
+	duplicates := []duplicate{}
+	for _, other := range fingerprints {
+		if other.VideoID == videoID {
+			continue
+		}
+		if distance, ok := isDuplicate(fingerprints[i], other); ok {
+			duplicates = append(duplicates, duplicate{
+				VideoID:   other.VideoID,
+				Title:     other.Title,
+				CreatedAt: other.CreatedAt,
+				Distance:  distance,
+			})
+		}
+	}
+	slices.SortStableFunc(duplicates, func(a, b duplicate) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	respondWithJSON(w, http.StatusOK, duplicates)
+}
+
+// handlerAdminDuplicatesGet reports the groups of videos across the
+// library that look the same, largest first.
+func (cfg *apiConfig) handlerAdminDuplicatesGet(w http.ResponseWriter, r *http.Request) {
+	type video struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		UserID    uuid.UUID `json:"user_id"`
+		Title     string    `json:"title"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	type group struct {
+		Videos []video `json:"videos"`
+		// MaxDistance is the largest average distance between frame hashes
+		// of two videos of the group found duplicates
+		MaxDistance float64 `json:"max_distance"`
+	}
+
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+	fingerprints, err := cfg.db.GetFingerprints(uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get fingerprints", err)
+		return
+	}
+
+	groups := duplicateGroups(fingerprints)
+	report := make([]group, len(groups))
+	for i, dupes := range groups {
+		report[i].MaxDistance = dupes.maxDistance
+		for _, member := range dupes.fingerprints {
+			report[i].Videos = append(report[i].Videos, video{
+				VideoID:   member.VideoID,
+				UserID:    member.UserID,
+				Title:     member.Title,
+				CreatedAt: member.CreatedAt,
+			})
+		}
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
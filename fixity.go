@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+)
+
+const (
+	fixityPollInterval = 10 * time.Minute
+	// at most this many objects are checked per poll, spreading the work
+	// over the interval instead of checking everything at once
+	fixityBatchSize = 100
+	// objects are hashed in ranges, so a dropped connection only repeats one
+	fixityRangeSize = 16 << 20
+)
+
+// fixityResult is the outcome of checking one stored object.
+type fixityResult struct {
+	Status string
+	// SHA256 is the digest found, if the check got that far
+	SHA256 string
+	// Detail explains a failed check
+	Detail string
+}
+
+// runFixityChecker re-verifies stored objects on a rolling schedule until
+// ctx is done, so each is checked about once per cfg.fixity.Interval.
+func (cfg *apiConfig) runFixityChecker(ctx context.Context) {
+	ticker := time.NewTicker(fixityPollInterval)
+	defer ticker.Stop()
+	for {
+		cfg.checkDueFixity(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) checkDueFixity(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	renditions, err := cfg.db.GetRenditionsDueForFixity(time.Now().Add(-cfg.fixity.Interval), fixityBatchSize)
+	if err != nil {
+		logger.Error("couldn't look up objects due for a fixity check", "error", err)
+		return
+	}
+	for _, rendition := range renditions {
+		if ctx.Err() != nil {
+			return
+		}
+		result, err := cfg.checkFixity(ctx, rendition)
+		if err != nil {
+			// leave it due, it's tried again on the next poll
+			logger.Warn("couldn't check object fixity", "rendition_id", rendition.ID, "error", err)
+			continue
+		}
+		if err := cfg.db.RecordFixityCheck(rendition.ID, result.Status, result.SHA256); err != nil {
+			logger.Error("couldn't record fixity check", "rendition_id", rendition.ID, "error", err)
+			continue
+		}
+		if result.Status == database.FixityMismatch || result.Status == database.FixityMissing {
+			cfg.reportFixityFailure(ctx, rendition, result)
+		}
+	}
+}
+
+// checkFixity checks the object behind rendition against the digest
+// recorded when it was stored. A rendition without one, like an import,
+// adopts the digest found on its first check.
+func (cfg *apiConfig) checkFixity(ctx context.Context, rendition database.Rendition) (fixityResult, error) {
+	bucket, key, ok := cfg.objectFromURL(rendition.VideoURL)
+	if !ok {
+		return fixityResult{Status: database.FixityExternal}, nil
+	}
+
+	var head *s3.HeadObjectOutput
+	err := withS3Retry(ctx, s3DefaultRetry, "HeadObject "+key, func(ctx context.Context) error {
+		var err error
+		head, err = cfg.buckets.client(bucket).HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ChecksumMode:        types.ChecksumModeEnabled,
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return fixityResult{Status: database.FixityMissing, Detail: "object " + key + " is missing"}, nil
+	}
+	if err != nil {
+		return fixityResult{}, err
+	}
+
+	size := aws.ToInt64(head.ContentLength)
+	if rendition.Size > 0 && size != rendition.Size {
+		return fixityResult{
+			Status: database.FixityMismatch,
+			Detail: fmt.Sprintf("object %s is %d bytes, expected %d", key, size, rendition.Size),
+		}, nil
+	}
+
+	// a composite checksum covers the upload's parts, not the whole object
+	var digest string
+	if cfg.fixity.Mode == fixityChecksum && head.ChecksumSHA256 != nil && head.ChecksumType != types.ChecksumTypeComposite {
+		sum, err := base64.StdEncoding.DecodeString(*head.ChecksumSHA256)
+		if err != nil {
+			return fixityResult{}, fmt.Errorf("invalid checksum on %s: %w", key, err)
+		}
+		digest = hex.EncodeToString(sum)
+	} else {
+		digest, err = cfg.hashObject(ctx, bucket, key, size)
+		if err != nil {
+			return fixityResult{}, err
+		}
+	}
+
+	if rendition.SHA256 != "" && digest != rendition.SHA256 {
+		return fixityResult{
+			Status: database.FixityMismatch,
+			SHA256: digest,
+			Detail: fmt.Sprintf("object %s has SHA-256 %s, expected %s", key, digest, rendition.SHA256),
+		}, nil
+	}
+	return fixityResult{Status: database.FixityOK, SHA256: digest}, nil
+}
+
+// hashObject downloads key from bucket in ranges and returns its hex
+// SHA-256.
+func (cfg *apiConfig) hashObject(ctx context.Context, bucket, key string, size int64) (string, error) {
+	hasher := sha256.New()
+	for offset := int64(0); offset < size; offset += fixityRangeSize {
+		length := min(fixityRangeSize, size-offset)
+		if err := cfg.hashRange(ctx, hasher, bucket, key, offset, length); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashRange adds one range of key to hasher. The range is buffered so a
+// retried read can't feed the hasher the same bytes twice.
+func (cfg *apiConfig) hashRange(ctx context.Context, hasher hash.Hash, bucket, key string, offset, length int64) error {
+	var buf bytes.Buffer
+	err := withS3Retry(ctx, s3DefaultRetry, "GetObject "+key, func(ctx context.Context) error {
+		buf.Reset()
+		out, err := cfg.buckets.client(bucket).GetObject(ctx, &s3.GetObjectInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			Range:               aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+		_, err = io.Copy(&buf, out.Body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't read bytes %d-%d of %s: %w", offset, offset+length-1, key, err)
+	}
+	if int64(buf.Len()) != length {
+		return fmt.Errorf("read %d bytes at %d of %s, expected %d", buf.Len(), offset, key, length)
+	}
+	hasher.Write(buf.Bytes())
+	return nil
+}
+
+// reportFixityFailure logs a failed check and tells the owner's
+// integrations, once per change of status so a damaged object doesn't
+// alert again on every check.
+func (cfg *apiConfig) reportFixityFailure(ctx context.Context, rendition database.Rendition, result fixityResult) {
+	logger := loggerFrom(ctx).With("video_id", rendition.VideoID, "rendition_id", rendition.ID)
+	logger.Error("fixity check failed", "status", result.Status, "detail", result.Detail)
+	if rendition.FixityStatus == result.Status {
+		return
+	}
+	video, err := cfg.db.GetVideo(rendition.VideoID)
+	if err != nil {
+		logger.Error("couldn't look up video to report fixity failure", "error", err)
+		return
+	}
+	cfg.notifyUser(video.UserID, notify.Event{
+		Type:       notify.EventFixityFailed,
+		VideoID:    video.ID,
+		VideoTitle: video.Title,
+		Error:      result.Detail,
+	})
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	tubelyv1 "github.com/bootdotdev/learn-file-storage-s3-golang-starter/proto/tubely/v1"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcErrorDomain is the domain of the ErrorInfo details on gRPC errors
+// that carry an error code.
+const grpcErrorDomain = "tubely.dev"
+
+// newGRPCServer returns a gRPC server for the VideoService, sharing the
+// database and storage with the HTTP API.
+func (cfg *apiConfig) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(cfg.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(cfg.grpcStreamInterceptor),
+	)
+	tubelyv1.RegisterVideoServiceServer(srv, &grpcVideoService{cfg: cfg})
+	return srv
+}
+
+// grpcCaller is who a gRPC call is made by. A zero UserID is an anonymous
+// caller.
+type grpcCaller struct {
+	UserID uuid.UUID
+	// ActorID is the admin behind an impersonation token, or else UserID
+	ActorID uuid.UUID
+	Role    auth.Role
+}
+
+type grpcCallerKey struct{}
+
+func grpcCallerFrom(ctx context.Context) grpcCaller {
+	caller, _ := ctx.Value(grpcCallerKey{}).(grpcCaller)
+	return caller
+}
+
+// requireRole returns an error unless the caller is signed in with a role
+// that includes role.
+func (c grpcCaller) requireRole(role auth.Role) error {
+	if c.UserID == uuid.Nil {
+		return status.Error(codes.Unauthenticated, "This needs an access token or API key")
+	}
+	if !c.Role.Includes(role) {
+		return status.Error(codes.PermissionDenied, "This needs the "+string(role)+" role")
+	}
+	return nil
+}
+
+// grpcContext gives a call what withRequestID gives an HTTP request, a
+// request ID and a logger, authenticates it, and cancels it if a shutdown
+// gives up waiting for it. The returned func releases it.
+func (cfg *apiConfig) grpcContext(ctx context.Context, method string) (context.Context, func(), error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if ids := md.Get("x-request-id"); len(ids) > 0 {
+		id = ids[0]
+	}
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	ctx = withLogger(ctx, cfg.logger.With("request_id", id, "grpc_method", method))
+
+	caller, err := cfg.authenticateGRPC(ctx, md)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx = context.WithValue(ctx, grpcCallerKey{}, caller)
+	if caller.UserID != uuid.Nil {
+		ctx = withLogger(ctx, loggerFrom(ctx).With("user_id", caller.UserID))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(cfg.inflight.ctx, cancel)
+	cfg.inflight.wg.Add(1)
+	return ctx, func() {
+		stop()
+		cancel()
+		cfg.inflight.wg.Done()
+	}, nil
+}
+
+func (cfg *apiConfig) grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, done, err := cfg.grpcContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return handler(ctx, req)
+}
+
+func (cfg *apiConfig) grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done, err := cfg.grpcContext(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcServerStream is a stream with the context grpcContext made for it.
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateGRPC checks the "authorization" metadata, which holds what
+// the HTTP API's Authorization header does. Calls without it are
+// anonymous.
+func (cfg *apiConfig) authenticateGRPC(ctx context.Context, md metadata.MD) (grpcCaller, error) {
+	values := md.Get("authorization")
+	if len(values) == 0 || values[0] == "" {
+		return grpcCaller{}, nil
+	}
+	header := http.Header{"Authorization": values[:1]}
+
+	if key, err := auth.GetAPIKey(header); err == nil {
+		apiKey, user, err := cfg.apiKeyUser(key)
+		if err != nil {
+			return grpcCaller{}, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't check API key", err)
+		}
+		if apiKey == nil {
+			return grpcCaller{}, status.Error(codes.Unauthenticated, "Invalid or revoked API key")
+		}
+		if err := cfg.db.TouchAPIKey(apiKey.ID); err != nil {
+			loggerFrom(ctx).Warn("couldn't record API key use", "api_key_id", apiKey.ID, "error", err)
+		}
+		return grpcCaller{UserID: user.ID, ActorID: user.ID, Role: apiKeyRole(*user)}, nil
+	}
+
+	token, err := auth.GetBearerToken(header)
+	if err != nil {
+		return grpcCaller{}, status.Error(codes.Unauthenticated, "Malformed authorization metadata")
+	}
+	claims, err := auth.ParseAccessToken(token, cfg.jwtSecret)
+	if err != nil {
+		return grpcCaller{}, status.Error(codes.Unauthenticated, "Couldn't validate JWT")
+	}
+	user, err := cfg.db.GetUser(claims.UserID)
+	if err != nil {
+		return grpcCaller{}, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't get user", err)
+	}
+	if user == nil {
+		return grpcCaller{}, status.Error(codes.Unauthenticated, "User not found")
+	}
+	// as in requireRole
+	if user.RoleChangedAt != nil && !claims.IssuedAt.After(*user.RoleChangedAt) {
+		return grpcCaller{}, status.Error(codes.Unauthenticated, "Your role has changed, refresh your token or log in again")
+	}
+	caller := grpcCaller{UserID: claims.UserID, ActorID: claims.UserID, Role: claims.Role}
+	if claims.ImpersonatorID != uuid.Nil {
+		caller.ActorID = claims.ImpersonatorID
+	}
+	return caller, nil
+}
+
+// grpcError is the gRPC form of an HTTP error response: the status maps to
+// the closest code, and an error code becomes ErrorInfo details.
+func grpcError(ctx context.Context, httpStatus int, errorCode, msg string, err error) error {
+	logger := loggerFrom(ctx)
+	if err != nil {
+		logger.Info("request failed", "status", httpStatus, "error", err)
+	}
+	if httpStatus > 499 {
+		logger.Error("responding with 5XX error", "status", httpStatus, "message", msg)
+	}
+	st := status.New(grpcCode(httpStatus), msg)
+	if errorCode == "" {
+		return st.Err()
+	}
+	withInfo, detailErr := st.WithDetails(&errdetails.ErrorInfo{Reason: errorCode, Domain: grpcErrorDomain})
+	if detailErr != nil {
+		return st.Err()
+	}
+	return withInfo.Err()
+}
+
+// grpcIngestError is respondWithIngestError for gRPC calls.
+func grpcIngestError(ctx context.Context, e *ingestError) error {
+	return grpcError(ctx, e.Status, e.code(), e.Message, e.Err)
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// auditGRPC is audit for gRPC calls. The method stands in for the path.
+func (cfg *apiConfig) auditGRPC(ctx context.Context, entry database.CreateAuditEntryParams) {
+	caller := grpcCallerFrom(ctx)
+	if entry.ActorID == uuid.Nil {
+		entry.ActorID = caller.ActorID
+	}
+	if entry.UserID == uuid.Nil {
+		entry.UserID = caller.UserID
+	}
+	entry.Method = "GRPC"
+	entry.Path, _ = grpc.Method(ctx)
+	entry.RequestID, _ = ctx.Value(requestIDKey{}).(string)
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(entry.IP); err == nil {
+			entry.IP = host
+		}
+	}
+	if err := cfg.db.CreateAuditEntry(entry); err != nil {
+		loggerFrom(ctx).Error("couldn't write audit entry",
+			"action", entry.Action,
+			"actor_id", entry.ActorID,
+			"video_id", entry.VideoID,
+			"error", err,
+		)
+	}
+}
+
+// grpcVideoService implements the VideoService.
+type grpcVideoService struct {
+	tubelyv1.UnimplementedVideoServiceServer
+	cfg *apiConfig
+}
+
+// Upload is handlerUploadVideo for streams of chunks instead of multipart
+// forms.
+func (s *grpcVideoService) Upload(stream grpc.ClientStreamingServer[tubelyv1.UploadRequest, tubelyv1.UploadResponse]) error {
+	cfg := s.cfg
+	ctx := stream.Context()
+	caller := grpcCallerFrom(ctx)
+	if err := caller.requireRole(auth.RoleCreator); err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	meta := first.GetMetadata()
+	if meta == nil {
+		return status.Error(codes.InvalidArgument, "The first message must carry the upload's metadata")
+	}
+	videoID, err := uuid.Parse(meta.VideoId)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Invalid video ID")
+	}
+	var wantSHA256 []byte
+	if meta.Sha256 != "" {
+		wantSHA256, err = hex.DecodeString(meta.Sha256)
+		if err != nil || len(wantSHA256) != sha256.Size {
+			return status.Error(codes.InvalidArgument, "sha256 must be a hex encoded SHA-256")
+		}
+	}
+
+	logger := loggerFrom(ctx).With("video_id", videoID)
+	started := time.Now()
+	logger.Info("video upload started", "size", meta.Size)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return grpcError(ctx, http.StatusInternalServerError, "", "Couldn't get video", err)
+	}
+	if video.ID == uuid.Nil {
+		return status.Error(codes.NotFound, "Video not found")
+	}
+	if video.UserID != caller.UserID {
+		return status.Error(codes.PermissionDenied, "You do not own this video")
+	}
+
+	user, err := cfg.db.GetUser(caller.UserID)
+	if err != nil || user == nil {
+		return grpcError(ctx, http.StatusInternalServerError, "", "Couldn't get user", err)
+	}
+	maxUploadSize := cfg.uploadLimits.maxUploadSizeFor(user.Tier)
+	typeLimit, ok := cfg.uploadLimits.maxUploadSizeForType(user.Tier, meta.MediaType)
+	if !ok {
+		return status.Error(codes.InvalidArgument, "Invalid file type, accepted types are "+cfg.uploadLimits.acceptedMediaTypes())
+	}
+	limit := min(maxUploadSize, typeLimit)
+	if meta.Size > limit {
+		return grpcTooLarge(limit)
+	}
+	if meta.Size > 0 {
+		if err := cfg.checkGRPCQuota(ctx, caller.UserID, meta.Size); err != nil {
+			return err
+		}
+	}
+
+	tempFile, err := cfg.tempStore.Create(tempReservation(meta.Size, limit), "upload-*.mp4")
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return grpcError(ctx, http.StatusInsufficientStorage, "", "Not enough temporary storage for this upload, try again later", err)
+	}
+	if err != nil {
+		return grpcError(ctx, http.StatusInternalServerError, "", "Failed to create temp file", err)
+	}
+	defer tempFile.Release()
+
+	hasher := sha256.New()
+	dest := io.MultiWriter(tempFile, hasher)
+	var written int64
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if msg.GetMetadata() != nil {
+			return status.Error(codes.InvalidArgument, "Only the first message can carry metadata")
+		}
+		chunk := msg.GetChunk()
+		written += int64(len(chunk))
+		if written > limit {
+			return grpcTooLarge(limit)
+		}
+		_, err = dest.Write(chunk)
+		if errors.Is(err, tempstore.ErrNoCapacity) {
+			return grpcError(ctx, http.StatusInsufficientStorage, "", "Not enough temporary storage for this upload, try again later", err)
+		}
+		if err != nil {
+			return grpcError(ctx, http.StatusInternalServerError, "", "Failed to save uploaded file", err)
+		}
+	}
+	if written == 0 {
+		return status.Error(codes.InvalidArgument, "The upload has no file")
+	}
+	sum := hasher.Sum(nil)
+	if wantSHA256 != nil && !bytes.Equal(wantSHA256, sum) {
+		return status.Error(codes.DataLoss, "Uploaded file doesn't match sha256, it may have been corrupted in transit")
+	}
+	if meta.Size <= 0 {
+		if err := cfg.checkGRPCQuota(ctx, caller.UserID, written); err != nil {
+			return err
+		}
+	}
+
+	opts, err := cfg.resolveUploadOptions(caller.UserID, uploadOptions{
+		Visibility:        video.Visibility,
+		Profile:           meta.Profile,
+		NormalizeLoudness: meta.NormalizeLoudness,
+	})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		return grpcIngestError(ctx, ingestErr)
+	}
+	profile, err := getProcessingProfile(opts.Profile)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	profile.NormalizeLoudness = opts.NormalizeLoudness
+	logger.Info("video file received", "bytes", written)
+
+	ingestCtx, cancel := context.WithTimeout(withLogger(ctx, logger), 30*time.Minute)
+	defer cancel()
+	hadFile := video.VideoURL != nil
+	video, err = cfg.ingestVideo(ingestCtx, video, ingestSource{
+		Path:      tempFile.Name(),
+		MediaType: meta.MediaType,
+		SHA256:    hex.EncodeToString(sum),
+		Size:      written,
+		Filename:  cleanFilename(meta.Filename),
+	}, profile)
+	if errors.As(err, &ingestErr) {
+		logger.Error("video ingest failed", "error", err)
+		return grpcIngestError(ctx, ingestErr)
+	}
+	if err != nil {
+		return grpcError(ctx, http.StatusInternalServerError, "", "Failed to process video", err)
+	}
+	action := database.AuditVideoUploaded
+	if hadFile {
+		action = database.AuditVideoReplaced
+	}
+	cfg.auditGRPC(ctx, database.CreateAuditEntryParams{
+		UserID:    video.UserID,
+		Action:    action,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+		Detail:    video.OriginalFilename,
+	})
+
+	video, err = cfg.presentVideo(ctx, video)
+	if err != nil {
+		return grpcError(ctx, http.StatusInternalServerError, "", "Couldn't generate playback URL", err)
+	}
+	logger.Info("video upload complete", "bytes", written, "duration", time.Since(started))
+	return stream.SendAndClose(&tubelyv1.UploadResponse{Video: grpcVideo(video)})
+}
+
+// grpcTooLarge is respondWithTooLarge for gRPC calls.
+func grpcTooLarge(limit int64) error {
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", limit))
+}
+
+// checkGRPCQuota is checkStorageQuota for gRPC calls.
+func (cfg *apiConfig) checkGRPCQuota(ctx context.Context, userID uuid.UUID, size int64) error {
+	quota, err := cfg.storageQuotaStatus(userID)
+	if err != nil {
+		return grpcError(ctx, http.StatusInternalServerError, "", "Couldn't check storage quota", err)
+	}
+	if !quota.allows(size) {
+		return status.Error(codes.ResourceExhausted, "Upload would exceed your storage quota")
+	}
+	return nil
+}
+
+// GetVideo is handlerVideoGet, without localization.
+func (s *grpcVideoService) GetVideo(ctx context.Context, req *tubelyv1.GetVideoRequest) (*tubelyv1.Video, error) {
+	videoID, err := uuid.Parse(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid video ID")
+	}
+	video, err := s.cfg.db.GetVideo(videoID)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't get video", err)
+	}
+	// private videos look like they don't exist to anyone but the owner
+	if video.ID == uuid.Nil || !canView(video, grpcCallerFrom(ctx).UserID) {
+		return nil, status.Error(codes.NotFound, "Couldn't get video")
+	}
+	video, err = s.cfg.presentVideo(ctx, video)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't generate playback URL", err)
+	}
+	return grpcVideo(video), nil
+}
+
+// ListVideos is handlerVideosRetrieve, with the cursor in the response
+// rather than a header.
+func (s *grpcVideoService) ListVideos(ctx context.Context, req *tubelyv1.ListVideosRequest) (*tubelyv1.ListVideosResponse, error) {
+	userID := grpcCallerFrom(ctx).UserID
+	if userID == uuid.Nil {
+		return nil, status.Error(codes.Unauthenticated, "This needs an access token or API key")
+	}
+
+	ownerID := userID
+	if req.UserId != "" {
+		var err error
+		ownerID, err = uuid.Parse(req.UserId)
+		// the nil ID would list everyone's videos
+		if err != nil || ownerID == uuid.Nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid user ID")
+		}
+	}
+	limit := int(req.PageSize)
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit < 1 || limit > maxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxPageSize)
+	}
+
+	params := database.GetVideosPageParams{
+		UserID: ownerID,
+		// fetch one extra row to learn whether another page exists
+		Limit: limit + 1,
+		// other people's channels only list public videos
+		PublicOnly: ownerID != userID,
+	}
+	if req.Tag != "" {
+		tag, err := normalizeTag(req.Tag)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		params.Tag = tag
+	}
+	if req.PageToken != "" {
+		cursor, err := decodePageCursor(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid page token")
+		}
+		params.BeforeCreatedAt = cursor.CreatedAt
+		params.BeforeID = cursor.ID
+	}
+
+	videos, err := s.cfg.db.GetVideosPage(params)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't retrieve videos", err)
+	}
+	resp := &tubelyv1.ListVideosResponse{}
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[len(videos)-1]
+		resp.NextPageToken = pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	videos, err = s.cfg.presentVideos(ctx, videos, userID)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't generate playback URLs", err)
+	}
+	for _, video := range videos {
+		resp.Videos = append(resp.Videos, grpcVideo(video))
+	}
+	return resp, nil
+}
+
+// DeleteVideo is handlerVideoMetaDelete.
+func (s *grpcVideoService) DeleteVideo(ctx context.Context, req *tubelyv1.DeleteVideoRequest) (*tubelyv1.DeleteVideoResponse, error) {
+	caller := grpcCallerFrom(ctx)
+	if err := caller.requireRole(auth.RoleCreator); err != nil {
+		return nil, err
+	}
+	videoID, err := uuid.Parse(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid ID")
+	}
+	video, err := s.cfg.db.GetVideo(videoID)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't get video", err)
+	}
+	if video.ID == uuid.Nil {
+		return nil, status.Error(codes.NotFound, "Couldn't get video")
+	}
+	if video.UserID != caller.UserID {
+		return nil, status.Error(codes.PermissionDenied, "You can't delete this video")
+	}
+
+	if err := s.cfg.deleteVideo(video); err != nil {
+		return nil, grpcError(ctx, http.StatusInternalServerError, "", "Couldn't delete video", err)
+	}
+	s.cfg.auditGRPC(ctx, database.CreateAuditEntryParams{
+		Action:    database.AuditVideoRemoved,
+		VideoID:   video.ID,
+		ObjectKey: s.cfg.videoObjectKey(video),
+	})
+	return &tubelyv1.DeleteVideoResponse{}, nil
+}
+
+// grpcVideo converts a presented video to its message.
+func grpcVideo(video database.Video) *tubelyv1.Video {
+	msg := &tubelyv1.Video{
+		Id:               video.ID.String(),
+		CreatedAt:        timestamppb.New(video.CreatedAt),
+		UpdatedAt:        timestamppb.New(video.UpdatedAt),
+		UserId:           video.UserID.String(),
+		Title:            video.Title,
+		Description:      video.Description,
+		Visibility:       video.Visibility,
+		DefaultLanguage:  video.DefaultLanguage,
+		MediaKind:        video.MediaKind,
+		OriginalFilename: video.OriginalFilename,
+		ViewCount:        video.ViewCount,
+		Version:          int32(video.Version),
+		ModerationStatus: video.ModerationStatus,
+	}
+	if video.ThumbnailURL != nil {
+		msg.ThumbnailUrl = *video.ThumbnailURL
+	}
+	if video.VideoURL != nil {
+		msg.VideoUrl = *video.VideoURL
+	}
+	if video.Width != nil && video.Height != nil {
+		msg.Width, msg.Height = int32(*video.Width), int32(*video.Height)
+	}
+	if video.Duration != nil {
+		msg.Duration = *video.Duration
+	}
+	if video.ReadyAt != nil {
+		msg.ReadyAt = timestamppb.New(*video.ReadyAt)
+	}
+	return msg
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerAdminUsersGet lists every user, newest first, with how much
+// storage they use.
+func (cfg *apiConfig) handlerAdminUsersGet(w http.ResponseWriter, r *http.Request) {
+	type userResponse struct {
+		database.UserOverview
+		Storage quotaStatus `json:"storage"`
+	}
+
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+
+	limit, err := parsePageSize(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params := database.GetUsersPageParams{Limit: limit + 1}
+	if cursorString := r.URL.Query().Get("cursor"); cursorString != "" {
+		cursor, err := decodePageCursor(cursorString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.BeforeCreatedAt = cursor.CreatedAt
+		params.BeforeID = cursor.ID
+	}
+
+	users, err := cfg.db.GetUsersPage(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[len(users)-1]
+		setNextPageHeaders(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode())
+	}
+
+	resp := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		status, err := cfg.storageQuotaStatus(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+			return
+		}
+		if user.IsAdmin {
+			user.Role = string(auth.RoleAdmin)
+		}
+		resp = append(resp, userResponse{UserOverview: user, Storage: status})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminUserUsageGet reports one user's storage use against their
+// quota.
+func (cfg *apiConfig) handlerAdminUserUsageGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	status, err := cfg.storageQuotaStatus(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, status)
+}
+
+// handlerAdminUserRoleUpdate makes a user a creator or a read-only
+// viewer. Admins come from ADMIN_EMAILS, so the admin role can't be given
+// here. Their current access tokens stop working right away.
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	admin, ok := cfg.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if role == auth.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "Admins are set with ADMIN_EMAILS", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	if user.Role != string(role) {
+		if err := cfg.db.SetUserRole(user.ID, string(role)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+			return
+		}
+		cfg.audit(r, database.CreateAuditEntryParams{
+			ActorID: admin.ID,
+			UserID:  user.ID,
+			Action:  database.AuditRoleChanged,
+			Detail:  user.Role + " -> " + string(role),
+		})
+		user.Role = string(role)
+	}
+
+	user.Password = ""
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// handlerAdminUserStorageRegionUpdate sets the region a user's new videos
+// are stored in when buckets are sharded by region, "" for the default
+// bucket. Videos already stored stay where they are.
+func (cfg *apiConfig) handlerAdminUserStorageRegionUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Region string `json:"region"`
+	}
+	type response struct {
+		UserID uuid.UUID `json:"user_id"`
+		Region string    `json:"region"`
+	}
+
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Region != "" && !cfg.buckets.hasRegion(params.Region) {
+		respondWithError(w, http.StatusBadRequest, "No bucket is in region "+params.Region, nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if err := cfg.db.SetUserStorageRegion(user.ID, params.Region); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update storage region", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{UserID: user.ID, Region: params.Region})
+}
+
+// handlerAdminVideosGet lists every user's videos, newest first, whatever
+// their visibility. user_id, tag and moderation narrow the list;
+// moderation=pending_review lists the videos waiting on a review.
+func (cfg *apiConfig) handlerAdminVideosGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+
+	limit, err := parsePageSize(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params := database.GetVideosPageParams{Limit: limit + 1}
+	if owner := r.URL.Query().Get("user_id"); owner != "" {
+		params.UserID, err = uuid.Parse(owner)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+			return
+		}
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		params.Tag, err = normalizeTag(tag)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	switch status := r.URL.Query().Get("moderation"); status {
+	case "", database.ModerationPending, database.ModerationApproved, database.ModerationPendingReview, database.ModerationRejected:
+		params.ModerationStatus = status
+	default:
+		respondWithError(w, http.StatusBadRequest, "moderation must be pending, approved, pending_review or rejected", nil)
+		return
+	}
+	if cursorString := r.URL.Query().Get("cursor"); cursorString != "" {
+		cursor, err := decodePageCursor(cursorString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.BeforeCreatedAt = cursor.CreatedAt
+		params.BeforeID = cursor.ID
+	}
+
+	videos, err := cfg.db.GetVideosPage(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[len(videos)-1]
+		setNextPageHeaders(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode())
+	}
+
+	for i, video := range videos {
+		videos[i], err = cfg.presentVideo(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerAdminVideoDelete deletes any user's video along with its stored
+// files. Unlike an owner's delete, the cleanup runs before responding, so
+// the objects are gone once it returns 204. If part of it fails, the
+// tombstone worker keeps retrying and the response is 202 with the error.
+// The optional reason query parameter is kept in the audit log.
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	type pendingResponse struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Error   string    `json:"error"`
+	}
+
+	admin, ok := cfg.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+
+	if err := cfg.deleteVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		ActorID:   admin.ID,
+		UserID:    video.UserID,
+		Action:    database.AuditVideoDeleted,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+		Detail:    strings.TrimSpace(r.URL.Query().Get("reason")),
+	})
+
+	tombstone, err := cfg.db.GetTombstone(video.ID)
+	if err == nil && tombstone != nil {
+		if err = cfg.processTombstone(r.Context(), *tombstone); err == nil {
+			tombstone, err = cfg.db.GetTombstone(video.ID)
+		}
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Video deleted, but couldn't check its cleanup", err)
+		return
+	}
+	if tombstone != nil {
+		resp := pendingResponse{VideoID: video.ID}
+		if tombstone.LastError != nil {
+			resp.Error = *tombstone.LastError
+		}
+		respondWithJSON(w, http.StatusAccepted, resp)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminOrphansScan scans the bucket for objects no video refers to,
+// such as those of uploads that failed after storing their file. They're
+// reported, or deleted too when delete is set. Objects stored in the last
+// day are left alone, their uploads may still be running.
+func (cfg *apiConfig) handlerAdminOrphansScan(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Delete bool `json:"delete"`
+	}
+
+	admin, ok := cfg.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+
+	scan, err := cfg.collectOrphans(r.Context(), params.Delete)
+	if errors.Is(err, errOrphanScanRunning) {
+		respondWithError(w, http.StatusConflict, "A scan is already running", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't scan the bucket", err)
+		return
+	}
+	for _, orphan := range scan.Orphans {
+		if orphan.Deleted {
+			cfg.audit(r, database.CreateAuditEntryParams{
+				ActorID:   admin.ID,
+				Action:    database.AuditOrphanDeleted,
+				ObjectKey: orphan.Key,
+			})
+		}
+	}
+	respondWithJSON(w, http.StatusOK, scan)
+}
+
+// handlerAdminStorageCheck checks that every object the database refers to
+// is in the bucket at the size recorded, and suggests repairs for the
+// videos that aren't. Nothing is changed.
+func (cfg *apiConfig) handlerAdminStorageCheck(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+	report, err := cfg.checkConsistency(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't check storage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// captionLabelMaxLength caps the track name players show for captions
+const captionLabelMaxLength = 100
+
+// captionObjectKey is where a video's captions in a language are stored.
+// Every upload gets a key of its own, so the captions it replaces stay
+// intact until the new ones are committed.
+func captionObjectKey(videoID uuid.UUID, language string) (string, error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("videos/%s/captions/%s-%x.vtt", videoID, language, randomBytes), nil
+}
+
+func (cfg *apiConfig) handlerVideoCaptionsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, cfg.optionalViewerID(r)) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	captions, err := cfg.db.GetCaptions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+	captions, err = cfg.presentCaptions(r.Context(), video, captions)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate caption URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, captions)
+}
+
+// handlerVideoCaptionPut stores the captions uploaded as the "captions"
+// form file as the video's captions in a language, replacing any it had.
+// SRT files are converted to WebVTT, which is what browsers play.
+func (cfg *apiConfig) handlerVideoCaptionPut(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// leave room for the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionSize+64<<10)
+	if err := r.ParseMultipartForm(maxCaptionSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Caption files can be at most %d MB", maxCaptionSize>>20), err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
+		return
+	}
+	file, _, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to retrieve caption file", err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxCaptionSize+1))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read caption file", err)
+		return
+	}
+	if len(data) > maxCaptionSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Caption files can be at most %d MB", maxCaptionSize>>20), nil)
+		return
+	}
+	vtt, err := toWebVTT(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid captions: "+err.Error(), err)
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = language
+	}
+	if utf8.RuneCountInString(label) > captionLabelMaxLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("label can be at most %d characters", captionLabelMaxLength), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	key, err := captionObjectKey(videoID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate object key", err)
+		return
+	}
+	sum := sha256.Sum256(vtt)
+	bucket := cfg.videoBucket(video)
+	err = cfg.putPendingObject(r.Context(), video, "", key, bytes.NewReader(vtt), "text/vtt; charset=utf-8", hex.EncodeToString(sum[:]))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to upload captions to S3", err)
+		return
+	}
+
+	caption := database.Caption{
+		VideoID:  videoID,
+		Language: language,
+		Label:    label,
+		URL:      cfg.getObjectURL(bucket, key),
+		Size:     int64(len(vtt)),
+		Source:   database.CaptionSourceUpload,
+		// close enough to the stored time for the response
+		CreatedAt: time.Now().UTC(),
+	}
+	replaced, err := cfg.db.CommitCaption(caption, key)
+	if err != nil {
+		cfg.discardPendingObjects(r.Context(), bucket, []string{key})
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+		return
+	}
+	if oldBucket, oldKey, ok := cfg.objectFromURL(replaced); ok && replaced != "" {
+		cfg.releaseObjects(r.Context(), oldBucket, []string{oldKey})
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:    database.AuditCaptionUploaded,
+		VideoID:   videoID,
+		ObjectKey: key,
+		Detail:    language,
+	})
+
+	captions, err := cfg.presentCaptions(r.Context(), video, []database.Caption{caption})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate caption URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, captions[0])
+}
+
+func (cfg *apiConfig) handlerVideoCaptionDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	url, err := cfg.db.DeleteCaption(videoID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete captions", err)
+		return
+	}
+	if url == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no captions in that language", nil)
+		return
+	}
+	bucket, key, ok := cfg.objectFromURL(url)
+	if ok {
+		cfg.releaseObjects(r.Context(), bucket, []string{key})
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:    database.AuditCaptionDeleted,
+		VideoID:   videoID,
+		ObjectKey: key,
+		Detail:    language,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// presentCaptions prepares a video's captions for a response. Like the
+// video's file, captions of private videos, or of any video with
+// VIDEO_DELIVERY=proxy, get short-lived presigned URLs, and others go
+// through Transfer Acceleration when it's on.
+func (cfg *apiConfig) presentCaptions(ctx context.Context, video database.Video, captions []database.Caption) ([]database.Caption, error) {
+	if captions == nil {
+		return []database.Caption{}, nil
+	}
+	presign := objectsHidden(video) || cfg.videoDelivery == deliveryProxy
+	if !presign && !cfg.s3Accelerate {
+		return captions, nil
+	}
+	presented := make([]database.Caption, len(captions))
+	for i, caption := range captions {
+		presented[i] = caption
+		bucket, key, ok := cfg.objectFromURL(caption.URL)
+		if !ok {
+			continue
+		}
+		if !presign {
+			presented[i].URL = cfg.clientObjectURL(bucket, key)
+			continue
+		}
+		url, err := cfg.presignClientGetObject(ctx, bucket, key, privateURLExpiry)
+		if err != nil {
+			return nil, err
+		}
+		presented[i].URL = url
+	}
+	return presented, nil
+}
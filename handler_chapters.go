@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideoChaptersPatch replaces a video's chapters with the list in
+// the body. An empty list removes them.
+func (cfg *apiConfig) handlerVideoChaptersPatch(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Chapters *[]database.Chapter `json:"chapters"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Chapters == nil {
+		respondWithError(w, http.StatusBadRequest, "chapters is required", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	chapters, err := validateChapters(*params.Chapters, video.Duration)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.SetChapters(videoID, chapters)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:  database.AuditChaptersUpdated,
+		VideoID: videoID,
+		Detail:  strconv.Itoa(len(chapters)),
+	})
+
+	respondWithJSON(w, http.StatusOK, chapters)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindClip = "clip"
+	// maxClipLength caps how long a clip can be, in seconds
+	maxClipLength = 10 * 60
+	// clipTimeout bounds cutting and storing a clip
+	clipTimeout = 30 * time.Minute
+)
+
+// clipParams are what a clip job runs with. The clip's video records the
+// same, this is what the job was asked for.
+type clipParams struct {
+	SourceVideoID uuid.UUID `json:"source_video_id"`
+	Start         float64   `json:"start"`
+	End           float64   `json:"end"`
+}
+
+// handlerCreateClip cuts a part of a video into a new video of its own,
+// linked to the source. The clip is created right away, its file is cut as
+// a job; the response points at the job, whose video_id is the clip.
+func (cfg *apiConfig) handlerCreateClip(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Start and End are in seconds into the source video
+		Start       *float64 `json:"start"`
+		End         *float64 `json:"end"`
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+	}
+
+	sourceID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Start == nil || params.End == nil {
+		respondWithError(w, http.StatusBadRequest, "start and end are required", nil)
+		return
+	}
+	start, end := *params.Start, *params.End
+	if start < 0 || end <= start {
+		respondWithError(w, http.StatusBadRequest, "start must be non-negative and end after it", nil)
+		return
+	}
+	if end-start > maxClipLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Clips can be at most %d minutes long", maxClipLength/60), nil)
+		return
+	}
+
+	source, err := cfg.db.GetVideo(sourceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if source.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file to clip", nil)
+		return
+	}
+	if respondIfArchived(w, source) {
+		return
+	}
+	if source.MediaKind != database.MediaKindVideo {
+		respondWithError(w, http.StatusConflict, "Clips can only be cut from video files", nil)
+		return
+	}
+	if source.Duration != nil && end > *source.Duration {
+		respondWithError(w, http.StatusBadRequest, "end is past the end of the video", nil)
+		return
+	}
+
+	// the clip's size is only known once it's cut, the limit is checked against an estimate
+	var estimate int64
+	if source.Bitrate != nil {
+		estimate = int64(float64(*source.Bitrate) * (end - start) / 8)
+	}
+	if !cfg.checkStorageQuota(w, source.UserID, estimate) {
+		return
+	}
+
+	title := strings.TrimSpace(params.Title)
+	if title == "" {
+		title = source.Title + " (clip)"
+	}
+	bucket, err := cfg.newVideoBucket(source.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't pick a bucket", err)
+		return
+	}
+	clip, err := cfg.db.CreateClip(database.CreateVideoParams{
+		Title:           title,
+		Description:     params.Description,
+		UserID:          source.UserID,
+		Visibility:      source.Visibility,
+		DefaultLanguage: source.DefaultLanguage,
+		StorageBucket:   bucket,
+	}, database.Clip{SourceVideoID: source.ID, Start: start, End: end})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create clip", err)
+		return
+	}
+
+	jobParams, err := json.Marshal(clipParams{SourceVideoID: source.ID, Start: start, End: end})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode job parameters", err)
+		return
+	}
+	job, err := cfg.db.CreateJob(clip.UserID, clip.ID, jobKindClip, string(jobParams))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:  database.AuditClipCreated,
+		VideoID: clip.ID,
+		Detail:  source.ID.String(),
+	})
+	cfg.startJob(r.Context(), job)
+
+	w.Header().Set("Location", apiPath(r, "/jobs/"+job.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// handlerVideoClipsGet lists the clips cut from a video that the viewer
+// can see.
+func (cfg *apiConfig) handlerVideoClipsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	viewerID := cfg.optionalViewerID(r)
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, viewerID) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	clips, err := cfg.db.GetClips(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clips", err)
+		return
+	}
+	clips, err = cfg.presentVideos(r.Context(), clips, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, clips)
+}
+
+func (cfg *apiConfig) runClipJob(ctx context.Context, job database.Job) error {
+	var params clipParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return fmt.Errorf("invalid job parameters: %w", err)
+	}
+	return cfg.renderClip(ctx, job.VideoID, params)
+}
+
+// renderClip cuts the clip's part of the source video and ingests it as
+// the clip's file, the way an upload would be.
+func (cfg *apiConfig) renderClip(ctx context.Context, clipID uuid.UUID, params clipParams) error {
+	clip, err := cfg.db.GetVideo(clipID)
+	if err != nil {
+		return err
+	}
+	if clip.ID == uuid.Nil {
+		return errors.New("clip was deleted")
+	}
+	source, err := cfg.db.GetVideo(params.SourceVideoID)
+	if err != nil {
+		return err
+	}
+	if source.ID == uuid.Nil || source.VideoURL == nil {
+		return errors.New("source video no longer has a file")
+	}
+	bucket, key, ok := cfg.objectFromURL(*source.VideoURL)
+	if !ok {
+		return errors.New("source video file isn't stored in this bucket")
+	}
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return fmt.Errorf("couldn't presign video URL: %w", err)
+	}
+
+	var estimate int64
+	if source.Bitrate != nil {
+		estimate = int64(float64(*source.Bitrate) * (params.End - params.Start) / 8)
+	}
+	out, err := cfg.tempStore.Create(estimate, "clip-*.mp4")
+	if err != nil {
+		return fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer out.Release()
+
+	_, span := tracer.Start(ctx, "cut clip")
+	err = cfg.media.cutClip(ctx, sourceURL, params.Start, params.End, out.Name())
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(out.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	sha, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+
+	profile, _ := getProcessingProfile("")
+	_, err = cfg.ingestVideo(ctx, clip, ingestSource{
+		Path:      out.Name(),
+		MediaType: "video/mp4",
+		SHA256:    sha,
+		Size:      info.Size(),
+	}, profile)
+	return err
+}
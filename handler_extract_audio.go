@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	"github.com/google/uuid"
+)
+
+const (
+	// extracted audio is encoded at this many bits per second
+	extractedAudioBitrate = 192_000
+	// extractAudioTimeout bounds the ffmpeg run, which reads the whole file
+	extractAudioTimeout = 30 * time.Minute
+)
+
+// extractedAudioFormat is a format a video's audio can be extracted to.
+type extractedAudioFormat struct {
+	Ext         string
+	ContentType string
+	CodecArgs   []string
+}
+
+var extractedAudioFormats = map[string]extractedAudioFormat{
+	"m4a": {".m4a", "audio/mp4", []string{"-c:a", "aac", "-b:a", "192k", "-movflags", "faststart"}},
+	"mp3": {".mp3", "audio/mpeg", []string{"-c:a", "libmp3lame", "-b:a", "192k"}},
+}
+
+// extractedAudioObjectKey is where audio extracted from version n of a
+// video's file is stored, next to the file itself. Every extraction gets a
+// key of its own, so the audio it replaces stays intact until the new one
+// is committed.
+func extractedAudioObjectKey(videoID uuid.UUID, version int, ext string) (string, error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("videos/%s/v%d_audio_%x%s", videoID, version, randomBytes, ext), nil
+}
+
+// handlerExtractAudio extracts the audio track of a video's current file
+// and stores it as the video's "audio" rendition, replacing an earlier
+// one, so it can be offered as a podcast or to listeners who don't need
+// the picture.
+func (cfg *apiConfig) handlerExtractAudio(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Format is m4a, the default, or mp3
+		Format string `json:"format"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Format == "" {
+		params.Format = "m4a"
+	}
+	format, ok := extractedAudioFormats[params.Format]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "format must be m4a or mp3", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file to extract audio from", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+	if video.MediaKind == database.MediaKindAudio {
+		respondWithError(w, http.StatusConflict, "Video's file is audio already", nil)
+		return
+	}
+	sourceKey, ok := cfg.keyInBucket(cfg.videoBucket(video), *video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in this bucket", nil)
+		return
+	}
+
+	// the audio's size is only known once it's extracted, the limit is checked against an estimate
+	var estimate int64
+	if video.Duration != nil {
+		estimate = int64(*video.Duration * extractedAudioBitrate / 8)
+	}
+	if !cfg.checkStorageQuota(w, video.UserID, estimate) {
+		return
+	}
+
+	rendition, err := cfg.extractAudio(r.Context(), video, sourceKey, estimate, params.Format, format)
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract audio", err)
+		return
+	}
+	cfg.updateQuotaAlerts(r.Context(), video.UserID)
+
+	// like other renditions, private ones are handed out presigned
+	bucket, key, _ := cfg.objectFromURL(rendition.VideoURL)
+	if objectsHidden(video) || cfg.videoDelivery == deliveryProxy {
+		rendition.VideoURL, err = cfg.presignClientGetObject(r.Context(), bucket, key, privateURLExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+			return
+		}
+	} else {
+		rendition.VideoURL = cfg.clientObjectURL(bucket, key)
+	}
+	respondWithJSON(w, http.StatusCreated, rendition)
+}
+
+// extractAudio runs ffmpeg on video's object at sourceKey, stores what it
+// writes and commits it as video's audio rendition. estimate is the space
+// reserved for the output. Errors are *ingestError.
+func (cfg *apiConfig) extractAudio(ctx context.Context, video database.Video, sourceKey string, estimate int64, formatName string, format extractedAudioFormat) (database.CreateRenditionParams, error) {
+	logger := loggerFrom(ctx)
+
+	out, err := cfg.tempStore.Create(estimate, "audio-*"+format.Ext)
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInsufficientStorage, "Not enough temporary storage to extract the audio, try again later", err}
+	}
+	if err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Failed to create temp file", err}
+	}
+	defer out.Release()
+
+	bucket := cfg.videoBucket(video)
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, sourceKey, privateURLExpiry)
+	if err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Couldn't presign video URL", err}
+	}
+	extractCtx, cancel := context.WithTimeout(ctx, extractAudioTimeout)
+	defer cancel()
+	extractStarted := time.Now()
+	_, span := tracer.Start(ctx, "extract audio")
+	err = cfg.media.extractAudio(extractCtx, sourceURL, format.CodecArgs, out.Name())
+	endSpan(span, err)
+	if errors.Is(err, errNoAudioStream) {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusUnprocessableEntity, "Video has no audio to extract", err}
+	}
+	if err != nil {
+		return database.CreateRenditionParams{}, mediaIngestError("Failed to extract audio", err)
+	}
+
+	file, err := os.Open(out.Name())
+	if err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Failed to open extracted audio", err}
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Failed to read extracted audio", err}
+	}
+	sha, err := hashFile(file)
+	if err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Failed to hash extracted audio", err}
+	}
+
+	key, err := extractedAudioObjectKey(video.ID, video.Version, format.Ext)
+	if err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Couldn't generate object key", err}
+	}
+	if err := cfg.putPendingObject(ctx, video, "", key, file, format.ContentType, sha); err != nil {
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Failed to upload audio to S3", err}
+	}
+	logger.Info("extracted audio",
+		"key", key,
+		"format", formatName,
+		"bytes", info.Size(),
+		"duration", time.Since(extractStarted),
+	)
+
+	rendition := database.CreateRenditionParams{
+		VideoID:  video.ID,
+		Kind:     "audio",
+		VideoURL: cfg.getObjectURL(bucket, key),
+		Size:     info.Size(),
+		SHA256:   sha,
+	}
+	replaced, err := cfg.db.CommitRendition(video.Version, *video.VideoURL, rendition, []string{key})
+	if err != nil {
+		cfg.discardPendingObjects(ctx, bucket, []string{key})
+		if errors.Is(err, database.ErrVideoFileChanged) {
+			return database.CreateRenditionParams{}, &ingestError{http.StatusConflict, "Video's file was replaced while its audio was extracted, try again", err}
+		}
+		return database.CreateRenditionParams{}, &ingestError{http.StatusInternalServerError, "Couldn't save audio rendition", err}
+	}
+	// the earlier audio is unused now unless another version kept it
+	if oldKey, ok := cfg.keyInBucket(bucket, replaced); ok && replaced != "" {
+		cfg.releaseObjects(ctx, bucket, []string{oldKey})
+	}
+	return rendition, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerImportS3 sets a video's file from an object already in S3, named
+// by a presigned GET URL or by bucket and key. The object is copied within
+// S3 rather than downloaded and uploaded again. The source bucket must be
+// in the server's region and listed in S3_IMPORT_BUCKETS, since the copy
+// uses Tubely's credentials rather than the URL's signature. A URL must
+// still grant access itself, while bucket and key let any creator import
+// any object in an allowed bucket.
+func (cfg *apiConfig) handlerImportS3(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL     string `json:"url"`
+		Bucket  string `json:"bucket"`
+		Key     string `json:"key"`
+		Profile string `json:"profile"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if len(cfg.s3ImportBuckets) == 0 {
+		respondWithError(w, http.StatusNotImplemented, "Importing from S3 isn't enabled on this server", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	src := s3ImportSource{Bucket: params.Bucket, Key: params.Key}
+	checkURL := false
+	switch {
+	case params.URL != "" && (params.Bucket != "" || params.Key != ""):
+		respondWithError(w, http.StatusBadRequest, "Send either url or bucket and key, not both", nil)
+		return
+	case params.URL != "":
+		var region string
+		var err error
+		src, region, err = parseS3URL(params.URL)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid S3 URL: "+err.Error(), err)
+			return
+		}
+		if region != "" && region != cfg.s3Region {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("The source bucket must be in %s", cfg.s3Region), nil)
+			return
+		}
+		checkURL = true
+	case params.Bucket == "" || params.Key == "":
+		respondWithError(w, http.StatusBadRequest, "url, or bucket and key, is required", nil)
+		return
+	}
+	if !slices.Contains(cfg.s3ImportBuckets, src.Bucket) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Importing from bucket %s isn't allowed", src.Bucket), nil)
+		return
+	}
+	if checkURL {
+		var ingestErr *ingestError
+		if err := cfg.checkPresignedURL(r.Context(), params.URL); errors.As(err, &ingestErr) {
+			respondWithIngestError(w, ingestErr)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	user, err := cfg.db.GetUser(video.UserID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	head, err := cfg.headObject(r.Context(), src.Bucket, src.Key)
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusMovedPermanently:
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("The source bucket must be in %s", cfg.s3Region), err)
+			return
+		case http.StatusForbidden:
+			respondWithError(w, http.StatusForbidden, "Tubely can't read the source object", err)
+			return
+		case http.StatusNotFound:
+			respondWithError(w, http.StatusNotFound, "Source object not found", err)
+			return
+		}
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read the source object", err)
+		return
+	}
+	size := aws.ToInt64(head.ContentLength)
+
+	// imports are stored as MP4s
+	maxUploadSize, ok := cfg.uploadLimits.maxUploadSizeForType(user.Tier, "video/mp4")
+	if !ok {
+		respondWithErrorCode(w, http.StatusBadRequest, errorCodeUnsupportedMediaType, "Invalid file type, accepted types are "+cfg.uploadLimits.acceptedMediaTypes(), nil)
+		return
+	}
+	if size > maxUploadSize {
+		respondWithTooLarge(w, maxUploadSize, nil)
+		return
+	}
+	if !cfg.checkStorageQuota(w, user.ID, size) {
+		return
+	}
+
+	opts, err := cfg.resolveUploadOptions(user.ID, uploadOptions{
+		Visibility: video.Visibility,
+		Profile:    params.Profile,
+	})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	profile, err := getProcessingProfile(opts.Profile)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("video_id", video.ID, "user_id", user.ID)
+	ctx, cancel := context.WithTimeout(withLogger(r.Context(), logger), 2*time.Hour)
+	defer cancel()
+
+	cfg.publishUploaded(ctx, video, database.MediaKindVideo)
+	imported, err := cfg.importS3Object(ctx, video, src, size, profile)
+	cfg.reportIngest(ctx, video, err)
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to import video", err)
+		return
+	}
+	cfg.auditUpload(r, video.VideoURL != nil, imported, "imported from s3://"+src.Bucket+"/"+src.Key)
+
+	presented, err := cfg.presentVideo(ctx, imported)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message":   "Video imported successfully",
+		"video_url": *presented.VideoURL,
+	})
+}
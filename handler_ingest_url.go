@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindIngestURL = "ingest_url"
+	// urlIngestTimeout bounds fetching a file and processing it
+	urlIngestTimeout = 2 * time.Hour
+	// urlIngestProgressInterval is how often a download's progress is saved
+	// on its job
+	urlIngestProgressInterval = 2 * time.Second
+)
+
+// urlIngestParams are what an ingest_url job runs with. The options were
+// resolved against the owner's upload policy when the job was created.
+type urlIngestParams struct {
+	URL               string `json:"url"`
+	Profile           string `json:"profile,omitempty"`
+	NormalizeLoudness bool   `json:"normalize_loudness,omitempty"`
+}
+
+// handlerIngestURL sets a video's file from one hosted elsewhere. The
+// server downloads it as a job, checking its size and type as an upload's,
+// then runs it through the same pipeline; the response points at the job,
+// whose progress counts the bytes downloaded.
+func (cfg *apiConfig) handlerIngestURL(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL               string `json:"url"`
+		Profile           string `json:"profile"`
+		NormalizeLoudness bool   `json:"normalize_loudness"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	source, err := parseIngestURL(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid url: "+err.Error(), err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// the size is only known once the download starts, but a user already
+	// over quota needn't wait for it
+	if !cfg.checkStorageQuota(w, video.UserID, 0) {
+		return
+	}
+
+	opts, err := cfg.resolveUploadOptions(video.UserID, uploadOptions{
+		Visibility:        video.Visibility,
+		Profile:           params.Profile,
+		NormalizeLoudness: params.NormalizeLoudness,
+	})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	if _, err := getProcessingProfile(opts.Profile); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	jobParams, err := json.Marshal(urlIngestParams{
+		URL:               source.String(),
+		Profile:           opts.Profile,
+		NormalizeLoudness: opts.NormalizeLoudness,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode job parameters", err)
+		return
+	}
+	job, err := cfg.db.CreateJob(video.UserID, video.ID, jobKindIngestURL, string(jobParams))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		UserID:  video.UserID,
+		Action:  database.AuditURLIngestStarted,
+		VideoID: video.ID,
+		Detail:  redactURL(source),
+	})
+	cfg.startJob(r.Context(), job)
+
+	w.Header().Set("Location", apiPath(r, "/jobs/"+job.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// parseIngestURL checks rawURL is an absolute http or https URL. Which
+// addresses it may reach is checked when it's fetched, see
+// newIngestHTTPClient.
+func parseIngestURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, errors.New("url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("URL must use http or https")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("URL has no host")
+	}
+	if u.User != nil {
+		return nil, errors.New("URL can't contain credentials")
+	}
+	return u, nil
+}
+
+// redactURL returns u without its query and fragment, which often carry
+// signatures or tokens that shouldn't be kept in logs.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.RawQuery = ""
+	redacted.Fragment = ""
+	return redacted.String()
+}
+
+// newIngestHTTPClient returns the client files are fetched from URLs with.
+// Unless allowPrivate is set, it refuses to connect to loopback, private
+// and link-local addresses, redirects included, so a URL can't be used to
+// reach services on the server's own network.
+func newIngestHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return fmt.Errorf("connecting to %s isn't allowed", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = time.Minute
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect must use http or https")
+			}
+			return nil
+		},
+	}
+}
+
+func (cfg *apiConfig) runIngestURLJob(ctx context.Context, job database.Job) error {
+	var params urlIngestParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return fmt.Errorf("invalid job parameters: %w", err)
+	}
+	return cfg.ingestFromURL(ctx, job.ID, job.VideoID, params)
+}
+
+// ingestFromURL downloads the file at params.URL, recording progress on
+// the job as it goes, and ingests it as the video's file the way an upload
+// would be.
+func (cfg *apiConfig) ingestFromURL(ctx context.Context, jobID, videoID uuid.UUID, params urlIngestParams) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return errors.New("video was deleted")
+	}
+	user, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("video's owner was deleted")
+	}
+	maxUploadSize := cfg.uploadLimits.maxUploadSizeFor(user.Tier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params.URL, nil)
+	if err != nil {
+		return err
+	}
+	_, fetchSpan := tracer.Start(ctx, "fetch url")
+	resp, err := cfg.ingestClient.Do(req)
+	endSpan(fetchSpan, err)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't fetch file: server responded %s", resp.Status)
+	}
+	if resp.ContentLength > maxUploadSize {
+		return fmt.Errorf("file is %d bytes, over the limit of %d", resp.ContentLength, maxUploadSize)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	mediaType, err := ingestMediaType(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return err
+	}
+	typeLimit, ok := cfg.uploadLimits.maxUploadSizeForType(user.Tier, mediaType)
+	if !ok {
+		return fmt.Errorf("file type %s isn't accepted, accepted types are %s", mediaType, cfg.uploadLimits.acceptedMediaTypes())
+	}
+	if resp.ContentLength > typeLimit {
+		return fmt.Errorf("file is %d bytes, over the limit of %d for %s", resp.ContentLength, typeLimit, mediaType)
+	}
+
+	tempFile, err := cfg.tempStore.Create(tempReservation(resp.ContentLength, typeLimit), "ingest-*")
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return errors.New("not enough temporary storage for the file, try again later")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer tempFile.Release()
+
+	var total *int64
+	if resp.ContentLength >= 0 {
+		total = &resp.ContentLength
+	}
+	progress := &ingestProgress{cfg: cfg, ctx: ctx, jobID: jobID, total: total}
+	hash := sha256.New()
+	started := time.Now()
+	written, err := io.Copy(io.MultiWriter(tempFile, hash, progress), io.LimitReader(body, typeLimit+1))
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return errors.New("not enough temporary storage for the file, try again later")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't download file: %w", err)
+	}
+	if written > typeLimit {
+		return fmt.Errorf("file is over the limit of %d bytes for %s", typeLimit, mediaType)
+	}
+	if total != nil && written != *total {
+		return fmt.Errorf("download ended after %d of %d bytes", written, *total)
+	}
+	progress.total = &written
+	progress.save()
+	loggerFrom(ctx).Info("file downloaded", "video_id", videoID, "bytes", written, "duration", time.Since(started))
+
+	status, err := cfg.storageQuotaStatus(user.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't check storage quota: %w", err)
+	}
+	if !status.allows(written) {
+		return errors.New("file would put the owner over their storage quota")
+	}
+
+	profile, err := getProcessingProfile(params.Profile)
+	if err != nil {
+		return err
+	}
+	profile.NormalizeLoudness = params.NormalizeLoudness
+	_, err = cfg.ingestVideo(ctx, video, ingestSource{
+		Path:      tempFile.Name(),
+		MediaType: mediaType,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		Size:      written,
+		Filename:  cleanFilename(path.Base(req.URL.Path)),
+	}, profile)
+	return err
+}
+
+// ingestMediaType returns the media type of a fetched file. Servers that
+// don't say, or only call it application/octet-stream, have the start of
+// the file sniffed instead.
+func ingestMediaType(contentType string, body *bufio.Reader) (string, error) {
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return "", fmt.Errorf("invalid Content-Type %q: %w", contentType, err)
+		}
+		if mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+			return mediaType, nil
+		}
+	}
+	head, err := body.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("couldn't download file: %w", err)
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mediaType, nil
+}
+
+// ingestProgress counts the bytes written through it, saving the count on
+// the job at most every urlIngestProgressInterval.
+type ingestProgress struct {
+	cfg   *apiConfig
+	ctx   context.Context
+	jobID uuid.UUID
+	total *int64
+	done  int64
+	saved time.Time
+}
+
+func (p *ingestProgress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.saved) >= urlIngestProgressInterval {
+		p.save()
+	}
+	return len(b), nil
+}
+
+// save records the progress so far. A failure is logged, the download
+// carries on.
+func (p *ingestProgress) save() {
+	p.saved = time.Now()
+	if err := p.cfg.db.UpdateJobProgress(p.jobID, p.done, p.total); err != nil {
+		loggerFrom(p.ctx).Warn("couldn't record job progress", "error", err)
+	}
+}
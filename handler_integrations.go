@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerIntegrationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Kind       string   `json:"kind"`
+		WebhookURL string   `json:"webhook_url"`
+		Events     []string `json:"events"`
+		Template   string   `json:"template"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = notify.ValidateWebhookURL(params.Kind, params.WebhookURL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required", nil)
+		return
+	}
+	for _, event := range params.Events {
+		if !notify.ValidEvent(event) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event "+event, nil)
+			return
+		}
+		// catch template mistakes now rather than when the event fires
+		if _, err := notify.Render(params.Template, notify.Event{Type: event}); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid template", err)
+			return
+		}
+	}
+
+	integration, err := cfg.db.CreateIntegration(database.CreateIntegrationParams{
+		UserID:     userID,
+		Kind:       params.Kind,
+		WebhookURL: params.WebhookURL,
+		Events:     params.Events,
+		Template:   params.Template,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create integration", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, integration)
+}
+
+func (cfg *apiConfig) handlerIntegrationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	integrations, err := cfg.db.GetIntegrations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve integrations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, integrations)
+}
+
+func (cfg *apiConfig) handlerIntegrationDelete(w http.ResponseWriter, r *http.Request) {
+	integrationIDString := r.PathValue("integrationID")
+	integrationID, err := uuid.Parse(integrationIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid integration ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	integration, err := cfg.db.GetIntegration(integrationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get integration", err)
+		return
+	}
+	if integration.ID == uuid.Nil || integration.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Integration not found", nil)
+		return
+	}
+
+	err = cfg.db.DeleteIntegration(integrationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete integration", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
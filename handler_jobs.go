@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	// someone else's job is reported as missing rather than forbidden
+	if job.ID == uuid.Nil || job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// handlerVideoReprocess starts the video's failed jobs again. Each job is
+// retried with backoff: the first retry is allowed a minute after the
+// failure, and the wait doubles with every attempt, up to jobMaxAttempts
+// in all.
+func (cfg *apiConfig) handlerVideoReprocess(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	failed, err := cfg.db.GetRetryableJobs(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get jobs", err)
+		return
+	}
+
+	now := time.Now()
+	due := []database.Job{}
+	var wait time.Time
+	for _, job := range failed {
+		retryAt := jobRetryAt(job)
+		switch {
+		case job.Params == "" || retryAt.IsZero():
+			// created before jobs recorded their parameters, or out of attempts
+		case retryAt.After(now):
+			if wait.IsZero() || retryAt.Before(wait) {
+				wait = retryAt
+			}
+		default:
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		switch {
+		case !wait.IsZero():
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Sub(now).Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Failed jobs can't be retried yet", nil)
+		case len(failed) > 0:
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("Failed jobs have used up their %d attempts", jobMaxAttempts), nil)
+		default:
+			respondWithError(w, http.StatusConflict, "Video has no failed jobs to retry", nil)
+		}
+		return
+	}
+
+	requeued := []database.Job{}
+	for _, job := range due {
+		ok, err := cfg.db.RequeueJob(job.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't requeue job", err)
+			return
+		}
+		if !ok {
+			// another request got to it first
+			continue
+		}
+		job, err = cfg.db.GetJob(job.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+			return
+		}
+		cfg.startJob(r.Context(), job)
+		requeued = append(requeued, job)
+	}
+
+	respondWithJSON(w, http.StatusAccepted, requeued)
+}
+
+// handlerAdminJobsGet shows the job queue: how many jobs of each kind are
+// in each status, the jobs waiting or running, and the failed ones that
+// will be retried, soonest first. With JOB_QUEUE_URL set it also counts
+// the messages in the queue workers receive jobs from.
+func (cfg *apiConfig) handlerAdminJobsGet(w http.ResponseWriter, r *http.Request) {
+	type retry struct {
+		database.Job
+		RetryAt time.Time `json:"retry_at"`
+	}
+	type response struct {
+		Counts  []database.JobCount `json:"counts"`
+		Active  []database.Job      `json:"active"`
+		Retries []retry             `json:"retries"`
+		// Queue is nil if jobs run in process, or the queue couldn't be
+		// read
+		Queue *jobQueueStats `json:"queue,omitempty"`
+	}
+
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+	counts, err := cfg.db.GetJobCounts()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count jobs", err)
+		return
+	}
+	active, err := cfg.db.GetJobsByStatus(database.JobPending, database.JobRunning)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get jobs", err)
+		return
+	}
+	failed, err := cfg.db.GetRetryableJobs(uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get jobs", err)
+		return
+	}
+
+	retries := []retry{}
+	for _, job := range failed {
+		if retryAt := jobRetryAt(job); job.Params != "" && !retryAt.IsZero() {
+			retries = append(retries, retry{job, retryAt})
+		}
+	}
+	slices.SortStableFunc(retries, func(a, b retry) int {
+		return a.RetryAt.Compare(b.RetryAt)
+	})
+	var queue *jobQueueStats
+	if cfg.jobQueue != nil {
+		stats, err := cfg.jobQueue.stats(r.Context())
+		if err != nil {
+			loggerFrom(r.Context()).Error("couldn't read job queue", "error", err)
+		} else {
+			queue = &stats
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Counts:  counts,
+		Active:  active,
+		Retries: retries,
+		Queue:   queue,
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -44,35 +45,44 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
+	accessToken, refreshToken, err := cfg.startSession(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start session", err)
+		return
+	}
+
+	user.Role = string(accessRole(user))
+	respondWithJSON(w, http.StatusOK, response{
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// startSession issues a new access token and refresh token for user.
+func (cfg *apiConfig) startSession(user database.User) (accessToken, refreshToken string, err error) {
+	accessToken, err = auth.MakeJWT(
 		user.ID,
+		accessRole(user),
 		cfg.jwtSecret,
 		time.Hour*24*30,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	refreshToken, err = auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
 	}
-
-	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-	})
+	return accessToken, refreshToken, nil
 }
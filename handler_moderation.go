@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindModerate = "moderate"
+	// moderationTimeout bounds grabbing and moderating a video's frames
+	moderationTimeout = 15 * time.Minute
+	// moderationFrames is how many frames, spread evenly over a video, are
+	// moderated
+	moderationFrames = 5
+)
+
+// handlerAdminModerationGet reports where a video is in moderation and
+// what moderation found in it.
+func (cfg *apiConfig) handlerAdminModerationGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID uuid.UUID                  `json:"video_id"`
+		Status  string                     `json:"status"`
+		Labels  []database.ModerationLabel `json:"labels"`
+	}
+
+	if _, ok := cfg.authorizeAdmin(w, r); !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+
+	labels, err := cfg.db.GetModerationLabels(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get moderation labels", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{VideoID: videoID, Status: video.ModerationStatus, Labels: labels})
+}
+
+// handlerAdminModerationReview approves a video moderation held, making it
+// visible again, or rejects it, keeping it hidden from everyone but its
+// owner.
+func (cfg *apiConfig) handlerAdminModerationReview(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Decision is approve or reject
+		Decision string `json:"decision"`
+	}
+
+	admin, ok := cfg.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	var status string
+	switch params.Decision {
+	case "approve":
+		status = database.ModerationApproved
+	case "reject":
+		status = database.ModerationRejected
+	default:
+		respondWithError(w, http.StatusBadRequest, "decision must be approve or reject", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	err = cfg.db.SetModerationStatus(videoID, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		ActorID: admin.ID,
+		UserID:  video.UserID,
+		Action:  database.AuditModerationReviewed,
+		VideoID: videoID,
+		Detail:  video.ModerationStatus + " -> " + status,
+	})
+
+	video.ModerationStatus = status
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// queueModeration starts moderating a video whose file was just stored, if
+// moderation is set up. Audio has no frames to moderate. Failures are
+// logged, the upload stands either way.
+func (cfg *apiConfig) queueModeration(ctx context.Context, video database.Video) {
+	if cfg.moderator == nil {
+		return
+	}
+	// the video passed in is from before its file was stored
+	videoID := video.ID
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue moderation", "video_id", videoID, "error", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.MediaKind != database.MediaKindVideo {
+		return
+	}
+	if err := cfg.db.SetModerationStatus(video.ID, database.ModerationPending); err != nil {
+		loggerFrom(ctx).Error("couldn't queue moderation", "video_id", video.ID, "error", err)
+		return
+	}
+	job, err := cfg.db.CreateJob(video.UserID, video.ID, jobKindModerate, "")
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue moderation", "video_id", video.ID, "error", err)
+		return
+	}
+	cfg.startJob(ctx, job)
+}
+
+func (cfg *apiConfig) runModerateJob(ctx context.Context, job database.Job) error {
+	return cfg.moderateVideo(ctx, job.VideoID)
+}
+
+// moderateVideo moderates frames sampled across the video's current file
+// and stores the labels found with enough confidence. If there are any,
+// the video is held for an admin to review; otherwise it's approved.
+func (cfg *apiConfig) moderateVideo(ctx context.Context, videoID uuid.UUID) error {
+	if cfg.moderator == nil {
+		return errors.New("moderation isn't set up")
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return errors.New("video no longer has a file")
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		return errors.New("video file isn't stored in this bucket")
+	}
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return fmt.Errorf("couldn't presign video URL: %w", err)
+	}
+
+	timestamps := []float64{0}
+	if video.Duration != nil && *video.Duration > 0 {
+		timestamps = make([]float64, moderationFrames)
+		for i := range timestamps {
+			timestamps[i] = (float64(i) + 0.5) * *video.Duration / moderationFrames
+		}
+	}
+
+	labels := []database.ModerationLabel{}
+	for _, at := range timestamps {
+		found, err := cfg.moderateFrame(ctx, sourceURL, at)
+		if errors.Is(err, errFrameOutOfRange) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, label := range found {
+			if label.Confidence >= cfg.moderationMinConfidence {
+				label.Timestamp = at
+				labels = append(labels, label)
+			}
+		}
+	}
+
+	status := database.ModerationApproved
+	if len(labels) > 0 {
+		status = database.ModerationPendingReview
+	}
+	err = cfg.db.CommitModeration(videoID, *video.VideoURL, status, labels)
+	if errors.Is(err, database.ErrVideoFileChanged) {
+		return errors.New("video's file was replaced while it was moderated")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't save moderation labels: %w", err)
+	}
+	loggerFrom(ctx).Info("moderated video",
+		"video_id", videoID,
+		"provider", cfg.moderator.provider(),
+		"status", status,
+		"labels", len(labels),
+	)
+	return nil
+}
+
+// moderateFrame grabs the frame at `at` seconds into input and returns
+// what the moderator found in it.
+func (cfg *apiConfig) moderateFrame(ctx context.Context, input string, at float64) ([]database.ModerationLabel, error) {
+	frame, err := cfg.tempStore.Create(0, "moderation-*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer frame.Release()
+	if err := cfg.media.extractFrame(ctx, input, at, frame.Name()); err != nil {
+		return nil, err
+	}
+
+	_, span := tracer.Start(ctx, "moderate frame")
+	labels, err := cfg.moderator.moderate(ctx, frame.Name())
+	endSpan(span, err)
+	return labels, err
+}
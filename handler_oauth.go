@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthStateCookie = "tubely_oauth_state"
+	// oauthStateTTL is how long a user has to sign in at the provider
+	oauthStateTTL = 10 * time.Minute
+	// oauthExchangeTimeout bounds the calls to the provider in the callback
+	oauthExchangeTimeout = 15 * time.Second
+)
+
+// handlerOAuthProviders lists the providers users can log in with, so the
+// login page can offer them.
+func (cfg *apiConfig) handlerOAuthProviders(w http.ResponseWriter, r *http.Request) {
+	type provider struct {
+		Name     string `json:"name"`
+		LoginURL string `json:"login_url"`
+	}
+
+	providers := make([]provider, 0, len(cfg.oauthProviders))
+	for name := range cfg.oauthProviders {
+		providers = append(providers, provider{
+			Name:     name,
+			LoginURL: apiPath(r, "/oauth/"+name+"/login"),
+		})
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	respondWithJSON(w, http.StatusOK, providers)
+}
+
+// handlerOAuthLogin sends the browser to the provider to sign in. The
+// state and PKCE verifier are kept in a signed cookie until the provider
+// sends the browser back to the callback.
+func (cfg *apiConfig) handlerOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider", nil)
+		return
+	}
+
+	state, err := auth.NewOAuthState(provider.Name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	cookie, err := auth.MakeOAuthStateToken(state, cfg.jwtSecret, oauthStateTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	http.SetCookie(w, cfg.oauthCookie(cookie, int(oauthStateTTL.Seconds())))
+	http.Redirect(w, r, provider.AuthCodeURL(state.State, state.Verifier), http.StatusFound)
+}
+
+// handlerOAuthCallback finishes a login the provider has sent back. The
+// provider's account is linked to the user it was linked to before, or to
+// the user with its verified email, or a new user without a password is
+// created for it. The browser is sent on to the app with the same tokens a
+// password login returns in the URL fragment, or with oauth_error.
+func (cfg *apiConfig) handlerOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider", nil)
+		return
+	}
+
+	// the state is only good for one try
+	http.SetCookie(w, cfg.oauthCookie("", -1))
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		cfg.oauthLoginFailed(w, r, "Login expired, try again", err)
+		return
+	}
+	state, err := auth.ValidateOAuthStateToken(cookie.Value, cfg.jwtSecret)
+	if err != nil {
+		cfg.oauthLoginFailed(w, r, "Login expired, try again", err)
+		return
+	}
+	query := r.URL.Query()
+	if state.Provider != provider.Name || query.Get("state") != state.State {
+		cfg.oauthLoginFailed(w, r, "Login state doesn't match, try again", nil)
+		return
+	}
+	if reason := query.Get("error"); reason != "" {
+		cfg.oauthLoginFailed(w, r, "Login was canceled", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oauthExchangeTimeout)
+	defer cancel()
+	identity, err := provider.Identify(ctx, query.Get("code"), state.Verifier)
+	if err != nil {
+		cfg.oauthLoginFailed(w, r, "Couldn't sign in with "+provider.Name, err)
+		return
+	}
+
+	user, err := cfg.userForIdentity(identity)
+	if err != nil {
+		msg := "Couldn't sign in with " + provider.Name
+		var loginErr oauthLoginError
+		if errors.As(err, &loginErr) {
+			msg = loginErr.Error()
+		}
+		cfg.oauthLoginFailed(w, r, msg, err)
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.startSession(*user)
+	if err != nil {
+		cfg.oauthLoginFailed(w, r, "Couldn't start session", err)
+		return
+	}
+	loggerFrom(r.Context()).Info("oauth login",
+		"provider", identity.Provider,
+		"user_id", user.ID,
+	)
+	fragment := url.Values{
+		"token":         {accessToken},
+		"refresh_token": {refreshToken},
+	}
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
+
+// oauthLoginError is a failed OAuth login that can be shown to the user.
+type oauthLoginError string
+
+func (e oauthLoginError) Error() string { return string(e) }
+
+// userForIdentity returns the user identity logs in as, linking or creating
+// them on its first login. Only verified emails are trusted to match an
+// existing account.
+func (cfg *apiConfig) userForIdentity(identity auth.OAuthIdentity) (*database.User, error) {
+	userID, err := cfg.db.GetIdentityUserID(identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != uuid.Nil {
+		user, err := cfg.db.GetUser(userID)
+		if err == nil && user == nil {
+			return nil, oauthLoginError("Your account no longer exists")
+		}
+		return user, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, oauthLoginError("Your " + identity.Provider + " account has no verified email")
+	}
+	link := database.UserIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	existing, err := cfg.db.GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing.ID == uuid.Nil {
+		return cfg.db.CreateIdentityUser(link)
+	}
+	link.UserID = existing.ID
+	if err := cfg.db.LinkIdentity(link); err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// oauthLoginFailed sends the browser back to the app with msg.
+func (cfg *apiConfig) oauthLoginFailed(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	if err != nil {
+		loggerFrom(r.Context()).Warn("oauth login failed",
+			"provider", r.PathValue("provider"),
+			"error", err,
+		)
+	}
+	fragment := url.Values{"oauth_error": {msg}}
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
+
+// oauthCookie holds the login state. It has to survive the top-level
+// redirect back from the provider, so it is SameSite=Lax.
+func (cfg *apiConfig) oauthCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/api/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
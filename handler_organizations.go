@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerOrganizationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Organization name is required", nil)
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(params.Name, userID)
+	if errors.Is(err, database.ErrAlreadyInOrganization) {
+		respondWithError(w, http.StatusConflict, "You already belong to an organization", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (cfg *apiConfig) handlerOrganizationGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Organization
+		Members []database.OrganizationMember `json:"members"`
+	}
+
+	orgID, _, ok := cfg.authorizeOrganizationMember(w, r, false)
+	if !ok {
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	members, err := cfg.db.GetOrganizationMembers(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get members", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Organization: org,
+		Members:      members,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	orgID, _, ok := cfg.authorizeOrganizationMember(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role == "" {
+		params.Role = database.OrgRoleMember
+	}
+	if params.Role != database.OrgRoleMember && params.Role != database.OrgRoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "Role must be member or admin", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+
+	err = cfg.db.AddOrganizationMember(orgID, user.ID, params.Role)
+	if errors.Is(err, database.ErrAlreadyInOrganization) {
+		respondWithError(w, http.StatusConflict, "User already belongs to an organization", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add member", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		UserID: user.ID,
+		Action: database.AuditMemberAdded,
+		Detail: "organization " + orgID.String() + " as " + params.Role,
+	})
+
+	member, err := cfg.db.GetMembership(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get member", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, member)
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberRemove(w http.ResponseWriter, r *http.Request) {
+	orgID, adminID, ok := cfg.authorizeOrganizationMember(w, r, true)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	// leaving an organization without an admin would lock its policy forever
+	if userID == adminID {
+		respondWithError(w, http.StatusBadRequest, "Admins can't remove themselves", nil)
+		return
+	}
+
+	err = cfg.db.RemoveOrganizationMember(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		UserID: userID,
+		Action: database.AuditMemberRemoved,
+		Detail: "organization " + orgID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUploadPolicyGet(w http.ResponseWriter, r *http.Request) {
+	orgID, _, ok := cfg.authorizeOrganizationMember(w, r, false)
+	if !ok {
+		return
+	}
+
+	policy, err := cfg.db.GetUploadPolicy(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload policy", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+func (cfg *apiConfig) handlerUploadPolicyUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility    string   `json:"visibility"`
+		Profile       string   `json:"profile"`
+		Tags          []string `json:"tags"`
+		RetentionDays int      `json:"retention_days"`
+	}
+
+	orgID, _, ok := cfg.authorizeOrganizationMember(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Visibility != "" && !validVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private", nil)
+		return
+	}
+	if params.Profile != "" {
+		if _, err := getProcessingProfile(params.Profile); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.RetentionDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "retention_days can't be negative", nil)
+		return
+	}
+	tags := []string{}
+	for _, tag := range params.Tags {
+		normalized, err := normalizeTag(tag)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		tags = append(tags, normalized)
+	}
+
+	policy := database.UploadPolicy{
+		OrganizationID: orgID,
+		Visibility:     params.Visibility,
+		Profile:        params.Profile,
+		Tags:           tags,
+		RetentionDays:  params.RetentionDays,
+	}
+	err = cfg.db.SaveUploadPolicy(policy)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload policy", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// authorizeOrganizationMember checks the caller belongs to the organization
+// in the path, and is one of its admins if requireAdmin is set. Outsiders
+// get a 404 so organization IDs can't be probed.
+func (cfg *apiConfig) authorizeOrganizationMember(w http.ResponseWriter, r *http.Request, requireAdmin bool) (uuid.UUID, uuid.UUID, bool) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	member, err := cfg.db.GetMembership(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	if member == nil || member.OrganizationID != orgID {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return uuid.Nil, uuid.Nil, false
+	}
+	if requireAdmin && member.Role != database.OrgRoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Only organization admins can do that", nil)
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, userID, true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultPlaybackExpiry covers watching a typical video in one sitting
+	defaultPlaybackExpiry = time.Hour
+	maxPlaybackExpiry     = 12 * time.Hour
+)
+
+// handlerPlaybackTokenCreate mints a token that streams one video through
+// the playback proxy, for players that can't send an Authorization header.
+// Anyone who can see the video can mint one.
+func (cfg *apiConfig) handlerPlaybackTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds int `json:"expires_in_seconds"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	expiresIn := cfg.clientURLExpiry(defaultPlaybackExpiry)
+	if params.ExpiresInSeconds != 0 {
+		expiresIn = time.Duration(params.ExpiresInSeconds) * time.Second
+	}
+	if maxExpiry := cfg.clientURLExpiry(maxPlaybackExpiry); expiresIn <= 0 || expiresIn > maxExpiry {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(maxExpiry.Seconds())), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	viewerID := cfg.optionalViewerID(r)
+	// private videos look like they don't exist to anyone but the owner
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, viewerID) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file uploaded yet", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+
+	token, err := auth.MakePlaybackToken(video.ID, viewerID, cfg.jwtSecret, expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playback token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		URL:       withVideoAccess(r, video, cfg.publicURL+apiPath(r, "/playback/"+token)),
+		ExpiresAt: time.Now().UTC().Add(expiresIn),
+	})
+}
+
+// handlerPlayback streams the video a playback token was minted for. The
+// token is the credential. Whether its viewer may still see the video is checked on
+// every request, so making a video private stops other viewers' tokens.
+func (cfg *apiConfig) handlerPlayback(w http.ResponseWriter, r *http.Request) {
+	videoID, viewerID, err := auth.ValidatePlaybackToken(r.PathValue("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired playback token", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, viewerID) || video.VideoURL == nil {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video isn't stored in Tubely", nil)
+		return
+	}
+
+	if isPlaybackStart(r) {
+		cfg.recordView(r, video, viewerID)
+	}
+	cfg.recordBytesServed(r, video, cfg.streamObject(w, r, bucket, key, ""))
+}
@@ -5,11 +5,23 @@ import (
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+const (
+	refreshTokenTTL = time.Hour * 24 * 60
+	// refreshReuseGrace forgives a replaced refresh token being used again
+	// this soon, as when two tabs refresh at once, without ending the
+	// session
+	refreshReuseGrace = 10 * time.Second
+)
+
+// handlerRefresh trades a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,14 +30,63 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	rt, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	if rt.RevokedAt != nil {
+		cfg.rejectUsedRefreshToken(w, r, rt)
+		return
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token expired, log in again", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(rt.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+
+	next, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	rotated, err := cfg.db.RotateRefreshToken(rt.Token, database.CreateRefreshTokenParams{
+		Token:     next,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		FamilyID:  rt.FamilyID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+	if !rotated {
+		// a concurrent refresh got there first
+		rt, err = cfg.db.GetRefreshToken(refreshToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+			return
+		}
+		cfg.rejectUsedRefreshToken(w, r, rt)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		accessRole(*user),
 		cfg.jwtSecret,
 		time.Hour,
 	)
@@ -35,10 +96,36 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: next,
 	})
 }
 
+// rejectUsedRefreshToken refuses a revoked refresh token. One that was
+// replaced by a refresh a while ago is being replayed, likely by someone
+// who stole it, so the session it belongs to is ended for everyone.
+func (cfg *apiConfig) rejectUsedRefreshToken(w http.ResponseWriter, r *http.Request, rt database.RefreshToken) {
+	if rt.ReplacedBy == "" {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token revoked, log in again", nil)
+		return
+	}
+	if time.Since(rt.UpdatedAt) < refreshReuseGrace {
+		respondWithError(w, http.StatusConflict, "Refresh token was just used, use the tokens that refresh returned", nil)
+		return
+	}
+
+	loggerFrom(r.Context()).Warn("replaced refresh token used again, ending its session",
+		"user_id", rt.UserID,
+		"family_id", rt.FamilyID,
+	)
+	if err := cfg.db.RevokeRefreshToken(rt.Token); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token was already used, log in again", nil)
+}
+
+// handlerRevoke ends the session a refresh token belongs to.
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerRenditionsGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, cfg.optionalViewerID(r)) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+
+	renditions, err := cfg.db.GetRenditions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+
+	// renditions aren't streamed, so with a private bucket they're presigned too
+	presign := objectsHidden(video) || cfg.videoDelivery == deliveryProxy
+	if presign || cfg.s3Accelerate {
+		for i, rendition := range renditions {
+			bucket, key, ok := cfg.objectFromURL(rendition.VideoURL)
+			if !ok {
+				continue
+			}
+			if !presign {
+				renditions[i].VideoURL = cfg.clientObjectURL(bucket, key)
+				continue
+			}
+			renditions[i].VideoURL, err = cfg.presignClientGetObject(r.Context(), bucket, key, privateURLExpiry)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URLs", err)
+				return
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, renditions)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShareExpiry = 24 * time.Hour
+	maxShareExpiry     = 30 * 24 * time.Hour
+)
+
+func (cfg *apiConfig) handlerVideoShare(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds int `json:"expires_in_seconds"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+
+	expiresIn := defaultShareExpiry
+	if params.ExpiresInSeconds != 0 {
+		expiresIn = time.Duration(params.ExpiresInSeconds) * time.Second
+	}
+	if expiresIn <= 0 || expiresIn > maxShareExpiry {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(maxShareExpiry.Seconds())), nil)
+		return
+	}
+
+	token, err := auth.MakeShareToken(videoID, cfg.jwtSecret, expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share token", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:  database.AuditShareCreated,
+		VideoID: videoID,
+		Detail:  "expires in " + expiresIn.String(),
+	})
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		URL:       cfg.publicURL + apiPath(r, "/share/"+token),
+		ExpiresAt: time.Now().UTC().Add(expiresIn),
+	})
+}
+
+// handlerShareResolve is public: the token itself is the credential.
+func (cfg *apiConfig) handlerShareResolve(w http.ResponseWriter, r *http.Request) {
+	videoID, err := auth.ValidateShareToken(r.PathValue("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired share link", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// a share link opens up a private video, not one moderation holds
+	if video.ID == uuid.Nil || moderationHeld(video) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+	video, err = cfg.localizeVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't localize video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
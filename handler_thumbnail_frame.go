@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const jobKindThumbnailFromFrame = "thumbnail_from_frame"
+
+// thumbnailFromFrameParams are what a thumbnail_from_frame job runs with.
+type thumbnailFromFrameParams struct {
+	// Timestamp is in seconds from the start of the video
+	Timestamp float64 `json:"timestamp"`
+}
+
+// handlerThumbnailFromFrame sets the thumbnail to the frame at a timestamp.
+// Extraction runs as a job; the response points at it for status.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Timestamp is in seconds from the start of the video
+		Timestamp *float64 `json:"timestamp"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Timestamp == nil || *params.Timestamp < 0 {
+		respondWithError(w, http.StatusBadRequest, "A non-negative timestamp is required", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file to take a frame from", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+
+	jobParams, err := json.Marshal(thumbnailFromFrameParams{Timestamp: *params.Timestamp})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode job parameters", err)
+		return
+	}
+	job, err := cfg.db.CreateJob(video.UserID, video.ID, jobKindThumbnailFromFrame, string(jobParams))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.startJob(r.Context(), job)
+
+	w.Header().Set("Location", apiPath(r, "/jobs/"+job.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+func (cfg *apiConfig) runThumbnailFromFrameJob(ctx context.Context, job database.Job) error {
+	var params thumbnailFromFrameParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return fmt.Errorf("invalid job parameters: %w", err)
+	}
+	return cfg.setThumbnailFromFrame(ctx, job.VideoID, params.Timestamp)
+}
+
+func (cfg *apiConfig) setThumbnailFromFrame(ctx context.Context, videoID uuid.UUID, timestamp float64) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return errors.New("video no longer has a file")
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		return errors.New("video file isn't stored in this bucket")
+	}
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return fmt.Errorf("couldn't presign video URL: %w", err)
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+	assetPath := getAssetPath(base64.RawURLEncoding.EncodeToString(randomBytes), "image/jpeg")
+	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	if err := cfg.media.extractFrame(ctx, sourceURL, timestamp, assetDiskPath); err != nil {
+		return err
+	}
+
+	// reload so edits made while the frame was extracted aren't overwritten
+	video, err = cfg.db.GetVideo(videoID)
+	if err == nil && video.ID == uuid.Nil {
+		err = errors.New("video was deleted")
+	}
+	if err != nil {
+		os.Remove(assetDiskPath)
+		return err
+	}
+	oldThumbnailURL := video.ThumbnailURL
+
+	url := cfg.getAssetURL(assetPath)
+	video.ThumbnailURL = &url
+	video.ThumbnailBlurhash = thumbnailBlurhash(ctx, assetDiskPath)
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		os.Remove(assetDiskPath)
+		return err
+	}
+
+	if oldThumbnailURL != nil {
+		if oldPath, ok := cfg.assetPathFromURL(*oldThumbnailURL); ok {
+			if err := os.Remove(cfg.getAssetDiskPath(oldPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				loggerFrom(ctx).Warn("couldn't remove old thumbnail", "path", oldPath, "error", err)
+			}
+			cfg.invalidateCDNLater(ctx, []string{assetCDNPath(oldPath)})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindTranscribe = "transcribe"
+	// transcribeTimeout bounds a transcription, which on a CPU can take
+	// longer than the video plays
+	transcribeTimeout = 3 * time.Hour
+)
+
+// transcribeParams are what a transcribe job runs with.
+type transcribeParams struct {
+	// Language is a language tag, "" to detect it
+	Language string `json:"language,omitempty"`
+}
+
+// handlerTranscribeVideo transcribes a video again, or for the first time
+// if it was uploaded before transcription was set up. Transcription runs
+// as a job; the response points at it for status.
+func (cfg *apiConfig) handlerTranscribeVideo(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Language is what the video is spoken in, detected if unset
+		Language string `json:"language"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if cfg.transcriber == nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Transcription isn't set up on this server", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Language != "" {
+		language, err := normalizeLanguage(params.Language)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		params.Language = language
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file to transcribe", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+
+	job, err := cfg.createTranscribeJob(video, params.Language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.startJob(r.Context(), job)
+
+	w.Header().Set("Location", apiPath(r, "/jobs/"+job.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+func (cfg *apiConfig) handlerVideoTranscriptGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, cfg.optionalViewerID(r)) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	transcript, err := cfg.db.GetTranscript(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get transcript", err)
+		return
+	}
+	if transcript.VideoID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video has no transcript", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, transcript)
+}
+
+func (cfg *apiConfig) createTranscribeJob(video database.Video, language string) (database.Job, error) {
+	if language == "" {
+		language = cfg.transcriptionLanguage
+	}
+	jobParams, err := json.Marshal(transcribeParams{Language: language})
+	if err != nil {
+		return database.Job{}, err
+	}
+	return cfg.db.CreateJob(video.UserID, video.ID, jobKindTranscribe, string(jobParams))
+}
+
+// queueTranscription starts transcribing a video whose file was just
+// stored, if transcription is set up. Failures are logged, the upload
+// stands either way.
+func (cfg *apiConfig) queueTranscription(ctx context.Context, video database.Video) {
+	if cfg.transcriber == nil {
+		return
+	}
+	job, err := cfg.createTranscribeJob(video, "")
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't queue transcription", "video_id", video.ID, "error", err)
+		return
+	}
+	cfg.startJob(ctx, job)
+}
+
+func (cfg *apiConfig) runTranscribeJob(ctx context.Context, job database.Job) error {
+	var params transcribeParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return fmt.Errorf("invalid job parameters: %w", err)
+	}
+	return cfg.transcribeVideo(ctx, job.VideoID, params.Language)
+}
+
+// transcribeVideo transcribes the video's current file and stores the
+// transcript along with captions made from it, unless the owner uploaded
+// captions in the language it's spoken in.
+func (cfg *apiConfig) transcribeVideo(ctx context.Context, videoID uuid.UUID, language string) error {
+	if cfg.transcriber == nil {
+		return errors.New("transcription isn't set up")
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return errors.New("video no longer has a file")
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		return errors.New("video file isn't stored in this bucket")
+	}
+	sourceURL, err := cfg.presignGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return fmt.Errorf("couldn't presign video URL: %w", err)
+	}
+
+	started := time.Now()
+	_, span := tracer.Start(ctx, "transcribe")
+	result, err := cfg.transcriber.transcribe(ctx, transcriptionSource{
+		URL:      sourceURL,
+		Bucket:   bucket,
+		Key:      key,
+		Duration: video.Duration,
+		Language: language,
+	})
+	endSpan(span, err)
+	if errors.Is(err, errNoAudioStream) {
+		return errors.New("video has no audio to transcribe")
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.Language != "" {
+		language = result.Language
+	}
+	language, err = normalizeLanguage(language)
+	if err != nil {
+		// the language is unknown or not one a tag can name
+		language = "und"
+	}
+	transcript := database.Transcript{
+		VideoID:  videoID,
+		Language: language,
+		Provider: cfg.transcriber.provider(),
+		Text:     result.Text,
+	}
+
+	// silence transcribes to no text and no cues, which isn't worth captions
+	var caption *database.Caption
+	var captionKey string
+	if vtt, err := toWebVTT(result.VTT); err == nil && strings.TrimSpace(result.Text) != "" {
+		captionKey, err = captionObjectKey(videoID, language)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(vtt)
+		err = cfg.putPendingObject(ctx, video, "", captionKey, bytes.NewReader(vtt), "text/vtt; charset=utf-8", hex.EncodeToString(sum[:]))
+		if err != nil {
+			return fmt.Errorf("couldn't upload captions: %w", err)
+		}
+		caption = &database.Caption{
+			VideoID:  videoID,
+			Language: language,
+			Label:    language + " (auto-generated)",
+			URL:      cfg.getObjectURL(cfg.videoBucket(video), captionKey),
+			Size:     int64(len(vtt)),
+			Source:   database.CaptionSourceTranscription,
+		}
+	}
+
+	replaced, saved, err := cfg.db.CommitTranscript(*video.VideoURL, transcript, caption, captionKey)
+	if caption != nil && (err != nil || !saved) {
+		cfg.discardPendingObjects(ctx, cfg.videoBucket(video), []string{captionKey})
+	}
+	if errors.Is(err, database.ErrVideoFileChanged) {
+		return errors.New("video's file was replaced while it was transcribed")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't save transcript: %w", err)
+	}
+	if oldBucket, oldKey, ok := cfg.objectFromURL(replaced); ok && replaced != "" {
+		cfg.releaseObjects(ctx, oldBucket, []string{oldKey})
+	}
+	loggerFrom(ctx).Info("transcribed video",
+		"video_id", videoID,
+		"language", language,
+		"provider", transcript.Provider,
+		"captioned", saved,
+		"duration", time.Since(started),
+	)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// triggerItem is one event in a polling trigger feed. ID is a dedupe key
+// that stays the same however often the event is returned, so automation
+// platforms that dedupe on "id" only fire once per event. Cursor resumes
+// the feed right after this item.
+type triggerItem struct {
+	ID         string         `json:"id"`
+	Event      string         `json:"event"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Cursor     string         `json:"cursor"`
+	Video      database.Video `json:"video"`
+}
+
+// handlerTriggerNewVideos feeds the caller's videos in creation order.
+func (cfg *apiConfig) handlerTriggerNewVideos(w http.ResponseWriter, r *http.Request) {
+	cfg.serveVideoTrigger(w, r, "video.created", cfg.db.GetVideosCreatedAfter, func(v database.Video) time.Time {
+		return v.CreatedAt
+	})
+}
+
+// handlerTriggerReadyVideos feeds the caller's videos in the order they
+// became playable.
+func (cfg *apiConfig) handlerTriggerReadyVideos(w http.ResponseWriter, r *http.Request) {
+	cfg.serveVideoTrigger(w, r, "video.ready", cfg.db.GetVideosReadyAfter, func(v database.Video) time.Time {
+		return *v.ReadyAt
+	})
+}
+
+// serveVideoTrigger returns the events after `cursor` oldest first. The
+// next cursor is always set, echoing the request's when nothing new
+// happened, so a poller can store it unconditionally.
+func (cfg *apiConfig) serveVideoTrigger(
+	w http.ResponseWriter,
+	r *http.Request,
+	event string,
+	fetch func(database.VideoFeedParams) ([]database.Video, error),
+	occurredAt func(database.Video) time.Time,
+) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	limit, err := parsePageSize(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	params := database.VideoFeedParams{
+		UserID: userID,
+		Limit:  limit,
+	}
+	cursorString := r.URL.Query().Get("cursor")
+	if cursorString != "" {
+		cursor, err := decodePageCursor(cursorString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.AfterTime = cursor.CreatedAt
+		params.AfterID = cursor.ID
+	}
+
+	videos, err := fetch(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+
+	videos, err = cfg.presentVideos(r.Context(), videos, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URLs", err)
+		return
+	}
+
+	items := make([]triggerItem, 0, len(videos))
+	for _, video := range videos {
+		at := occurredAt(video)
+		items = append(items, triggerItem{
+			ID:         event + ":" + video.ID.String(),
+			Event:      event,
+			OccurredAt: at,
+			Cursor:     pageCursor{CreatedAt: at, ID: video.ID}.encode(),
+			Video:      video,
+		})
+	}
+
+	nextCursor := cursorString
+	if len(items) > 0 {
+		nextCursor = items[len(items)-1].Cursor
+	}
+	if nextCursor != "" {
+		setNextPageHeaders(w, r, nextCursor)
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
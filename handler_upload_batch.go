@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	"github.com/google/uuid"
+)
+
+const (
+	maxBatchUploadSize = 4 << 30 // 4 GB, same as a zip upload
+	maxBatchFiles      = 100
+)
+
+// batchManifestEntry gives the details of one file of a batch upload,
+// matched to it by the part's file name. Every field but the file name is
+// optional; the title defaults to the file's name.
+type batchManifestEntry struct {
+	Filename    string   `json:"filename"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// batchFile is what a file of a batch upload is created with.
+type batchFile struct {
+	entry batchManifestEntry
+	opts  uploadOptions
+}
+
+// handlerUploadBatch creates one video for every "video" part of a
+// multipart form, so a migration can send many files in one request. The
+// files are read and processed one at a time as they stream in, so the
+// settings, and the optional JSON manifest, must come before the first
+// file.
+func (cfg *apiConfig) handlerUploadBatch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Created int            `json:"created"`
+		Failed  int            `json:"failed"`
+		Skipped int            `json:"skipped"`
+		Results []importResult `json:"results"`
+		// Stopped is why the rest of the form wasn't read, if it wasn't
+		Stopped string `json:"stopped,omitempty"`
+	}
+
+	if r.ContentLength > maxBatchUploadSize {
+		respondWithTooLarge(w, maxBatchUploadSize, nil)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchUploadSize)
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart form", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Hour)
+	defer cancel()
+
+	form := uploadForm{}
+	var opts uploadOptions
+	var manifest map[string]batchFile
+	started := false
+	resp := response{Results: []importResult{}}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var maxBytesErr *http.MaxBytesError
+		if err != nil && !started {
+			if errors.As(err, &maxBytesErr) {
+				respondWithTooLarge(w, maxBatchUploadSize, err)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
+			return
+		}
+		if err != nil {
+			// the files before this one are in, so report them rather than fail
+			loggerFrom(ctx).Error("couldn't read rest of batch upload", "error", err)
+			resp.Stopped = batchStopReason(err)
+			break
+		}
+
+		if part.FormName() != "video" {
+			if started {
+				resp.Stopped = "form fields must come before the files"
+				break
+			}
+			err := form.read(part)
+			if errors.As(err, &maxBytesErr) {
+				respondWithTooLarge(w, maxBatchUploadSize, err)
+				return
+			}
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+			continue
+		}
+
+		if !started {
+			started = true
+			opts, manifest, err = cfg.batchUploadOptions(userID, &form)
+			var ingestErr *ingestError
+			if errors.As(err, &ingestErr) {
+				respondWithIngestError(w, ingestErr)
+				return
+			}
+		}
+		if len(resp.Results) == maxBatchFiles {
+			resp.Stopped = fmt.Sprintf("batches can have at most %d files", maxBatchFiles)
+			break
+		}
+
+		file, ok := manifest[part.FileName()]
+		if !ok {
+			file = batchFile{opts: opts}
+		}
+		result, err := cfg.ingestBatchFile(ctx, part, user, r.ContentLength, file)
+		switch result.Status {
+		case "created":
+			resp.Created++
+			if video, err := cfg.db.GetVideo(*result.VideoID); err == nil {
+				cfg.auditUpload(r, false, video, "from batch upload")
+			}
+		case "failed":
+			resp.Failed++
+		default:
+			resp.Skipped++
+		}
+		resp.Results = append(resp.Results, result)
+		if err != nil {
+			resp.Stopped = batchStopReason(err)
+			break
+		}
+	}
+	if !started {
+		respondWithError(w, http.StatusBadRequest, "Failed to retrieve video files", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// batchStopReason says why a batch upload's form couldn't be read to the
+// end.
+func batchStopReason(err error) string {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return fmt.Sprintf("batches can be at most %d bytes", maxBatchUploadSize)
+	}
+	return "couldn't read the rest of the form"
+}
+
+// batchUploadOptions resolves the settings of a batch upload's form, and
+// those of each file its manifest describes. A problem with either comes
+// back as an *ingestError.
+func (cfg *apiConfig) batchUploadOptions(userID uuid.UUID, form *uploadForm) (uploadOptions, map[string]batchFile, error) {
+	normalizeLoudness, err := form.bool("normalize_loudness")
+	if err != nil {
+		return uploadOptions{}, nil, &ingestError{http.StatusBadRequest, err.Error(), err}
+	}
+	requested := uploadOptions{
+		Visibility:        form.get("visibility"),
+		Profile:           form.get("profile"),
+		NormalizeLoudness: normalizeLoudness,
+	}
+	opts, err := cfg.resolveUploadOptions(userID, requested)
+	if err != nil {
+		return uploadOptions{}, nil, err
+	}
+
+	manifest := map[string]batchFile{}
+	if form.get("manifest") == "" {
+		return opts, manifest, nil
+	}
+	var entries []batchManifestEntry
+	if err := json.Unmarshal([]byte(form.get("manifest")), &entries); err != nil {
+		return uploadOptions{}, nil, &ingestError{http.StatusBadRequest, "Invalid manifest", err}
+	}
+	for _, entry := range entries {
+		if entry.Filename == "" {
+			return uploadOptions{}, nil, &ingestError{http.StatusBadRequest, "Every manifest entry needs a filename", nil}
+		}
+		if _, ok := manifest[entry.Filename]; ok {
+			return uploadOptions{}, nil, &ingestError{http.StatusBadRequest, "Manifest lists " + entry.Filename + " more than once", nil}
+		}
+		fileOpts := opts
+		if len(entry.Tags) > 0 {
+			// the file's tags go on top of the batch's, policy ones included
+			requested.Tags = entry.Tags
+			if fileOpts, err = cfg.resolveUploadOptions(userID, requested); err != nil {
+				return uploadOptions{}, nil, err
+			}
+		}
+		manifest[entry.Filename] = batchFile{entry: entry, opts: fileOpts}
+	}
+	return opts, manifest, nil
+}
+
+// ingestBatchFile creates a video from one file of a batch upload. The
+// error is only set when the form itself couldn't be read, meaning the
+// files after this one can't be either.
+func (cfg *apiConfig) ingestBatchFile(ctx context.Context, part *multipart.Part, user *database.User, contentLength int64, file batchFile) (importResult, error) {
+	result := importResult{Filename: part.FileName()}
+	skip := func(reason string) (importResult, error) {
+		result.Status = "skipped"
+		result.Error = reason
+		return result, nil
+	}
+	fail := func(reason string, err error) (importResult, error) {
+		loggerFrom(ctx).Error("couldn't ingest file from batch upload", "filename", result.Filename, "error", err)
+		result.Status = "failed"
+		result.Error = reason
+		return result, nil
+	}
+
+	base := path.Base(strings.ReplaceAll(result.Filename, `\`, "/"))
+	if result.Filename == "" || base == "." || base == "/" {
+		return skip("not a file")
+	}
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return skip("invalid Content-Type")
+	}
+	typeLimit, ok := cfg.uploadLimits.maxUploadSizeForType(user.Tier, mediaType)
+	if !ok {
+		return skip("file type not accepted, accepted types are " + cfg.uploadLimits.acceptedMediaTypes())
+	}
+	// each file can carry its own checksums, the request's belong to no one file
+	checksums, err := parseContentChecksums(part.Header, http.Header{})
+	if err != nil {
+		return fail(err.Error(), err)
+	}
+
+	tempFile, err := cfg.tempStore.Create(tempReservation(contentLength, typeLimit), "upload-*"+strings.ToLower(path.Ext(base)))
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return fail("not enough temporary storage", err)
+	}
+	if err != nil {
+		return fail("couldn't create temp file", err)
+	}
+	defer tempFile.Release()
+
+	hasher := sha256.New()
+	md5Hasher := md5.New()
+	written, err := io.Copy(io.MultiWriter(tempFile, hasher, md5Hasher), io.LimitReader(part, typeLimit+1))
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		result, _ = fail("not enough temporary storage", err)
+		return result, err
+	}
+	if err != nil {
+		result, _ = fail("couldn't read file", err)
+		return result, err
+	}
+	if written > typeLimit {
+		return skip(fmt.Sprintf("file too large, the limit for %s is %d bytes", mediaType, typeLimit))
+	}
+	sum := hasher.Sum(nil)
+	if checksums.SHA256 != nil && !bytes.Equal(checksums.SHA256, sum) {
+		return fail("file doesn't match X-Content-SHA256, it may have been corrupted in transit", nil)
+	}
+	if checksums.MD5 != nil && !bytes.Equal(checksums.MD5, md5Hasher.Sum(nil)) {
+		return fail("file doesn't match Content-MD5, it may have been corrupted in transit", nil)
+	}
+
+	// each file counts against the quota the ones before it used up
+	quota, err := cfg.storageQuotaStatus(user.ID)
+	if err != nil {
+		return fail("couldn't check storage quota", err)
+	}
+	if !quota.allows(written) {
+		return skip("storage quota exceeded")
+	}
+
+	title := strings.TrimSpace(file.entry.Title)
+	if title == "" {
+		title = strings.TrimSuffix(base, path.Ext(base))
+	}
+	bucket, err := cfg.newVideoBucket(user.ID)
+	if err != nil {
+		return fail("couldn't pick a bucket", err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:         title,
+		Description:   file.entry.Description,
+		UserID:        user.ID,
+		Visibility:    file.opts.Visibility,
+		StorageBucket: bucket,
+	})
+	if err != nil {
+		return fail("couldn't create video", err)
+	}
+	if _, err := cfg.applyUploadOptions(video, file.opts); err != nil {
+		if delErr := cfg.db.DeleteVideo(video.ID); delErr != nil {
+			loggerFrom(ctx).Error("couldn't remove draft video", "video_id", video.ID, "error", delErr)
+		}
+		return fail("couldn't apply upload policy", err)
+	}
+	// options were validated up front, so the profile is known to exist
+	profile, _ := getProcessingProfile(file.opts.Profile)
+	profile.NormalizeLoudness = file.opts.NormalizeLoudness
+
+	result.JobID, err = cfg.ingestFileOrQueue(ctx, video, tempFile, ingestSource{
+		Path:      tempFile.Name(),
+		MediaType: mediaType,
+		SHA256:    hex.EncodeToString(sum),
+		Size:      written,
+		Filename:  cleanFilename(base),
+	}, file.opts, profile)
+	if err != nil {
+		// don't leave an empty draft behind for a file that didn't make it
+		if delErr := cfg.db.DeleteVideo(video.ID); delErr != nil {
+			loggerFrom(ctx).Error("couldn't remove draft video", "video_id", video.ID, "error", delErr)
+		}
+		reason := "processing failed"
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) {
+			reason = ingestErr.Message
+		}
+		return fail(reason, err)
+	}
+
+	result.Status = "created"
+	result.VideoID = &video.ID
+	return result, nil
+}
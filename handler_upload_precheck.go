@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerUploadPrecheck lets a client skip the upload entirely when the
+// server already stores a file with the same SHA-256 and size. On a hit it
+// creates a new video pointing at the existing object.
+func (cfg *apiConfig) handlerUploadPrecheck(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SHA256      string `json:"sha256"`
+		Size        int64  `json:"size"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+		Filename    string `json:"filename"`
+	}
+	type response struct {
+		Exists bool            `json:"exists"`
+		Video  *database.Video `json:"video,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	checksum := strings.ToLower(params.SHA256)
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != 32 {
+		respondWithError(w, http.StatusBadRequest, "sha256 must be a hex encoded SHA-256 digest", err)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "size must be positive", nil)
+		return
+	}
+	opts, err := cfg.resolveUploadOptions(userID, uploadOptions{Visibility: params.Visibility})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+
+	content, err := cfg.db.GetContentObject(userID, checksum, params.Size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up content", err)
+		return
+	}
+	bucket, err := cfg.newVideoBucket(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't pick a bucket", err)
+		return
+	}
+	// a video's objects all live in its bucket, content elsewhere is uploaded again
+	if content == nil || cfg.buckets.orDefault(content.Bucket) != bucket {
+		respondWithJSON(w, http.StatusOK, response{Exists: false})
+		return
+	}
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:         params.Title,
+		Description:   params.Description,
+		UserID:        userID,
+		Visibility:    opts.Visibility,
+		StorageBucket: bucket,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	video, err = cfg.applyUploadOptions(video, opts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't apply upload policy", err)
+		return
+	}
+
+	videoURL := cfg.getObjectURL(bucket, content.ObjectKey)
+	video.VideoURL = &videoURL
+	video.Projection = content.Projection
+	video.OriginalFilename = cleanFilename(params.Filename)
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't link video to existing content", err)
+		return
+	}
+
+	rendition := database.CreateRenditionParams{
+		VideoID:         video.ID,
+		Kind:            "primary",
+		VideoURL:        videoURL,
+		FrameRate:       content.FrameRate,
+		SourceFrameRate: content.FrameRate,
+		FrameRateMode:   string(frameRatePreserve),
+		Size:            content.Size,
+	}
+	_, err = cfg.db.CreateRendition(rendition)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save rendition", err)
+		return
+	}
+	version, err := cfg.db.ReserveVideoVersion(video.ID)
+	if err == nil {
+		video, err = cfg.saveCurrentVersion(video, version, []database.CreateRenditionParams{rendition})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save version", err)
+		return
+	}
+	cfg.auditUpload(r, false, video, "linked to existing content")
+	cfg.publishUploaded(r.Context(), video, database.MediaKindVideo)
+	cfg.publishEvent(r.Context(), newVideoEvent(eventVideoReady, video))
+
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Exists: true,
+		Video:  &video,
+	})
+}
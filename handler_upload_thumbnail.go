@@ -3,13 +3,13 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -33,7 +33,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	loggerFrom(r.Context()).Info("uploading thumbnail", "video_id", videoID, "user_id", userID)
 
 	// TODO: implement the upload here
 
@@ -54,7 +54,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+		respondWithErrorCode(w, http.StatusBadRequest, errorCodeUnsupportedMediaType, "Invalid file type", nil)
 		return
 	}
 
@@ -91,13 +91,31 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	oldThumbnailURL := video.ThumbnailURL
 	url := cfg.getAssetURL(assetPath)
 	video.ThumbnailURL = &url
+	video.ThumbnailBlurhash = thumbnailBlurhash(r.Context(), assetDiskPath)
 	err = cfg.db.UpdateVideo(video)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	if oldThumbnailURL != nil {
+		if oldPath, ok := cfg.assetPathFromURL(*oldThumbnailURL); ok {
+			cfg.invalidateCDNLater(r.Context(), []string{assetCDNPath(oldPath)})
+		}
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:    database.AuditThumbnailUploaded,
+		VideoID:   video.ID,
+		ObjectKey: assetPath,
+	})
+
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, video)
 }
@@ -3,29 +3,27 @@ package main
 import (
 	"bytes"
 	"context"
-	crand "crypto/rand"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"os/exec"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // store files in S3. images stay on local file system for now
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	// set upload size to 1GB using http.MaxBytesReader
-	const maxUploadSize = 1 << 30 // 1 GB
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-
 	// extract the videoID from the URL path and parse it as a UUID
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -47,14 +45,16 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// fetch the video row and verify the user owns it
-	fmt.Println("uploading video for video", videoID, "by user", userID)
+	logger := loggerFrom(r.Context()).With(
+		"video_id", videoID,
+		"user_id", userID,
+	)
 
 	// verify video ownership. compare to userID
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", err)
 		return
 	}
 
@@ -63,202 +63,295 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// parse the multipart form with max memory of 32MB
-	const maxMemory = 32 << 20 // 32 MB
-	err = r.ParseMultipartForm(maxMemory)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse multipart form", err)
+	// cap the upload size based on the user's tier
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
 		return
 	}
-	// TODO: extract the video file from the multipart form
-
-	file, fileHeader, err := r.FormFile("video")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Failed to retrieve video file", err)
+	maxUploadSize := cfg.uploadLimits.maxUploadSizeFor(user.Tier)
+	if r.ContentLength > maxUploadSize {
+		respondWithTooLarge(w, maxUploadSize, nil)
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
-	// debug print after formfile
-	fmt.Println("Retrieved file:", fileHeader.Filename, "size:", fileHeader.Size)
-
-	defer file.Close()
-
-	// validate the media type to ensure it's a MP4 video. using mime.ParseMediaType. not from header but from file
-	mediaType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
-		return
-	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+	// hard storage limit, the soft thresholds only warn once the upload is
+	// in. The whole body is a close enough stand-in for the file's size
+	// when it's known; otherwise the file is checked once it's received.
+	if r.ContentLength > 0 && !cfg.checkStorageQuota(w, userID, r.ContentLength) {
 		return
 	}
 
-	// upload file to a temp file on disk first. use os.CreateTemp
-	tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	// read the form part by part, so the video streams straight into a
+	// temp file instead of being buffered, and fields are read on their
+	// own, small parts whichever side of the file they're on
+	reader, err := r.MultipartReader()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart form", err)
 		return
 	}
-
-	// debug print temp file name
-	fmt.Println("Created temp file:", tempFile.Name())
-
-	// defer remove the temp with os.Remove
+	form := uploadForm{}
+	var tempFile *tempstore.File
 	defer func() {
-		tempFile.Close()
-		os.Remove(tempFile.Name())
+		// release the temp file and its reserved space when we're done
+		if tempFile != nil {
+			tempFile.Release()
+		}
 	}()
+	var mediaType, filename string
+	var sum []byte
+	var written int64
+	var copyStarted time.Time
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithTooLarge(w, maxUploadSize, err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
+			return
+		}
 
-	// copy the uploaded file to the temp file
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save uploaded file", err)
-		return
-	}
+		if part.FormName() != "video" {
+			err := form.read(part)
+			if errors.As(err, &maxBytesErr) {
+				respondWithTooLarge(w, maxUploadSize, err)
+				return
+			}
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+			continue
+		}
+		if tempFile != nil {
+			respondWithError(w, http.StatusBadRequest, "Only one video file can be uploaded at a time", nil)
+			return
+		}
+		filename = part.FileName()
+		logger.Debug("receiving video file", "filename", filename)
 
-	// debug print after copy
-	fmt.Println("Copied uploaded file to temp file")
+		checksums, err := parseContentChecksums(part.Header, r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
 
-	//get aspect ratio of the video file. Depending on the aspect ratio, add "landscape", "portrait", or "other" prefix to the key
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to get video aspect ratio", err)
+		// validate the media type against the types this server accepts, each of which may have a smaller size cap of its own
+		mediaType, _, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+			return
+		}
+		typeLimit, ok := cfg.uploadLimits.maxUploadSizeForType(user.Tier, mediaType)
+		if !ok {
+			respondWithErrorCode(w, http.StatusBadRequest, errorCodeUnsupportedMediaType, "Invalid file type, accepted types are "+cfg.uploadLimits.acceptedMediaTypes(), nil)
+			return
+		}
+
+		// upload file to a temp file on disk first, on a volume with room for the whole body
+		tempFile, err = cfg.tempStore.Create(tempReservation(r.ContentLength, typeLimit), "upload-*.mp4")
+		if errors.Is(err, tempstore.ErrNoCapacity) {
+			respondWithError(w, http.StatusInsufficientStorage, "Not enough temporary storage for this upload, try again later", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+			return
+		}
+		logger.Debug("created temp file", "path", tempFile.Name())
+
+		// copy the uploaded file to the temp file, hashing it on the way for deduplication
+		// and to check it against the client's checksums
+		hasher := sha256.New()
+		md5Hasher := md5.New()
+		copyStarted = time.Now()
+		_, copySpan := tracer.Start(r.Context(), "copy upload to temp file")
+		written, err = io.Copy(io.MultiWriter(tempFile, hasher, md5Hasher), io.LimitReader(part, typeLimit+1))
+		copySpan.SetAttributes(attribute.Int64("bytes", written))
+		endSpan(copySpan, err)
+		if errors.As(err, &maxBytesErr) {
+			respondWithTooLarge(w, maxUploadSize, err)
+			return
+		}
+		if errors.Is(err, tempstore.ErrNoCapacity) {
+			respondWithError(w, http.StatusInsufficientStorage, "Not enough temporary storage for this upload, try again later", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to save uploaded file", err)
+			return
+		}
+		if written > typeLimit {
+			respondWithTooLarge(w, typeLimit, nil)
+			return
+		}
+		sum = hasher.Sum(nil)
+		if checksums.SHA256 != nil && !bytes.Equal(checksums.SHA256, sum) {
+			respondWithError(w, http.StatusBadRequest, "Uploaded file doesn't match X-Content-SHA256, it may have been corrupted in transit", nil)
+			return
+		}
+		if checksums.MD5 != nil && !bytes.Equal(checksums.MD5, md5Hasher.Sum(nil)) {
+			respondWithError(w, http.StatusBadRequest, "Uploaded file doesn't match Content-MD5, it may have been corrupted in transit", nil)
+			return
+		}
+	}
+	if tempFile == nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to retrieve video file", nil)
 		return
 	}
-
-	// debug print aspect ratio
-	fmt.Println("Video aspect ratio:", aspectRatio)
-
-	// determine prefix based on aspect ratio
-	aspectRatioPrefix := "other"
-	switch aspectRatio {
-	case "16:9", "4:3":
-		aspectRatioPrefix = "landscape"
-	case "9:16", "3:4":
-		aspectRatioPrefix = "portrait"
+	if r.ContentLength <= 0 && !cfg.checkStorageQuota(w, userID, written) {
+		return
 	}
-	fmt.Println("aspect ratio:", aspectRatio, "prefix:", aspectRatioPrefix)
 
-	// debug print temp.seek
-	fmt.Println("Seeked temp file to beginning")
-
-	// reset the tempFile's file pointer to the beginning with .Seek(0,io.SeekStart)
-	_, err = tempFile.Seek(0, io.SeekStart)
+	normalizeLoudness, err := form.bool("normalize_loudness")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to seek temp file", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
-
-	// generate random 32 byte hex filename
-	randomBytes := make([]byte, 32)
-	_, err = crand.Read(randomBytes)
+	opts, err := cfg.resolveUploadOptions(userID, uploadOptions{
+		Visibility:        video.Visibility,
+		Profile:           form.get("profile"),
+		NormalizeLoudness: normalizeLoudness,
+	})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	profile, err := getProcessingProfile(opts.Profile)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating random filename", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
-	// make a 64-char lowercase hex string using hex encoding
-	randomHexFilename := hex.EncodeToString(randomBytes)
+	profile.NormalizeLoudness = opts.NormalizeLoudness
 
-	// debug print before upload to S3
-	fmt.Println("Uploading file to S3 with key:", randomHexFilename+".mp4")
+	logger.Info("video file received", "bytes", written, "duration", time.Since(copyStarted))
 
-	// upload the file to S3 with aspect ratio prefix in the path
-	s3Key := fmt.Sprintf("videos/%s/%s.mp4", aspectRatioPrefix, randomHexFilename)
+	src := ingestSource{
+		Path:      tempFile.Name(),
+		MediaType: mediaType,
+		SHA256:    hex.EncodeToString(sum),
+		Size:      written,
+		Filename:  cleanFilename(filename),
+	}
+	if cfg.jobQueue != nil {
+		cfg.queueUpload(w, r, video, tempFile, src, opts)
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	ctx, cancel := context.WithTimeout(withLogger(r.Context(), logger), 30*time.Minute)
 	defer cancel()
 
-	// debug print
-	// go
-	fmt.Println("S3 bucket:", cfg.s3Bucket, "region:", cfg.s3Region, "key:", s3Key)
+	ingestStarted := time.Now()
 
-	_, err = cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(s3Key),
-		Body:        tempFile,
-		ContentType: aws.String(mediaType),
-	})
+	hadFile := video.VideoURL != nil
+	ingestCtx, ingestSpan := tracer.Start(ctx, "ingest video", trace.WithAttributes(attribute.String("profile", opts.Profile)))
+	video, err = cfg.ingestVideo(ingestCtx, video, src, profile)
+	endSpan(ingestSpan, err)
+	if err != nil {
+		logger.Error("video ingest failed", "error", err, "duration", time.Since(ingestStarted))
+	}
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload file to S3", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to process video", err)
 		return
 	}
+	cfg.auditUpload(r, hadFile, video, video.OriginalFilename)
 
-	// debug print after upload
-	fmt.Println("Successfully uploaded file to S3 with key:", s3Key)
-
-	// update the video's VideoURL field to the S3 URL and return a success JSON response
-	videoURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, s3Key)
-	u := videoURL
-	video.VideoURL = &u
-	err = cfg.db.UpdateVideo(video)
+	presented, err := cfg.presentVideo(ctx, video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update video URL in database", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
 		return
 	}
 
+	logger.Info("video ingested", "duration", time.Since(ingestStarted))
+
 	response := map[string]string{
 		"message":   "Video uploaded successfully",
-		"video_url": videoURL,
+		"video_url": *presented.VideoURL,
 	}
 	respondWithJSON(w, http.StatusOK, response)
 
 }
 
-// create getVideoAspectRatio. Takes a file path and returns the aspect ratio as a string
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return "", err
-	}
+// formBool parses an optional true/false form field, false if it's absent.
+func formBool(r *http.Request, name string) (bool, error) {
+	return parseFormBool(name, r.FormValue(name))
+}
 
-	var ff struct {
-		Streams []struct {
-			CodecType string `json:"codec_type"`
-			Width     int    `json:"width"`
-			Height    int    `json:"height"`
-			Tags      struct {
-				Rotate string `json:"rotate"`
-			} `json:"tags"`
-		} `json:"streams"`
+func parseFormBool(name, value string) (bool, error) {
+	if value == "" {
+		return false, nil
 	}
-	if err := json.Unmarshal(out.Bytes(), &ff); err != nil {
-		return "", err
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
 	}
+	return b, nil
+}
 
-	// Go
-	for _, s := range ff.Streams {
-		if s.CodecType != "video" {
-			continue
-		}
-		if s.Width <= 0 || s.Height <= 0 {
-			continue
-		}
-		w, h := s.Width, s.Height
-		if s.Tags.Rotate == "90" || s.Tags.Rotate == "270" {
-			w, h = h, w
-		}
+const (
+	// maxFormFieldSize caps each field of a streamed upload other than the
+	// file; they hold settings, not data
+	maxFormFieldSize = 64 << 10
+	maxFormFields    = 32
+)
+
+// uploadForm holds the fields of a multipart form read part by part, by
+// name.
+type uploadForm struct {
+	fields map[string]string
+	// parts counts every field part read, repeated names included
+	parts int
+}
+
+// read stores the field in part, refusing fields too large or too many to
+// be settings. A repeated field keeps its last value but still counts
+// against the limit.
+func (f *uploadForm) read(part *multipart.Part) error {
+	if f.parts >= maxFormFields {
+		return fmt.Errorf("forms can have at most %d fields besides the file", maxFormFields)
+	}
+	f.parts++
+	value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+	if err != nil {
+		return err
+	}
+	if len(value) > maxFormFieldSize {
+		return fmt.Errorf("form field %s is larger than %d bytes", part.FormName(), maxFormFieldSize)
+	}
+	if f.fields == nil {
+		f.fields = map[string]string{}
+	}
+	f.fields[part.FormName()] = string(value)
+	return nil
+}
 
-		// debug print width and height
-		fmt.Printf("Video width: %d, height: %d\n", w, h)
+// get returns the field called name, or "" if it's absent.
+func (f *uploadForm) get(name string) string {
+	return f.fields[name]
+}
 
-		ar := float64(w) / float64(h)
+// bool parses an optional true/false field, false if it's absent.
+func (f *uploadForm) bool(name string) (bool, error) {
+	return parseFormBool(name, f.get(name))
+}
 
-		if ar > 1.6 && ar < 1.85 {
-			return "16:9", nil
-		}
-		if ar > 1.28 && ar < 1.36 {
-			return "4:3", nil
-		}
-		if ar > 0.53 && ar < 0.62 {
-			return "9:16", nil
-		}
-		if ar > 0.73 && ar < 0.82 {
-			return "3:4", nil
-		}
+// tempReservation is the temp space to reserve for a file of size bytes,
+// or when its size isn't known, for the most it may grow to, limit.
+func tempReservation(size, limit int64) int64 {
+	if size <= 0 {
+		return limit
 	}
-	// no match found
-	return "other", nil
+	return size
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	"github.com/google/uuid"
+)
+
+const (
+	maxZipUploadSize = 4 << 30  // 4 GB
+	maxZipEntrySize  = 1 << 30  // 1 GB, same as a single upload
+	maxZipTotalSize  = 10 << 30 // 10 GB uncompressed
+	maxZipEntries    = 100
+)
+
+// archives carry no content types, so go by extension
+var zipVideoExtensions = map[string]string{
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+}
+
+// importResult is how one file of a multi-file upload went: "created",
+// "failed" or "skipped", with the reason for the last two. A file created
+// while jobs go to workers has the job processing it.
+type importResult struct {
+	Filename string     `json:"filename"`
+	Status   string     `json:"status"`
+	VideoID  *uuid.UUID `json:"video_id,omitempty"`
+	JobID    *uuid.UUID `json:"job_id,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// handlerUploadZip ingests every video in a .zip archive (e.g. a camera card
+// dump), creating one video per file and reporting how each one went.
+func (cfg *apiConfig) handlerUploadZip(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Created int            `json:"created"`
+		Failed  int            `json:"failed"`
+		Skipped int            `json:"skipped"`
+		Results []importResult `json:"results"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxZipUploadSize)
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	err = r.ParseMultipartForm(cfg.uploadLimits.MultipartMemory)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithTooLarge(w, maxZipUploadSize, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse multipart form", err)
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("archive")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to retrieve archive", err)
+		return
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		return
+	}
+	if mediaType != "application/zip" && mediaType != "application/x-zip-compressed" {
+		respondWithErrorCode(w, http.StatusBadRequest, errorCodeUnsupportedMediaType, "Invalid file type", nil)
+		return
+	}
+
+	normalizeLoudness, err := formBool(r, "normalize_loudness")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	opts, err := cfg.resolveUploadOptions(userID, uploadOptions{
+		Visibility:        r.FormValue("visibility"),
+		Profile:           r.FormValue("profile"),
+		NormalizeLoudness: normalizeLoudness,
+	})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+
+	// zip needs random access, so spool the archive to disk first
+	archiveFile, err := cfg.tempStore.Create(fileHeader.Size, "archive-*.zip")
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		respondWithError(w, http.StatusInsufficientStorage, "Not enough temporary storage for this upload, try again later", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+		return
+	}
+	defer archiveFile.Release()
+
+	archiveSize, err := io.Copy(archiveFile, file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save uploaded archive", err)
+		return
+	}
+
+	archive, err := zip.NewReader(archiveFile, archiveSize)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid zip archive", err)
+		return
+	}
+
+	// check the limits up front so we don't process half an oversized archive
+	entries := 0
+	var totalSize uint64
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		entries++
+		totalSize += f.UncompressedSize64
+	}
+	if entries > maxZipEntries {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Archives can contain at most %d files", maxZipEntries), nil)
+		return
+	}
+	if totalSize > maxZipTotalSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Archive is too large once extracted", nil)
+		return
+	}
+	// the videos take up their extracted size, not their compressed one
+	if !cfg.checkStorageQuota(w, userID, int64(totalSize)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Hour)
+	defer cancel()
+
+	resp := response{Results: []importResult{}}
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		result := cfg.ingestZipEntry(ctx, f, userID, opts)
+		switch result.Status {
+		case "created":
+			resp.Created++
+			if video, err := cfg.db.GetVideo(*result.VideoID); err == nil {
+				cfg.auditUpload(r, false, video, "from archive "+fileHeader.Filename)
+			}
+		case "failed":
+			resp.Failed++
+		default:
+			resp.Skipped++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) ingestZipEntry(ctx context.Context, f *zip.File, userID uuid.UUID, opts uploadOptions) importResult {
+	result := importResult{Filename: f.Name}
+	skip := func(reason string) importResult {
+		result.Status = "skipped"
+		result.Error = reason
+		return result
+	}
+	fail := func(reason string, err error) importResult {
+		loggerFrom(ctx).Error("couldn't ingest file from archive", "filename", f.Name, "error", err)
+		result.Status = "failed"
+		result.Error = reason
+		return result
+	}
+
+	// entries are never written under their own name, but reject anything
+	// that tries to escape the archive root so it can't be misused later
+	name := strings.ReplaceAll(f.Name, `\`, "/")
+	if path.IsAbs(name) || strings.HasPrefix(name, "../") || strings.Contains(name, "/../") || path.Clean(name) != name {
+		return skip("unsafe path")
+	}
+	base := path.Base(name)
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+		return skip("hidden file")
+	}
+	ext := strings.ToLower(path.Ext(base))
+	mediaType, ok := zipVideoExtensions[ext]
+	if !ok {
+		return skip("unsupported file type")
+	}
+	typeLimit, accepted := cfg.uploadLimits.MediaTypes[mediaType]
+	if !accepted {
+		return skip("file type not accepted")
+	}
+	maxSize := int64(maxZipEntrySize)
+	if typeLimit > 0 {
+		maxSize = min(maxSize, typeLimit)
+	}
+	if f.UncompressedSize64 > uint64(maxSize) {
+		return skip("file too large")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return fail("couldn't read file from archive", err)
+	}
+	defer rc.Close()
+
+	tempFile, err := cfg.tempStore.Create(int64(f.UncompressedSize64), "upload-*"+ext)
+	if err != nil {
+		return fail("not enough temporary storage", err)
+	}
+	defer tempFile.Release()
+
+	// the declared size can lie, so enforce the limit while extracting
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tempFile, hasher), io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return fail("couldn't extract file", err)
+	}
+	if written > maxSize {
+		return skip("file too large")
+	}
+
+	// each file counts against the quota the ones before it used up
+	quota, err := cfg.storageQuotaStatus(userID)
+	if err != nil {
+		return fail("couldn't check storage quota", err)
+	}
+	if !quota.allows(written) {
+		return skip("storage quota exceeded")
+	}
+
+	bucket, err := cfg.newVideoBucket(userID)
+	if err != nil {
+		return fail("couldn't pick a bucket", err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:         strings.TrimSuffix(base, path.Ext(base)),
+		UserID:        userID,
+		Visibility:    opts.Visibility,
+		StorageBucket: bucket,
+	})
+	if err != nil {
+		return fail("couldn't create video", err)
+	}
+	if _, err := cfg.applyUploadOptions(video, opts); err != nil {
+		if delErr := cfg.db.DeleteVideo(video.ID); delErr != nil {
+			loggerFrom(ctx).Error("couldn't remove draft video", "video_id", video.ID, "error", delErr)
+		}
+		return fail("couldn't apply upload policy", err)
+	}
+	// options were validated up front, so the profile is known to exist
+	profile, _ := getProcessingProfile(opts.Profile)
+	profile.NormalizeLoudness = opts.NormalizeLoudness
+
+	result.JobID, err = cfg.ingestFileOrQueue(ctx, video, tempFile, ingestSource{
+		Path:      tempFile.Name(),
+		MediaType: mediaType,
+		SHA256:    hex.EncodeToString(hasher.Sum(nil)),
+		Size:      written,
+		Filename:  cleanFilename(base),
+	}, opts, profile)
+	if err != nil {
+		// don't leave an empty draft behind for a file that didn't make it
+		if delErr := cfg.db.DeleteVideo(video.ID); delErr != nil {
+			loggerFrom(ctx).Error("couldn't remove draft video", "video_id", video.ID, "error", delErr)
+		}
+		reason := "processing failed"
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) {
+			reason = ingestErr.Message
+		}
+		return fail(reason, err)
+	}
+
+	result.Status = "created"
+	result.VideoID = &video.ID
+	return result
+}
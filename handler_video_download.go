@@ -0,0 +1,97 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// maxFilenameLength keeps stored filenames within what file systems allow
+const maxFilenameLength = 255
+
+// handlerVideoDownload sends a video's file as an attachment named after
+// the file it was uploaded as, so it saves with a sensible name instead of
+// its S3 key. Range requests are passed on, so downloads can resume.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// private videos look like they don't exist to anyone but the owner
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, userID) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file uploaded yet", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video isn't stored in Tubely", nil)
+		return
+	}
+
+	filename := video.OriginalFilename
+	if filename == "" {
+		filename = cleanFilename(strings.NewReplacer("/", "-", `\`, "-").Replace(video.Title))
+		if filename == "" {
+			filename = "video"
+		}
+		filename += path.Ext(key)
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	cfg.recordBytesServed(r, video, cfg.streamObject(w, r, bucket, key, disposition))
+}
+
+// cleanFilename reduces a client supplied file name or path to a name that
+// is safe to store and send back in a Content-Disposition header.
+func cleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxFilenameLength-len(ext)], "") + ext
+	}
+	return name
+}
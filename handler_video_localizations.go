@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoLocalizationsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, cfg.optionalViewerID(r)) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	localizations, err := cfg.db.GetLocalizations(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get localizations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, localizations)
+}
+
+func (cfg *apiConfig) handlerVideoLocalizationPut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if strings.TrimSpace(params.Title) == "" {
+		respondWithError(w, http.StatusBadRequest, "Title is required", nil)
+		return
+	}
+
+	loc := database.Localization{
+		VideoID:     videoID,
+		Language:    language,
+		Title:       params.Title,
+		Description: params.Description,
+	}
+	err = cfg.db.SaveLocalization(loc)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save localization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, loc)
+}
+
+func (cfg *apiConfig) handlerVideoLocalizationDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.DeleteLocalization(videoID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete localization", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -12,6 +13,7 @@ import (
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
+		Tags []string `json:"tags"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -34,11 +36,41 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	}
 	params.UserID = userID
 
+	// fill in the organization's defaults for anything not asked for
+	opts, err := cfg.resolveUploadOptions(userID, uploadOptions{
+		Visibility: params.Visibility,
+		Tags:       params.Tags,
+	})
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	params.Visibility = opts.Visibility
+	if params.DefaultLanguage != "" {
+		params.DefaultLanguage, err = normalizeLanguage(params.DefaultLanguage)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	params.StorageBucket, err = cfg.newVideoBucket(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't pick a bucket", err)
+		return
+	}
+
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	video, err = cfg.applyUploadOptions(video, opts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't apply upload policy", err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
@@ -64,7 +96,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
@@ -72,11 +104,16 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.deleteVideo(video)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:    database.AuditVideoRemoved,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -91,10 +128,102 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", err)
+		return
+	}
+	// private videos look like they don't exist to anyone but the owner
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, cfg.optionalViewerID(r)) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+	video, err = cfg.localizeVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't localize video", err)
 		return
 	}
 
+	w.Header().Add("Vary", "Accept-Language")
+	if cfg.notModified(w, r, []database.Video{video}) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title           *string `json:"title"`
+		Description     *string `json:"description"`
+		Visibility      *string `json:"visibility"`
+		DefaultLanguage *string `json:"default_language"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if params.Title != nil {
+		video.Title = *params.Title
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	previousVisibility := video.Visibility
+	if params.Visibility != nil {
+		if !validVisibility(*params.Visibility) {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private", nil)
+			return
+		}
+		video.Visibility = *params.Visibility
+	}
+	if params.DefaultLanguage != nil {
+		video.DefaultLanguage = ""
+		if *params.DefaultLanguage != "" {
+			video.DefaultLanguage, err = normalizeLanguage(*params.DefaultLanguage)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+		}
+	}
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if video.Visibility != previousVisibility {
+		cfg.audit(r, database.CreateAuditEntryParams{
+			Action:  database.AuditVisibilityChanged,
+			VideoID: video.ID,
+			Detail:  previousVisibility + " -> " + video.Visibility,
+		})
+	}
+
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, video)
 }
 
@@ -110,11 +239,72 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	ownerID := userID
+	if owner := r.URL.Query().Get("user_id"); owner != "" {
+		ownerID, err = uuid.Parse(owner)
+		// the nil ID would list everyone's videos
+		if err != nil || ownerID == uuid.Nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+			return
+		}
+	}
+
+	limit, err := parsePageSize(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	params := database.GetVideosPageParams{
+		UserID: ownerID,
+		// fetch one extra row to learn whether another page exists
+		Limit: limit + 1,
+		// other people's channels only list public videos
+		PublicOnly: ownerID != userID,
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		params.Tag, err = normalizeTag(tag)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if cursorString := r.URL.Query().Get("cursor"); cursorString != "" {
+		cursor, err := decodePageCursor(cursorString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.BeforeCreatedAt = cursor.CreatedAt
+		params.BeforeID = cursor.ID
+	}
+
+	videos, err := cfg.db.GetVideosPage(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[len(videos)-1]
+		setNextPageHeaders(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode())
+	}
+
+	videos, err = cfg.presentVideos(r.Context(), videos, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URLs", err)
+		return
+	}
+	videos, err = cfg.localizeVideos(r, videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't localize videos", err)
+		return
+	}
+
+	// the same videos with or without a page after them are different pages
+	if cfg.notModified(w, r, videos, w.Header().Get("X-Next-Cursor")) {
+		return
+	}
 	respondWithJSON(w, http.StatusOK, videos)
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// handlerVideoStream streams a video's file through the API, so the bucket
+// can stay private and players can still seek. Private videos are only
+// streamed to their owner, whose token comes in the Authorization header;
+// players that can't send one use a playback token instead.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	viewerID := cfg.optionalViewerID(r)
+	// private videos look like they don't exist to anyone but the owner
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, viewerID) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file uploaded yet", nil)
+		return
+	}
+	if respondIfArchived(w, video) {
+		return
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video isn't stored in Tubely", nil)
+		return
+	}
+
+	if isPlaybackStart(r) {
+		cfg.recordView(r, video, viewerID)
+	}
+	cfg.recordBytesServed(r, video, cfg.streamObject(w, r, bucket, key, ""))
+}
+
+// streamObject sends key from bucket to the client, passing a Range
+// request through to S3 so players can seek without the bucket being
+// readable. A non-empty disposition is sent as Content-Disposition
+// with the object, but not with errors. It returns how many bytes of the
+// object were sent.
+func (cfg *apiConfig) streamObject(w http.ResponseWriter, r *http.Request, bucket, key, disposition string) int64 {
+	input := &s3.GetObjectInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(key),
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	}
+	if spec := r.Header.Get("Range"); spec != "" {
+		input.Range = aws.String(spec)
+	}
+	// not retried: the body is streamed straight to the player, which
+	// retries on its own
+	out, err := cfg.buckets.client(bucket).GetObject(r.Context(), input)
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) {
+			switch respErr.HTTPStatusCode() {
+			case http.StatusNotFound:
+				respondWithError(w, http.StatusNotFound, "Video file not found", err)
+				return 0
+			case http.StatusRequestedRangeNotSatisfiable:
+				respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Range not satisfiable", err)
+				return 0
+			}
+		}
+		respondWithError(w, http.StatusBadGateway, "Couldn't read video", err)
+		return 0
+	}
+	defer out.Body.Close()
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Cache-Control", "private")
+	if disposition != "" {
+		h.Set("Content-Disposition", disposition)
+	}
+	if out.ContentType != nil {
+		h.Set("Content-Type", *out.ContentType)
+	}
+	if out.ContentLength != nil {
+		h.Set("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	if out.ETag != nil {
+		h.Set("ETag", *out.ETag)
+	}
+	if out.LastModified != nil {
+		h.Set("Last-Modified", out.LastModified.UTC().Format(http.TimeFormat))
+	}
+	status := http.StatusOK
+	if out.ContentRange != nil {
+		h.Set("Content-Range", *out.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	written, err := io.Copy(w, out.Body)
+	if err != nil {
+		loggerFrom(r.Context()).Debug("stream ended early", "key", key, "error", err)
+	}
+	return written
+}
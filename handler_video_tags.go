@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const maxTagLength = 50
+
+// normalizeTag lowercases and trims a tag and checks it only uses letters,
+// digits, dashes and underscores.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", errors.New("tags can't be empty")
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tags can be at most %d characters", maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return "", fmt.Errorf("invalid character %q in tag", r)
+		}
+	}
+	return tag, nil
+}
+
+func (cfg *apiConfig) handlerVideoTagsAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Tags) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one tag is required", nil)
+		return
+	}
+
+	tags := make([]string, 0, len(params.Tags))
+	for _, tag := range params.Tags {
+		normalized, err := normalizeTag(tag)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		tags = append(tags, normalized)
+	}
+
+	err = cfg.db.AddVideoTags(videoID, tags)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add tags", err)
+		return
+	}
+
+	allTags, err := cfg.db.GetVideoTags(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, allTags)
+}
+
+func (cfg *apiConfig) handlerVideoTagRemove(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.RemoveVideoTag(videoID, tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove tag", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVideoTagsGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, cfg.optionalViewerID(r)) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+
+	tags, err := cfg.db.GetVideoTags(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
+
+// authorizeVideoOwner parses the videoID path value and checks the caller's
+// JWT belongs to the video's owner. On failure it has already responded.
+func (cfg *apiConfig) authorizeVideoOwner(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return uuid.Nil, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You do not own this video", nil)
+		return uuid.Nil, false
+	}
+	return videoID, true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideoVersionsGet lists the kept versions of a video's file,
+// newest first, marking the current one. Only the owner can see them.
+func (cfg *apiConfig) handlerVideoVersionsGet(w http.ResponseWriter, r *http.Request) {
+	type version struct {
+		database.VideoVersion
+		Current bool `json:"current"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	versions, err := cfg.db.GetVideoVersions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get versions", err)
+		return
+	}
+
+	resp := make([]version, 0, len(versions))
+	for _, v := range versions {
+		resp = append(resp, version{VideoVersion: v, Current: v.Version == video.Version})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerVideoVersionRestore rolls a video back to one of its kept
+// versions. The file it had becomes a kept version in turn.
+func (cfg *apiConfig) handlerVideoVersionRestore(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || number < 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid version", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// the archived file would become a version no one can play
+	if respondIfArchived(w, video) {
+		return
+	}
+	version, err := cfg.db.GetVideoVersion(videoID, number)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get version", err)
+		return
+	}
+	if version == nil {
+		respondWithError(w, http.StatusNotFound, "Version not found", nil)
+		return
+	}
+	if version.Version == video.Version {
+		respondWithError(w, http.StatusConflict, "That version is already current", nil)
+		return
+	}
+
+	video, err = cfg.restoreVideoVersion(video, *version)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore version", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:    database.AuditVersionRestored,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+		Detail:    fmt.Sprintf("version %d", version.Version),
+	})
+
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
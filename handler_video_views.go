@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 365
+)
+
+// handlerVideoViewCreate is a beacon players send when they start playing
+// a video they got straight from S3, which Tubely doesn't see otherwise.
+func (cfg *apiConfig) handlerVideoViewCreate(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	viewerID := cfg.optionalViewerID(r)
+	// private videos look like they don't exist to anyone but the owner
+	if video.ID == uuid.Nil || !cfg.canAccess(r, video, viewerID) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+
+	cfg.recordView(r, video, viewerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoStatsGet shows a video's owner its view count, along with
+// unique viewers and views per day over the last ?days (30 by default).
+func (cfg *apiConfig) handlerVideoStatsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	days := defaultStatsDays
+	if daysString := r.URL.Query().Get("days"); daysString != "" {
+		n, err := strconv.Atoi(daysString)
+		if err != nil || n < 1 || n > maxStatsDays {
+			respondWithError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxStatsDays), err)
+			return
+		}
+		days = n
+	}
+
+	// whole UTC days, so the first day isn't cut short
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := cfg.db.GetVideoViewStats(videoID, today.AddDate(0, 0, -(days-1)))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get view stats", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, stats)
+}
+
+// analyticsWindows are the periods handlerVideoAnalyticsGet reports on. A
+// zero duration means all time.
+var analyticsWindows = []struct {
+	Name   string
+	Length time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"all", 0},
+}
+
+// handlerVideoAnalyticsGet shows a video's owner its views, unique viewers
+// and bytes served over the last day, week, month and all time. Bytes are
+// only known for what Tubely streamed itself, not plays straight from S3.
+func (cfg *apiConfig) handlerVideoAnalyticsGet(w http.ResponseWriter, r *http.Request) {
+	type window struct {
+		Window string     `json:"window"`
+		Since  *time.Time `json:"since"`
+		database.VideoActivity
+	}
+	type response struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Windows []window  `json:"windows"`
+	}
+
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	resp := response{VideoID: videoID, Windows: make([]window, 0, len(analyticsWindows))}
+	for _, aw := range analyticsWindows {
+		var since time.Time
+		result := window{Window: aw.Name}
+		if aw.Length > 0 {
+			since = now.Add(-aw.Length)
+			result.Since = &since
+		}
+		activity, err := cfg.db.GetVideoActivity(videoID, since)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get analytics", err)
+			return
+		}
+		result.VideoActivity = activity
+		resp.Windows = append(resp.Windows, result)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
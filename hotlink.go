@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// errorCodeHotlinkBlocked marks a video refused to a page on a site not
+// allowed to embed it
+const errorCodeHotlinkBlocked = "hotlink_blocked"
+
+// blockHotlinks refuses requests from pages on sites not allowed to play
+// videos, so they can't embed the server's video URLs and spend its
+// bandwidth. It does nothing without HOTLINK_ALLOWED_ORIGINS.
+func (cfg *apiConfig) blockHotlinks(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.embedAllowed(r) {
+			respondWithErrorCode(w, http.StatusForbidden, errorCodeHotlinkBlocked, "Videos can't be played from this site", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// embedAllowed reports whether r comes from a page allowed to play
+// videos. The page is taken from the Origin header, which players send
+// with CORS requests, or else from the Referer.
+func (cfg *apiConfig) embedAllowed(r *http.Request) bool {
+	if len(cfg.hotlink.AllowedOrigins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return cfg.hotlink.AllowNoReferer
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	return slices.Contains(cfg.hotlink.AllowedOrigins, origin)
+}
+
+// clientURLExpiry caps expiry, how long a video URL handed to a client
+// would last, at HOTLINK_URL_EXPIRY, so an embedded copy stops working
+// soon.
+func (cfg *apiConfig) clientURLExpiry(expiry time.Duration) time.Duration {
+	if cfg.hotlink.URLExpiry > 0 {
+		return min(expiry, cfg.hotlink.URLExpiry)
+	}
+	return expiry
+}
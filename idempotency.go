@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// replays of a request within this window get the original response
+const idempotencyKeyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder tees a response so it can be stored for replay.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent lets clients safely retry next by sending an Idempotency-Key
+// header: the first request with a key runs normally and its response is
+// stored, later ones with the same key get that response back. Server
+// errors aren't stored, so those requests can be retried for real.
+// Requests without the header, or that fail auth, go straight to next.
+func (cfg *apiConfig) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key is too long", nil)
+			return
+		}
+
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		record, reserved, err := cfg.db.ReserveIdempotencyKey(userID, key, r.URL.Path, idempotencyKeyTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check Idempotency-Key", err)
+			return
+		}
+		if !reserved {
+			switch {
+			case record.RequestPath != r.URL.Path:
+				respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", nil)
+			case record.StatusCode == 0:
+				respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress", nil)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.ResponseBody)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		if rec.status == 0 || rec.status >= 500 {
+			err = cfg.db.ReleaseIdempotencyKey(userID, key)
+		} else {
+			err = cfg.db.CompleteIdempotencyKey(userID, key, rec.status, rec.body.Bytes())
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Error("couldn't record Idempotency-Key", "key", key, "user_id", userID, "error", err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,6 +19,10 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	TokenTypeShare  TokenType = "tubely-share"
+	// TokenTypeImpersonation is an access token an admin uses to act as
+	// another user
+	TokenTypeImpersonation TokenType = "tubely-impersonation"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -38,33 +43,189 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return match, nil
 }
 
+// MakeJWT mints an access token for userID with the given role.
 func MakeJWT(
 	userID uuid.UUID,
+	role Role,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role: role,
 	})
 	return token.SignedString(signingKey)
 }
 
+// ValidateJWT returns the user an access token acts as. Impersonation
+// tokens are accepted too, so every authenticated route works with them.
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	claims, err := ParseAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+// AccessClaims identify who a request acts as and, for impersonation
+// tokens, the admin behind it.
+type AccessClaims struct {
+	UserID uuid.UUID
+	// ImpersonatorID is uuid.Nil for a user's own tokens
+	ImpersonatorID uuid.UUID
+	Role           Role
+	IssuedAt       time.Time
+	ExpiresAt      time.Time
+}
+
+// accessTokenClaims carry the user's role and, for impersonation tokens,
+// name the admin in the actor claim, as in RFC 8693.
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	Role  Role        `json:"role,omitempty"`
+	Actor *actorClaim `json:"act,omitempty"`
+}
+
+type actorClaim struct {
+	Subject string `json:"sub"`
+}
+
+// ParseAccessToken validates an access or impersonation token.
+func ParseAccessToken(tokenString, tokenSecret string) (AccessClaims, error) {
+	return ParseAccessTokenAt(tokenString, tokenSecret, time.Now())
+}
+
+// ParseAccessTokenAt validates an access or impersonation token as of at,
+// so a token that has since expired is accepted if it was valid then.
+func ParseAccessTokenAt(tokenString, tokenSecret string, at time.Time) (AccessClaims, error) {
+	claimsStruct := accessTokenClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		jwt.WithTimeFunc(func() time.Time { return at }),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return AccessClaims{}, err
+	}
+	if issuer != string(TokenTypeAccess) && issuer != string(TokenTypeImpersonation) {
+		return AccessClaims{}, errors.New("invalid issuer")
+	}
+
+	claims := AccessClaims{}
+	claims.UserID, err = uuid.Parse(userIDString)
+	if err != nil {
+		return AccessClaims{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+	// tokens from before roles existed belong to creators, which everyone
+	// was then
+	claims.Role = claimsStruct.Role
+	if claims.Role == "" {
+		claims.Role = RoleCreator
+	}
+	if claimsStruct.IssuedAt != nil {
+		claims.IssuedAt = claimsStruct.IssuedAt.Time
+	}
+	if claimsStruct.ExpiresAt != nil {
+		claims.ExpiresAt = claimsStruct.ExpiresAt.Time
+	}
+
+	if issuer == string(TokenTypeImpersonation) {
+		if claimsStruct.Actor == nil {
+			return AccessClaims{}, errors.New("impersonation token has no actor")
+		}
+		claims.ImpersonatorID, err = uuid.Parse(claimsStruct.Actor.Subject)
+		if err != nil {
+			return AccessClaims{}, fmt.Errorf("invalid impersonator ID: %w", err)
+		}
+	}
+	return claims, nil
+}
+
+// MakeImpersonationJWT mints an access token for userID, with their role,
+// that records adminID as the one acting.
+func MakeImpersonationJWT(
+	userID uuid.UUID,
+	role Role,
+	adminID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(tokenSecret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeImpersonation),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role:  role,
+		Actor: &actorClaim{Subject: adminID.String()},
+	})
+	return token.SignedString(signingKey)
+}
+
+// ReissueAccessToken mints a token with the same claims as one already
+// validated, issue time included, that expires after expiresIn instead.
+func ReissueAccessToken(claims AccessClaims, tokenSecret string, expiresIn time.Duration) (string, error) {
+	tokenClaims := accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(claims.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   claims.UserID.String(),
+		},
+		Role: claims.Role,
+	}
+	if claims.ImpersonatorID != uuid.Nil {
+		tokenClaims.Issuer = string(TokenTypeImpersonation)
+		tokenClaims.Actor = &actorClaim{Subject: claims.ImpersonatorID.String()}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// MakeShareToken mints a token granting read access to a single video until
+// it expires. It can't be used as an access token.
+func MakeShareToken(
+	videoID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(tokenSecret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeShare),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   videoID.String(),
+	})
+	return token.SignedString(signingKey)
+}
+
+// ValidateShareToken returns the video a share token grants access to.
+func ValidateShareToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claimsStruct := jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -73,13 +234,17 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(TokenTypeShare) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
-	id, err := uuid.Parse(userIDString)
+	videoIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := uuid.Parse(videoIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, fmt.Errorf("invalid video ID: %w", err)
 	}
 	return id, nil
 }
@@ -106,6 +271,26 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// APIKeyPrefix starts every API key, so leaked keys are easy to spot.
+const APIKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new random API key.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey returns the form an API key is stored and looked up in.
+// Keys are random, so a fast unsalted hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetAPIKey reads an API key sent as "Authorization: ApiKey <key>".
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// TokenTypeOAuthState is the short-lived token that carries a login's
+// state and PKCE verifier from the redirect to the provider to the
+// callback.
+const TokenTypeOAuthState TokenType = "tubely-oauth-state"
+
+// OAuthIdentity is the account a provider says signed in.
+type OAuthIdentity struct {
+	Provider string
+	// Subject is the provider's stable ID for the account
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// OAuthProvider signs users in with an OAuth2 provider's accounts.
+type OAuthProvider struct {
+	Name     string
+	config   oauth2.Config
+	identify func(ctx context.Context, client *http.Client) (OAuthIdentity, error)
+}
+
+// NewGoogleProvider signs users in with Google accounts.
+func NewGoogleProvider(clientID, clientSecret, redirectURL string) *OAuthProvider {
+	return &OAuthProvider{
+		Name: "google",
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"openid", "email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://accounts.google.com/o/oauth2/v2/auth",
+				TokenURL:  "https://oauth2.googleapis.com/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		identify: googleIdentity,
+	}
+}
+
+// NewGitHubProvider signs users in with GitHub accounts.
+func NewGitHubProvider(clientID, clientSecret, redirectURL string) *OAuthProvider {
+	return &OAuthProvider{
+		Name: "github",
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"user:email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://github.com/login/oauth/authorize",
+				TokenURL:  "https://github.com/login/oauth/access_token",
+				AuthStyle: oauth2.AuthStyleInHeader,
+			},
+		},
+		identify: githubIdentity,
+	}
+}
+
+// AuthCodeURL is where to send the user to sign in. verifier is the PKCE
+// code verifier the callback will need.
+func (p *OAuthProvider) AuthCodeURL(state, verifier string) string {
+	return p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+// Identify exchanges the code the provider sent back for a token and looks
+// up who it belongs to.
+func (p *OAuthProvider) Identify(ctx context.Context, code, verifier string) (OAuthIdentity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return OAuthIdentity{}, fmt.Errorf("couldn't exchange code: %w", err)
+	}
+	identity, err := p.identify(ctx, p.config.Client(ctx, token))
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	identity.Provider = p.Name
+	return identity, nil
+}
+
+func googleIdentity(ctx context.Context, client *http.Client) (OAuthIdentity, error) {
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &info); err != nil {
+		return OAuthIdentity{}, err
+	}
+	if info.Subject == "" {
+		return OAuthIdentity{}, errors.New("google returned no account ID")
+	}
+	return OAuthIdentity{Subject: info.Subject, Email: info.Email, EmailVerified: info.EmailVerified}, nil
+}
+
+func githubIdentity(ctx context.Context, client *http.Client) (OAuthIdentity, error) {
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return OAuthIdentity{}, err
+	}
+	if user.ID == 0 {
+		return OAuthIdentity{}, errors.New("github returned no account ID")
+	}
+	identity := OAuthIdentity{Subject: strconv.FormatInt(user.ID, 10)}
+
+	// the profile email is optional and unverified, the primary one isn't
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return OAuthIdentity{}, err
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// OAuthState is what a login has to remember between sending the user to
+// the provider and the provider sending them back.
+type OAuthState struct {
+	Provider string
+	// State is sent to the provider and must come back unchanged
+	State string
+	// Verifier is the PKCE code verifier
+	Verifier string
+}
+
+type oauthStateClaims struct {
+	jwt.RegisteredClaims
+	Verifier string `json:"verifier"`
+}
+
+// NewOAuthState starts a login with provider.
+func NewOAuthState(provider string) (OAuthState, error) {
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return OAuthState{}, err
+	}
+	return OAuthState{
+		Provider: provider,
+		State:    hex.EncodeToString(state),
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// MakeOAuthStateToken signs s so it can be kept in a cookie.
+func MakeOAuthStateToken(s OAuthState, tokenSecret string, expiresIn time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, oauthStateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeOAuthState),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   s.Provider,
+			ID:        s.State,
+		},
+		Verifier: s.Verifier,
+	})
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidateOAuthStateToken returns the login state a cookie holds.
+func ValidateOAuthStateToken(tokenString, tokenSecret string) (OAuthState, error) {
+	claims := oauthStateClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
+	if err != nil {
+		return OAuthState{}, err
+	}
+	if claims.Issuer != string(TokenTypeOAuthState) {
+		return OAuthState{}, errors.New("invalid issuer")
+	}
+	return OAuthState{Provider: claims.Subject, State: claims.ID, Verifier: claims.Verifier}, nil
+}
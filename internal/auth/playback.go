@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TokenTypePlayback lets a player stream one video through the playback
+// proxy without a user's access token.
+const TokenTypePlayback TokenType = "tubely-playback"
+
+type playbackClaims struct {
+	jwt.RegisteredClaims
+	// Viewer is who the token was minted for, empty for anonymous viewers
+	Viewer string `json:"viewer,omitempty"`
+}
+
+// MakePlaybackToken mints a token that streams videoID for viewerID until
+// it expires. viewerID is uuid.Nil for anonymous viewers. It can't be used
+// as an access token.
+func MakePlaybackToken(
+	videoID uuid.UUID,
+	viewerID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	claims := playbackClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypePlayback),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   videoID.String(),
+		},
+	}
+	if viewerID != uuid.Nil {
+		claims.Viewer = viewerID.String()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidatePlaybackToken returns the video a playback token streams and the
+// viewer it was minted for.
+func ValidatePlaybackToken(tokenString, tokenSecret string) (videoID, viewerID uuid.UUID, err error) {
+	claims := playbackClaims{}
+	_, err = jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if claims.Issuer != string(TokenTypePlayback) {
+		return uuid.Nil, uuid.Nil, errors.New("invalid issuer")
+	}
+
+	videoID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid video ID: %w", err)
+	}
+	if claims.Viewer != "" {
+		viewerID, err = uuid.Parse(claims.Viewer)
+		if err != nil {
+			return uuid.Nil, uuid.Nil, fmt.Errorf("invalid viewer ID: %w", err)
+		}
+	}
+	return videoID, viewerID, nil
+}
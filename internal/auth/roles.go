@@ -0,0 +1,37 @@
+package auth
+
+import "fmt"
+
+// Role is what an access token's holder may do. Each role can do
+// everything the ones below it can.
+type Role string
+
+const (
+	// RoleAdmin can manage every user and video
+	RoleAdmin Role = "admin"
+	// RoleCreator can upload, edit and delete their own videos
+	RoleCreator Role = "creator"
+	// RoleViewer can only watch and read
+	RoleViewer Role = "viewer"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:  1,
+	RoleCreator: 2,
+	RoleAdmin:   3,
+}
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q, must be viewer, creator or admin", s)
+	}
+	return role, nil
+}
+
+// Includes reports whether r can do what required can.
+func (r Role) Includes(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
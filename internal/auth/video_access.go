@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TokenTypeVideoAccess lets a viewer who knows a video's password see it,
+// without an account.
+const TokenTypeVideoAccess TokenType = "tubely-video-access"
+
+type videoAccessClaims struct {
+	jwt.RegisteredClaims
+	// Key identifies the password the token was minted with
+	Key string `json:"key"`
+}
+
+// MakeVideoAccessToken mints a token that opens videoID until it expires.
+// key identifies the video's password; it's checked against the current
+// one on every use, so changing the password ends the tokens minted before.
+// It can't be used as an access token.
+func MakeVideoAccessToken(videoID uuid.UUID, key, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := videoAccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeVideoAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   videoID.String(),
+		},
+		Key: key,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidateVideoAccessToken returns the video a video access token opens
+// and the key of the password it was minted with.
+func ValidateVideoAccessToken(tokenString, tokenSecret string) (videoID uuid.UUID, key string, err error) {
+	claims := videoAccessClaims{}
+	_, err = jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if claims.Issuer != string(TokenTypeVideoAccess) {
+		return uuid.Nil, "", errors.New("invalid issuer")
+	}
+	videoID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("invalid video ID: %w", err)
+	}
+	return videoID, claims.Key, nil
+}
@@ -0,0 +1,639 @@
+// Package blobstore provides an in-memory stand-in for S3. It speaks the
+// subset of the S3 REST API Tubely uses (path-style object reads, writes,
+// copies, listings and multipart uploads), so the AWS SDK can be pointed at it and
+// the rest of the server runs unchanged without an AWS account.
+package blobstore
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data         []byte
+	contentType  string
+	sha256       []byte
+	lastModified time.Time
+	// storageClass is "" for STANDARD
+	storageClass string
+	// restored is set once an archived object is restored, which here
+	// happens at once
+	restored bool
+}
+
+// readable reports whether the object can be read: archived ones have to
+// be restored first.
+func (o *object) readable() bool {
+	return !archival(o.storageClass) || o.restored
+}
+
+func archival(storageClass string) bool {
+	return storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE"
+}
+
+type upload struct {
+	bucket       string
+	key          string
+	contentType  string
+	storageClass string
+	parts        map[int][]byte
+}
+
+// Memory holds objects in memory for the life of the process. It is an
+// http.Handler for the S3 API.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]*object
+	uploads map[string]*upload
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		objects: map[string]*object{},
+		uploads: map[string]*upload{},
+	}
+}
+
+// Transport returns a RoundTripper that hands requests straight to h,
+// e.g. a Memory, so an SDK client can use it without a network listener.
+func Transport(h http.Handler) http.RoundTripper {
+	return roundTripper{h}
+}
+
+type roundTripper struct {
+	h http.Handler
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	rec := &recorder{header: http.Header{}, status: http.StatusOK}
+	rt.h.ServeHTTP(rec, req)
+	req.Body.Close()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// recorder collects a response in memory for roundTripper.
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status = status
+	r.wroteHeader = true
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// ServeHTTP handles path-style S3 requests, /{bucket}/{key}.
+func (m *Memory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeError(w, r, http.StatusBadRequest, "InvalidBucketName", "bucket is required")
+		return
+	}
+	query := r.URL.Query()
+	if key == "" {
+		// buckets have no settings here, answer like one that never had them set
+		if query.Has("ownershipControls") {
+			writeError(w, r, http.StatusNotFound, "OwnershipControlsNotFoundError", "the bucket ownership controls were not found")
+			return
+		}
+		// every bucket exists
+		if r.Method == http.MethodHead && len(query) == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method == http.MethodGet && query.Get("list-type") == "2" {
+			m.listObjects(w, r, bucket)
+			return
+		}
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "bucket operations aren't supported")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("restore"):
+		m.restoreObject(w, r, bucket, key)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		m.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		m.completeMultipartUpload(w, r, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		m.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		m.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		m.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		m.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		m.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		m.mu.Lock()
+		delete(m.objects, bucket+"/"+key)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "the method isn't supported")
+	}
+}
+
+func (m *Memory) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := sha256.Sum256(data)
+	if want := r.Header.Get("X-Amz-Checksum-Sha256"); want != "" && want != base64.StdEncoding.EncodeToString(sum[:]) {
+		writeError(w, r, http.StatusBadRequest, "BadDigest", "the SHA256 you specified did not match the calculated checksum")
+		return
+	}
+	obj := m.store(bucket, key, data, r.Header.Get("Content-Type"), r.Header.Get("X-Amz-Storage-Class"))
+	w.Header().Set("ETag", etag(obj.data))
+	w.Header().Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(obj.sha256))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *Memory) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	src, ok := m.copySource(r)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the copy source does not exist")
+		return
+	}
+	if !src.readable() {
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "the source object is archived")
+		return
+	}
+	contentType := src.contentType
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		contentType = r.Header.Get("Content-Type")
+	}
+	obj := m.store(bucket, key, src.data, contentType, r.Header.Get("X-Amz-Storage-Class"))
+	writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: etag(obj.data), LastModified: obj.lastModified.Format(time.RFC3339)})
+}
+
+func (m *Memory) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	m.mu.RLock()
+	obj, ok := m.objects[bucket+"/"+key]
+	m.mu.RUnlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+
+	if r.Method == http.MethodGet && !obj.readable() {
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "the object is archived")
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", obj.contentType)
+	h.Set("ETag", etag(obj.data))
+	h.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if obj.storageClass != "" {
+		h.Set("X-Amz-Storage-Class", obj.storageClass)
+	}
+	if obj.restored {
+		h.Set("X-Amz-Restore", `ongoing-request="false", expiry-date="`+time.Now().Add(24*time.Hour).UTC().Format(http.TimeFormat)+`"`)
+	}
+
+	body := obj.data
+	status := http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" {
+		start, end, ok := parseRange(spec, int64(len(obj.data)))
+		if !ok {
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the requested range is not satisfiable")
+			return
+		}
+		body = obj.data[start : end+1]
+		status = http.StatusPartialContent
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+	} else if r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+		// like S3, checksums only come with whole objects
+		h.Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(obj.sha256))
+		h.Set("X-Amz-Checksum-Type", "FULL_OBJECT")
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+type listedObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+// listObjects answers ListObjectsV2 in key order. The continuation token
+// is the last key of the previous page.
+func (m *Memory) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+	maxKeys := 1000
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+
+	m.mu.RLock()
+	keys := []string{}
+	for name := range m.objects {
+		objBucket, key, _ := strings.Cut(name, "/")
+		if objBucket == bucket && strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > maxKeys
+	if truncated {
+		keys = keys[:maxKeys]
+	}
+	contents := make([]listedObject, 0, len(keys))
+	for _, key := range keys {
+		obj := m.objects[bucket+"/"+key]
+		contents = append(contents, listedObject{
+			Key:          key,
+			LastModified: obj.lastModified.Format(time.RFC3339),
+			ETag:         etag(obj.data),
+			Size:         len(obj.data),
+			StorageClass: cmp.Or(obj.storageClass, "STANDARD"),
+		})
+	}
+	m.mu.RUnlock()
+
+	next := ""
+	if truncated {
+		next = keys[len(keys)-1]
+	}
+	writeXML(w, http.StatusOK, struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []listedObject
+	}{
+		Name:                  bucket,
+		Prefix:                prefix,
+		KeyCount:              len(contents),
+		MaxKeys:               maxKeys,
+		IsTruncated:           truncated,
+		ContinuationToken:     query.Get("continuation-token"),
+		NextContinuationToken: next,
+		Contents:              contents,
+	})
+}
+
+func (m *Memory) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	m.mu.Lock()
+	m.uploads[id] = &upload{
+		bucket:       bucket,
+		key:          key,
+		contentType:  r.Header.Get("Content-Type"),
+		storageClass: r.Header.Get("X-Amz-Storage-Class"),
+		parts:        map[int][]byte{},
+	}
+	m.mu.Unlock()
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (m *Memory) uploadPart(w http.ResponseWriter, r *http.Request, uploadID, partNumber string) {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+
+	var data []byte
+	copying := r.Header.Get("X-Amz-Copy-Source") != ""
+	if copying {
+		src, ok := m.copySource(r)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", "the copy source does not exist")
+			return
+		}
+		if !src.readable() {
+			writeError(w, r, http.StatusForbidden, "InvalidObjectState", "the source object is archived")
+			return
+		}
+		data = src.data
+		if spec := r.Header.Get("X-Amz-Copy-Source-Range"); spec != "" {
+			start, end, ok := parseRange(spec, int64(len(src.data)))
+			if !ok {
+				writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid copy source range")
+				return
+			}
+			data = src.data[start : end+1]
+		}
+	} else {
+		data, err = readBody(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+	}
+
+	m.mu.Lock()
+	u, ok := m.uploads[uploadID]
+	if ok {
+		u.parts[number] = bytes.Clone(data)
+	}
+	m.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+		return
+	}
+
+	if copying {
+		writeXML(w, http.StatusOK, struct {
+			XMLName      xml.Name `xml:"CopyPartResult"`
+			ETag         string
+			LastModified string
+		}{ETag: etag(data), LastModified: time.Now().UTC().Format(time.RFC3339)})
+		return
+	}
+	w.Header().Set("ETag", etag(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *Memory) completeMultipartUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	var req struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	m.mu.Lock()
+	u, ok := m.uploads[uploadID]
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+		return
+	}
+
+	numbers := []int{}
+	for _, part := range req.Parts {
+		numbers = append(numbers, part.PartNumber)
+	}
+	sort.Ints(numbers)
+	var data []byte
+	for _, number := range numbers {
+		part, ok := u.parts[number]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was never uploaded", number))
+			return
+		}
+		data = append(data, part...)
+	}
+	obj := m.store(u.bucket, u.key, data, u.contentType, u.storageClass)
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: u.bucket, Key: u.key, ETag: etag(obj.data)})
+}
+
+func (m *Memory) abortMultipartUpload(w http.ResponseWriter, uploadID string) {
+	m.mu.Lock()
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Memory) store(bucket, key string, data []byte, contentType, storageClass string) *object {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if storageClass == "STANDARD" {
+		storageClass = ""
+	}
+	sum := sha256.Sum256(data)
+	obj := &object{
+		data:         bytes.Clone(data),
+		contentType:  contentType,
+		sha256:       sum[:],
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		storageClass: storageClass,
+	}
+	m.mu.Lock()
+	m.objects[bucket+"/"+key] = obj
+	m.mu.Unlock()
+	return obj
+}
+
+// restoreObject restores an archived object, at once rather than after the
+// hours S3 takes.
+func (m *Memory) restoreObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	m.mu.Lock()
+	obj, ok := m.objects[bucket+"/"+key]
+	archived := ok && archival(obj.storageClass)
+	already := archived && obj.restored
+	if archived {
+		obj.restored = true
+	}
+	m.mu.Unlock()
+	switch {
+	case !ok:
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+	case !archived:
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "the object isn't archived")
+	case already:
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// copySource looks up the object named by the X-Amz-Copy-Source header,
+// "bucket/key" with the key URL-escaped.
+func (m *Memory) copySource(r *http.Request) (*object, bool) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[source]
+	return obj, ok
+}
+
+// readBody reads a request body, undoing the aws-chunked encoding the SDK
+// uses when it sends a trailing checksum.
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") &&
+		!strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked body: %w", err)
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			// trailers follow, nothing else to keep
+			return data, nil
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked body: %w", err)
+		}
+		data = append(data, chunk...)
+		if _, err := br.Discard(2); err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked body: %w", err)
+		}
+	}
+}
+
+// parseRange parses a single "bytes=start-end" range, as sent by the SDK.
+func parseRange(spec string, size int64) (int64, int64, bool) {
+	startStr, endStr, ok := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if startStr == "" {
+		// a suffix range, the last n bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(0, size-n), size - 1, size > 0
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// writeError sends an S3 style error. HEAD responses carry no body, so
+// the SDK only sees the status there.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
+
+// ReadOnly exposes only GET and HEAD of h, e.g. to let browsers play
+// stored videos without being able to change them.
+func ReadOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "the store is read-only here")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+// Package blurhash encodes images as BlurHash strings: a few dozen
+// characters a client decodes into a blurred placeholder to show while the
+// real image loads. See https://blurha.sh for the format.
+package blurhash
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strings"
+)
+
+// maxSamples is how many pixels are read along each side of an image.
+// The hash only keeps a handful of frequencies, so reading every pixel of
+// a large image would change nothing but the time taken.
+const maxSamples = 64
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Encode returns the BlurHash of img with xComponents by yComponents
+// frequencies, each between 1 and 9. More components keep more detail in
+// a longer hash.
+func Encode(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", errors.New("blurhash: components must be between 1 and 9")
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return "", errors.New("blurhash: image is empty")
+	}
+	pixels := sample(img)
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := range yComponents {
+		for i := range xComponents {
+			factors = append(factors, component(pixels, i, j))
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMax := int(max(0, min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		hash.WriteString(encode83(encodeAC(f, maxValue), 2))
+	}
+	return hash.String(), nil
+}
+
+// sample reads up to maxSamples by maxSamples pixels of img, evenly spread,
+// as linear RGB.
+func sample(img image.Image) [][][3]float64 {
+	bounds := img.Bounds()
+	width, height := min(bounds.Dx(), maxSamples), min(bounds.Dy(), maxSamples)
+	pixels := make([][][3]float64, height)
+	for y := range height {
+		pixels[y] = make([][3]float64, width)
+		sy := bounds.Min.Y + y*bounds.Dy()/height
+		for x := range width {
+			sx := bounds.Min.X + x*bounds.Dx()/width
+			r, g, b, _ := img.At(sx, sy).RGBA()
+			pixels[y][x] = [3]float64{sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8)}
+		}
+	}
+	return pixels
+}
+
+// component is the weight of the cosine with i horizontal and j vertical
+// half periods in each channel of pixels.
+func component(pixels [][][3]float64, i, j int) [3]float64 {
+	height, width := len(pixels), len(pixels[0])
+	var sum [3]float64
+	for y, row := range pixels {
+		for x, pixel := range row {
+			basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+				math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+			sum[0] += basis * pixel[0]
+			sum[1] += basis * pixel[1]
+			sum[2] += basis * pixel[2]
+		}
+	}
+	normalisation := 2.0
+	if i == 0 && j == 0 {
+		normalisation = 1
+	}
+	scale := normalisation / float64(width*height)
+	return [3]float64{sum[0] * scale, sum[1] * scale, sum[2] * scale}
+}
+
+func encodeAC(f [3]float64, maxValue float64) int {
+	quantise := func(v float64) int {
+		return int(max(0, min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+	}
+	return quantise(f[0])*19*19 + quantise(f[1])*19 + quantise(f[2])
+}
+
+func encode83(value, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+func sRGBToLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := max(0, min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
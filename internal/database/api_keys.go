@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey lets a user's scripts and CI pipelines upload without logging in.
+// Only a hash of the key is stored; Prefix is kept so users can tell their
+// keys apart.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type CreateAPIKeyParams struct {
+	UserID  uuid.UUID
+	Name    string
+	Prefix  string
+	KeyHash string
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		last_used_at,
+		revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Name, params.Prefix, params.KeyHash)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return c.GetAPIKey(id)
+}
+
+// GetAPIKey returns the zero APIKey if there's no such key.
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, nil
+	}
+	return key, err
+}
+
+// GetAPIKeyByHash returns the unrevoked key with the given hash, or nil.
+func (c Client) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ? AND revoked_at IS NULL
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeys returns a user's keys, revoked ones included, oldest first.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey stops a key working. Revoked keys stay listed so their
+// owner can see when they were last used.
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec(`
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`, id)
+	return err
+}
+
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ArchiveArchived is a video whose file was moved to archival storage,
+	// where it can't be read until it's restored
+	ArchiveArchived = "archived"
+	// ArchiveRestoring is an archived video whose file is being restored
+	ArchiveRestoring = "restoring"
+)
+
+// GetVideosToArchive returns up to limit videos, after afterID in ID
+// order, whose file is in regular storage, no older than idleSince, and
+// that weren't viewed or restored since.
+func (c Client) GetVideosToArchive(idleSince time.Time, afterID uuid.UUID, limit int) ([]Video, error) {
+	cutoff := idleSince.UTC().Format(viewTimeFormat)
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE archive_status = '' AND video_url IS NOT NULL AND id > ?
+		AND (restored_at IS NULL OR restored_at < ?)
+		AND NOT EXISTS (SELECT 1 FROM renditions WHERE video_id = videos.id AND created_at >= ?)
+		AND NOT EXISTS (SELECT 1 FROM video_views WHERE video_id = videos.id AND created_at >= ?)
+	ORDER BY id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, afterID, cutoff, cutoff, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// GetRestoringVideos returns up to limit videos whose file is being
+// restored, those archived longest first.
+func (c Client) GetRestoringVideos(limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE archive_status = ?
+	ORDER BY archived_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, ArchiveRestoring, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// ArchiveVideo marks the video's file as archived, if videoURL is still
+// its file. Otherwise it returns ErrVideoFileChanged.
+func (c Client) ArchiveVideo(videoID uuid.UUID, videoURL string) error {
+	result, err := c.db.Exec(`
+	UPDATE videos
+	SET archive_status = ?, archived_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND video_url = ?
+	`, ArchiveArchived, videoID, videoURL)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoFileChanged
+	}
+	return nil
+}
+
+// SetArchiveRestoring marks an archived video's file as being restored,
+// reporting whether it was archived.
+func (c Client) SetArchiveRestoring(videoID uuid.UUID) (bool, error) {
+	result, err := c.db.Exec(
+		`UPDATE videos SET archive_status = ? WHERE id = ? AND archive_status = ?`,
+		ArchiveRestoring, videoID, ArchiveArchived,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UnarchiveVideo marks the video's file as back in regular storage,
+// returning sql.ErrNoRows if the video is gone. The restore counts as
+// activity, so the video isn't archived again before it's been idle anew.
+func (c Client) UnarchiveVideo(videoID uuid.UUID) error {
+	result, err := c.db.Exec(
+		`UPDATE videos SET archive_status = '', archived_at = NULL, restored_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		videoID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ObjectURLSharedWith reports whether a video other than videoID, or one
+// of its renditions or kept versions, points at url.
+func (c Client) ObjectURLSharedWith(url string, videoID uuid.UUID) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM videos WHERE video_url = ? AND id <> ?)
+		OR EXISTS (SELECT 1 FROM renditions WHERE video_url = ? AND video_id <> ?)
+		OR EXISTS (SELECT 1 FROM video_versions WHERE video_url = ? AND video_id <> ?)
+	`
+	var shared bool
+	err := c.db.QueryRow(query, url, videoID, url, videoID, url, videoID).Scan(&shared)
+	return shared, err
+}
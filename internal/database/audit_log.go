@@ -0,0 +1,254 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// AuditImpersonationStarted is logged when an admin is issued a token
+	// to act as a user
+	AuditImpersonationStarted = "impersonation.started"
+	// AuditImpersonatedRequest is logged for every request made with one
+	AuditImpersonatedRequest = "impersonation.request"
+	// AuditVideoDeleted is logged when an admin deletes someone's video
+	AuditVideoDeleted = "admin.video_deleted"
+	// AuditRoleChanged is logged when an admin changes a user's role
+	AuditRoleChanged = "admin.role_changed"
+	// AuditOrphanDeleted is logged when an admin deletes an object no
+	// video refers to
+	AuditOrphanDeleted = "admin.orphan_deleted"
+	// AuditJobRequeued is logged when an admin requeues a job that failed
+	// every attempt, with the job in the detail
+	AuditJobRequeued = "admin.job_requeued"
+	// AuditDeadLetterDiscarded is logged when an admin gives up on a job
+	// that failed every attempt, with the job in the detail
+	AuditDeadLetterDiscarded = "admin.dead_letter_discarded"
+
+	// AuditVideoUploaded is logged when a video's first file is stored
+	AuditVideoUploaded = "video.uploaded"
+	// AuditVideoReplaced is logged when a video's file is uploaded again
+	AuditVideoReplaced = "video.replaced"
+	// AuditVideoRemoved is logged when an owner deletes their video
+	AuditVideoRemoved = "video.deleted"
+	// AuditVersionRestored is logged when a video is rolled back to an
+	// earlier version of its file
+	AuditVersionRestored = "video.version_restored"
+	// AuditThumbnailUploaded is logged when a video's thumbnail is stored
+	AuditThumbnailUploaded = "video.thumbnail_uploaded"
+	// AuditCaptionUploaded is logged when a video's captions in a language
+	// are stored
+	AuditCaptionUploaded = "video.caption_uploaded"
+	// AuditCaptionDeleted is logged when a video's captions are removed
+	AuditCaptionDeleted = "video.caption_deleted"
+	// AuditChaptersUpdated is logged when an owner sets a video's chapters
+	AuditChaptersUpdated = "video.chapters_updated"
+	// AuditClipCreated is logged when a clip is cut from a video, on the
+	// clip, with the source video in the detail
+	AuditClipCreated = "video.clip_created"
+	// AuditModerationReviewed is logged when an admin approves or rejects
+	// a video moderation held, with the decision in the detail
+	AuditModerationReviewed = "video.moderation_reviewed"
+	// AuditURLIngestStarted is logged when an owner asks for a video's
+	// file to be fetched from a URL, with the URL, minus its query, in the
+	// detail
+	AuditURLIngestStarted = "video.url_ingest_started"
+	// AuditUploadQueued is logged when an upload is staged for a worker
+	// to process, with the staged object's key and the file's name
+	AuditUploadQueued = "video.upload_queued"
+	// AuditVisibilityChanged is logged when a video's visibility changes
+	AuditVisibilityChanged = "video.visibility_changed"
+	// AuditPasswordChanged is logged when a video's password is set or
+	// removed, which the detail says
+	AuditPasswordChanged = "video.password_changed"
+	// AuditShareCreated is logged when a share link to a video is made
+	AuditShareCreated = "video.share_created"
+	// AuditMemberAdded is logged when a user is added to an organization
+	AuditMemberAdded = "organization.member_added"
+	// AuditMemberRemoved is logged when a user leaves an organization
+	AuditMemberRemoved = "organization.member_removed"
+	// AuditAPIKeyCreated is logged when a user creates an API key
+	AuditAPIKeyCreated = "api_key.created"
+	// AuditAPIKeyRevoked is logged when a user revokes an API key
+	AuditAPIKeyRevoked = "api_key.revoked"
+)
+
+// auditLogGuards make audit_log append-only: entries can't be changed or
+// removed, except by Reset.
+const auditLogGuards = `
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+`
+
+// AuditEntry records an action taken by ActorID on behalf of UserID.
+type AuditEntry struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ActorID    uuid.UUID `json:"actor_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Action     string    `json:"action"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	VideoID    string    `json:"video_id,omitempty"`
+	ObjectKey  string    `json:"object_key,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+type CreateAuditEntryParams struct {
+	ActorID    uuid.UUID
+	UserID     uuid.UUID
+	Action     string
+	Method     string
+	Path       string
+	StatusCode int
+	RequestID  string
+	IP         string
+	VideoID    uuid.UUID
+	ObjectKey  string
+	Detail     string
+}
+
+func (c Client) CreateAuditEntry(params CreateAuditEntryParams) error {
+	query := `
+	INSERT INTO audit_log (
+		id,
+		created_at,
+		actor_id,
+		user_id,
+		action,
+		method,
+		path,
+		status_code,
+		request_id,
+		ip,
+		video_id,
+		object_key,
+		detail
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	videoID := ""
+	if params.VideoID != uuid.Nil {
+		videoID = params.VideoID.String()
+	}
+	_, err := c.db.Exec(query,
+		uuid.New(),
+		params.ActorID,
+		params.UserID,
+		params.Action,
+		params.Method,
+		params.Path,
+		params.StatusCode,
+		params.RequestID,
+		params.IP,
+		videoID,
+		params.ObjectKey,
+		params.Detail,
+	)
+	return err
+}
+
+// AuditLogFilter narrows GetAuditEntries, zero fields match everything.
+type AuditLogFilter struct {
+	ActorID uuid.UUID
+	UserID  uuid.UUID
+	VideoID uuid.UUID
+	Action  string
+	// Since and Until bound created_at, Until exclusively
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// GetAuditEntries returns the newest matching entries first.
+func (c Client) GetAuditEntries(filter AuditLogFilter) ([]AuditEntry, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		actor_id,
+		user_id,
+		action,
+		method,
+		path,
+		status_code,
+		request_id,
+		ip,
+		video_id,
+		object_key,
+		detail
+	FROM audit_log
+	WHERE (? = '' OR actor_id = ?)
+	  AND (? = '' OR user_id = ?)
+	  AND (? = '' OR video_id = ?)
+	  AND (? = '' OR action = ?)`
+	actor, user, video := "", "", ""
+	if filter.ActorID != uuid.Nil {
+		actor = filter.ActorID.String()
+	}
+	if filter.UserID != uuid.Nil {
+		user = filter.UserID.String()
+	}
+	if filter.VideoID != uuid.Nil {
+		video = filter.VideoID.String()
+	}
+	args := []any{
+		actor, actor,
+		user, user,
+		video, video,
+		filter.Action, filter.Action,
+	}
+	if !filter.Since.IsZero() {
+		query += `
+	  AND created_at >= ?`
+		args = append(args, filter.Since.UTC().Format(viewTimeFormat))
+	}
+	if !filter.Until.IsZero() {
+		query += `
+	  AND created_at < ?`
+		args = append(args, filter.Until.UTC().Format(viewTimeFormat))
+	}
+	query += `
+	ORDER BY created_at DESC, rowid DESC
+	LIMIT ?
+	`
+	args = append(args, filter.Limit)
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.UserID,
+			&entry.Action,
+			&entry.Method,
+			&entry.Path,
+			&entry.StatusCode,
+			&entry.RequestID,
+			&entry.IP,
+			&entry.VideoID,
+			&entry.ObjectKey,
+			&entry.Detail,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// CaptionSourceUpload captions were uploaded by the video's owner
+	CaptionSourceUpload = "upload"
+	// CaptionSourceTranscription captions were generated by transcribing
+	// the video's audio
+	CaptionSourceTranscription = "transcription"
+)
+
+// Caption is a WebVTT caption or subtitle track of a video, one per
+// language.
+type Caption struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	Language string    `json:"language"`
+	// Label is what players show in their track menu
+	Label     string    `json:"label"`
+	URL       string    `json:"url"`
+	Size      int64     `json:"size"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommitCaption creates or replaces the video's caption in its language
+// and forgets that the object at key was pending, in one transaction. It
+// returns the URL of the caption it replaced, "" if there was none, and
+// sql.ErrNoRows if the video is gone.
+func (c Client) CommitCaption(caption Caption, key string) (string, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	replaced, err := upsertCaption(tx, caption)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, key); err != nil {
+		return "", err
+	}
+	return replaced, tx.Commit()
+}
+
+// upsertCaption creates or replaces the video's caption in its language,
+// returning the URL of the one it replaced, and sql.ErrNoRows if the video
+// is gone.
+func upsertCaption(tx *transaction, caption Caption) (string, error) {
+	var replaced string
+	err := tx.QueryRow(`SELECT caption_url FROM captions WHERE video_id = ? AND language = ?`, caption.VideoID, caption.Language).Scan(&replaced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	result, err := tx.Exec(`
+	INSERT INTO captions (video_id, language, label, caption_url, size, source, created_at)
+	SELECT id, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP
+	FROM videos
+	WHERE id = ?
+	ON CONFLICT (video_id, language) DO UPDATE SET
+		label = excluded.label,
+		caption_url = excluded.caption_url,
+		size = excluded.size,
+		source = excluded.source,
+		created_at = excluded.created_at
+	`, caption.Language, caption.Label, caption.URL, caption.Size, caption.Source, caption.VideoID)
+	if err != nil {
+		return "", err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return replaced, nil
+}
+
+// DeleteCaption forgets the video's caption in a language and returns its
+// URL, "" if there was none. The object is the caller's to release.
+func (c Client) DeleteCaption(videoID uuid.UUID, language string) (string, error) {
+	var url string
+	err := c.db.QueryRow(`
+	DELETE FROM captions
+	WHERE video_id = ? AND language = ?
+	RETURNING caption_url
+	`, videoID, language).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return url, err
+}
+
+func (c Client) GetCaptions(videoID uuid.UUID) ([]Caption, error) {
+	byVideo, err := c.GetCaptionsForVideos([]uuid.UUID{videoID})
+	if err != nil {
+		return nil, err
+	}
+	if byVideo[videoID] == nil {
+		return []Caption{}, nil
+	}
+	return byVideo[videoID], nil
+}
+
+// GetCaptionsForVideos loads the captions of several videos at once, keyed
+// by video ID.
+func (c Client) GetCaptionsForVideos(videoIDs []uuid.UUID) (map[uuid.UUID][]Caption, error) {
+	byVideo := map[uuid.UUID][]Caption{}
+	if len(videoIDs) == 0 {
+		return byVideo, nil
+	}
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT video_id, language, label, caption_url, size, source, created_at
+	FROM captions
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	ORDER BY video_id, language
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var caption Caption
+		if err := rows.Scan(&caption.VideoID, &caption.Language, &caption.Label, &caption.URL, &caption.Size, &caption.Source, &caption.CreatedAt); err != nil {
+			return nil, err
+		}
+		byVideo[caption.VideoID] = append(byVideo[caption.VideoID], caption)
+	}
+	return byVideo, rows.Err()
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Chapter marks where a titled section of a video starts.
+type Chapter struct {
+	// Start is in seconds from the start of the video
+	Start float64 `json:"start"`
+	Title string  `json:"title"`
+}
+
+// SetChapters replaces the video's chapters, returning sql.ErrNoRows if
+// the video is gone. An empty list removes them.
+func (c Client) SetChapters(videoID uuid.UUID, chapters []Chapter) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM videos WHERE id = ?)`, videoID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM chapters WHERE video_id = ?`, videoID); err != nil {
+		return err
+	}
+	if err := insertChapters(tx, videoID, chapters); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetChaptersIfNone gives the video chapters unless it has some already,
+// reporting whether it did. Chapters read from a file don't override the
+// ones its owner set.
+func (c Client) SetChaptersIfNone(videoID uuid.UUID, chapters []Chapter) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chapters WHERE video_id = ?)`, videoID).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	if err := insertChapters(tx, videoID, chapters); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func insertChapters(tx *transaction, videoID uuid.UUID, chapters []Chapter) error {
+	for _, chapter := range chapters {
+		_, err := tx.Exec(`
+		INSERT INTO chapters (video_id, start_time, title)
+		VALUES (?, ?, ?)
+		`, videoID, chapter.Start, chapter.Title)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Client) GetChapters(videoID uuid.UUID) ([]Chapter, error) {
+	byVideo, err := c.GetChaptersForVideos([]uuid.UUID{videoID})
+	if err != nil {
+		return nil, err
+	}
+	if byVideo[videoID] == nil {
+		return []Chapter{}, nil
+	}
+	return byVideo[videoID], nil
+}
+
+// GetChaptersForVideos loads the chapters of several videos at once, in
+// order and keyed by video ID.
+func (c Client) GetChaptersForVideos(videoIDs []uuid.UUID) (map[uuid.UUID][]Chapter, error) {
+	byVideo := map[uuid.UUID][]Chapter{}
+	if len(videoIDs) == 0 {
+		return byVideo, nil
+	}
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT video_id, start_time, title
+	FROM chapters
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	ORDER BY video_id, start_time
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var videoID uuid.UUID
+		var chapter Chapter
+		if err := rows.Scan(&videoID, &chapter.Start, &chapter.Title); err != nil {
+			return nil, err
+		}
+		byVideo[videoID] = append(byVideo[videoID], chapter)
+	}
+	return byVideo, rows.Err()
+}
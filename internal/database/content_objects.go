@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ContentObject records an uploaded source file by content hash so a later
+// upload of the same bytes by the same user can reuse the stored object.
+// Entries are per user so knowing a hash never grants access to someone
+// else's upload. Bucket is "" for objects in S3_BUCKET.
+type ContentObject struct {
+	CreatedAt time.Time `json:"created_at"`
+	CreateContentObjectParams
+}
+
+type CreateContentObjectParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	Bucket      string    `json:"bucket"`
+	ObjectKey   string    `json:"object_key"`
+	ContentType string    `json:"content_type"`
+	Projection  *string   `json:"projection"`
+	FrameRate   float64   `json:"frame_rate"`
+}
+
+func (c Client) SaveContentObject(params CreateContentObjectParams) error {
+	query := `
+	INSERT INTO content_objects (
+		user_id,
+		sha256,
+		size,
+		created_at,
+		bucket,
+		object_key,
+		content_type,
+		projection,
+		frame_rate
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	ON CONFLICT (user_id, sha256, size) DO UPDATE SET
+		created_at = excluded.created_at,
+		bucket = excluded.bucket,
+		object_key = excluded.object_key,
+		content_type = excluded.content_type,
+		projection = excluded.projection,
+		frame_rate = excluded.frame_rate
+	`
+	_, err := c.db.Exec(
+		query,
+		params.UserID,
+		params.SHA256,
+		params.Size,
+		params.Bucket,
+		params.ObjectKey,
+		params.ContentType,
+		params.Projection,
+		params.FrameRate,
+	)
+	return err
+}
+
+// GetContentObject returns the matching entry, or nil if there is none.
+func (c Client) GetContentObject(userID uuid.UUID, sha256 string, size int64) (*ContentObject, error) {
+	query := `
+	SELECT
+		user_id,
+		sha256,
+		size,
+		created_at,
+		bucket,
+		object_key,
+		content_type,
+		projection,
+		frame_rate
+	FROM content_objects
+	WHERE user_id = ? AND sha256 = ? AND size = ?
+	`
+	var obj ContentObject
+	err := c.db.QueryRow(query, userID, sha256, size).Scan(
+		&obj.UserID,
+		&obj.SHA256,
+		&obj.Size,
+		&obj.CreatedAt,
+		&obj.Bucket,
+		&obj.ObjectKey,
+		&obj.ContentType,
+		&obj.Projection,
+		&obj.FrameRate,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (c Client) DeleteContentObjectsByKey(objectKey string) error {
+	query := `
+	DELETE FROM content_objects
+	WHERE object_key = ?
+	`
+	_, err := c.db.Exec(query, objectKey)
+	return err
+}
+
+// ObjectURLInUse reports whether any video, rendition, kept version or caption
+// still points at url.
+func (c Client) ObjectURLInUse(url string) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM videos WHERE video_url = ?)
+		OR EXISTS (SELECT 1 FROM renditions WHERE video_url = ?)
+		OR EXISTS (SELECT 1 FROM video_versions WHERE video_url = ?)
+		OR EXISTS (SELECT 1 FROM captions WHERE caption_url = ?)
+	`
+	var inUse bool
+	err := c.db.QueryRow(query, url, url, url, url).Scan(&inUse)
+	return inUse, err
+}
+
+// GetObjectURLsInUse returns every URL a video, rendition, kept version or caption
+// points at, the URLs ObjectURLInUse reports as in use.
+func (c Client) GetObjectURLsInUse() ([]string, error) {
+	rows, err := c.db.Query(`
+	SELECT video_url FROM videos WHERE video_url IS NOT NULL
+	UNION
+	SELECT video_url FROM renditions
+	UNION
+	SELECT video_url FROM video_versions
+	UNION
+	SELECT caption_url FROM captions
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []string{}
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
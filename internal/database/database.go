@@ -8,7 +8,9 @@ import (
 )
 
 type Client struct {
-	db *sql.DB
+	db conn
+	// fts is true when the SQLite build includes FTS5 (build tag sqlite_fts5)
+	fts bool
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,8 +18,14 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	c := Client{db: conn{DB: db, dialect: sqliteDialect{}}}
+	err = c.migrate()
+	if err != nil {
+		return Client{}, err
+	}
+	// full-text search depends on how SQLite was built, not on the schema
+	// version, so it's set up on every start
+	err = c.migrateSearch()
 	if err != nil {
 		return Client{}, err
 	}
@@ -25,7 +33,10 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
-func (c *Client) autoMigrate() error {
+// migrateBaseline builds the schema as it was before migrations were
+// versioned. Databases from back then may have any part of it already, so
+// every step checks first.
+func migrateBaseline(tx *transaction) error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
 		id TEXT PRIMARY KEY,
@@ -35,7 +46,7 @@ func (c *Client) autoMigrate() error {
 		email TEXT UNIQUE NOT NULL
 	);
 	`
-	_, err := c.db.Exec(userTable)
+	_, err := tx.Exec(userTable)
 	if err != nil {
 		return err
 	}
@@ -50,7 +61,7 @@ func (c *Client) autoMigrate() error {
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
-	_, err = c.db.Exec(refreshTokenTable)
+	_, err = tx.Exec(refreshTokenTable)
 	if err != nil {
 		return err
 	}
@@ -68,20 +79,538 @@ func (c *Client) autoMigrate() error {
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
-	_, err = c.db.Exec(videoTable)
+	_, err = tx.Exec(videoTable)
+	if err != nil {
+		return err
+	}
+
+	renditionTable := `
+	CREATE TABLE IF NOT EXISTS renditions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		video_url TEXT NOT NULL,
+		frame_rate REAL NOT NULL,
+		source_frame_rate REAL NOT NULL,
+		frame_rate_mode TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = tx.Exec(renditionTable)
+	if err != nil {
+		return err
+	}
+
+	videoTagTable := `
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, tag),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_tags_tag ON video_tags(tag);
+	`
+	_, err = tx.Exec(videoTagTable)
 	if err != nil {
 		return err
 	}
+
+	videoViewTable := `
+	CREATE TABLE IF NOT EXISTS video_views (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		viewer_key TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_views_viewer ON video_views(video_id, viewer_key, created_at);
+	CREATE INDEX IF NOT EXISTS video_views_video ON video_views(video_id, created_at);
+	`
+	_, err = tx.Exec(videoViewTable)
+	if err != nil {
+		return err
+	}
+
+	videoTrafficTable := `
+	CREATE TABLE IF NOT EXISTS video_traffic (
+		video_id TEXT NOT NULL,
+		hour TIMESTAMP NOT NULL,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY(video_id, hour),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = tx.Exec(videoTrafficTable)
+	if err != nil {
+		return err
+	}
+
+	contentObjectTable := `
+	CREATE TABLE IF NOT EXISTS content_objects (
+		user_id TEXT NOT NULL,
+		sha256 TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		object_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		projection TEXT,
+		frame_rate REAL NOT NULL,
+		PRIMARY KEY(user_id, sha256, size),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = tx.Exec(contentObjectTable)
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		user_id TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = tx.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	integrationTable := `
+	CREATE TABLE IF NOT EXISTS integrations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		webhook_url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		template TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = tx.Exec(integrationTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = tx.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	identityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		PRIMARY KEY(provider, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = tx.Exec(identityTable)
+	if err != nil {
+		return err
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = tx.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	idempotencyTable := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_path TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		response_body BLOB,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(user_id, key),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = tx.Exec(idempotencyTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS organization_members (
+		user_id TEXT PRIMARY KEY,
+		organization_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);
+	CREATE TABLE IF NOT EXISTS organization_upload_policies (
+		organization_id TEXT PRIMARY KEY,
+		visibility TEXT NOT NULL DEFAULT '',
+		profile TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '',
+		retention_days INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);
+	`
+	_, err = tx.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	localizationTable := `
+	CREATE TABLE IF NOT EXISTS video_localizations (
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = tx.Exec(localizationTable)
+	if err != nil {
+		return err
+	}
+
+	versionTable := `
+	CREATE TABLE IF NOT EXISTS video_versions (
+		video_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		projection TEXT,
+		original_filename TEXT NOT NULL DEFAULT '',
+		video_url TEXT NOT NULL,
+		frame_rate REAL NOT NULL,
+		source_frame_rate REAL NOT NULL,
+		frame_rate_mode TEXT NOT NULL,
+		size INTEGER NOT NULL DEFAULT 0,
+		sha256 TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY(video_id, version, kind),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_versions_url ON video_versions(video_url);
+	`
+	_, err = tx.Exec(versionTable)
+	if err != nil {
+		return err
+	}
+
+	auditLogTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		method TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		status_code INTEGER NOT NULL DEFAULT 0,
+		request_id TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log(actor_id, created_at);
+	CREATE INDEX IF NOT EXISTS audit_log_user ON audit_log(user_id, created_at);
+	`
+	_, err = tx.Exec(auditLogTable)
+	if err != nil {
+		return err
+	}
+	auditColumns := []struct{ name, definition string }{
+		{"ip", "TEXT NOT NULL DEFAULT ''"},
+		{"video_id", "TEXT NOT NULL DEFAULT ''"},
+		{"object_key", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range auditColumns {
+		err = addColumnIfMissing(tx, "audit_log", col.name, col.definition)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+	CREATE INDEX IF NOT EXISTS audit_log_action ON audit_log(action, created_at);
+	CREATE INDEX IF NOT EXISTS audit_log_video ON audit_log(video_id, created_at);
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(auditLogGuards)
+	if err != nil {
+		return err
+	}
+
+	tombstoneTable := `
+	CREATE TABLE IF NOT EXISTS video_tombstones (
+		video_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		object_keys TEXT NOT NULL,
+		asset_paths TEXT NOT NULL,
+		step INTEGER NOT NULL DEFAULT 0,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS video_tombstones_due ON video_tombstones(next_attempt_at);
+	`
+	_, err = tx.Exec(tombstoneTable)
+	if err != nil {
+		return err
+	}
+
+	err = addColumnIfMissing(tx, "refresh_tokens", "family_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(tx, "refresh_tokens", "replaced_by", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	// tokens from before rotation each start a family of their own
+	_, err = tx.Exec(`UPDATE refresh_tokens SET family_id = token WHERE family_id = ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(tx, "users", "tier", "TEXT NOT NULL DEFAULT 'free'")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(tx, "users", "quota_alert_level", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(tx, "users", "is_admin", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(tx, "users", "role", "TEXT NOT NULL DEFAULT 'creator'")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(tx, "users", "role_changed_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	renditionColumns := []struct{ name, definition string }{
+		{"size", "INTEGER NOT NULL DEFAULT 0"},
+		{"sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"fixity_status", "TEXT NOT NULL DEFAULT ''"},
+		{"fixity_checked_at", "TIMESTAMP"},
+	}
+	for _, col := range renditionColumns {
+		err = addColumnIfMissing(tx, "renditions", col.name, col.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	videoColumns := []struct{ name, definition string }{
+		{"projection", "TEXT"},
+		{"visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"ready_at", "TIMESTAMP"},
+		{"expires_at", "TIMESTAMP"},
+		{"default_language", "TEXT NOT NULL DEFAULT ''"},
+		{"original_filename", "TEXT NOT NULL DEFAULT ''"},
+		{"view_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"version", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_version", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, col := range videoColumns {
+		err = addColumnIfMissing(tx, "videos", col.name, col.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// videos uploaded before ready_at existed count as ready since their last update
+	_, err = tx.Exec(`UPDATE videos SET ready_at = updated_at WHERE ready_at IS NULL AND video_url IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	CREATE INDEX IF NOT EXISTS videos_user_created ON videos(user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS videos_user_ready ON videos(user_id, ready_at, id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// addColumnIfMissing lets migrateBaseline grow tables that were created by
+// an older version of the schema.
+func addColumnIfMissing(tx *transaction, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM pending_objects"); err != nil {
+		return fmt.Errorf("failed to reset table pending_objects: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tombstones"); err != nil {
+		return fmt.Errorf("failed to reset table video_tombstones: %w", err)
+	}
+	// the audit log is append-only outside of resets
+	if _, err := c.db.Exec(c.db.dialect.resetAuditLog()); err != nil {
+		return fmt.Errorf("failed to reset table audit_log: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM organization_upload_policies"); err != nil {
+		return fmt.Errorf("failed to reset table organization_upload_policies: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM idempotency_keys"); err != nil {
+		return fmt.Errorf("failed to reset table idempotency_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM dead_letter_jobs"); err != nil {
+		return fmt.Errorf("failed to reset table dead_letter_jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM integrations"); err != nil {
+		return fmt.Errorf("failed to reset table integrations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM content_objects"); err != nil {
+		return fmt.Errorf("failed to reset table content_objects: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM captions"); err != nil {
+		return fmt.Errorf("failed to reset table captions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM transcripts"); err != nil {
+		return fmt.Errorf("failed to reset table transcripts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM chapters"); err != nil {
+		return fmt.Errorf("failed to reset table chapters: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM moderation_labels"); err != nil {
+		return fmt.Errorf("failed to reset table moderation_labels: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_fingerprints"); err != nil {
+		return fmt.Errorf("failed to reset table video_fingerprints: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_localizations"); err != nil {
+		return fmt.Errorf("failed to reset table video_localizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_views"); err != nil {
+		return fmt.Errorf("failed to reset table video_views: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_traffic"); err != nil {
+		return fmt.Errorf("failed to reset table video_traffic: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM renditions"); err != nil {
+		return fmt.Errorf("failed to reset table renditions: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter is a job that failed every attempt it was allowed, kept with
+// what's needed to see why, until an admin requeues it.
+type DeadLetter struct {
+	JobID     uuid.UUID `json:"job_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Kind      string    `json:"kind"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	// ToolOutput is what the media tool that failed printed, if one did
+	ToolOutput string `json:"tool_output"`
+	// InputKey is what the job worked from: the object key of a video's
+	// file, or the URL a file was ingested from
+	InputKey string `json:"input_key"`
+}
+
+const deadLetterColumns = `
+		job_id,
+		created_at,
+		user_id,
+		video_id,
+		kind,
+		attempts,
+		error,
+		tool_output,
+		input_key`
+
+// CreateDeadLetter records that a job failed for good, replacing what was
+// recorded for it before.
+func (c Client) CreateDeadLetter(d DeadLetter) error {
+	query := `
+	INSERT INTO dead_letter_jobs (
+		job_id,
+		created_at,
+		user_id,
+		video_id,
+		kind,
+		attempts,
+		error,
+		tool_output,
+		input_key
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (job_id) DO UPDATE SET
+		created_at = excluded.created_at,
+		attempts = excluded.attempts,
+		error = excluded.error,
+		tool_output = excluded.tool_output,
+		input_key = excluded.input_key
+	`
+	_, err := c.db.Exec(query, d.JobID, d.UserID, d.VideoID, d.Kind, d.Attempts, d.Error, d.ToolOutput, d.InputKey)
+	return err
+}
+
+// GetDeadLetters returns every dead letter, newest first.
+func (c Client) GetDeadLetters() ([]DeadLetter, error) {
+	query := `
+	SELECT` + deadLetterColumns + `
+	FROM dead_letter_jobs
+	ORDER BY created_at DESC, job_id
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := []DeadLetter{}
+	for rows.Next() {
+		d, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, d)
+	}
+	return deadLetters, rows.Err()
+}
+
+// GetDeadLetter returns the dead letter of a job, the zero DeadLetter if
+// it has none.
+func (c Client) GetDeadLetter(jobID uuid.UUID) (DeadLetter, error) {
+	query := `
+	SELECT` + deadLetterColumns + `
+	FROM dead_letter_jobs
+	WHERE job_id = ?
+	`
+	d, err := scanDeadLetter(c.db.QueryRow(query, jobID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeadLetter{}, nil
+	}
+	return d, err
+}
+
+// RequeueDeadLetter moves a dead letter's job back to pending, as
+// RequeueJob does, and drops the dead letter. It reports false if the job
+// has no dead letter or isn't failed.
+func (c Client) RequeueDeadLetter(jobID uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM dead_letter_jobs WHERE job_id = ?`, jobID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	result, err = tx.Exec(`
+	UPDATE jobs
+	SET status = ?, error = NULL, attempts = attempts + 1, bytes_done = 0, bytes_total = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`, JobPending, jobID, JobFailed)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DeleteDeadLetter drops a job's dead letter, leaving the job failed. It
+// reports false if the job has no dead letter.
+func (c Client) DeleteDeadLetter(jobID uuid.UUID) (bool, error) {
+	result, err := c.db.Exec(`DELETE FROM dead_letter_jobs WHERE job_id = ?`, jobID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanDeadLetter(row rowScanner) (DeadLetter, error) {
+	var d DeadLetter
+	err := row.Scan(
+		&d.JobID,
+		&d.CreatedAt,
+		&d.UserID,
+		&d.VideoID,
+		&d.Kind,
+		&d.Attempts,
+		&d.Error,
+		&d.ToolOutput,
+		&d.InputKey,
+	)
+	return d, err
+}
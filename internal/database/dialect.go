@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// dialect is what differs between the databases a Client can run on.
+// Queries are written once, for SQLite with ? placeholders, keeping to SQL
+// that Postgres understands too; the dialect covers the rest.
+type dialect interface {
+	// rebind rewrites a query into the dialect's own SQL
+	rebind(query string) string
+	// baseline creates the schema as of the first versioned migration
+	baseline(tx *transaction) error
+	// lockMigrations keeps other instances from migrating until tx ends
+	lockMigrations(tx *transaction) error
+	// resetAuditLog is a script emptying the otherwise append-only audit log
+	resetAuditLog() string
+}
+
+// conn is the Client's database, running every query through its dialect.
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Begin() (*transaction, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, dialect: c.dialect}, nil
+}
+
+// transaction is a transaction on a conn, running every query through its
+// dialect too.
+type transaction struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *transaction) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// sqliteDialect runs queries as they are written.
+type sqliteDialect struct{}
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) baseline(tx *transaction) error { return migrateBaseline(tx) }
+
+// SQLite allows one writer at a time, so a migration's transaction already
+// shuts out other instances.
+func (sqliteDialect) lockMigrations(tx *transaction) error { return nil }
+
+func (sqliteDialect) resetAuditLog() string {
+	return `
+	DROP TRIGGER IF EXISTS audit_log_no_update;
+	DROP TRIGGER IF EXISTS audit_log_no_delete;
+	DELETE FROM audit_log;
+	` + auditLogGuards
+}
+
+// rewriteSQL copies query, passing every word and ? placeholder outside
+// string literals and quoted identifiers through replace. n counts the
+// placeholders so far, starting at 1.
+func rewriteSQL(query string, replace func(token string, n int) string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			// a doubled quote is an escaped one, carried over with the rest
+			end := i + 1
+			for end < len(query) {
+				if query[end] == ch {
+					if end+1 < len(query) && query[end+1] == ch {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(query))
+			b.WriteString(query[i:end])
+			i = end
+		case ch == '?':
+			n++
+			b.WriteString(replace("?", n))
+			i++
+		case isWordByte(ch):
+			end := i
+			for end < len(query) && isWordByte(query[end]) {
+				end++
+			}
+			b.WriteString(replace(query[i:end], n))
+			i = end
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isWordByte(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
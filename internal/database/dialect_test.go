@@ -0,0 +1,76 @@
+package database
+
+import "testing"
+
+func TestPostgresRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "placeholders are numbered",
+			query: `SELECT id FROM videos WHERE user_id = ? AND title = ?`,
+			want:  `SELECT id FROM videos WHERE user_id = $1 AND title = $2`,
+		},
+		{
+			name:  "placeholders without spaces",
+			query: `INSERT INTO tags (video_id, tag) VALUES (?,?)`,
+			want:  `INSERT INTO tags (video_id, tag) VALUES ($1,$2)`,
+		},
+		{
+			name:  "question mark in a string literal",
+			query: `SELECT id FROM videos WHERE title = 'why?' AND id = ?`,
+			want:  `SELECT id FROM videos WHERE title = 'why?' AND id = $1`,
+		},
+		{
+			name:  "doubled quote in a string literal",
+			query: `SELECT 'it''s ? here', ? FROM videos`,
+			want:  `SELECT 'it''s ? here', $1 FROM videos`,
+		},
+		{
+			name:  "question mark in a quoted identifier",
+			query: `SELECT "odd?col", "with ""quotes"" ?" FROM t WHERE id = ?`,
+			want:  `SELECT "odd?col", "with ""quotes"" ?" FROM t WHERE id = $1`,
+		},
+		{
+			name:  "unterminated string literal is kept",
+			query: `SELECT ? WHERE x = 'open ?`,
+			want:  `SELECT $1 WHERE x = 'open ?`,
+		},
+		{
+			name:  "CURRENT_TIMESTAMP is replaced",
+			query: `UPDATE jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			want:  `UPDATE jobs SET updated_at = ` + postgresNow + ` WHERE id = $1`,
+		},
+		{
+			name:  "CURRENT_TIMESTAMP inside an identifier",
+			query: `SELECT CURRENT_TIMESTAMP_utc, last_CURRENT_TIMESTAMP FROM t`,
+			want:  `SELECT CURRENT_TIMESTAMP_utc, last_CURRENT_TIMESTAMP FROM t`,
+		},
+		{
+			name:  "CURRENT_TIMESTAMP in a string literal",
+			query: `SELECT 'CURRENT_TIMESTAMP', "CURRENT_TIMESTAMP" FROM t`,
+			want:  `SELECT 'CURRENT_TIMESTAMP', "CURRENT_TIMESTAMP" FROM t`,
+		},
+		{
+			name:  "no placeholders",
+			query: `DELETE FROM sessions`,
+			want:  `DELETE FROM sessions`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (postgresDialect{}).rebind(tt.query); got != tt.want {
+				t.Errorf("rebind(%q)\n got %q\nwant %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteRebindKeepsQuery(t *testing.T) {
+	query := `UPDATE jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = ? AND note = 'a?'`
+	if got := (sqliteDialect{}).rebind(query); got != query {
+		t.Errorf("rebind(%q) = %q, want it unchanged", query, got)
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Fingerprint is the perceptual hashes of frames sampled across a video's
+// current file, for finding videos that look the same.
+type Fingerprint struct {
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	Title     string
+	CreatedAt time.Time
+	// FrameHashes are the frames' hashes in the order they appear
+	FrameHashes []uint64
+}
+
+// CommitFingerprint stores the frame hashes of the video's file at
+// videoURL, replacing any it had, unless the file was replaced since, in
+// which case it returns ErrVideoFileChanged.
+func (c Client) CommitFingerprint(videoID uuid.UUID, videoURL string, frameHashes []uint64) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentURL sql.NullString
+	err = tx.QueryRow(`SELECT video_url FROM videos WHERE id = ?`, videoID).Scan(&currentURL)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && currentURL.String != videoURL {
+		return ErrVideoFileChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded := make([]string, len(frameHashes))
+	for i, hash := range frameHashes {
+		encoded[i] = fmt.Sprintf("%016x", hash)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO video_fingerprints (video_id, video_url, frame_hashes, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (video_id) DO UPDATE SET
+		video_url = excluded.video_url,
+		frame_hashes = excluded.frame_hashes,
+		created_at = excluded.created_at
+	`, videoID, videoURL, strings.Join(encoded, ","))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFingerprints returns the fingerprints of userID's videos, or with a
+// nil userID of every video. Fingerprints of a file since replaced are
+// left out.
+func (c Client) GetFingerprints(userID uuid.UUID) ([]Fingerprint, error) {
+	owner := ""
+	if userID != uuid.Nil {
+		owner = userID.String()
+	}
+	rows, err := c.db.Query(`
+	SELECT v.id, v.user_id, v.title, v.created_at, f.frame_hashes
+	FROM video_fingerprints f
+	JOIN videos v ON v.id = f.video_id AND v.video_url = f.video_url
+	WHERE (? = '' OR v.user_id = ?)
+	ORDER BY v.created_at, v.id
+	`, owner, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := []Fingerprint{}
+	for rows.Next() {
+		var (
+			fingerprint Fingerprint
+			encoded     string
+		)
+		if err := rows.Scan(&fingerprint.VideoID, &fingerprint.UserID, &fingerprint.Title, &fingerprint.CreatedAt, &encoded); err != nil {
+			return nil, err
+		}
+		for _, hex := range strings.Split(encoded, ",") {
+			hash, err := strconv.ParseUint(hex, 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid frame hash %q of video %s: %w", hex, fingerprint.VideoID, err)
+			}
+			fingerprint.FrameHashes = append(fingerprint.FrameHashes, hash)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, rows.Err()
+}
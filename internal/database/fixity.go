@@ -0,0 +1,49 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FixityOK       = "ok"
+	FixityMismatch = "mismatch"
+	FixityMissing  = "missing"
+	// FixityExternal marks renditions stored outside Tubely's bucket,
+	// which it can't check
+	FixityExternal = "external"
+)
+
+// GetRenditionsDueForFixity returns up to limit renditions that haven't
+// been checked since checkedBefore, never checked ones first and then the
+// longest unchecked, so every object comes round in turn. Renditions of
+// archived videos can't be read, and wait until they're restored.
+func (c Client) GetRenditionsDueForFixity(checkedBefore time.Time, limit int) ([]Rendition, error) {
+	query := `
+	SELECT ` + renditionColumns + `
+	FROM renditions
+	WHERE (fixity_checked_at IS NULL OR fixity_checked_at < ?)
+		AND video_id NOT IN (SELECT id FROM videos WHERE archive_status <> '')
+	ORDER BY fixity_checked_at IS NOT NULL, fixity_checked_at, created_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, checkedBefore.UTC().Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRenditions(rows)
+}
+
+// RecordFixityCheck stores the outcome of checking a rendition's object.
+// sha256 becomes its expected digest if it didn't have one yet.
+func (c Client) RecordFixityCheck(id uuid.UUID, status, sha256 string) error {
+	_, err := c.db.Exec(`
+	UPDATE renditions
+	SET fixity_status = ?,
+		fixity_checked_at = CURRENT_TIMESTAMP,
+		sha256 = CASE WHEN sha256 = '' THEN ? ELSE sha256 END
+	WHERE id = ?
+	`, status, sha256, id)
+	return err
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyRecord remembers the response to a request made with an
+// Idempotency-Key. A zero StatusCode means the original request is still
+// in flight.
+type IdempotencyRecord struct {
+	UserID       uuid.UUID
+	Key          string
+	RequestPath  string
+	StatusCode   int
+	ResponseBody []byte
+	CreatedAt    time.Time
+}
+
+// ReserveIdempotencyKey claims key for a new request. If the key is already
+// taken within ttl, reserved is false and the existing record is returned.
+// Expired records are cleared out on the way.
+func (c Client) ReserveIdempotencyKey(userID uuid.UUID, key, requestPath string, ttl time.Duration) (IdempotencyRecord, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	defer tx.Rollback()
+
+	cutoff := time.Now().Add(-ttl).UTC().Format("2006-01-02 15:04:05")
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, cutoff); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	result, err := tx.Exec(`
+	INSERT INTO idempotency_keys (
+		user_id,
+		key,
+		request_path,
+		status_code,
+		created_at
+	) VALUES (?, ?, ?, 0, CURRENT_TIMESTAMP)
+	ON CONFLICT DO NOTHING
+	`, userID, key, requestPath)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if inserted == 1 {
+		return IdempotencyRecord{}, true, tx.Commit()
+	}
+
+	record := IdempotencyRecord{UserID: userID, Key: key}
+	err = tx.QueryRow(`
+	SELECT request_path, status_code, response_body, created_at
+	FROM idempotency_keys
+	WHERE user_id = ? AND key = ?
+	`, userID, key).Scan(&record.RequestPath, &record.StatusCode, &record.ResponseBody, &record.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IdempotencyRecord{}, false, errors.New("idempotency key vanished during reservation")
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return record, false, tx.Commit()
+}
+
+// CompleteIdempotencyKey stores the response to replay for key.
+func (c Client) CompleteIdempotencyKey(userID uuid.UUID, key string, statusCode int, body []byte) error {
+	_, err := c.db.Exec(`
+	UPDATE idempotency_keys
+	SET status_code = ?, response_body = ?
+	WHERE user_id = ? AND key = ?
+	`, statusCode, body, userID, key)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets key so the request can be retried.
+func (c Client) ReleaseIdempotencyKey(userID uuid.UUID, key string) error {
+	_, err := c.db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?`, userID, key)
+	return err
+}
+
+// ReleasePendingIdempotencyKeys forgets every key whose request never
+// finished. Requests don't survive a restart, so at startup these would
+// otherwise block retries until they expire.
+func (c Client) ReleasePendingIdempotencyKeys() (int64, error) {
+	result, err := c.db.Exec(`DELETE FROM idempotency_keys WHERE status_code = 0`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links an account at an OAuth provider to a user, so they
+// can log in with it.
+type UserIdentity struct {
+	Provider string
+	// Subject is the provider's ID for the account
+	Subject string
+	UserID  uuid.UUID
+	// Email is the account's address when it was linked
+	Email string
+}
+
+// GetIdentityUserID returns the user an identity is linked to, or uuid.Nil
+// if it isn't linked.
+func (c Client) GetIdentityUserID(provider, subject string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := c.db.QueryRow(`
+	SELECT user_id
+	FROM user_identities
+	WHERE provider = ? AND subject = ?
+	`, provider, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return userID, err
+}
+
+// LinkIdentity links identity to an existing user. Linking an identity that
+// is already linked does nothing.
+func (c Client) LinkIdentity(identity UserIdentity) error {
+	_, err := c.db.Exec(`
+	INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+	VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	ON CONFLICT DO NOTHING
+	`, identity.Provider, identity.Subject, identity.UserID, identity.Email)
+	return err
+}
+
+// CreateIdentityUser creates a user who logs in only with identity. They
+// have no password, so password logins fail until they set one.
+func (c Client) CreateIdentityUser(identity UserIdentity) (*User, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	_, err = tx.Exec(`
+	INSERT INTO users (id, created_at, updated_at, email, password)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, '')
+	`, id.String(), identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+	VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	`, identity.Provider, identity.Subject, id.String(), identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c.GetUser(id)
+}
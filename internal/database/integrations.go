@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Integration is a chat webhook that a user wants events posted to.
+type Integration struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateIntegrationParams
+}
+
+type CreateIntegrationParams struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Kind       string    `json:"kind"`
+	WebhookURL string    `json:"webhook_url"`
+	Events     []string  `json:"events"`
+	Template   string    `json:"template"`
+}
+
+const integrationColumns = `
+		id,
+		created_at,
+		user_id,
+		kind,
+		webhook_url,
+		events,
+		template`
+
+func scanIntegration(row rowScanner) (Integration, error) {
+	var integration Integration
+	var events string
+	err := row.Scan(
+		&integration.ID,
+		&integration.CreatedAt,
+		&integration.UserID,
+		&integration.Kind,
+		&integration.WebhookURL,
+		&events,
+		&integration.Template,
+	)
+	integration.Events = strings.Split(events, ",")
+	return integration, err
+}
+
+func (c Client) CreateIntegration(params CreateIntegrationParams) (Integration, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO integrations (
+		id,
+		created_at,
+		user_id,
+		kind,
+		webhook_url,
+		events,
+		template
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.UserID,
+		params.Kind,
+		params.WebhookURL,
+		strings.Join(params.Events, ","),
+		params.Template,
+	)
+	if err != nil {
+		return Integration{}, err
+	}
+	return c.GetIntegration(id)
+}
+
+func (c Client) GetIntegration(id uuid.UUID) (Integration, error) {
+	query := `
+	SELECT` + integrationColumns + `
+	FROM integrations
+	WHERE id = ?
+	`
+	integration, err := scanIntegration(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Integration{}, nil
+		}
+		return Integration{}, err
+	}
+	return integration, nil
+}
+
+func (c Client) GetIntegrations(userID uuid.UUID) ([]Integration, error) {
+	query := `
+	SELECT` + integrationColumns + `
+	FROM integrations
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	integrations := []Integration{}
+	for rows.Next() {
+		integration, err := scanIntegration(rows)
+		if err != nil {
+			return nil, err
+		}
+		integrations = append(integrations, integration)
+	}
+	return integrations, rows.Err()
+}
+
+// GetIntegrationsForEvent returns the user's integrations subscribed to event.
+func (c Client) GetIntegrationsForEvent(userID uuid.UUID, event string) ([]Integration, error) {
+	all, err := c.GetIntegrations(userID)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := []Integration{}
+	for _, integration := range all {
+		if slices.Contains(integration.Events, event) {
+			subscribed = append(subscribed, integration)
+		}
+	}
+	return subscribed, nil
+}
+
+func (c Client) DeleteIntegration(id uuid.UUID) error {
+	query := `
+	DELETE FROM integrations
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
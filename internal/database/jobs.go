@@ -0,0 +1,368 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job tracks a long-running operation on a video so clients can poll for
+// its outcome instead of holding a request open.
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Kind      string    `json:"kind"`
+	Status    string    `json:"status"`
+	Error     *string   `json:"error"`
+	// Attempts counts the times the job was started, retries included
+	Attempts int `json:"attempts"`
+	// Params is what the job was started with, as JSON, so it can be
+	// started again
+	Params string `json:"-"`
+	// BytesDone and BytesTotal track jobs that transfer a file, BytesTotal
+	// being nil until the size is known
+	BytesDone  int64  `json:"bytes_done,omitempty"`
+	BytesTotal *int64 `json:"bytes_total,omitempty"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		video_id,
+		kind,
+		status,
+		error,
+		attempts,
+		params,
+		bytes_done,
+		bytes_total`
+
+func (c Client) CreateJob(userID, videoID uuid.UUID, kind, params string) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		video_id,
+		kind,
+		status,
+		attempts,
+		params
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, 1, ?)
+	`
+	_, err := c.db.Exec(query, id, userID, videoID, kind, JobPending, params)
+	if err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(id)
+}
+
+// GetJob returns a zero Job if it doesn't exist.
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, nil
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// GetRetryableJobs returns the video's failed jobs that no later job of the
+// same kind has superseded, oldest first. A nil videoID returns those of
+// every video.
+func (c Client) GetRetryableJobs(videoID uuid.UUID) ([]Job, error) {
+	video := ""
+	if videoID != uuid.Nil {
+		video = videoID.String()
+	}
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE (? = '' OR video_id = ?) AND status = ? AND NOT EXISTS (
+		SELECT 1 FROM jobs AS later
+		WHERE later.video_id = jobs.video_id
+			AND later.kind = jobs.kind
+			AND later.created_at > jobs.created_at
+	)
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, video, video, JobFailed)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// GetJobsByStatus returns every job in one of statuses, oldest first.
+func (c Client) GetJobsByStatus(statuses ...string) ([]Job, error) {
+	if len(statuses) == 0 {
+		return []Job{}, nil
+	}
+	args := make([]any, len(statuses))
+	for i, status := range statuses {
+		args[i] = status
+	}
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// JobCount is how many jobs of a kind are in a status.
+type JobCount struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// GetJobCounts counts the jobs of each kind in each status.
+func (c Client) GetJobCounts() ([]JobCount, error) {
+	rows, err := c.db.Query(`
+	SELECT kind, status, COUNT(*)
+	FROM jobs
+	GROUP BY kind, status
+	ORDER BY kind, status
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []JobCount{}
+	for rows.Next() {
+		var count JobCount
+		if err := rows.Scan(&count.Kind, &count.Status, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RequeueJob moves a failed job back to pending as its next attempt. It
+// reports false if the job wasn't failed, such as when another request
+// requeued it first.
+func (c Client) RequeueJob(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET status = ?, error = NULL, attempts = attempts + 1, bytes_done = 0, bytes_total = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	result, err := c.db.Exec(query, JobPending, id, JobFailed)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.UserID,
+		&job.VideoID,
+		&job.Kind,
+		&job.Status,
+		&job.Error,
+		&job.Attempts,
+		&job.Params,
+		&job.BytesDone,
+		&job.BytesTotal,
+	)
+	return job, err
+}
+
+// ClaimJob moves a pending job to running for owner, the instance that
+// runs it, which holds it until leaseUntil unless it renews the lease. It
+// reports false if the job wasn't pending, such as when it's already being
+// run.
+func (c Client) ClaimJob(id uuid.UUID, owner string, leaseUntil time.Time) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET status = ?, owner = ?, lease_expires_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	result, err := c.db.Exec(query, JobRunning, owner, leaseUntil.UTC().Format(viewTimeFormat), id, JobPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ReclaimStaleJob takes over, for owner until leaseUntil, a job left
+// running, since before staleBefore, by a process that stopped, counting
+// it as another attempt. It reports false if the job isn't such a job,
+// say because it was taken over already.
+func (c Client) ReclaimStaleJob(id uuid.UUID, staleBefore time.Time, owner string, leaseUntil time.Time) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET attempts = attempts + 1, bytes_done = 0, bytes_total = NULL, owner = ?, lease_expires_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ? AND updated_at < ?
+	`
+	result, err := c.db.Exec(query, owner, leaseUntil.UTC().Format(viewTimeFormat), id, JobRunning, staleBefore.UTC().Format(viewTimeFormat))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UpdateJobStatus moves a job to status. errMessage is only kept for
+// failed jobs.
+func (c Client) UpdateJobStatus(id uuid.UUID, status, errMessage string) error {
+	var jobErr *string
+	if status == JobFailed {
+		jobErr = &errMessage
+	}
+	query := `
+	UPDATE jobs
+	SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, jobErr, id)
+	return err
+}
+
+// UpdateJobProgress records how much of its file a job has transferred.
+// total is nil if the size isn't known.
+func (c Client) UpdateJobProgress(id uuid.UUID, done int64, total *int64) error {
+	query := `
+	UPDATE jobs
+	SET bytes_done = ?, bytes_total = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, done, total, id)
+	return err
+}
+
+// RenewJobLeases extends the lease on every job owner is running to
+// leaseUntil, returning how many it holds.
+func (c Client) RenewJobLeases(owner string, leaseUntil time.Time) (int64, error) {
+	query := `
+	UPDATE jobs
+	SET lease_expires_at = ?
+	WHERE owner = ? AND status = ?
+	`
+	result, err := c.db.Exec(query, leaseUntil.UTC().Format(viewTimeFormat), owner, JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RequeueInterruptedJobs moves the running jobs whose lease ran out before
+// now back to pending as their next attempt, and returns them. The
+// instance that claimed such a job stopped renewing its lease, so it was
+// cut off by a crash; jobs other instances are still running are left
+// alone. Jobs claimed before leases were recorded count from their last
+// update. Those that have used up maxAttempts are failed with errMessage
+// instead, so a job that keeps crashing the server stops being run, and
+// returned as failed.
+func (c Client) RequeueInterruptedJobs(now time.Time, maxAttempts int, errMessage string) (requeued, failed []Job, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	const expired = `status = ? AND COALESCE(lease_expires_at, updated_at) < ?`
+	cutoff := now.UTC().Format(viewTimeFormat)
+	rows, err := tx.Query(`
+	SELECT`+jobColumns+`
+	FROM jobs
+	WHERE `+expired+`
+	ORDER BY created_at
+	`, JobRunning, cutoff)
+	if err != nil {
+		return nil, nil, err
+	}
+	interrupted, err := scanJobs(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requeued, failed = []Job{}, []Job{}
+	for _, job := range interrupted {
+		var result sql.Result
+		giveUp := job.Attempts >= maxAttempts
+		if giveUp {
+			result, err = tx.Exec(`
+			UPDATE jobs
+			SET status = ?, error = ?, owner = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND `+expired,
+				JobFailed, errMessage, job.ID, JobRunning, cutoff)
+		} else {
+			result, err = tx.Exec(`
+			UPDATE jobs
+			SET status = ?, attempts = attempts + 1, bytes_done = 0, bytes_total = NULL, owner = NULL, lease_expires_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND `+expired,
+				JobPending, job.ID, JobRunning, cutoff)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// another instance may have requeued it first
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, nil, err
+		} else if n == 0 {
+			continue
+		}
+		if giveUp {
+			failed = append(failed, job)
+			continue
+		}
+		job.Status = JobPending
+		job.Attempts++
+		job.BytesDone, job.BytesTotal = 0, nil
+		requeued = append(requeued, job)
+	}
+	return requeued, failed, tx.Commit()
+}
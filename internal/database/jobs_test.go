@@ -0,0 +1,74 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRequeueInterruptedJobsLeavesLiveLeases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	// two instances sharing one database
+	a, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claim := func(c Client, owner string, leaseUntil time.Time) Job {
+		t.Helper()
+		job, err := c.CreateJob(uuid.New(), uuid.New(), "transcribe", "{}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		claimed, err := c.ClaimJob(job.ID, owner, leaseUntil)
+		if err != nil || !claimed {
+			t.Fatalf("ClaimJob = %v, %v", claimed, err)
+		}
+		return job
+	}
+	live := claim(a, "instance-a", now.Add(time.Minute))
+	crashed := claim(b, "instance-b", now.Add(-time.Minute))
+
+	// instance b restarts and looks for jobs cut off by its crash
+	requeued, failed, err := b.RequeueInterruptedJobs(now, 5, "interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requeued) != 1 || requeued[0].ID != crashed.ID || len(failed) != 0 {
+		t.Fatalf("requeued %v and failed %v, want only %s requeued", requeued, failed, crashed.ID)
+	}
+
+	got, err := a.GetJob(live.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != JobRunning {
+		t.Errorf("job leased to a live instance is %s, want %s", got.Status, JobRunning)
+	}
+	got, err = a.GetJob(crashed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != JobPending || got.Attempts != 2 {
+		t.Errorf("crashed job is %s on attempt %d, want %s on attempt 2", got.Status, got.Attempts, JobPending)
+	}
+
+	// a's lease would run out without renewal, a renewed one doesn't
+	if held, err := a.RenewJobLeases("instance-a", now.Add(3*time.Minute)); err != nil || held != 1 {
+		t.Fatalf("RenewJobLeases = %d, %v, want 1", held, err)
+	}
+	requeued, _, err = b.RequeueInterruptedJobs(now.Add(2*time.Minute), 5, "interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requeued) != 0 {
+		t.Errorf("requeued %v with its lease renewed", requeued)
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Localization is a video's title and description in another language.
+type Localization struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	Language    string    `json:"language"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SaveLocalization creates or replaces the video's variant in a language.
+func (c Client) SaveLocalization(loc Localization) error {
+	query := `
+	INSERT INTO video_localizations (
+		video_id,
+		language,
+		title,
+		description,
+		updated_at
+	) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (video_id, language) DO UPDATE SET
+		title = excluded.title,
+		description = excluded.description,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, loc.VideoID, loc.Language, loc.Title, loc.Description)
+	return err
+}
+
+func (c Client) DeleteLocalization(videoID uuid.UUID, language string) error {
+	query := `
+	DELETE FROM video_localizations
+	WHERE video_id = ? AND language = ?
+	`
+	_, err := c.db.Exec(query, videoID, language)
+	return err
+}
+
+func (c Client) GetLocalizations(videoID uuid.UUID) ([]Localization, error) {
+	byVideo, err := c.GetLocalizationsForVideos([]uuid.UUID{videoID})
+	if err != nil {
+		return nil, err
+	}
+	if byVideo[videoID] == nil {
+		return []Localization{}, nil
+	}
+	return byVideo[videoID], nil
+}
+
+// GetLocalizationsForVideos loads the localizations of several videos at
+// once, keyed by video ID.
+func (c Client) GetLocalizationsForVideos(videoIDs []uuid.UUID) (map[uuid.UUID][]Localization, error) {
+	byVideo := map[uuid.UUID][]Localization{}
+	if len(videoIDs) == 0 {
+		return byVideo, nil
+	}
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT video_id, language, title, description, updated_at
+	FROM video_localizations
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	ORDER BY video_id, language
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var loc Localization
+		if err := rows.Scan(&loc.VideoID, &loc.Language, &loc.Title, &loc.Description, &loc.UpdatedAt); err != nil {
+			return nil, err
+		}
+		byVideo[loc.VideoID] = append(byVideo[loc.VideoID], loc)
+	}
+	return byVideo, rows.Err()
+}
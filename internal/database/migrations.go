@@ -0,0 +1,231 @@
+package database
+
+import (
+	"fmt"
+)
+
+// migration is one step in the schema's history. Each runs once, in a
+// transaction, and is recorded in schema_migrations when it commits.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *transaction) error
+}
+
+// migrations is the schema's history, oldest first. A released migration
+// is never edited: a change to the schema is a new migration with the next
+// version at the end of the list.
+var migrations = []migration{
+	{1, "baseline", func(tx *transaction) error { return tx.dialect.baseline(tx) }},
+	{2, "pending objects", execMigration(`
+	CREATE TABLE pending_objects (
+		object_key TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX pending_objects_created_at ON pending_objects(created_at);
+	`)},
+	{3, "job attempts", execMigration(`
+	ALTER TABLE jobs ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE jobs ADD COLUMN params TEXT NOT NULL DEFAULT '';
+	`)},
+	{4, "video dimensions", execMigration(`
+	ALTER TABLE videos ADD COLUMN width INTEGER;
+	ALTER TABLE videos ADD COLUMN height INTEGER;
+	ALTER TABLE video_versions ADD COLUMN width INTEGER;
+	ALTER TABLE video_versions ADD COLUMN height INTEGER;
+	`)},
+	{5, "audio", execMigration(`
+	ALTER TABLE videos ADD COLUMN media_kind TEXT NOT NULL DEFAULT 'video';
+	ALTER TABLE videos ADD COLUMN duration DOUBLE PRECISION;
+	ALTER TABLE videos ADD COLUMN bitrate BIGINT;
+	ALTER TABLE video_versions ADD COLUMN duration DOUBLE PRECISION;
+	ALTER TABLE video_versions ADD COLUMN bitrate BIGINT;
+	`)},
+	{6, "loudness normalization", execMigration(`
+	ALTER TABLE videos ADD COLUMN loudness_target DOUBLE PRECISION;
+	ALTER TABLE video_versions ADD COLUMN loudness_target DOUBLE PRECISION;
+	`)},
+	{7, "captions", execMigration(`
+	CREATE TABLE captions (
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		label TEXT NOT NULL,
+		caption_url TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX captions_url ON captions(caption_url);
+	`)},
+	{8, "transcripts", execMigration(`
+	ALTER TABLE captions ADD COLUMN source TEXT NOT NULL DEFAULT 'upload';
+	CREATE TABLE transcripts (
+		video_id TEXT PRIMARY KEY,
+		language TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		text TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`)},
+	{9, "chapters", execMigration(`
+	CREATE TABLE chapters (
+		video_id TEXT NOT NULL,
+		start_time DOUBLE PRECISION NOT NULL,
+		title TEXT NOT NULL,
+		PRIMARY KEY(video_id, start_time),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`)},
+	{10, "clips", execMigration(`
+	ALTER TABLE videos ADD COLUMN clip_source_id TEXT;
+	ALTER TABLE videos ADD COLUMN clip_start DOUBLE PRECISION;
+	ALTER TABLE videos ADD COLUMN clip_end DOUBLE PRECISION;
+	CREATE INDEX videos_clip_source ON videos(clip_source_id);
+	`)},
+	{11, "moderation", execMigration(`
+	ALTER TABLE videos ADD COLUMN moderation_status TEXT NOT NULL DEFAULT '';
+	CREATE INDEX videos_moderation_status ON videos(moderation_status);
+	CREATE TABLE moderation_labels (
+		video_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		parent_name TEXT NOT NULL,
+		confidence DOUBLE PRECISION NOT NULL,
+		frame_time DOUBLE PRECISION NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX moderation_labels_video ON moderation_labels(video_id);
+	`)},
+	{12, "job progress", execMigration(`
+	ALTER TABLE jobs ADD COLUMN bytes_done BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN bytes_total BIGINT;
+	`)},
+	// '' stands for S3_BUCKET, where everything before sharding was stored
+	{13, "storage buckets", execMigration(`
+	ALTER TABLE videos ADD COLUMN storage_bucket TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN storage_region TEXT NOT NULL DEFAULT '';
+	ALTER TABLE pending_objects ADD COLUMN bucket TEXT NOT NULL DEFAULT '';
+	ALTER TABLE content_objects ADD COLUMN bucket TEXT NOT NULL DEFAULT '';
+	ALTER TABLE video_tombstones ADD COLUMN storage_bucket TEXT NOT NULL DEFAULT '';
+	`)},
+	{14, "archival", execMigration(`
+	ALTER TABLE videos ADD COLUMN archive_status TEXT NOT NULL DEFAULT '';
+	ALTER TABLE videos ADD COLUMN archived_at TIMESTAMP;
+	ALTER TABLE videos ADD COLUMN restored_at TIMESTAMP;
+	`)},
+	{15, "video_passwords", execMigration(`
+	ALTER TABLE videos ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+	`)},
+	{16, "thumbnail blurhash", execMigration(`
+	ALTER TABLE videos ADD COLUMN thumbnail_blurhash TEXT;
+	`)},
+	{17, "fingerprints", execMigration(`
+	CREATE TABLE video_fingerprints (
+		video_id TEXT PRIMARY KEY,
+		video_url TEXT NOT NULL,
+		frame_hashes TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`)},
+	{18, "dead letter jobs", execMigration(`
+	CREATE TABLE dead_letter_jobs (
+		job_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		tool_output TEXT NOT NULL DEFAULT '',
+		input_key TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(job_id) REFERENCES jobs(id)
+	);
+	CREATE INDEX dead_letter_jobs_created_at ON dead_letter_jobs(created_at);
+	`)},
+	// a running job belongs to the instance that claimed it for as long as
+	// that instance keeps renewing its lease
+	{19, "job leases", execMigration(`
+	ALTER TABLE jobs ADD COLUMN owner TEXT;
+	ALTER TABLE jobs ADD COLUMN lease_expires_at TIMESTAMP;
+	`)},
+}
+
+// execMigration is a migration that runs a fixed script.
+func execMigration(script string) func(tx *transaction) error {
+	return func(tx *transaction) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+// migrate brings the schema up to date by running the migrations the
+// database hasn't had yet, in order. A database migrated by a newer build
+// is refused rather than used with a schema this one doesn't know.
+func (c *Client) migrate() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return err
+	}
+
+	current, err := c.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("database schema is at version %d, newer than this build's %d", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := c.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) applyMigration(m migration) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.dialect.lockMigrations(tx); err != nil {
+		return err
+	}
+	// another instance may have applied it while this one waited
+	var applied bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`, m.version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// database, 0 if none has been.
+func (c Client) SchemaVersion() (int, error) {
+	var version int
+	err := c.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ModerationPending is a video whose file is waiting to be moderated.
+	// It stays visible meanwhile.
+	ModerationPending = "pending"
+	// ModerationApproved is a video moderation found nothing in, or that
+	// an admin approved
+	ModerationApproved = "approved"
+	// ModerationPendingReview is a video moderation flagged, hidden from
+	// everyone but its owner until an admin reviews it
+	ModerationPendingReview = "pending_review"
+	// ModerationRejected is a flagged video an admin rejected. It stays
+	// hidden from everyone but its owner.
+	ModerationRejected = "rejected"
+)
+
+// ModerationLabel is something moderation found in a frame of a video.
+type ModerationLabel struct {
+	Name string `json:"name"`
+	// ParentName is the broader category Name belongs to, if any
+	ParentName string `json:"parent_name"`
+	// Confidence is from 0 to 100
+	Confidence float64 `json:"confidence"`
+	// Timestamp is how far into the video the frame is, in seconds
+	Timestamp float64 `json:"timestamp"`
+}
+
+// SetModerationStatus sets where the video is in moderation, returning
+// sql.ErrNoRows if the video is gone.
+func (c Client) SetModerationStatus(videoID uuid.UUID, status string) error {
+	result, err := c.db.Exec(`UPDATE videos SET moderation_status = ? WHERE id = ?`, status, videoID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CommitModeration stores what moderating the video found, replacing the
+// labels it had, and sets its status, if videoURL is still the video's
+// file. Otherwise it returns ErrVideoFileChanged.
+func (c Client) CommitModeration(videoID uuid.UUID, videoURL, status string, labels []ModerationLabel) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentURL sql.NullString
+	err = tx.QueryRow(`SELECT video_url FROM videos WHERE id = ?`, videoID).Scan(&currentURL)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && currentURL.String != videoURL {
+		return ErrVideoFileChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM moderation_labels WHERE video_id = ?`, videoID); err != nil {
+		return err
+	}
+	for _, label := range labels {
+		_, err := tx.Exec(`
+		INSERT INTO moderation_labels (video_id, name, parent_name, confidence, frame_time)
+		VALUES (?, ?, ?, ?, ?)
+		`, videoID, label.Name, label.ParentName, label.Confidence, label.Timestamp)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE videos SET moderation_status = ? WHERE id = ?`, status, videoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetModerationLabels returns what moderation found in the video's file,
+// most confident first.
+func (c Client) GetModerationLabels(videoID uuid.UUID) ([]ModerationLabel, error) {
+	rows, err := c.db.Query(`
+	SELECT name, parent_name, confidence, frame_time
+	FROM moderation_labels
+	WHERE video_id = ?
+	ORDER BY confidence DESC, frame_time
+	`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []ModerationLabel{}
+	for rows.Next() {
+		var label ModerationLabel
+		if err := rows.Scan(&label.Name, &label.ParentName, &label.Confidence, &label.Timestamp); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// ErrAlreadyInOrganization is returned when adding a user who already
+// belongs to an organization. Users are in at most one.
+var ErrAlreadyInOrganization = errors.New("user already belongs to an organization")
+
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// UploadPolicy holds an organization's defaults for its members' uploads.
+// Empty values and a zero RetentionDays mean "no default".
+type UploadPolicy struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Visibility     string    `json:"visibility"`
+	Profile        string    `json:"profile"`
+	Tags           []string  `json:"tags"`
+	RetentionDays  int       `json:"retention_days"`
+}
+
+// CreateOrganization creates an organization with adminID as its first admin.
+func (c Client) CreateOrganization(name string, adminID uuid.UUID) (Organization, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	_, err = tx.Exec(`
+	INSERT INTO organizations (id, created_at, name)
+	VALUES (?, CURRENT_TIMESTAMP, ?)
+	`, id, name)
+	if err != nil {
+		return Organization{}, err
+	}
+	if err := addOrganizationMember(tx, id, adminID, OrgRoleAdmin); err != nil {
+		return Organization{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+	return c.GetOrganization(id)
+}
+
+// GetOrganization returns a zero Organization if it doesn't exist.
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	var org Organization
+	err := c.db.QueryRow(`
+	SELECT id, created_at, name
+	FROM organizations
+	WHERE id = ?
+	`, id).Scan(&org.ID, &org.CreatedAt, &org.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Organization{}, nil
+	}
+	return org, err
+}
+
+// GetMembership returns the user's organization membership, or nil if the
+// user isn't in one.
+func (c Client) GetMembership(userID uuid.UUID) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := c.db.QueryRow(`
+	SELECT m.organization_id, m.user_id, u.email, m.role, m.created_at
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.user_id = ?
+	`, userID).Scan(&member.OrganizationID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (c Client) GetOrganizationMembers(orgID uuid.UUID) ([]OrganizationMember, error) {
+	rows, err := c.db.Query(`
+	SELECT m.organization_id, m.user_id, u.email, m.role, m.created_at
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.organization_id = ?
+	ORDER BY m.created_at, u.email
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		if err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (c Client) AddOrganizationMember(orgID, userID uuid.UUID, role string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := addOrganizationMember(tx, orgID, userID, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addOrganizationMember(tx *transaction, orgID, userID uuid.UUID, role string) error {
+	result, err := tx.Exec(`
+	INSERT INTO organization_members (user_id, organization_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT DO NOTHING
+	`, userID, orgID, role)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrAlreadyInOrganization
+	}
+	return nil
+}
+
+func (c Client) RemoveOrganizationMember(orgID, userID uuid.UUID) error {
+	_, err := c.db.Exec(`
+	DELETE FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`, orgID, userID)
+	return err
+}
+
+// GetUploadPolicy returns the organization's policy, which is empty if
+// none was ever saved.
+func (c Client) GetUploadPolicy(orgID uuid.UUID) (UploadPolicy, error) {
+	policy := UploadPolicy{OrganizationID: orgID, Tags: []string{}}
+	var tags string
+	err := c.db.QueryRow(`
+	SELECT visibility, profile, tags, retention_days
+	FROM organization_upload_policies
+	WHERE organization_id = ?
+	`, orgID).Scan(&policy.Visibility, &policy.Profile, &tags, &policy.RetentionDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policy, nil
+	}
+	if err != nil {
+		return UploadPolicy{}, err
+	}
+	if tags != "" {
+		policy.Tags = strings.Split(tags, ",")
+	}
+	return policy, nil
+}
+
+func (c Client) SaveUploadPolicy(policy UploadPolicy) error {
+	_, err := c.db.Exec(`
+	INSERT INTO organization_upload_policies (
+		organization_id,
+		visibility,
+		profile,
+		tags,
+		retention_days,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (organization_id) DO UPDATE SET
+		visibility = excluded.visibility,
+		profile = excluded.profile,
+		tags = excluded.tags,
+		retention_days = excluded.retention_days,
+		updated_at = excluded.updated_at
+	`,
+		policy.OrganizationID,
+		policy.Visibility,
+		policy.Profile,
+		strings.Join(policy.Tags, ","),
+		policy.RetentionDays,
+	)
+	return err
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingObject is an object being stored for a video that the video
+// doesn't point at yet. It's recorded before the object is written and
+// forgotten in the transaction that points the video at it, so an upload
+// that never gets that far leaves a record behind for the collector.
+type PendingObject struct {
+	// Bucket is where the object is stored, "" for S3_BUCKET
+	Bucket    string
+	ObjectKey string
+	VideoID   uuid.UUID
+	CreatedAt time.Time
+}
+
+// CreatePendingObject records that key is about to be stored in bucket
+// for a video. Keys start with the ID of the video they were made for, so
+// a key alone tells pending objects apart.
+func (c Client) CreatePendingObject(bucket, key string, videoID uuid.UUID) error {
+	query := `
+	INSERT INTO pending_objects (object_key, bucket, video_id, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (object_key) DO UPDATE SET
+		bucket = excluded.bucket,
+		video_id = excluded.video_id,
+		created_at = excluded.created_at
+	`
+	_, err := c.db.Exec(query, key, bucket, videoID)
+	return err
+}
+
+func (c Client) DeletePendingObject(key string) error {
+	_, err := c.db.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, key)
+	return err
+}
+
+// GetStalePendingObjects returns up to limit pending objects recorded
+// before cutoff, oldest first.
+func (c Client) GetStalePendingObjects(cutoff time.Time, limit int) ([]PendingObject, error) {
+	query := `
+	SELECT bucket, object_key, video_id, created_at
+	FROM pending_objects
+	WHERE created_at < ?
+	ORDER BY created_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, cutoff.UTC().Format(viewTimeFormat), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []PendingObject{}
+	for rows.Next() {
+		var p PendingObject
+		if err := rows.Scan(&p.Bucket, &p.ObjectKey, &p.VideoID, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+// CommitVideoObjects updates video, which now points at the objects
+// stored under keys, replaces its renditions with version's and makes
+// version its current one, and forgets that the objects were pending, in
+// one transaction.
+func (c Client) CommitVideoObjects(video Video, version VideoVersion, keys []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateVideo(tx, video); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM renditions WHERE video_id = ?`, video.ID); err != nil {
+		return err
+	}
+	for _, rendition := range version.Renditions {
+		if _, err := createRendition(tx, rendition); err != nil {
+			return err
+		}
+	}
+	if err := saveVideoVersion(tx, version); err != nil {
+		return err
+	}
+	if err := setCurrentVersion(tx, video.ID, version.Version); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := tx.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
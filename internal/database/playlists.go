@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrPlaylistOrderMismatch is returned by ReorderPlaylist when the given
+// order isn't a permutation of the playlist's current videos.
+var ErrPlaylistOrderMismatch = errors.New("order must list every video in the playlist exactly once")
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	UserID      uuid.UUID `json:"user_id"`
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		user_id
+	FROM playlists
+	WHERE id = ?
+	`
+	var playlist Playlist
+	err := c.db.QueryRow(query, id).Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.UserID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		user_id
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		var playlist Playlist
+		if err := rows.Scan(
+			&playlist.ID,
+			&playlist.CreatedAt,
+			&playlist.UpdatedAt,
+			&playlist.Title,
+			&playlist.Description,
+			&playlist.UserID,
+		); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	query := `
+	UPDATE playlists
+	SET
+		title = ?,
+		description = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, playlist.Title, playlist.Description, playlist.ID)
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM playlist_videos WHERE playlist_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM playlists WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistVideos returns the playlist's videos in playlist order.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN playlist_videos pv ON pv.video_id = videos.id
+	WHERE pv.playlist_id = ?
+	ORDER BY pv.position
+	`
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// AddPlaylistVideo appends a video to the end of a playlist. Adding a video
+// that's already in the playlist is a no-op.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID) error {
+	query := `
+	INSERT INTO playlist_videos (playlist_id, video_id, position)
+	SELECT CAST(? AS TEXT), CAST(? AS TEXT), COALESCE(MAX(position), -1) + 1
+	FROM playlist_videos
+	WHERE playlist_id = ?
+	ON CONFLICT DO NOTHING
+	`
+	_, err := c.db.Exec(query, playlistID, videoID, playlistID)
+	if err != nil {
+		return err
+	}
+	return c.touchPlaylist(playlistID)
+}
+
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) error {
+	query := `
+	DELETE FROM playlist_videos
+	WHERE playlist_id = ? AND video_id = ?
+	`
+	_, err := c.db.Exec(query, playlistID, videoID)
+	if err != nil {
+		return err
+	}
+	return c.touchPlaylist(playlistID)
+}
+
+// ReorderPlaylist sets the playlist order. videoIDs must contain exactly the
+// videos currently in the playlist.
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM playlist_videos WHERE playlist_id = ?`, playlistID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(videoIDs) {
+		return ErrPlaylistOrderMismatch
+	}
+	seen := make(map[uuid.UUID]bool, len(videoIDs))
+	for _, videoID := range videoIDs {
+		if seen[videoID] {
+			return ErrPlaylistOrderMismatch
+		}
+		seen[videoID] = true
+	}
+
+	query := `
+	UPDATE playlist_videos
+	SET position = ?
+	WHERE playlist_id = ? AND video_id = ?
+	`
+	for position, videoID := range videoIDs {
+		res, err := tx.Exec(query, position, playlistID, videoID)
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated != 1 {
+			return ErrPlaylistOrderMismatch
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) touchPlaylist(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
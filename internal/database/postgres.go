@@ -0,0 +1,315 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// PoolSettings size a Postgres client's connection pool. Instances share
+// the server's connection limit, so each should keep to its share.
+type PoolSettings struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// NewPostgresClient connects to the Postgres database at dsn, a postgres://
+// URL or key=value connection string, and brings its schema up to date.
+// Unlike a SQLite file it can be shared by several instances of the server.
+func NewPostgresClient(dsn string, pool PoolSettings) (Client, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return Client{}, err
+	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return Client{}, fmt.Errorf("couldn't reach Postgres: %w", err)
+	}
+
+	// search falls back to LIKE matching, FTS5 is SQLite's
+	c := Client{db: conn{DB: db, dialect: postgresDialect{}}}
+	if err := c.migrate(); err != nil {
+		db.Close()
+		return Client{}, err
+	}
+	return c, nil
+}
+
+// postgresNow stands in for CURRENT_TIMESTAMP, storing times the way SQLite
+// does: in UTC and to the second, which paging cursors rely on.
+const postgresNow = `date_trunc('second', CURRENT_TIMESTAMP AT TIME ZONE 'UTC')`
+
+type postgresDialect struct{}
+
+// rebind numbers the placeholders, $1, $2 and so on.
+func (postgresDialect) rebind(query string) string {
+	return rewriteSQL(query, func(token string, n int) string {
+		switch token {
+		case "?":
+			return "$" + strconv.Itoa(n)
+		case "CURRENT_TIMESTAMP":
+			return postgresNow
+		}
+		return token
+	})
+}
+
+func (postgresDialect) baseline(tx *transaction) error {
+	_, err := tx.Exec(postgresBaseline)
+	return err
+}
+
+func (postgresDialect) lockMigrations(tx *transaction) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('tubely schema_migrations'))`)
+	return err
+}
+
+// TRUNCATE doesn't fire the row triggers that keep the log append-only.
+func (postgresDialect) resetAuditLog() string {
+	return `TRUNCATE audit_log`
+}
+
+// postgresBaseline is the schema migrateBaseline builds in SQLite. Foreign
+// keys are left out: SQLite doesn't enforce them, and rows are deleted in
+// an order that relies on that. audit_log gets an explicit rowid, which
+// SQLite tables have built in, to keep entries of the same second in order.
+const postgresBaseline = `
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	tier TEXT NOT NULL DEFAULT 'free',
+	quota_alert_level BIGINT NOT NULL DEFAULT 0,
+	is_admin BIGINT NOT NULL DEFAULT 0,
+	role TEXT NOT NULL DEFAULT 'creator',
+	role_changed_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	family_id TEXT NOT NULL DEFAULT '',
+	replaced_by TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT,
+	projection TEXT,
+	visibility TEXT NOT NULL DEFAULT 'public',
+	ready_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	default_language TEXT NOT NULL DEFAULT '',
+	original_filename TEXT NOT NULL DEFAULT '',
+	view_count BIGINT NOT NULL DEFAULT 0,
+	version BIGINT NOT NULL DEFAULT 0,
+	last_version BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS videos_user_created ON videos(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS videos_user_ready ON videos(user_id, ready_at, id);
+CREATE TABLE IF NOT EXISTS renditions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	video_url TEXT NOT NULL,
+	frame_rate DOUBLE PRECISION NOT NULL,
+	source_frame_rate DOUBLE PRECISION NOT NULL,
+	frame_rate_mode TEXT NOT NULL,
+	size BIGINT NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL DEFAULT '',
+	fixity_status TEXT NOT NULL DEFAULT '',
+	fixity_checked_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS video_tags (
+	video_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(video_id, tag)
+);
+CREATE INDEX IF NOT EXISTS video_tags_tag ON video_tags(tag);
+CREATE TABLE IF NOT EXISTS video_views (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	viewer_key TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS video_views_viewer ON video_views(video_id, viewer_key, created_at);
+CREATE INDEX IF NOT EXISTS video_views_video ON video_views(video_id, created_at);
+CREATE TABLE IF NOT EXISTS video_traffic (
+	video_id TEXT NOT NULL,
+	hour TIMESTAMP NOT NULL,
+	bytes BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY(video_id, hour)
+);
+CREATE TABLE IF NOT EXISTS content_objects (
+	user_id TEXT NOT NULL,
+	sha256 TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	object_key TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	projection TEXT,
+	frame_rate DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY(user_id, sha256, size)
+);
+CREATE TABLE IF NOT EXISTS playlists (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	user_id TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS playlist_videos (
+	playlist_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	position BIGINT NOT NULL,
+	PRIMARY KEY(playlist_id, video_id)
+);
+CREATE TABLE IF NOT EXISTS integrations (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	webhook_url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	template TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT UNIQUE NOT NULL,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	email TEXT NOT NULL,
+	PRIMARY KEY(provider, subject)
+);
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT
+);
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id TEXT NOT NULL,
+	key TEXT NOT NULL,
+	request_path TEXT NOT NULL,
+	status_code BIGINT NOT NULL DEFAULT 0,
+	response_body BYTEA,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(user_id, key)
+);
+CREATE TABLE IF NOT EXISTS organizations (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS organization_members (
+	user_id TEXT PRIMARY KEY,
+	organization_id TEXT NOT NULL,
+	role TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS organization_upload_policies (
+	organization_id TEXT PRIMARY KEY,
+	visibility TEXT NOT NULL DEFAULT '',
+	profile TEXT NOT NULL DEFAULT '',
+	tags TEXT NOT NULL DEFAULT '',
+	retention_days BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS video_localizations (
+	video_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(video_id, language)
+);
+CREATE TABLE IF NOT EXISTS video_versions (
+	video_id TEXT NOT NULL,
+	version BIGINT NOT NULL,
+	kind TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	projection TEXT,
+	original_filename TEXT NOT NULL DEFAULT '',
+	video_url TEXT NOT NULL,
+	frame_rate DOUBLE PRECISION NOT NULL,
+	source_frame_rate DOUBLE PRECISION NOT NULL,
+	frame_rate_mode TEXT NOT NULL,
+	size BIGINT NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY(video_id, version, kind)
+);
+CREATE INDEX IF NOT EXISTS video_versions_url ON video_versions(video_url);
+CREATE TABLE IF NOT EXISTS audit_log (
+	rowid BIGSERIAL,
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	actor_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	method TEXT NOT NULL DEFAULT '',
+	path TEXT NOT NULL DEFAULT '',
+	status_code BIGINT NOT NULL DEFAULT 0,
+	request_id TEXT NOT NULL DEFAULT '',
+	detail TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	video_id TEXT NOT NULL DEFAULT '',
+	object_key TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log(actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_user ON audit_log(user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_action ON audit_log(action, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_video ON audit_log(video_id, created_at);
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TABLE IF NOT EXISTS video_tombstones (
+	video_id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	object_keys TEXT NOT NULL,
+	asset_paths TEXT NOT NULL,
+	step BIGINT NOT NULL DEFAULT 0,
+	attempts BIGINT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT
+);
+CREATE INDEX IF NOT EXISTS video_tombstones_due ON video_tombstones(next_attempt_at);
+`
@@ -7,30 +7,43 @@ import (
 	"github.com/google/uuid"
 )
 
+// RefreshToken is single use: refreshing replaces it with a new token in
+// the same family. A token that's used again after being replaced was
+// probably stolen, so its whole family is revoked.
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// ReplacedBy is the token this one was rotated into, if any
+	ReplacedBy string `json:"-"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// FamilyID links the tokens descended from one login. Empty starts a
+	// new family.
+	FamilyID string `json:"family_id"`
 }
 
-func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
-	query := `
+const insertRefreshToken = `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+
+func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if params.FamilyID == "" {
+		params.FamilyID = uuid.NewString()
+	}
+	_, err := c.db.Exec(insertRefreshToken, params.Token, params.UserID.String(), params.ExpiresAt, params.FamilyID)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -38,11 +51,43 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes old and creates next in its family. It
+// reports false, changing nothing, if old was already revoked, e.g. by a
+// concurrent refresh.
+func (c Client) RotateRefreshToken(old string, next CreateRefreshTokenParams) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token = ? AND revoked_at IS NULL
+	`, next.Token, old)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = tx.Exec(insertRefreshToken, next.Token, next.UserID.String(), next.ExpiresAt, next.FamilyID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RevokeRefreshToken revokes token and every other token in its family,
+// ending the session it belongs to.
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
-		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token = ?)
+		  AND revoked_at IS NULL
 	`
 	_, err := c.db.Exec(query, token)
 	return err
@@ -50,14 +95,14 @@ func (c Client) RevokeRefreshToken(token string) error {
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.FamilyID, &rt.ReplacedBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
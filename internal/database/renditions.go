@@ -0,0 +1,319 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rendition is one stored encoding of a video. Every upload produces a
+// "primary" rendition; processing profiles may add others such as "slowmo".
+type Rendition struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateRenditionParams
+	// FixityStatus is the outcome of the last scheduled integrity check,
+	// empty until the object has been checked
+	FixityStatus    string     `json:"fixity_status,omitempty"`
+	FixityCheckedAt *time.Time `json:"fixity_checked_at,omitempty"`
+}
+
+type CreateRenditionParams struct {
+	VideoID         uuid.UUID `json:"video_id"`
+	Kind            string    `json:"kind"`
+	VideoURL        string    `json:"video_url"`
+	FrameRate       float64   `json:"frame_rate"`
+	SourceFrameRate float64   `json:"source_frame_rate"`
+	FrameRateMode   string    `json:"frame_rate_mode"`
+	Size            int64     `json:"size"`
+	// SHA256 is the hex digest of the stored object, empty if it wasn't
+	// hashed on the way in (e.g. imports); fixity checks fill it in
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func (c Client) CreateRendition(params CreateRenditionParams) (Rendition, error) {
+	return createRendition(c.db, params)
+}
+
+func createRendition(db execer, params CreateRenditionParams) (Rendition, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO renditions (
+		id,
+		created_at,
+		video_id,
+		kind,
+		video_url,
+		frame_rate,
+		source_frame_rate,
+		frame_rate_mode,
+		size,
+		sha256
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := db.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.Kind,
+		params.VideoURL,
+		params.FrameRate,
+		params.SourceFrameRate,
+		params.FrameRateMode,
+		params.Size,
+		params.SHA256,
+	)
+	if err != nil {
+		return Rendition{}, err
+	}
+
+	return Rendition{
+		ID:                    id,
+		CreatedAt:             time.Now().UTC(),
+		CreateRenditionParams: params,
+	}, nil
+}
+
+// ErrVideoFileChanged is returned when a video's file was replaced while
+// something derived from the old one was being made.
+var ErrVideoFileChanged = errors.New("video's file changed")
+
+// CommitRendition adds rendition to its video, replacing one of the same
+// kind, if the video is still at version with videoURL as its file. The
+// kept version gets the rendition too, and the objects stored under keys
+// stop being pending, in one transaction. It returns the URL of the
+// rendition it replaced, "" if there was none.
+func (c Client) CommitRendition(version int, videoURL string, rendition CreateRenditionParams, keys []string) (string, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var currentVersion int
+	var currentURL sql.NullString
+	err = tx.QueryRow(`SELECT version, video_url FROM videos WHERE id = ?`, rendition.VideoID).Scan(&currentVersion, &currentURL)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && (currentVersion != version || currentURL.String != videoURL) {
+		return "", ErrVideoFileChanged
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var replaced string
+	err = tx.QueryRow(`SELECT video_url FROM renditions WHERE video_id = ? AND kind = ?`, rendition.VideoID, rendition.Kind).Scan(&replaced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if _, err := tx.Exec(`DELETE FROM renditions WHERE video_id = ? AND kind = ?`, rendition.VideoID, rendition.Kind); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO renditions (
+		id,
+		created_at,
+		video_id,
+		kind,
+		video_url,
+		frame_rate,
+		source_frame_rate,
+		frame_rate_mode,
+		size,
+		sha256
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		uuid.New(),
+		rendition.VideoID,
+		rendition.Kind,
+		rendition.VideoURL,
+		rendition.FrameRate,
+		rendition.SourceFrameRate,
+		rendition.FrameRateMode,
+		rendition.Size,
+		rendition.SHA256,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	// the version row takes the rest of its columns from the version's primary
+	_, err = tx.Exec(`DELETE FROM video_versions WHERE video_id = ? AND version = ? AND kind = ?`, rendition.VideoID, version, rendition.Kind)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO video_versions (
+		video_id,
+		version,
+		kind,
+		created_at,
+		projection,
+		width,
+		height,
+		duration,
+		bitrate,
+		loudness_target,
+		original_filename,
+		video_url,
+		frame_rate,
+		source_frame_rate,
+		frame_rate_mode,
+		size,
+		sha256
+	)
+	SELECT video_id, version, ?, created_at, projection, width, height, duration, bitrate, loudness_target, original_filename, ?, ?, ?, ?, ?, ?
+	FROM video_versions
+	WHERE video_id = ? AND version = ? AND kind = 'primary'
+	`,
+		rendition.Kind,
+		rendition.VideoURL,
+		rendition.FrameRate,
+		rendition.SourceFrameRate,
+		rendition.FrameRateMode,
+		rendition.Size,
+		rendition.SHA256,
+		rendition.VideoID,
+		version,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		if _, err := tx.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, key); err != nil {
+			return "", err
+		}
+	}
+	return replaced, tx.Commit()
+}
+
+func (c Client) GetRenditions(videoID uuid.UUID) ([]Rendition, error) {
+	query := `
+	SELECT ` + renditionColumns + `
+	FROM renditions
+	WHERE video_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRenditions(rows)
+}
+
+const renditionColumns = `
+		id,
+		created_at,
+		video_id,
+		kind,
+		video_url,
+		frame_rate,
+		source_frame_rate,
+		frame_rate_mode,
+		size,
+		sha256,
+		fixity_status,
+		fixity_checked_at`
+
+func scanRenditions(rows *sql.Rows) ([]Rendition, error) {
+	defer rows.Close()
+
+	renditions := []Rendition{}
+	for rows.Next() {
+		var rendition Rendition
+		if err := rows.Scan(
+			&rendition.ID,
+			&rendition.CreatedAt,
+			&rendition.VideoID,
+			&rendition.Kind,
+			&rendition.VideoURL,
+			&rendition.FrameRate,
+			&rendition.SourceFrameRate,
+			&rendition.FrameRateMode,
+			&rendition.Size,
+			&rendition.SHA256,
+			&rendition.FixityStatus,
+			&rendition.FixityCheckedAt,
+		); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, rendition)
+	}
+	return renditions, rows.Err()
+}
+
+// GetPrimarySizes returns the size of each of videoIDs' primary rendition,
+// the file clients are given. Videos without one are left out.
+func (c Client) GetPrimarySizes(videoIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	sizes := map[uuid.UUID]int64{}
+	if len(videoIDs) == 0 {
+		return sizes, nil
+	}
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT video_id, size
+	FROM renditions
+	WHERE kind = 'primary'
+	AND video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			videoID uuid.UUID
+			size    int64
+		)
+		if err := rows.Scan(&videoID, &size); err != nil {
+			return nil, err
+		}
+		sizes[videoID] = size
+	}
+	return sizes, rows.Err()
+}
+
+func (c Client) DeleteRenditions(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM renditions
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
+
+// GetStorageUsage totals the size of the objects behind a user's
+// renditions and kept versions. Objects shared by several of the user's
+// videos count once.
+func (c Client) GetStorageUsage(userID uuid.UUID) (int64, error) {
+	query := `
+	SELECT COALESCE(SUM(size), 0)
+	FROM (
+		SELECT video_url, MAX(size) AS size
+		FROM (
+			SELECT r.video_url, r.size
+			FROM renditions r
+			JOIN videos v ON v.id = r.video_id
+			WHERE v.user_id = ?
+			UNION ALL
+			SELECT vv.video_url, vv.size
+			FROM video_versions vv
+			JOIN videos v ON v.id = vv.video_id
+			WHERE v.user_id = ?
+		) AS stored
+		GROUP BY video_url
+	) AS objects
+	`
+	var usage int64
+	err := c.db.QueryRow(query, userID, userID).Scan(&usage)
+	return usage, err
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// searchTriggers keep the FTS5 indexes in step with the tables they index.
+var searchTriggers = []string{
+	"videos_fts_insert",
+	"videos_fts_delete",
+	"videos_fts_update",
+	"transcripts_fts_insert",
+	"transcripts_fts_delete",
+	"transcripts_fts_update",
+}
+
+// migrateSearch sets up the FTS5 indexes over video titles and
+// descriptions and over transcripts. SQLite builds without FTS5 fall back
+// to LIKE matching in SearchVideos.
+func (c *Client) migrateSearch() error {
+	available, err := c.fts5Available()
+	if err != nil {
+		return err
+	}
+	if !available {
+		// a database indexed by a build with FTS5 keeps its triggers,
+		// which would fail every write to videos and transcripts here
+		return c.dropSearchTriggers()
+	}
+	if err := c.migrateVideoSearch(); err != nil {
+		return err
+	}
+	if err := c.migrateTranscriptSearch(); err != nil {
+		return err
+	}
+	c.fts = true
+	return nil
+}
+
+// fts5Available reports whether this SQLite build includes FTS5.
+func (c *Client) fts5Available() (bool, error) {
+	var found int
+	err := c.db.QueryRow(`SELECT 1 FROM pragma_module_list WHERE name = 'fts5'`).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Client) dropSearchTriggers() error {
+	for _, name := range searchTriggers {
+		if _, err := c.db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) tableExists(name string) (bool, error) {
+	return c.schemaExists("table", name)
+}
+
+func (c *Client) triggerExists(name string) (bool, error) {
+	return c.schemaExists("trigger", name)
+}
+
+func (c *Client) schemaExists(kind, name string) (bool, error) {
+	var existing string
+	err := c.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = ? AND name = ?`, kind, name).Scan(&existing)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// migrateVideoSearch creates the index over video titles and descriptions
+// if it's missing, and rebuilds it if its triggers were dropped by a build
+// without FTS5. It's only called once FTS5 is known to be available.
+func (c *Client) migrateVideoSearch() error {
+	exists, err := c.tableExists("videos_fts")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		ftsTable := `
+		CREATE VIRTUAL TABLE videos_fts USING fts5(
+			title,
+			description,
+			content='videos',
+			content_rowid='rowid'
+		);
+		`
+		if _, err := c.db.Exec(ftsTable); err != nil {
+			return err
+		}
+	}
+	indexed, err := c.triggerExists("videos_fts_insert")
+	if err != nil || indexed {
+		return err
+	}
+
+	triggers := `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_insert AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts(rowid, title, description) VALUES (new.rowid, new.title, new.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_delete AFTER DELETE ON videos BEGIN
+		INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', old.rowid, old.title, old.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+		INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', old.rowid, old.title, old.description);
+		INSERT INTO videos_fts(rowid, title, description) VALUES (new.rowid, new.title, new.description);
+	END;
+	`
+	_, err = c.db.Exec(triggers)
+	if err != nil {
+		return err
+	}
+
+	// index any videos written while the index wasn't kept up
+	_, err = c.db.Exec(`INSERT INTO videos_fts(videos_fts) VALUES ('rebuild')`)
+	return err
+}
+
+// migrateTranscriptSearch is migrateVideoSearch for transcripts.
+func (c *Client) migrateTranscriptSearch() error {
+	exists, err := c.tableExists("transcripts_fts")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		ftsTable := `
+		CREATE VIRTUAL TABLE transcripts_fts USING fts5(
+			text,
+			content='transcripts',
+			content_rowid='rowid'
+		);
+		`
+		if _, err := c.db.Exec(ftsTable); err != nil {
+			return err
+		}
+	}
+	indexed, err := c.triggerExists("transcripts_fts_insert")
+	if err != nil || indexed {
+		return err
+	}
+
+	script := `
+	CREATE TRIGGER IF NOT EXISTS transcripts_fts_insert AFTER INSERT ON transcripts BEGIN
+		INSERT INTO transcripts_fts(rowid, text) VALUES (new.rowid, new.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS transcripts_fts_delete AFTER DELETE ON transcripts BEGIN
+		INSERT INTO transcripts_fts(transcripts_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS transcripts_fts_update AFTER UPDATE OF text ON transcripts BEGIN
+		INSERT INTO transcripts_fts(transcripts_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+		INSERT INTO transcripts_fts(rowid, text) VALUES (new.rowid, new.text);
+	END;
+	INSERT INTO transcripts_fts(transcripts_fts) VALUES ('rebuild');
+	`
+	_, err = c.db.Exec(script)
+	return err
+}
+
+// SearchVideos returns a user's videos whose title and description, or
+// whose transcript, contain every term in query, treating each term as a
+// prefix. Results are ranked by relevance when full-text search is
+// available.
+func (c Client) SearchVideos(userID uuid.UUID, query string, limit int) ([]Video, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return []Video{}, nil
+	}
+
+	if !c.fts {
+		return c.searchVideosLike(userID, terms, limit)
+	}
+
+	// quote every term so user input can't inject FTS query syntax
+	matchTerms := make([]string, 0, len(terms))
+	for _, term := range terms {
+		matchTerms = append(matchTerms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+
+	sqlQuery := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN (
+		SELECT match_rowid, MIN(rank) AS rank
+		FROM (
+			SELECT rowid AS match_rowid, bm25(videos_fts) AS rank
+			FROM videos_fts
+			WHERE videos_fts MATCH ?
+			UNION ALL
+			SELECT v.rowid AS match_rowid, bm25(transcripts_fts) AS rank
+			FROM transcripts_fts
+			JOIN transcripts t ON t.rowid = transcripts_fts.rowid
+			JOIN videos v ON v.id = t.video_id
+			WHERE transcripts_fts MATCH ?
+		)
+		GROUP BY match_rowid
+	) matches ON videos.rowid = matches.match_rowid
+	WHERE user_id = ?
+	ORDER BY matches.rank, created_at DESC
+	LIMIT ?
+	`
+	match := strings.Join(matchTerms, " ")
+	rows, err := c.db.Query(sqlQuery, match, match, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+func (c Client) searchVideosLike(userID uuid.UUID, terms []string, limit int) ([]Video, error) {
+	sqlQuery := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?`
+	args := []any{userID}
+	for _, term := range terms {
+		sqlQuery += `
+		AND (LOWER(title) LIKE LOWER(?) ESCAPE '\' OR LOWER(description) LIKE LOWER(?) ESCAPE '\')`
+		pattern := "%" + escapeLike(term) + "%"
+		args = append(args, pattern, pattern)
+	}
+	// a transcript matches on its own, like in the full-text index
+	sqlQuery += `
+	OR user_id = ? AND EXISTS (
+		SELECT 1 FROM transcripts
+		WHERE video_id = videos.id`
+	args = append(args, userID)
+	for _, term := range terms {
+		sqlQuery += `
+		AND LOWER(text) LIKE LOWER(?) ESCAPE '\'`
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+	sqlQuery += `
+	)`
+	sqlQuery += `
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	args = append(args, limit)
+
+	rows, err := c.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSearchWithoutFTS5AfterIndexing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.fts {
+		t.Skip("this build includes FTS5")
+	}
+	// what a build with FTS5 leaves behind: the trigger needs the fts5
+	// module to run, so without it every insert into videos fails
+	_, err = c.db.Exec(`
+	CREATE TABLE videos_fts (title TEXT, description TEXT);
+	CREATE TRIGGER videos_fts_insert AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', new.rowid, new.title, new.description);
+	END;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.CreateUser(CreateUserParams{Email: "owner@example.com", Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "Boot camp recap", UserID: user.ID, Visibility: "private"})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	found, err := c.SearchVideos(user.ID, "camp", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != video.ID {
+		t.Errorf("SearchVideos found %v, want %s", found, video.ID)
+	}
+}
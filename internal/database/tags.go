@@ -0,0 +1,59 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// AddVideoTags attaches tags to a video, ignoring ones it already has.
+func (c Client) AddVideoTags(videoID uuid.UUID, tags []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO video_tags (video_id, tag, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT DO NOTHING
+	`
+	for _, tag := range tags {
+		if _, err := tx.Exec(query, videoID, tag); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) RemoveVideoTag(videoID uuid.UUID, tag string) error {
+	query := `
+	DELETE FROM video_tags
+	WHERE video_id = ? AND tag = ?
+	`
+	_, err := c.db.Exec(query, videoID, tag)
+	return err
+}
+
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT tag
+	FROM video_tags
+	WHERE video_id = ?
+	ORDER BY tag
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tombstone records a deleted video whose stored files still have to be
+// cleaned up. The video's rows are gone as soon as it's created; a worker
+// then works through the cleanup steps, retrying each until it succeeds.
+type Tombstone struct {
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	// StorageBucket is the video's, see Video.StorageBucket
+	StorageBucket string
+	// ObjectKeys are the S3 objects the video referenced
+	ObjectKeys []string
+	// AssetPaths are the local assets, like thumbnails, it referenced
+	AssetPaths []string
+	// Step is the index of the next cleanup step to run
+	Step          int
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+}
+
+// CreateTombstone deletes a video and records what it referenced in one
+// transaction, so the files can't be forgotten if the cleanup fails.
+func (c Client) CreateTombstone(video Video, objectKeys, assetPaths []string) error {
+	keys, err := json.Marshal(objectKeys)
+	if err != nil {
+		return err
+	}
+	assets, err := json.Marshal(assetPaths)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO video_tombstones (
+		video_id,
+		user_id,
+		created_at,
+		storage_bucket,
+		object_keys,
+		asset_paths,
+		next_attempt_at
+	) VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	if _, err := tx.Exec(query, video.ID, video.UserID, video.StorageBucket, string(keys), string(assets)); err != nil {
+		return err
+	}
+	if err := deleteVideoRows(tx, video.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const tombstoneColumns = `
+		video_id,
+		user_id,
+		created_at,
+		storage_bucket,
+		object_keys,
+		asset_paths,
+		step,
+		attempts,
+		next_attempt_at,
+		last_error`
+
+// GetDueTombstones returns up to limit tombstones whose next cleanup
+// attempt is due, oldest first.
+func (c Client) GetDueTombstones(limit int) ([]Tombstone, error) {
+	query := `
+	SELECT` + tombstoneColumns + `
+	FROM video_tombstones
+	WHERE next_attempt_at <= CURRENT_TIMESTAMP
+	ORDER BY created_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanTombstones(rows)
+}
+
+// GetAllTombstones returns every tombstone that hasn't been cleaned up
+// yet, due or not.
+func (c Client) GetAllTombstones() ([]Tombstone, error) {
+	query := `
+	SELECT` + tombstoneColumns + `
+	FROM video_tombstones
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanTombstones(rows)
+}
+
+// GetTombstone returns the tombstone of a deleted video, or nil once it's
+// been cleaned up.
+func (c Client) GetTombstone(videoID uuid.UUID) (*Tombstone, error) {
+	query := `
+	SELECT` + tombstoneColumns + `
+	FROM video_tombstones
+	WHERE video_id = ?
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	tombstones, err := scanTombstones(rows)
+	if err != nil || len(tombstones) == 0 {
+		return nil, err
+	}
+	return &tombstones[0], nil
+}
+
+func scanTombstones(rows *sql.Rows) ([]Tombstone, error) {
+	defer rows.Close()
+
+	tombstones := []Tombstone{}
+	for rows.Next() {
+		var t Tombstone
+		var keys, assets string
+		var lastError sql.NullString
+		if err := rows.Scan(
+			&t.VideoID,
+			&t.UserID,
+			&t.CreatedAt,
+			&t.StorageBucket,
+			&keys,
+			&assets,
+			&t.Step,
+			&t.Attempts,
+			&t.NextAttemptAt,
+			&lastError,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(keys), &t.ObjectKeys); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(assets), &t.AssetPaths); err != nil {
+			return nil, err
+		}
+		if lastError.Valid {
+			t.LastError = &lastError.String
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, rows.Err()
+}
+
+// AdvanceTombstone records that the steps before step are done.
+func (c Client) AdvanceTombstone(videoID uuid.UUID, step int) error {
+	_, err := c.db.Exec(`
+	UPDATE video_tombstones
+	SET step = ?, attempts = 0, last_error = NULL
+	WHERE video_id = ?
+	`, step, videoID)
+	return err
+}
+
+// RetryTombstone records a failed cleanup attempt and when to try again.
+func (c Client) RetryTombstone(videoID uuid.UUID, attempts int, nextAttemptAt time.Time, lastError string) error {
+	_, err := c.db.Exec(`
+	UPDATE video_tombstones
+	SET attempts = ?, next_attempt_at = ?, last_error = ?
+	WHERE video_id = ?
+	`, attempts, nextAttemptAt.UTC().Format("2006-01-02 15:04:05"), lastError, videoID)
+	return err
+}
+
+func (c Client) DeleteTombstone(videoID uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM video_tombstones WHERE video_id = ?`, videoID)
+	return err
+}
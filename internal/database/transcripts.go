@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transcript is the text spoken in a video, as transcribed from its audio.
+type Transcript struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	Language string    `json:"language"`
+	// Provider is the transcription service that produced it
+	Provider  string    `json:"provider"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommitTranscript stores the video's transcript, replacing the one it had,
+// if videoURL is still the video's file. Captions made from the transcript
+// are stored too, unless the owner uploaded captions in that language,
+// and the object at key stops being pending. caption may be nil if the
+// transcription found nothing to caption. It returns the URL of the
+// caption it replaced, "" if there was none, and whether the caption was
+// stored; if it wasn't, the object at key is the caller's to discard.
+func (c Client) CommitTranscript(videoURL string, transcript Transcript, caption *Caption, key string) (string, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	var currentURL sql.NullString
+	err = tx.QueryRow(`SELECT video_url FROM videos WHERE id = ?`, transcript.VideoID).Scan(&currentURL)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && currentURL.String != videoURL {
+		return "", false, ErrVideoFileChanged
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO transcripts (video_id, language, provider, text, created_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (video_id) DO UPDATE SET
+		language = excluded.language,
+		provider = excluded.provider,
+		text = excluded.text,
+		created_at = excluded.created_at
+	`, transcript.VideoID, transcript.Language, transcript.Provider, transcript.Text)
+	if err != nil {
+		return "", false, err
+	}
+
+	if caption == nil {
+		return "", false, tx.Commit()
+	}
+	var existingSource string
+	err = tx.QueryRow(`SELECT source FROM captions WHERE video_id = ? AND language = ?`, caption.VideoID, caption.Language).Scan(&existingSource)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", false, err
+	}
+	// captions the owner uploaded are better than generated ones
+	if existingSource == CaptionSourceUpload {
+		return "", false, tx.Commit()
+	}
+	replaced, err := upsertCaption(tx, *caption)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_objects WHERE object_key = ?`, key); err != nil {
+		return "", false, err
+	}
+	return replaced, true, tx.Commit()
+}
+
+// GetTranscript returns the video's transcript, a zero Transcript if it
+// has none.
+func (c Client) GetTranscript(videoID uuid.UUID) (Transcript, error) {
+	var transcript Transcript
+	err := c.db.QueryRow(`
+	SELECT video_id, language, provider, text, created_at
+	FROM transcripts
+	WHERE video_id = ?
+	`, videoID).Scan(&transcript.VideoID, &transcript.Language, &transcript.Provider, &transcript.Text, &transcript.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transcript{}, nil
+	}
+	return transcript, err
+}
@@ -12,6 +12,16 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Tier selects per-user limits such as the maximum upload size
+	Tier string `json:"tier"`
+	// IsAdmin users can impersonate others for support
+	IsAdmin bool `json:"is_admin"`
+	// Role limits what the user can do; viewers can't change anything.
+	// Admins have the admin role whatever this says.
+	Role string `json:"role"`
+	// RoleChangedAt is when Role was last set. Access tokens issued before
+	// then carry the old role and are refused.
+	RoleChangedAt *time.Time `json:"-"`
 	CreateUserParams
 }
 
@@ -53,13 +63,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, tier, is_admin, role, role_changed_at
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Tier, &user.IsAdmin, &user.Role, &user.RoleChangedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -73,31 +83,6 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 	return user, nil
 }
 
-func (c Client) GetUserByRefreshToken(token string) (*User, error) {
-	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
-		FROM users u
-		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
-	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
-
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 	id := uuid.New()
 
@@ -117,13 +102,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, tier, is_admin, role, role_changed_at
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Tier, &user.IsAdmin, &user.Role, &user.RoleChangedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -145,3 +130,147 @@ func (c Client) DeleteUser(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+// GetQuotaAlertLevel returns the highest storage quota threshold, in
+// percent, the user has been warned about.
+func (c Client) GetQuotaAlertLevel(userID uuid.UUID) (int, error) {
+	var level int
+	err := c.db.QueryRow(`SELECT quota_alert_level FROM users WHERE id = ?`, userID.String()).Scan(&level)
+	return level, err
+}
+
+func (c Client) SetQuotaAlertLevel(userID uuid.UUID, level int) error {
+	_, err := c.db.Exec(`UPDATE users SET quota_alert_level = ? WHERE id = ?`, level, userID.String())
+	return err
+}
+
+// GetUserStorageRegion returns the AWS region the user's new videos are
+// stored in when buckets are sharded by region, "" if none was set.
+func (c Client) GetUserStorageRegion(userID uuid.UUID) (string, error) {
+	var region string
+	err := c.db.QueryRow(`SELECT storage_region FROM users WHERE id = ?`, userID.String()).Scan(&region)
+	return region, err
+}
+
+// SetUserStorageRegion sets the region the user's new videos are stored
+// in, "" for none.
+func (c Client) SetUserStorageRegion(userID uuid.UUID, region string) error {
+	_, err := c.db.Exec(`UPDATE users SET storage_region = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, region, userID.String())
+	return err
+}
+
+// SetUserRole changes a user's role, invalidating the access tokens that
+// carry the old one.
+func (c Client) SetUserRole(id uuid.UUID, role string) error {
+	_, err := c.db.Exec(`
+	UPDATE users
+	SET role = ?, role_changed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, role, id.String())
+	return err
+}
+
+// SyncAdmins makes the users with the given emails admins and everyone else
+// not, returning how many admins there are now. Users who gain or lose
+// admin have their role marked as changed.
+func (c Client) SyncAdmins(emails []string) (int, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// admins to be are marked 2 first, so the ones left at 1 are those
+	// losing admin
+	admins := 0
+	for _, email := range emails {
+		res, err := tx.Exec(`
+		UPDATE users
+		SET role_changed_at = CASE WHEN is_admin = 0 THEN CURRENT_TIMESTAMP ELSE role_changed_at END,
+			is_admin = 2
+		WHERE email = ?
+		`, email)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		admins += int(n)
+	}
+	if _, err := tx.Exec(`UPDATE users SET is_admin = 0, role_changed_at = CURRENT_TIMESTAMP WHERE is_admin = 1`); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET is_admin = 1 WHERE is_admin = 2`); err != nil {
+		return 0, err
+	}
+	return admins, tx.Commit()
+}
+
+// UserOverview is a user as admins see them in the user list.
+type UserOverview struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Email      string    `json:"email"`
+	Tier       string    `json:"tier"`
+	IsAdmin    bool      `json:"is_admin"`
+	Role       string    `json:"role"`
+	VideoCount int       `json:"video_count"`
+}
+
+// GetUsersPageParams selects one page of all users, newest first, in the
+// same (BeforeCreatedAt, BeforeID) order as GetVideosPageParams.
+type GetUsersPageParams struct {
+	Limit           int
+	BeforeCreatedAt time.Time
+	BeforeID        uuid.UUID
+}
+
+func (c Client) GetUsersPage(params GetUsersPageParams) ([]UserOverview, error) {
+	query := `
+	SELECT
+		u.id,
+		u.created_at,
+		u.email,
+		u.tier,
+		u.is_admin,
+		u.role,
+		(SELECT COUNT(*) FROM videos v WHERE v.user_id = u.id)
+	FROM users u`
+	args := []any{}
+
+	if !params.BeforeCreatedAt.IsZero() {
+		before := params.BeforeCreatedAt.UTC().Format("2006-01-02 15:04:05")
+		query += `
+	WHERE u.created_at < ? OR (u.created_at = ? AND u.id < ?)`
+		args = append(args, before, before, params.BeforeID.String())
+	}
+
+	query += `
+	ORDER BY u.created_at DESC, u.id DESC
+	LIMIT ?
+	`
+	args = append(args, params.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserOverview{}
+	for rows.Next() {
+		var user UserOverview
+		var id string
+		if err := rows.Scan(&id, &user.CreatedAt, &user.Email, &user.Tier, &user.IsAdmin, &user.Role, &user.VideoCount); err != nil {
+			return nil, err
+		}
+		user.ID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
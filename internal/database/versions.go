@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoVersion is one upload of a video's file, kept so the video can be
+// rolled back to it. The video's current file is a version too.
+type VideoVersion struct {
+	VideoID          uuid.UUID `json:"video_id"`
+	Version          int       `json:"version"`
+	CreatedAt        time.Time `json:"created_at"`
+	Projection       *string   `json:"projection"`
+	Width            *int      `json:"width"`
+	Height           *int      `json:"height"`
+	Duration         *float64  `json:"duration"`
+	Bitrate          *int64    `json:"bitrate"`
+	LoudnessTarget   *float64  `json:"loudness_target"`
+	OriginalFilename string    `json:"original_filename"`
+	// Size and SHA256 describe the primary rendition
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Renditions are what the video had with this version, primary first
+	Renditions []CreateRenditionParams `json:"-"`
+}
+
+// ReserveVideoVersion returns the next version number of videoID. Numbers
+// are never reused, even if the upload they were reserved for fails.
+func (c Client) ReserveVideoVersion(videoID uuid.UUID) (int, error) {
+	var version int
+	err := c.db.QueryRow(`
+	UPDATE videos SET last_version = last_version + 1
+	WHERE id = ?
+	RETURNING last_version
+	`, videoID).Scan(&version)
+	return version, err
+}
+
+// SaveVideoVersion records a version of a video's file.
+func (c Client) SaveVideoVersion(version VideoVersion) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveVideoVersion(tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveVideoVersion(db execer, version VideoVersion) error {
+	for _, rendition := range version.Renditions {
+		_, err := db.Exec(`
+		INSERT INTO video_versions (
+			video_id,
+			version,
+			kind,
+			created_at,
+			projection,
+			width,
+			height,
+			duration,
+			bitrate,
+			loudness_target,
+			original_filename,
+			video_url,
+			frame_rate,
+			source_frame_rate,
+			frame_rate_mode,
+			size,
+			sha256
+		) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			version.VideoID,
+			version.Version,
+			rendition.Kind,
+			version.Projection,
+			version.Width,
+			version.Height,
+			version.Duration,
+			version.Bitrate,
+			version.LoudnessTarget,
+			version.OriginalFilename,
+			rendition.VideoURL,
+			rendition.FrameRate,
+			rendition.SourceFrameRate,
+			rendition.FrameRateMode,
+			rendition.Size,
+			rendition.SHA256,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetVideoVersions returns videoID's versions, newest first.
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	rows, err := c.db.Query(`
+	SELECT
+		video_id,
+		version,
+		kind,
+		created_at,
+		projection,
+		width,
+		height,
+		duration,
+		bitrate,
+		loudness_target,
+		original_filename,
+		video_url,
+		frame_rate,
+		source_frame_rate,
+		frame_rate_mode,
+		size,
+		sha256
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY version DESC, kind = 'primary' DESC, kind
+	`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		var (
+			version   VideoVersion
+			rendition CreateRenditionParams
+		)
+		if err := rows.Scan(
+			&version.VideoID,
+			&version.Version,
+			&rendition.Kind,
+			&version.CreatedAt,
+			&version.Projection,
+			&version.Width,
+			&version.Height,
+			&version.Duration,
+			&version.Bitrate,
+			&version.LoudnessTarget,
+			&version.OriginalFilename,
+			&rendition.VideoURL,
+			&rendition.FrameRate,
+			&rendition.SourceFrameRate,
+			&rendition.FrameRateMode,
+			&rendition.Size,
+			&rendition.SHA256,
+		); err != nil {
+			return nil, err
+		}
+		rendition.VideoID = version.VideoID
+		if n := len(versions); n > 0 && versions[n-1].Version == version.Version {
+			versions[n-1].Renditions = append(versions[n-1].Renditions, rendition)
+			continue
+		}
+		version.Size = rendition.Size
+		version.SHA256 = rendition.SHA256
+		version.Renditions = []CreateRenditionParams{rendition}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// GetVideoVersion returns one version of videoID, or nil if there's no
+// such version.
+func (c Client) GetVideoVersion(videoID uuid.UUID, number int) (*VideoVersion, error) {
+	versions, err := c.GetVideoVersions(videoID)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version.Version == number {
+			return &version, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteVideoVersion forgets a version. Its objects are the caller's to
+// release.
+func (c Client) DeleteVideoVersion(videoID uuid.UUID, number int) error {
+	_, err := c.db.Exec(`DELETE FROM video_versions WHERE video_id = ? AND version = ?`, videoID, number)
+	return err
+}
+
+// SetCurrentVersion records which version a video's file is.
+func (c Client) SetCurrentVersion(videoID uuid.UUID, number int) error {
+	return setCurrentVersion(c.db, videoID, number)
+}
+
+func setCurrentVersion(db execer, videoID uuid.UUID, number int) error {
+	result, err := db.Exec(`UPDATE videos SET version = ? WHERE id = ?`, number, videoID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
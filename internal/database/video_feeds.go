@@ -0,0 +1,61 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoFeedParams selects the next batch of a user's videos in ascending
+// (timestamp, id) order, strictly after (AfterTime, AfterID). A zero
+// AfterTime starts from the beginning.
+type VideoFeedParams struct {
+	UserID    uuid.UUID
+	AfterTime time.Time
+	AfterID   uuid.UUID
+	Limit     int
+}
+
+// GetVideosCreatedAfter feeds videos in the order they were created.
+func (c Client) GetVideosCreatedAfter(params VideoFeedParams) ([]Video, error) {
+	return c.getVideoFeed("created_at", params)
+}
+
+// GetVideosReadyAfter feeds videos in the order they became playable.
+// Videos that never got a file are left out.
+func (c Client) GetVideosReadyAfter(params VideoFeedParams) ([]Video, error) {
+	return c.getVideoFeed("ready_at", params)
+}
+
+func (c Client) getVideoFeed(column string, params VideoFeedParams) ([]Video, error) {
+	// timestamps only have second precision, so rows from the current
+	// second are held back until it's over. Otherwise a video landing later
+	// in the same second with a lower id would sort before the cursor a
+	// poller already handed out and never be seen.
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+		AND ` + column + ` IS NOT NULL
+		AND ` + column + ` < CURRENT_TIMESTAMP`
+	args := []any{params.UserID}
+
+	if !params.AfterTime.IsZero() {
+		after := params.AfterTime.UTC().Format("2006-01-02 15:04:05")
+		query += `
+		AND (` + column + ` > ? OR (` + column + ` = ? AND id > ?))`
+		args = append(args, after, after, params.AfterID)
+	}
+
+	query += `
+	ORDER BY ` + column + ` ASC, id ASC
+	LIMIT ?
+	`
+	args = append(args, params.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
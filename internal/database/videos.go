@@ -3,29 +3,120 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"math"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	MediaKindVideo = "video"
+	// MediaKindAudio is an audio-only upload, such as a podcast episode
+	MediaKindAudio = "audio"
+)
+
 type Video struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// ThumbnailBlurhash is a BlurHash of the thumbnail for clients to show
+	// while it loads, nil if there's no thumbnail or it couldn't be read
+	ThumbnailBlurhash *string `json:"thumbnail_blurhash"`
+	VideoURL          *string `json:"video_url"`
+	Projection        *string `json:"projection"`
+	// Width and Height are the size the video is displayed at, with any
+	// rotation applied, if known
+	Width  *int `json:"width"`
+	Height *int `json:"height"`
+	// AspectRatio is Width divided by Height, see SetSize
+	AspectRatio *float64 `json:"aspect_ratio"`
+	// MediaKind is what the video's file is, MediaKindVideo or
+	// MediaKindAudio. A video without a file yet is MediaKindVideo.
+	MediaKind string `json:"media_kind"`
+	// Duration is the file's length in seconds, Bitrate its overall bit
+	// rate in bits per second, if known
+	Duration *float64 `json:"duration"`
+	Bitrate  *int64   `json:"bitrate"`
+	// LoudnessTarget is the integrated loudness, in LUFS, the file's audio
+	// was normalized to on upload, nil if it was stored as it came
+	LoudnessTarget *float64 `json:"loudness_target"`
+	// ReadyAt is when the video first got a playable file.
+	ReadyAt *time.Time `json:"ready_at"`
+	// ExpiresAt is when a retention policy deletes the video, if ever.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// OriginalFilename is the name the video's file was uploaded with, if
+	// known.
+	OriginalFilename string `json:"original_filename"`
+	// ViewCount is how many times playback was started, see RecordView
+	ViewCount int64 `json:"view_count"`
+	// Version is which of the video's versions its file is, 0 if it has
+	// none yet
+	Version int `json:"version"`
+	// Captions are only loaded for responses, see GetCaptionsForVideos
+	Captions []Caption `json:"captions"`
+	// Chapters are only loaded for responses, see GetChaptersForVideos
+	Chapters []Chapter `json:"chapters"`
+	// Clip is set if the video was cut from another one
+	Clip *Clip `json:"clip"`
+	// ModerationStatus is where the video's file is in moderation, one of
+	// the ModerationStatus constants, "" if it was never moderated
+	ModerationStatus string `json:"moderation_status"`
+	// ArchiveStatus is ArchiveArchived or ArchiveRestoring while the
+	// video's file is in archival storage, "" otherwise
+	ArchiveStatus string `json:"archive_status"`
+	// ArchivedAt is when the video's file was archived, if it is
+	ArchivedAt *time.Time `json:"archived_at"`
+	// PasswordHash is the hash of the password viewers other than the
+	// owner need, "" if there's none. It's only changed by
+	// SetVideoPassword.
+	PasswordHash string `json:"-"`
+	// PasswordProtected reports whether the video has a password
+	PasswordProtected bool `json:"password_protected"`
 	CreateVideoParams
 }
 
+// Clip records which part of which video a clip was cut from. The clip's
+// file is its own, so it outlives the source video.
+type Clip struct {
+	SourceVideoID uuid.UUID `json:"source_video_id"`
+	// Start and End are in seconds into the source video
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	Visibility  string    `json:"visibility"`
+	// DefaultLanguage is the language of Title and Description, if known.
+	DefaultLanguage string `json:"default_language"`
+	// StorageBucket is the bucket every one of the video's objects is
+	// stored in, "" for S3_BUCKET. It's picked when the video is created
+	// and never changes.
+	StorageBucket string `json:"-"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// GetVideosPageParams selects one page of a user's videos, newest first.
+// A zero BeforeCreatedAt starts at the most recent video; otherwise only
+// videos strictly older than (BeforeCreatedAt, BeforeID) are returned.
+// A non-empty Tag restricts the page to videos carrying that tag, and
+// PublicOnly hides unlisted, private and password protected videos along
+// with those held by moderation. A nil UserID pages through every user's videos, which only
+// admins get to do, and a non-empty ModerationStatus restricts the page to
+// videos in that state.
+type GetVideosPageParams struct {
+	UserID           uuid.UUID
+	Limit            int
+	BeforeCreatedAt  time.Time
+	BeforeID         uuid.UUID
+	Tag              string
+	PublicOnly       bool
+	ModerationStatus string
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,37 +124,151 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		projection,
+		width,
+		height,
+		media_kind,
+		duration,
+		bitrate,
+		loudness_target,
+		ready_at,
+		expires_at,
+		user_id,
+		visibility,
+		default_language,
+		original_filename,
+		view_count,
+		version,
+		clip_source_id,
+		clip_start,
+		clip_end,
+		moderation_status,
+		storage_bucket,
+		archive_status,
+		archived_at,
+		password_hash,
+		thumbnail_blurhash`
 
-	rows, err := c.db.Query(query, userID)
-	if err != nil {
-		return nil, err
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var clipSource uuid.NullUUID
+	var clipStart, clipEnd sql.NullFloat64
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.Projection,
+		&video.Width,
+		&video.Height,
+		&video.MediaKind,
+		&video.Duration,
+		&video.Bitrate,
+		&video.LoudnessTarget,
+		&video.ReadyAt,
+		&video.ExpiresAt,
+		&video.UserID,
+		&video.Visibility,
+		&video.DefaultLanguage,
+		&video.OriginalFilename,
+		&video.ViewCount,
+		&video.Version,
+		&clipSource,
+		&clipStart,
+		&clipEnd,
+		&video.ModerationStatus,
+		&video.StorageBucket,
+		&video.ArchiveStatus,
+		&video.ArchivedAt,
+		&video.PasswordHash,
+		&video.ThumbnailBlurhash,
+	)
+	video.PasswordProtected = video.PasswordHash != ""
+	video.SetSize(video.Width, video.Height)
+	if clipSource.Valid {
+		video.Clip = &Clip{SourceVideoID: clipSource.UUID, Start: clipStart.Float64, End: clipEnd.Float64}
+	}
+	return video, err
+}
+
+// SetSize sets the video's display size, keeping AspectRatio in step.
+func (v *Video) SetSize(width, height *int) {
+	v.Width, v.Height, v.AspectRatio = width, height, nil
+	if width != nil && height != nil && *height > 0 {
+		ratio := math.Round(float64(*width)/float64(*height)*10000) / 10000
+		v.AspectRatio = &ratio
 	}
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
 
-	return videos, nil
+func (c Client) GetVideosPage(params GetVideosPageParams) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE (? = '' OR user_id = ?)`
+	owner := ""
+	if params.UserID != uuid.Nil {
+		owner = params.UserID.String()
+	}
+	args := []any{owner, owner}
+
+	if !params.BeforeCreatedAt.IsZero() {
+		// created_at is stored in SQLite's CURRENT_TIMESTAMP text format, so
+		// compare against the same representation
+		before := params.BeforeCreatedAt.UTC().Format("2006-01-02 15:04:05")
+		query += `
+		AND (created_at < ? OR (created_at = ? AND id < ?))`
+		args = append(args, before, before, params.BeforeID)
+	}
+	if params.Tag != "" {
+		query += `
+		AND id IN (SELECT video_id FROM video_tags WHERE tag = ?)`
+		args = append(args, params.Tag)
+	}
+	if params.PublicOnly {
+		query += `
+		AND visibility = 'public'
+		AND password_hash = ''
+		AND moderation_status NOT IN (?, ?)`
+		args = append(args, ModerationPendingReview, ModerationRejected)
+	}
+	if params.ModerationStatus != "" {
+		query += `
+		AND moderation_status = ?`
+		args = append(args, params.ModerationStatus)
+	}
+
+	query += `
+	ORDER BY created_at DESC, id DESC
+	LIMIT ?
+	`
+	args = append(args, params.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +280,16 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility,
+		default_language,
+		storage_bucket
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if params.Visibility == "" {
+		params.Visibility = "public"
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.Visibility, params.DefaultLanguage, params.StorageBucket)
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,31 +297,61 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
-func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+// CreateClip creates a video for a clip of another one. The clip gets its
+// file like any other video.
+func (c Client) CreateClip(params CreateVideoParams, clip Clip) (Video, error) {
+	id := uuid.New()
 	query := `
-	SELECT
+	INSERT INTO videos (
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
-		thumbnail_url,
-		video_url,
-		user_id
+		user_id,
+		visibility,
+		default_language,
+		storage_bucket,
+		clip_source_id,
+		clip_start,
+		clip_end
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	if params.Visibility == "" {
+		params.Visibility = "public"
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.Visibility, params.DefaultLanguage, params.StorageBucket, clip.SourceVideoID, clip.Start, clip.End)
+	if err != nil {
+		return Video{}, err
+	}
+
+	return c.GetVideo(id)
+}
+
+// GetClips returns the clips cut from a video, newest first.
+func (c Client) GetClips(sourceID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE clip_source_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -122,34 +363,169 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	return updateVideo(c.db, video)
+}
+
+func updateVideo(db execer, video Video) error {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_blurhash = ?,
 		video_url = ?,
-		user_id = ?
+		projection = ?,
+		width = ?,
+		height = ?,
+		media_kind = ?,
+		duration = ?,
+		bitrate = ?,
+		loudness_target = ?,
+		ready_at = CASE WHEN CAST(? AS TEXT) IS NULL THEN NULL ELSE COALESCE(ready_at, CURRENT_TIMESTAMP) END,
+		user_id = ?,
+		visibility = ?,
+		default_language = ?,
+		original_filename = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	_, err := db.Exec(
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.ThumbnailBlurhash,
 		&video.VideoURL,
+		video.Projection,
+		video.Width,
+		video.Height,
+		video.MediaKind,
+		video.Duration,
+		video.Bitrate,
+		video.LoudnessTarget,
+		video.VideoURL,
 		video.UserID,
+		video.Visibility,
+		video.DefaultLanguage,
+		video.OriginalFilename,
 		video.ID,
 	)
 	return err
 }
 
+// GetVideosMissingBlurhash returns the videos with a thumbnail but no
+// BlurHash of it.
+func (c Client) GetVideosMissingBlurhash() ([]Video, error) {
+	rows, err := c.db.Query(`
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_url IS NOT NULL AND thumbnail_blurhash IS NULL
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// SetThumbnailBlurhash records the BlurHash of the video's thumbnail at
+// thumbnailURL, unless the thumbnail was replaced since.
+func (c Client) SetThumbnailBlurhash(id uuid.UUID, thumbnailURL, blurhash string) error {
+	_, err := c.db.Exec(`UPDATE videos SET thumbnail_blurhash = ? WHERE id = ? AND thumbnail_url = ?`, blurhash, id, thumbnailURL)
+	return err
+}
+
+// SetVideoExpiry schedules the video for deletion at expiresAt.
+func (c Client) SetVideoExpiry(id uuid.UUID, expiresAt time.Time) error {
+	_, err := c.db.Exec(
+		`UPDATE videos SET expires_at = ? WHERE id = ?`,
+		expiresAt.UTC().Format("2006-01-02 15:04:05"),
+		id,
+	)
+	return err
+}
+
+// SetVideoPassword sets the hash of the video's password, "" to remove
+// it.
+func (c Client) SetVideoPassword(id uuid.UUID, passwordHash string) error {
+	_, err := c.db.Exec(`UPDATE videos SET password_hash = ? WHERE id = ?`, passwordHash, id)
+	return err
+}
+
+// GetExpiredVideos returns up to limit videos whose retention has run out.
+func (c Client) GetExpiredVideos(limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP
+	ORDER BY expires_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	return deleteVideoRows(c.db, id)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// deleteVideoRows removes a video and everything that hangs off it.
+func deleteVideoRows(db execer, id uuid.UUID) error {
+	if _, err := db.Exec(`DELETE FROM renditions WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_tags WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_views WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_traffic WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_versions WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM playlist_videos WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM dead_letter_jobs WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM jobs WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_localizations WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM captions WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM transcripts WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM chapters WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM moderation_labels WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_fingerprints WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := db.Exec(query, id)
 	return err
 }
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoViewStats summarizes a video's views.
+type VideoViewStats struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	ViewCount int64     `json:"view_count"`
+	// UniqueViewers counts distinct viewers over Days
+	UniqueViewers int64        `json:"unique_viewers"`
+	Days          []DailyViews `json:"days"`
+}
+
+// DailyViews is the number of views on one UTC day.
+type DailyViews struct {
+	Date  string `json:"date"`
+	Views int64  `json:"views"`
+}
+
+// viewTimeFormat is how SQLite's CURRENT_TIMESTAMP stores created_at.
+const viewTimeFormat = "2006-01-02 15:04:05"
+
+// RecordView counts a view of videoID by viewerKey, unless the same viewer
+// already viewed it within dedupWindow. It reports whether the view was
+// counted.
+func (c Client) RecordView(videoID uuid.UUID, viewerKey string, dedupWindow time.Duration) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	cutoff := time.Now().UTC().Add(-dedupWindow).Format(viewTimeFormat)
+	var recent bool
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM video_views
+		WHERE video_id = ? AND viewer_key = ? AND created_at >= ?
+	)
+	`, videoID, viewerKey, cutoff).Scan(&recent)
+	if err != nil {
+		return false, err
+	}
+	if recent {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO video_views (id, created_at, video_id, viewer_key)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+	`, uuid.New(), videoID, viewerKey)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(`UPDATE videos SET view_count = view_count + 1 WHERE id = ?`, videoID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		// the video was deleted meanwhile
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetVideoViewStats summarizes the views of videoID since the given time,
+// along with its all-time view count.
+func (c Client) GetVideoViewStats(videoID uuid.UUID, since time.Time) (VideoViewStats, error) {
+	stats := VideoViewStats{VideoID: videoID, Days: []DailyViews{}}
+	err := c.db.QueryRow(`SELECT view_count FROM videos WHERE id = ?`, videoID).Scan(&stats.ViewCount)
+	if err != nil {
+		return VideoViewStats{}, err
+	}
+
+	cutoff := since.UTC().Format(viewTimeFormat)
+	err = c.db.QueryRow(`
+	SELECT COUNT(DISTINCT viewer_key)
+	FROM video_views
+	WHERE video_id = ? AND created_at >= ?
+	`, videoID, cutoff).Scan(&stats.UniqueViewers)
+	if err != nil {
+		return VideoViewStats{}, err
+	}
+
+	rows, err := c.db.Query(`
+	SELECT CAST(date(created_at) AS TEXT) AS day, COUNT(*)
+	FROM video_views
+	WHERE video_id = ? AND created_at >= ?
+	GROUP BY day
+	ORDER BY day
+	`, videoID, cutoff)
+	if err != nil {
+		return VideoViewStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day DailyViews
+		if err := rows.Scan(&day.Date, &day.Views); err != nil {
+			return VideoViewStats{}, err
+		}
+		stats.Days = append(stats.Days, day)
+	}
+	return stats, rows.Err()
+}
+
+// AddBytesServed adds bytes to what was sent of videoID in the hour of at.
+func (c Client) AddBytesServed(videoID uuid.UUID, at time.Time, bytes int64) error {
+	hour := at.UTC().Truncate(time.Hour).Format(viewTimeFormat)
+	_, err := c.db.Exec(`
+	INSERT INTO video_traffic (video_id, hour, bytes)
+	VALUES (?, ?, ?)
+	ON CONFLICT (video_id, hour) DO UPDATE SET bytes = video_traffic.bytes + excluded.bytes
+	`, videoID, hour, bytes)
+	return err
+}
+
+// VideoActivity is what happened to a video over some period.
+type VideoActivity struct {
+	Views         int64 `json:"views"`
+	UniqueViewers int64 `json:"unique_viewers"`
+	BytesServed   int64 `json:"bytes_served"`
+}
+
+// GetVideoActivity sums up videoID's views and traffic since the given
+// time. Traffic is kept per hour, so it includes all of since's hour.
+func (c Client) GetVideoActivity(videoID uuid.UUID, since time.Time) (VideoActivity, error) {
+	var activity VideoActivity
+	cutoff := since.UTC().Format(viewTimeFormat)
+	err := c.db.QueryRow(`
+	SELECT COUNT(*), COUNT(DISTINCT viewer_key)
+	FROM video_views
+	WHERE video_id = ? AND created_at >= ?
+	`, videoID, cutoff).Scan(&activity.Views, &activity.UniqueViewers)
+	if err != nil {
+		return VideoActivity{}, err
+	}
+	hour := since.UTC().Truncate(time.Hour).Format(viewTimeFormat)
+	err = c.db.QueryRow(`
+	SELECT COALESCE(SUM(bytes), 0)
+	FROM video_traffic
+	WHERE video_id = ? AND hour >= ?
+	`, videoID, hour).Scan(&activity.BytesServed)
+	if err != nil {
+		return VideoActivity{}, err
+	}
+	return activity, nil
+}
@@ -0,0 +1,200 @@
+// Package notify posts video events to chat webhooks (Slack and Discord),
+// with per-webhook rate limiting so a burst of uploads can't get a
+// workspace's webhook throttled or banned.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventUploadComplete   = "upload.complete"
+	EventProcessingFailed = "processing.failed"
+	EventQuotaWarning     = "quota.warning"
+	EventFixityFailed     = "fixity.failed"
+)
+
+// Events lists every event an integration can subscribe to.
+var Events = []string{EventUploadComplete, EventProcessingFailed, EventQuotaWarning, EventFixityFailed}
+
+const (
+	KindSlack   = "slack"
+	KindDiscord = "discord"
+)
+
+var ErrRateLimited = errors.New("webhook rate limit exceeded")
+
+// Event is the data available to message templates.
+type Event struct {
+	Type       string
+	VideoID    uuid.UUID
+	VideoTitle string
+	Error      string
+	// quota.warning only
+	UsagePercent int
+	UsedBytes    int64
+	QuotaBytes   int64
+}
+
+var defaultTemplates = map[string]string{
+	EventUploadComplete:   `Upload complete: "{{.VideoTitle}}" ({{.VideoID}})`,
+	EventProcessingFailed: `Processing failed for "{{.VideoTitle}}" ({{.VideoID}}): {{.Error}}`,
+	EventQuotaWarning:     `Storage is at {{.UsagePercent}}% of your quota ({{.UsedBytes}} of {{.QuotaBytes}} bytes)`,
+	EventFixityFailed:     `Integrity check failed for "{{.VideoTitle}}" ({{.VideoID}}): {{.Error}}`,
+}
+
+// Target is where and how to post an event.
+type Target struct {
+	Kind       string
+	WebhookURL string
+	// Template overrides the default message, using text/template syntax
+	Template string
+}
+
+// ValidateWebhookURL only accepts the official webhook hosts, so users
+// can't point the server at arbitrary (e.g. internal) URLs.
+func ValidateWebhookURL(kind, webhookURL string) error {
+	u, err := url.Parse(webhookURL)
+	if err != nil || u.Scheme != "https" {
+		return errors.New("webhook URL must be an https URL")
+	}
+	switch kind {
+	case KindSlack:
+		if u.Host != "hooks.slack.com" {
+			return errors.New("Slack webhook URLs must be on hooks.slack.com")
+		}
+	case KindDiscord:
+		if (u.Host != "discord.com" && u.Host != "discordapp.com") || !strings.HasPrefix(u.Path, "/api/webhooks/") {
+			return errors.New("Discord webhook URLs must be discord.com/api/webhooks/...")
+		}
+	default:
+		return fmt.Errorf("unknown integration kind %q", kind)
+	}
+	return nil
+}
+
+// ValidEvent reports whether event is one integrations can subscribe to.
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Render produces the message for e using tmpl, or the event's default
+// template if tmpl is empty.
+func Render(tmpl string, e Event) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultTemplates[e.Type]
+	}
+	t, err := template.New("message").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, e); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Notifier sends events, allowing each webhook a burst of messages that
+// refills at a steady rate.
+type Notifier struct {
+	client   *http.Client
+	rate     float64
+	burst    float64
+	mu       sync.Mutex
+	limiters map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a notifier allowing perMinute messages per webhook on
+// average, with bursts of up to burst messages.
+func New(perMinute, burst int) *Notifier {
+	return &Notifier{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		rate:     float64(perMinute) / 60,
+		burst:    float64(burst),
+		limiters: map[string]*tokenBucket{},
+	}
+}
+
+func (n *Notifier) allow(key string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	b, ok := n.limiters[key]
+	if !ok {
+		b = &tokenBucket{tokens: n.burst, last: now}
+		n.limiters[key] = b
+	}
+	b.tokens = min(n.burst, b.tokens+now.Sub(b.last).Seconds()*n.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Send posts e to t. It returns ErrRateLimited without sending if the
+// webhook is over its limit.
+func (n *Notifier) Send(ctx context.Context, t Target, e Event) error {
+	if !n.allow(t.WebhookURL) {
+		return ErrRateLimited
+	}
+
+	message, err := Render(t.Template, e)
+	if err != nil {
+		return fmt.Errorf("couldn't render message: %w", err)
+	}
+
+	var payload any
+	switch t.Kind {
+	case KindSlack:
+		payload = map[string]string{"text": message}
+	case KindDiscord:
+		payload = map[string]string{"content": message}
+	default:
+		return fmt.Errorf("unknown integration kind %q", t.Kind)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
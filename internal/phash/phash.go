@@ -0,0 +1,100 @@
+// Package phash computes perceptual hashes of images: 64-bit hashes that
+// stay close for images that look alike, even after re-encoding, scaling
+// or small edits, so near-duplicates can be found by comparing hashes.
+package phash
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// size is the side of the grayscale image the DCT is taken of
+	size = 32
+	// lowFrequencies is the side of the block of low frequencies hashed
+	lowFrequencies = 8
+)
+
+// Hash returns the perceptual hash of img: one bit for each of the lowest
+// frequencies of its DCT, set if it's above their median. Only the image's
+// coarse structure counts, not its size, colors or fine detail.
+func Hash(img image.Image) uint64 {
+	pixels := grayscale(img)
+
+	// the DCT's basis, shared by rows and columns
+	var basis [size][size]float64
+	for u := range size {
+		for x := range size {
+			basis[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	var rows [size][size]float64
+	for y := range size {
+		for u := range lowFrequencies {
+			sum := 0.0
+			for x := range size {
+				sum += pixels[y][x] * basis[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coefficients := make([]float64, 0, lowFrequencies*lowFrequencies)
+	for v := range lowFrequencies {
+		for u := range lowFrequencies {
+			sum := 0.0
+			for y := range size {
+				sum += rows[y][u] * basis[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	// the DC term is the overall brightness, which says nothing of what's
+	// in the image, so it's left out of the median
+	sorted := slices.Clone(coefficients[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// Distance is how many bits of a and b differ, from 0 for images that
+// look the same to 64.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayscale scales img down to size by size, averaging the pixels each
+// one covers, and returns its luminance.
+func grayscale(img image.Image) [size][size]float64 {
+	bounds := img.Bounds()
+	var pixels [size][size]float64
+	for y := range size {
+		y0 := bounds.Min.Y + y*bounds.Dy()/size
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/size)
+		for x := range size {
+			x0 := bounds.Min.X + x*bounds.Dx()/size
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/size)
+			// large images are sampled, a few pixels per cell are plenty
+			stepY, stepX := max(1, (y1-y0)/4), max(1, (x1-x0)/4)
+			sum, n := 0.0, 0
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					n++
+				}
+			}
+			pixels[y][x] = sum / float64(n)
+		}
+	}
+	return pixels
+}
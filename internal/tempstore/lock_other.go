@@ -0,0 +1,15 @@
+//go:build !unix
+
+package tempstore
+
+import "os"
+
+// lockFile creates path and keeps it open as the lock. Where flock isn't
+// available, a file another process still has open can't be removed
+// (Windows), so a lock file that can be removed is one nobody holds.
+func lockFile(path string) (*os.File, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package tempstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile opens path, creating it if needed, and takes an exclusive lock
+// on it without waiting. The lock lasts until the file is closed or the
+// process exits, however it exits.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
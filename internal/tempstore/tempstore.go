@@ -0,0 +1,278 @@
+// Package tempstore hands out temporary files for upload processing across
+// one or more volumes, so small files can land on fast local disk while huge
+// ingests spill to a larger scratch volume.
+package tempstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// filePrefix marks files created by the store. Each process keeps them in
+// a directory of its own on each volume, named filePrefix and a random
+// suffix, holding a lock on the directory's lockName file for as long as
+// it lives. Stale directories are only cleaned up once their lock is free:
+// servers and workers share temp dirs, on one host or across containers
+// mounting the same volume, where PIDs say nothing about who's running.
+const filePrefix = "tubely-"
+
+const lockName = ".lock"
+
+var ErrNoCapacity = errors.New("no temp volume has room for this file")
+
+// Volume is a directory that temp files can be created in. A zero
+// MaxFileSize or Capacity means no limit.
+type Volume struct {
+	Dir         string
+	MaxFileSize int64
+	Capacity    int64
+}
+
+// Usage is a snapshot of one volume's accounting.
+type Usage struct {
+	Dir      string `json:"dir"`
+	Capacity int64  `json:"capacity"`
+	Reserved int64  `json:"reserved"`
+	Files    int    `json:"files"`
+}
+
+type volume struct {
+	Volume
+	// dir is this process's directory in Dir, made with its first file
+	dir string
+	// lock is held on dir's lock file until the store is closed
+	lock     *os.File
+	reserved int64
+	files    int
+}
+
+type Store struct {
+	mu      sync.Mutex
+	volumes []*volume
+}
+
+// New creates a store over volumes, tried in order. Put small, fast volumes
+// first and large ones last. Close it to remove this process's files.
+func New(volumes []Volume) (*Store, error) {
+	if len(volumes) == 0 {
+		return nil, errors.New("at least one temp volume is required")
+	}
+	s := &Store{}
+	for _, v := range volumes {
+		if err := os.MkdirAll(v.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("couldn't create temp volume %s: %w", v.Dir, err)
+		}
+		s.volumes = append(s.volumes, &volume{Volume: v})
+	}
+	return s, nil
+}
+
+// File is a temp file with space reserved on its volume. Release it when done.
+// Writes past the reservation grow it, and fail with ErrNoCapacity once the
+// volume has no room left, so a file whose size wasn't known up front
+// can't fill its volume.
+type File struct {
+	*os.File
+	store    *Store
+	vol      *volume
+	dir      string
+	reserved int64
+	// written counts the bytes written through Write
+	written int64
+	once    sync.Once
+}
+
+// Create makes a temp file on the first volume that accepts files of
+// declaredSize and has that much unreserved capacity. pattern follows
+// os.CreateTemp and is prefixed so the file can be recognized later.
+func (s *Store) Create(declaredSize int64, pattern string) (*File, error) {
+	s.mu.Lock()
+	var chosen *volume
+	for _, v := range s.volumes {
+		if v.MaxFileSize > 0 && declaredSize > v.MaxFileSize {
+			continue
+		}
+		if v.Capacity > 0 && v.reserved+declaredSize > v.Capacity {
+			continue
+		}
+		chosen = v
+		break
+	}
+	if chosen == nil {
+		s.mu.Unlock()
+		return nil, ErrNoCapacity
+	}
+	if chosen.dir == "" {
+		dir, err := os.MkdirTemp(chosen.Dir, filePrefix)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		lock, err := lockFile(filepath.Join(dir, lockName))
+		if err != nil {
+			os.RemoveAll(dir)
+			s.mu.Unlock()
+			return nil, err
+		}
+		chosen.dir = dir
+		chosen.lock = lock
+	}
+	dir := chosen.dir
+	chosen.reserved += declaredSize
+	chosen.files++
+	s.mu.Unlock()
+
+	f, err := os.CreateTemp(dir, filePrefix+pattern)
+	if err != nil {
+		s.release(chosen, declaredSize)
+		return nil, err
+	}
+	return &File{File: f, store: s, vol: chosen, dir: dir, reserved: declaredSize}, nil
+}
+
+// Write writes p to the file, first growing its reservation if p takes it
+// past what was reserved.
+func (f *File) Write(p []byte) (int, error) {
+	if need := f.written + int64(len(p)); need > f.reserved {
+		if err := f.store.grow(f.vol, need-f.reserved, need); err != nil {
+			return 0, err
+		}
+		f.reserved = need
+	}
+	n, err := f.File.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// WriteString is Write for a string.
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// ReadFrom copies r into the file through Write, rather than the
+// *os.File's own ReadFrom, which would skip the accounting.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{f}, r)
+}
+
+// Dir returns the directory the file lives in, so derived files
+// (transcodes and the like) can be kept on the same volume.
+func (f *File) Dir() string {
+	return f.dir
+}
+
+// Release closes and removes the file and returns its reservation. It's
+// safe to call more than once.
+func (f *File) Release() {
+	f.once.Do(func() {
+		f.File.Close()
+		os.Remove(f.File.Name())
+		f.store.release(f.vol, f.reserved)
+	})
+}
+
+// grow adds size to a file's reservation on v, making it total, if v has
+// room for it and takes files that big.
+func (s *Store) grow(v *volume, size, total int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v.MaxFileSize > 0 && total > v.MaxFileSize {
+		return ErrNoCapacity
+	}
+	if v.Capacity > 0 && v.reserved+size > v.Capacity {
+		return ErrNoCapacity
+	}
+	v.reserved += size
+	return nil
+}
+
+func (s *Store) release(v *volume, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v.reserved -= size
+	v.files--
+}
+
+// Usage reports the current reservations on every volume.
+func (s *Store) Usage() []Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := make([]Usage, 0, len(s.volumes))
+	for _, v := range s.volumes {
+		usage = append(usage, Usage{
+			Dir:      v.Dir,
+			Capacity: v.Capacity,
+			Reserved: v.reserved,
+			Files:    v.files,
+		})
+	}
+	return usage
+}
+
+// CleanupStale removes the directories, and the files in them, left behind
+// by processes that are no longer running, e.g. after a crash mid-upload.
+// Other processes' files are left alone, even those of a server or worker
+// in another container sharing the volume: a directory is only stale once
+// its lock can be taken. It returns how many files were removed.
+func (s *Store) CleanupStale() (int, error) {
+	removed := 0
+	for _, v := range s.volumes {
+		entries, err := os.ReadDir(v.Dir)
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.HasPrefix(entry.Name(), filePrefix) {
+				continue
+			}
+			dir := filepath.Join(v.Dir, entry.Name())
+			// a directory without a lock file yet is still being made
+			lockPath := filepath.Join(dir, lockName)
+			if _, err := os.Stat(lockPath); err != nil {
+				continue
+			}
+			lock, err := lockFile(lockPath)
+			if err != nil {
+				continue
+			}
+			files, _ := os.ReadDir(dir)
+			lock.Close()
+			if err := os.RemoveAll(dir); err != nil {
+				return removed, err
+			}
+			// the lock file isn't one of the store's files
+			removed += len(files) - 1
+		}
+	}
+	return removed, nil
+}
+
+// Close removes this process's directories, and any files still in them,
+// returning how many files were removed. Call it once nothing uses the
+// store anymore.
+func (s *Store) Close() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	var errs []error
+	for _, v := range s.volumes {
+		if v.dir == "" {
+			continue
+		}
+		files, _ := os.ReadDir(v.dir)
+		v.lock.Close()
+		if err := os.RemoveAll(v.dir); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed += len(files) - 1
+		v.dir = ""
+		v.lock = nil
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+// Package throttle caps how fast bytes are read, so a large transfer can be
+// held to a share of the network instead of all of it. A Limiter can be
+// shared by every reader that should split one budget between them.
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunk is the most a throttled reader reads at once, so waits stay short
+// and a slow limiter doesn't hold a large buffer back in one go.
+const maxChunk = 32 << 10
+
+// Limiter lets bytes through at a steady rate. A nil *Limiter lets
+// everything through.
+type Limiter struct {
+	mu sync.Mutex
+	// perByte is how long each byte takes at the limiter's rate
+	perByte float64
+	// next is when the bytes let through so far have been paid for
+	next time.Time
+}
+
+// New returns a limiter for bytesPerSecond, or nil, which doesn't limit,
+// if it's 0 or less.
+func New(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Limiter{perByte: float64(time.Second) / float64(bytesPerSecond)}
+}
+
+// WaitN blocks until n more bytes fit the limiter's rate or ctx is done.
+// Idle time isn't saved up, so a burst after a pause is still held to the
+// rate.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(float64(n) * l.perByte))
+	l.mu.Unlock()
+
+	wait := start.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ReadSeeker is an io.ReadSeeker whose reads are held to the rate of every
+// one of its limiters. Seeking is passed through, so a rewound body is
+// throttled again as it's read again.
+type ReadSeeker struct {
+	ctx      context.Context
+	r        io.ReadSeeker
+	limiters []*Limiter
+}
+
+// NewReadSeeker throttles r by limiters, any of which can be nil. Reads
+// fail with ctx's error once it's done.
+func NewReadSeeker(ctx context.Context, r io.ReadSeeker, limiters ...*Limiter) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (t *ReadSeeker) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		if waitErr := l.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (t *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return t.r.Seek(offset, whence)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// jobQueueSendTimeout bounds handing a job to the queue
+	jobQueueSendTimeout = 30 * time.Second
+	// jobQueueWait is how long a worker waits for a message before asking
+	// again, the longest SQS allows
+	jobQueueWait = 20 * time.Second
+	// jobQueueClaimTimeout is how long a message received stays hidden
+	// from other workers before the job is claimed and it's hidden for as
+	// long as the job may run
+	jobQueueClaimTimeout = 5 * time.Minute
+	// jobQueueVisibilityMargin is added to a job's timeout so its message
+	// doesn't come back while the outcome is still being recorded
+	jobQueueVisibilityMargin = 5 * time.Minute
+	// jobQueueErrorBackoff is how long a worker waits after failing to
+	// reach the queue
+	jobQueueErrorBackoff = 5 * time.Second
+)
+
+// jobQueue hands jobs to worker processes. A message only carries the ID
+// of a job, whose row in the jobs table stays the record of its progress
+// and claims it, so a message delivered twice runs its job once.
+type jobQueue interface {
+	// send queues a message for the job.
+	send(ctx context.Context, jobID uuid.UUID) error
+	// receive waits up to jobQueueWait for up to max messages, hiding them
+	// from other workers for visibility. Messages that aren't a job's are
+	// deleted.
+	receive(ctx context.Context, max int, visibility time.Duration) ([]queuedJob, error)
+	// delete removes a message handled for good.
+	delete(ctx context.Context, receipt string) error
+	// hide keeps a message from other workers for d from now, 0 to hand it
+	// to one right away.
+	hide(ctx context.Context, receipt string, d time.Duration) error
+	// stats counts the messages in the queue.
+	stats(ctx context.Context) (jobQueueStats, error)
+	// name says where the queue is, without credentials, for logs.
+	name() string
+}
+
+// jobQueueStats counts a queue's messages, for admins to see how far
+// behind the workers are.
+type jobQueueStats struct {
+	Backend string `json:"backend"`
+	// Waiting are the messages a worker can receive now
+	Waiting int64 `json:"waiting"`
+	// Hidden are the messages received by a worker, or put off until a
+	// job running elsewhere should have finished
+	Hidden int64 `json:"hidden"`
+}
+
+// newJobQueue returns the queue settings names, nil if jobs run in the
+// process that starts them.
+func newJobQueue(settings jobQueueSettings, awsCfg aws.Config) jobQueue {
+	switch settings.Backend {
+	case jobQueueSQS:
+		return newSQSJobQueue(settings, awsCfg)
+	case jobQueueRedis:
+		return newRedisJobQueue(settings)
+	}
+	return nil
+}
+
+// sqsJobQueue is a jobQueue on an SQS queue.
+type sqsJobQueue struct {
+	settings jobQueueSettings
+	client   *sqs.Client
+}
+
+// jobMessage is the body of a job's message.
+type jobMessage struct {
+	JobID uuid.UUID `json:"job_id"`
+}
+
+// queuedJob is a message received from the queue.
+type queuedJob struct {
+	jobMessage
+	// receipt identifies this delivery of the message, to delete it or
+	// change how long it's hidden
+	receipt string
+}
+
+func newSQSJobQueue(settings jobQueueSettings, awsCfg aws.Config) *sqsJobQueue {
+	client := sqs.NewFromConfig(awsCfg, func(o *sqs.Options) {
+		o.Region = settings.Region
+		o.BaseEndpoint = aws.String(settings.Endpoint)
+	})
+	return &sqsJobQueue{settings: settings, client: client}
+}
+
+func (q *sqsJobQueue) send(ctx context.Context, jobID uuid.UUID) error {
+	body, err := json.Marshal(jobMessage{JobID: jobID})
+	if err != nil {
+		return err
+	}
+	_, err = q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.settings.URL),
+		MessageBody: aws.String(string(body)),
+	})
+	return err
+}
+
+func (q *sqsJobQueue) receive(ctx context.Context, max int, visibility time.Duration) ([]queuedJob, error) {
+	out, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.settings.URL),
+		MaxNumberOfMessages: int32(max),
+		WaitTimeSeconds:     int32(jobQueueWait.Seconds()),
+		VisibilityTimeout:   int32(visibility.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	jobs := []queuedJob{}
+	for _, message := range out.Messages {
+		job := queuedJob{receipt: aws.ToString(message.ReceiptHandle)}
+		body := aws.ToString(message.Body)
+		if err := json.Unmarshal([]byte(body), &job.jobMessage); err != nil || job.JobID == uuid.Nil {
+			loggerFrom(ctx).Warn("deleting message that isn't a job's", "body", body)
+			if err := q.delete(ctx, job.receipt); err != nil {
+				loggerFrom(ctx).Error("couldn't delete message", "error", err)
+			}
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (q *sqsJobQueue) delete(ctx context.Context, receipt string) error {
+	_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.settings.URL),
+		ReceiptHandle: aws.String(receipt),
+	})
+	return err
+}
+
+// hide hides a message for up to 12 hours, the longest SQS allows.
+func (q *sqsJobQueue) hide(ctx context.Context, receipt string, d time.Duration) error {
+	_, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.settings.URL),
+		ReceiptHandle:     aws.String(receipt),
+		VisibilityTimeout: int32(min(d, 12*time.Hour).Seconds()),
+	})
+	return err
+}
+
+// stats reads the queue's approximate counts, which SQS keeps up to date
+// within a minute or so.
+func (q *sqsJobQueue) stats(ctx context.Context) (jobQueueStats, error) {
+	out, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(q.settings.URL),
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+			types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		},
+	})
+	if err != nil {
+		return jobQueueStats{}, err
+	}
+	stats := jobQueueStats{Backend: jobQueueSQS}
+	stats.Waiting, _ = strconv.ParseInt(out.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)], 10, 64)
+	stats.Hidden, _ = strconv.ParseInt(out.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)], 10, 64)
+	return stats, nil
+}
+
+func (q *sqsJobQueue) name() string {
+	return q.settings.URL
+}
+
+// enqueueJob sends job to the queue for a worker to run. If it can't be
+// sent it's marked failed, to be sent again when its retry is due.
+func (cfg *apiConfig) enqueueJob(ctx context.Context, job database.Job) {
+	logger := loggerFrom(ctx).With("job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+	ctx = withLogger(context.WithoutCancel(ctx), logger)
+	cfg.goBackground(func() {
+		ctx, cancel := context.WithTimeout(ctx, jobQueueSendTimeout)
+		defer cancel()
+		if err := cfg.jobQueue.send(ctx, job.ID); err != nil {
+			logger.Error("couldn't queue job", "error", err)
+			cfg.failJob(ctx, job, fmt.Errorf("couldn't queue job: %w", err))
+			return
+		}
+		logger.Info("queued job")
+	})
+}
+
+// runWorker runs the jobs it receives from the queue, up to
+// WORKER_CONCURRENCY at once, until ctx is canceled. Jobs it's running
+// then are waited for by the shutdown.
+func (cfg *apiConfig) runWorker(ctx context.Context) {
+	log.Printf("Worker running up to %d jobs at once from %s", cfg.workerConcurrency, cfg.jobQueue.name())
+	for range cfg.workerConcurrency {
+		cfg.goBackground(func() {
+			for ctx.Err() == nil {
+				jobs, err := cfg.jobQueue.receive(ctx, 1, jobQueueClaimTimeout)
+				if err != nil {
+					if ctx.Err() == nil {
+						cfg.logger.Error("couldn't receive jobs", "error", err)
+					}
+					select {
+					case <-ctx.Done():
+					case <-time.After(jobQueueErrorBackoff):
+					}
+					continue
+				}
+				for _, queued := range jobs {
+					cfg.runQueuedJob(ctx, queued)
+				}
+			}
+		})
+	}
+}
+
+// runQueuedJob runs the job of a message received from the queue, and
+// deletes the message once the job's outcome is recorded. A job stopped by
+// shutdown goes back to the queue for another worker.
+func (cfg *apiConfig) runQueuedJob(ctx context.Context, queued queuedJob) {
+	logger := cfg.logger.With("job_id", queued.JobID)
+	// the job isn't canceled along with the polling, the shutdown cancels it
+	// if it runs too long
+	ctx = withLogger(context.WithoutCancel(ctx), logger)
+
+	job, ok, err := cfg.claimQueuedJob(ctx, queued)
+	if err != nil {
+		logger.Error("couldn't start job", "error", err)
+		// it comes back once it's no longer hidden
+		return
+	}
+	if !ok {
+		return
+	}
+	logger = logger.With("job_kind", job.Kind, "attempt", job.Attempts)
+	ctx = withLogger(ctx, logger)
+	runner, hasRunner := cfg.jobRunners()[job.Kind]
+	if hasRunner {
+		if err := cfg.jobQueue.hide(ctx, queued.receipt, runner.timeout+jobQueueVisibilityMargin); err != nil {
+			logger.Warn("couldn't extend job message's visibility", "error", err)
+		}
+	}
+
+	status := cfg.runJob(ctx, job)
+	if status == database.JobPending {
+		if err := cfg.jobQueue.hide(ctx, queued.receipt, 0); err != nil {
+			logger.Warn("couldn't hand interrupted job back to the queue", "error", err)
+		}
+		return
+	}
+	// failed jobs are sent again when their retry is due
+	if err := cfg.jobQueue.delete(ctx, queued.receipt); err != nil {
+		logger.Error("couldn't delete job message", "error", err)
+	}
+}
+
+// claimQueuedJob claims the job of a message for this worker, reporting
+// false if it's not to be run, having dealt with the message. A job
+// still marked running past its timeout belonged to a worker that died,
+// and is taken over.
+func (cfg *apiConfig) claimQueuedJob(ctx context.Context, queued queuedJob) (database.Job, bool, error) {
+	logger := loggerFrom(ctx)
+	job, err := cfg.db.GetJob(queued.JobID)
+	if err != nil {
+		return database.Job{}, false, err
+	}
+	if job.ID == uuid.Nil {
+		// deleted along with its video
+		return database.Job{}, false, cfg.jobQueue.delete(ctx, queued.receipt)
+	}
+
+	switch job.Status {
+	case database.JobPending:
+		claimed, err := cfg.claimJob(job.ID)
+		if err != nil || !claimed {
+			return database.Job{}, false, err
+		}
+	case database.JobRunning:
+		timeout := jobQueueClaimTimeout
+		if runner, ok := cfg.jobRunners()[job.Kind]; ok {
+			timeout = runner.timeout
+		}
+		staleAt := job.UpdatedAt.Add(timeout + jobQueueVisibilityMargin)
+		if wait := time.Until(staleAt); wait > 0 {
+			// running elsewhere, check again once it should have finished
+			return database.Job{}, false, cfg.jobQueue.hide(ctx, queued.receipt, wait)
+		}
+		if job.Attempts >= jobMaxAttempts {
+			logger.Warn("giving up on job its workers keep dying on")
+			if err := cfg.db.UpdateJobStatus(job.ID, database.JobFailed, errJobWorkersDied.Error()); err != nil {
+				return database.Job{}, false, err
+			}
+			cfg.deadLetterJob(ctx, job, errJobWorkersDied)
+			return database.Job{}, false, cfg.jobQueue.delete(ctx, queued.receipt)
+		}
+		taken, err := cfg.db.ReclaimStaleJob(job.ID, time.Now().Add(-timeout-jobQueueVisibilityMargin), cfg.instanceID, time.Now().Add(jobLeaseDuration))
+		if err != nil || !taken {
+			return database.Job{}, false, err
+		}
+		logger.Warn("taking over job of a worker that stopped")
+	default:
+		// already run, the message was delivered again
+		return database.Job{}, false, cfg.jobQueue.delete(ctx, queued.receipt)
+	}
+
+	job, err = cfg.db.GetJob(job.ID)
+	if err != nil {
+		return database.Job{}, false, err
+	}
+	if job.ID == uuid.Nil {
+		return database.Job{}, false, errors.New("job was deleted")
+	}
+	return job, true, nil
+}
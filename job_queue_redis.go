@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisJobQueueKey is the sorted set holding the queue's messages
+	redisJobQueueKey = "tubely:jobs"
+	// redisJobQueuePoll is how often a worker waiting for a message checks
+	// for one. Redis can't block on a sorted set's scores.
+	redisJobQueuePoll = time.Second
+)
+
+// redisJobQueue is a jobQueue in Redis. Each message is a job's ID in a
+// sorted set, scored with the time, in milliseconds, it can next be
+// received, so hiding a message is changing its score. A job has at most
+// one message: sending a job that's already queued makes it due now.
+type redisJobQueue struct {
+	client *redis.Client
+	addr   string
+}
+
+// redisReceiveScript takes up to ARGV[2] messages due by ARGV[1] and hides
+// them until ARGV[3], in one step, so two workers never receive the same
+// message at once.
+var redisReceiveScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(due) do
+	redis.call('ZADD', KEYS[1], ARGV[3], member)
+end
+return due
+`)
+
+func newRedisJobQueue(settings jobQueueSettings) *redisJobQueue {
+	return &redisJobQueue{
+		client: redis.NewClient(settings.Redis),
+		addr:   settings.Redis.Addr + "/" + strconv.Itoa(settings.Redis.DB),
+	}
+}
+
+// redisScore is the score of a message that can be received at t.
+func redisScore(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+func (q *redisJobQueue) send(ctx context.Context, jobID uuid.UUID) error {
+	return q.client.ZAdd(ctx, redisJobQueueKey, redis.Z{
+		Score:  redisScore(time.Now()),
+		Member: jobID.String(),
+	}).Err()
+}
+
+// receive asks for due messages every redisJobQueuePoll until some come in
+// or jobQueueWait has passed.
+func (q *redisJobQueue) receive(ctx context.Context, max int, visibility time.Duration) ([]queuedJob, error) {
+	deadline := time.Now().Add(jobQueueWait)
+	for {
+		now := time.Now()
+		members, err := redisReceiveScript.Run(ctx, q.client, []string{redisJobQueueKey},
+			redisScore(now), max, redisScore(now.Add(visibility))).StringSlice()
+		if err != nil {
+			return nil, err
+		}
+		jobs := []queuedJob{}
+		for _, member := range members {
+			jobID, err := uuid.Parse(member)
+			if err != nil || jobID == uuid.Nil {
+				loggerFrom(ctx).Warn("deleting message that isn't a job's", "body", member)
+				if err := q.delete(ctx, member); err != nil {
+					loggerFrom(ctx).Error("couldn't delete message", "error", err)
+				}
+				continue
+			}
+			jobs = append(jobs, queuedJob{jobMessage: jobMessage{JobID: jobID}, receipt: member})
+		}
+		if len(jobs) > 0 || time.Now().After(deadline) {
+			return jobs, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(redisJobQueuePoll):
+		}
+	}
+}
+
+func (q *redisJobQueue) delete(ctx context.Context, receipt string) error {
+	return q.client.ZRem(ctx, redisJobQueueKey, receipt).Err()
+}
+
+// hide only changes a message still queued, so a message deleted in the
+// meantime stays deleted.
+func (q *redisJobQueue) hide(ctx context.Context, receipt string, d time.Duration) error {
+	return q.client.ZAddXX(ctx, redisJobQueueKey, redis.Z{
+		Score:  redisScore(time.Now().Add(d)),
+		Member: receipt,
+	}).Err()
+}
+
+func (q *redisJobQueue) stats(ctx context.Context) (jobQueueStats, error) {
+	now := strconv.FormatFloat(redisScore(time.Now()), 'f', -1, 64)
+	waiting, err := q.client.ZCount(ctx, redisJobQueueKey, "-inf", now).Result()
+	if err != nil {
+		return jobQueueStats{}, err
+	}
+	hidden, err := q.client.ZCount(ctx, redisJobQueueKey, "("+now, "+inf").Result()
+	if err != nil {
+		return jobQueueStats{}, err
+	}
+	return jobQueueStats{Backend: jobQueueRedis, Waiting: waiting, Hidden: hidden}, nil
+}
+
+func (q *redisJobQueue) name() string {
+	return "redis at " + q.addr
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// a failed job can be retried this many times in all
+	jobMaxAttempts = 5
+	// retries wait a minute after the failure, doubling up to an hour
+	jobRetryBaseBackoff = time.Minute
+	jobRetryMaxBackoff  = time.Hour
+	// jobRetryPollInterval is how often failed jobs are checked for a
+	// retry that's due, and running ones for an expired lease
+	jobRetryPollInterval = 30 * time.Second
+	// a claimed job is leased to the instance running it for
+	// jobLeaseDuration, renewed every jobLeaseRenewInterval while the
+	// instance lives, so a job whose lease ran out was left by a crash
+	jobLeaseDuration      = 2 * time.Minute
+	jobLeaseRenewInterval = 30 * time.Second
+)
+
+var (
+	// errJobInterruptedTooOften fails a job running each time the server
+	// crashed, jobMaxAttempts times
+	errJobInterruptedTooOften = errors.New("interrupted by server restarts too many times")
+	// errJobWorkersDied fails a queued job whose workers stopped running
+	// it jobMaxAttempts times
+	errJobWorkersDied = errors.New("interrupted by worker restarts too many times")
+)
+
+// jobRunner runs jobs of one kind from the parameters they were created
+// with, so a failed job can be run again the same way.
+type jobRunner struct {
+	timeout time.Duration
+	run     func(ctx context.Context, job database.Job) error
+}
+
+func (cfg *apiConfig) jobRunners() map[string]jobRunner {
+	return map[string]jobRunner{
+		jobKindThumbnailFromFrame: {5 * time.Minute, cfg.runThumbnailFromFrameJob},
+		jobKindTranscribe:         {transcribeTimeout, cfg.runTranscribeJob},
+		jobKindClip:               {clipTimeout, cfg.runClipJob},
+		jobKindModerate:           {moderationTimeout, cfg.runModerateJob},
+		jobKindIngestURL:          {urlIngestTimeout, cfg.runIngestURLJob},
+		jobKindIngestUpload:       {uploadIngestTimeout, cfg.runIngestUploadJob},
+		jobKindFingerprint:        {fingerprintTimeout, cfg.runFingerprintJob},
+		jobKindAutoThumbnail:      {autoThumbnailTimeout, cfg.runAutoThumbnailJob},
+	}
+}
+
+// jobRetryAt returns when a failed job may be retried, the zero time if it
+// has used up its attempts.
+func jobRetryAt(job database.Job) time.Time {
+	if job.Attempts >= jobMaxAttempts {
+		return time.Time{}
+	}
+	delay := min(jobRetryMaxBackoff, jobRetryBaseBackoff<<min(job.Attempts-1, 16))
+	return job.UpdatedAt.Add(delay)
+}
+
+// startJob runs job in the background with the runner for its kind,
+// recording its progress, or with JOB_QUEUE_URL set sends it to a worker.
+// The job outlives the request that started it, so it isn't canceled along
+// with ctx, but keeps its values such as the request's logger. Shutdown
+// waits for it, canceling it if it runs past the shutdown timeout.
+func (cfg *apiConfig) startJob(ctx context.Context, job database.Job) {
+	if cfg.jobQueue != nil {
+		cfg.enqueueJob(ctx, job)
+		return
+	}
+	logger := loggerFrom(ctx).With("job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+	ctx = withLogger(context.WithoutCancel(ctx), logger)
+	cfg.goBackground(func() {
+		claimed, err := cfg.claimJob(job.ID)
+		if err != nil {
+			logger.Error("couldn't start job", "error", err)
+			return
+		}
+		if !claimed {
+			// already started elsewhere, such as by the job queue
+			return
+		}
+		cfg.runJob(ctx, job)
+	})
+}
+
+// runJob runs a job claimed for this process and records its outcome,
+// which it returns: pending again if shutdown cut it off.
+func (cfg *apiConfig) runJob(ctx context.Context, job database.Job) string {
+	logger := loggerFrom(ctx)
+	runner, ok := cfg.jobRunners()[job.Kind]
+	if !ok {
+		err := fmt.Errorf("no runner for jobs of kind %s", job.Kind)
+		logger.Error("job failed", "error", err)
+		cfg.failJob(ctx, job, err)
+		return database.JobFailed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runner.timeout)
+	defer cancel()
+	stop := context.AfterFunc(cfg.inflight.ctx, cancel)
+	defer stop()
+
+	status := database.JobSucceeded
+	err := runner.run(ctx, job)
+	if err != nil && cfg.inflight.ctx.Err() != nil {
+		// cut off by shutdown rather than failed, it starts over once
+		// the server is back
+		logger.Warn("job interrupted by shutdown", "error", err)
+		status = database.JobPending
+	} else if err != nil {
+		logger.Error("job failed", "error", err)
+		cfg.failJob(ctx, job, err)
+		return database.JobFailed
+	}
+	if err := cfg.db.UpdateJobStatus(job.ID, status, ""); err != nil {
+		logger.Error("couldn't record job outcome", "error", err)
+	}
+	return status
+}
+
+// newInstanceID names this process in the jobs it claims: the host, for
+// whoever reads the jobs table, and a random suffix, since a restarted
+// process is a new owner.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "tubely"
+	}
+	return host + "-" + uuid.NewString()[:8]
+}
+
+// claimJob moves a pending job to running for this instance, leased for
+// jobLeaseDuration.
+func (cfg *apiConfig) claimJob(id uuid.UUID) (bool, error) {
+	return cfg.db.ClaimJob(id, cfg.instanceID, time.Now().Add(jobLeaseDuration))
+}
+
+// runJobLeaseRenewer keeps renewing the leases on the jobs this instance
+// runs until ctx is canceled, which is once shutdown stops waiting for
+// them.
+func (cfg *apiConfig) runJobLeaseRenewer(ctx context.Context) {
+	ticker := time.NewTicker(jobLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := cfg.db.RenewJobLeases(cfg.instanceID, time.Now().Add(jobLeaseDuration)); err != nil {
+				cfg.logger.Error("couldn't renew job leases", "error", err)
+			}
+		}
+	}
+}
+
+// runJobQueue starts the jobs left pending when the server last stopped,
+// then keeps retrying failed jobs once their backoff has passed, and
+// without JOB_QUEUE_URL restarting jobs whose instance stopped, until ctx
+// is canceled.
+func (cfg *apiConfig) runJobQueue(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	pending, err := cfg.db.GetJobsByStatus(database.JobPending)
+	if err != nil {
+		logger.Error("couldn't look up pending jobs", "error", err)
+	}
+	resumed := 0
+	for _, job := range pending {
+		if job.Params != "" {
+			cfg.startJob(ctx, job)
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		logger.Info("resumed pending jobs", "count", resumed)
+	}
+
+	ticker := time.NewTicker(jobRetryPollInterval)
+	defer ticker.Stop()
+	for {
+		// with a queue, workers take over the jobs of workers that stopped
+		if cfg.jobQueue == nil {
+			cfg.requeueInterruptedJobs(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cfg.retryFailedJobs(ctx)
+		}
+	}
+}
+
+// requeueInterruptedJobs starts again the jobs left running by an instance
+// that stopped renewing their leases, and dead-letters those interrupted
+// too many times. Jobs other instances are running hold live leases, so
+// several servers sharing a database leave each other's jobs alone.
+func (cfg *apiConfig) requeueInterruptedJobs(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	requeued, failed, err := cfg.db.RequeueInterruptedJobs(time.Now(), jobMaxAttempts, errJobInterruptedTooOften.Error())
+	if err != nil {
+		logger.Error("couldn't requeue interrupted jobs", "error", err)
+		return
+	}
+	for _, job := range failed {
+		logger := logger.With("job_id", job.ID, "job_kind", job.Kind)
+		logger.Warn("giving up on job interrupted too many times")
+		cfg.deadLetterJob(withLogger(ctx, logger), job, errJobInterruptedTooOften)
+	}
+	for _, job := range requeued {
+		// jobs created before they recorded their parameters stay pending
+		if job.Params == "" {
+			continue
+		}
+		logger.Info("restarting interrupted job", "job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+		cfg.startJob(ctx, job)
+	}
+}
+
+// retryFailedJobs starts again the failed jobs whose retry is due.
+func (cfg *apiConfig) retryFailedJobs(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	failed, err := cfg.db.GetRetryableJobs(uuid.Nil)
+	if err != nil {
+		logger.Error("couldn't look up failed jobs", "error", err)
+		return
+	}
+	now := time.Now()
+	for _, job := range failed {
+		retryAt := jobRetryAt(job)
+		// jobs created before they recorded their parameters can't be run
+		// again
+		if job.Params == "" || retryAt.IsZero() || retryAt.After(now) {
+			continue
+		}
+		ok, err := cfg.db.RequeueJob(job.ID)
+		if err != nil {
+			logger.Error("couldn't requeue job", "job_id", job.ID, "error", err)
+			continue
+		}
+		if !ok {
+			// retried by its owner meanwhile
+			continue
+		}
+		job, err = cfg.db.GetJob(job.ID)
+		if err != nil {
+			logger.Error("couldn't get job", "job_id", job.ID, "error", err)
+			continue
+		}
+		logger.Info("retrying failed job", "job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+		cfg.startJob(ctx, job)
+	}
+}
@@ -2,22 +2,108 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 )
 
+// errorResponse is the body of every error response, the Error schema in
+// the OpenAPI document. Code is one of errorCodes, for the failures
+// clients need to tell apart without matching on Error.
+type errorResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+const (
+	// errorCodeVideoNotFound marks a 404 for a video that doesn't exist or
+	// the caller can't see, as opposed to a missing file, caption or route
+	errorCodeVideoNotFound = "video_not_found"
+	// errorCodeUnsupportedMediaType marks uploads refused for their type,
+	// or for not being the media they claim to be
+	errorCodeUnsupportedMediaType = "unsupported_media_type"
+	// errorCodeUploadTooLarge and errorCodeQuotaExceeded tell apart the two
+	// kinds of 413 for an upload: over the size limit, or the user's quota
+	errorCodeUploadTooLarge = "upload_too_large"
+	errorCodeQuotaExceeded  = "quota_exceeded"
+)
+
+// errorCodes lists every errorResponse code, for the OpenAPI document.
+var errorCodes = []string{
+	errorCodeVideoNotFound,
+	errorCodeUnsupportedMediaType,
+	errorCodeUploadTooLarge,
+	errorCodeQuotaExceeded,
+	errorCodeProbeFailed,
+	errorCodeProcessingUnavailable,
+	errorCodeMalwareDetected,
+	errorCodeVideoArchived,
+	errorCodeHotlinkBlocked,
+	errorCodePasswordAttemptsExceeded,
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	respondWithErrorCode(w, code, "", msg, err)
+}
+
+// respondWithErrorCode is respondWithError for failures clients need to
+// tell apart from others with the same status, which errorCode names.
+func respondWithErrorCode(w http.ResponseWriter, code int, errorCode, msg string, err error) {
+	id := w.Header().Get(requestIDHeader)
 	if err != nil {
-		log.Println(err)
+		slog.Info("request failed", "request_id", id, "status", code, "error", err)
 	}
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
-	}
-	type errorResponse struct {
-		Error string `json:"error"`
+		slog.Error("responding with 5XX error", "request_id", id, "status", code, "message", msg)
 	}
 	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+		Error:     msg,
+		Code:      errorCode,
+		RequestID: id,
+	})
+}
+
+// tooLargeResponse is the body of a 413 for an upload over the size limit.
+type tooLargeResponse struct {
+	errorResponse
+	MaxUploadSize int64 `json:"max_upload_size"`
+}
+
+// respondWithTooLarge reports an upload over the caller's size limit,
+// including the limit so clients can tell the user what's allowed.
+func respondWithTooLarge(w http.ResponseWriter, limit int64, err error) {
+	id := w.Header().Get(requestIDHeader)
+	if err != nil {
+		slog.Info("request failed", "request_id", id, "status", http.StatusRequestEntityTooLarge, "error", err)
+	}
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, tooLargeResponse{
+		errorResponse: errorResponse{
+			Error:     fmt.Sprintf("Upload exceeds the maximum size of %d bytes", limit),
+			Code:      errorCodeUploadTooLarge,
+			RequestID: id,
+		},
+		MaxUploadSize: limit,
+	})
+}
+
+// quotaResponse is the body of a 413 for an upload over the user's quota.
+type quotaResponse struct {
+	errorResponse
+	quotaStatus
+}
+
+// respondWithQuotaExceeded reports an upload that would take the user past
+// their storage quota's hard limit.
+func respondWithQuotaExceeded(w http.ResponseWriter, status quotaStatus) {
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, quotaResponse{
+		errorResponse: errorResponse{
+			Error:     "Upload would exceed your storage quota",
+			Code:      errorCodeQuotaExceeded,
+			RequestID: w.Header().Get(requestIDHeader),
+		},
+		quotaStatus: status,
 	})
 }
 
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// normalizeLanguage lowercases a BCP 47 language tag such as "pt-BR" and
+// checks its shape. Tags are compared case-insensitively everywhere.
+func normalizeLanguage(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !languageTagPattern.MatchString(tag) {
+		return "", errors.New("language must be a language tag like en or pt-br")
+	}
+	return tag, nil
+}
+
+// requestedLanguages lists the languages the client wants, best first: the
+// `lang` query parameter if given, otherwise Accept-Language by q-value.
+func requestedLanguages(r *http.Request) []string {
+	if lang, err := normalizeLanguage(r.URL.Query().Get("lang")); err == nil {
+		return []string{lang}
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	prefs := []weighted{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		normalized, err := normalizeLanguage(tag)
+		if err != nil || q <= 0 {
+			continue
+		}
+		prefs = append(prefs, weighted{normalized, q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	langs := make([]string, 0, len(prefs))
+	for _, p := range prefs {
+		langs = append(langs, p.tag)
+	}
+	return langs
+}
+
+// matchLanguage picks the first preference that one of the available
+// languages satisfies, exactly or by primary language ("pt-br" matches
+// "pt" and vice versa). It returns "" if nothing matches.
+func matchLanguage(prefs, available []string) string {
+	for _, pref := range prefs {
+		for _, lang := range available {
+			if lang == pref {
+				return lang
+			}
+		}
+		base, _, _ := strings.Cut(pref, "-")
+		for _, lang := range available {
+			if lb, _, _ := strings.Cut(lang, "-"); lb == base {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// localizeVideos swaps each video's title and description for the variant
+// in the best language the request asks for. Videos already in that
+// language, or without a matching variant, are left as they are.
+func (cfg *apiConfig) localizeVideos(r *http.Request, videos []database.Video) ([]database.Video, error) {
+	prefs := requestedLanguages(r)
+	if len(prefs) == 0 || len(videos) == 0 {
+		return videos, nil
+	}
+
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	byVideo, err := cfg.db.GetLocalizationsForVideos(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, video := range videos {
+		locs := byVideo[video.ID]
+		if len(locs) == 0 {
+			continue
+		}
+		available := []string{}
+		if video.DefaultLanguage != "" {
+			available = append(available, video.DefaultLanguage)
+		}
+		for _, loc := range locs {
+			available = append(available, loc.Language)
+		}
+
+		lang := matchLanguage(prefs, available)
+		if lang == "" || lang == video.DefaultLanguage {
+			continue
+		}
+		for _, loc := range locs {
+			if loc.Language == lang {
+				videos[i].Title = loc.Title
+				videos[i].Description = loc.Description
+				break
+			}
+		}
+	}
+	return videos, nil
+}
+
+// localizeVideo is localizeVideos for a single video.
+func (cfg *apiConfig) localizeVideo(r *http.Request, video database.Video) (database.Video, error) {
+	videos, err := cfg.localizeVideos(r, []database.Video{video})
+	if err != nil {
+		return database.Video{}, err
+	}
+	return videos[0], nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// newLogger builds the server's logger from LOG_LEVEL (debug, info, warn,
+// error; default info) and LOG_FORMAT (text or json; default text).
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT must be text or json, got %q", format)
+	}
+}
+
+type loggerKey struct{}
+
+// withLogger attaches a logger carrying request-scoped attributes to ctx,
+// so code further down (like the video pipeline) logs with them too.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger attached to ctx, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+type requestIDKey struct{}
+
+// requestIDHeader carries the request ID both ways: a client or proxy can
+// supply one, and every response reports the one that was used.
+const requestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// withRequestID tags each request with an ID, kept from the client's
+// X-Request-ID header if it looks sane, and attaches a logger that
+// includes it. The ID is echoed back in the response headers and error
+// bodies so a failure a user reports can be found in the logs.
+func (cfg *apiConfig) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = withLogger(ctx, cfg.logger.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID keeps client supplied IDs from injecting junk into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID withRequestID gave the request.
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return newRequestID()
+}
+
+type requestUserKey struct{}
+
+// logRequests writes one structured line for every request once it has
+// been answered: method, path, status, how long it took, the bytes read
+// from its body and written in the response, and the user it was made as,
+// when the request carried a valid token or API key.
+func (cfg *apiConfig) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		userID := new(uuid.UUID)
+		r = r.WithContext(context.WithValue(r.Context(), requestUserKey{}, userID))
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if *userID == uuid.Nil {
+			if token, err := auth.GetBearerToken(r.Header); err == nil {
+				*userID, _ = auth.ValidateJWT(token, cfg.jwtSecret)
+			}
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", redactPath(r.URL.Path)),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(started)),
+			slog.Int64("bytes_in", body.n),
+			slog.Int64("bytes_out", rec.bytes),
+		}
+		if *userID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", userID.String()))
+		}
+		loggerFrom(r.Context()).LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// tokenRoutes are the API routes whose last path segment is a credential,
+// a share or playback token.
+var tokenRoutes = []string{"/share/", "/playback/"}
+
+// redactPath returns path with the token in it replaced, if it's one of
+// tokenRoutes, so logs and the audit log don't hand out working links.
+func redactPath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/api")
+	if !ok {
+		return path
+	}
+	for version := range apiVersions {
+		if after, ok := strings.CutPrefix(rest, fmt.Sprintf("/v%d/", version)); ok {
+			rest = "/" + after
+			break
+		}
+	}
+	for _, route := range tokenRoutes {
+		if token, ok := strings.CutPrefix(rest, route); ok && token != "" {
+			return strings.TrimSuffix(path, token) + "[redacted]"
+		}
+	}
+	return path
+}
+
+// setRequestUser records who a request was made as for its log line, for
+// credentials logRequests can't check itself, like API keys.
+func setRequestUser(ctx context.Context, userID uuid.UUID) {
+	if user, ok := ctx.Value(requestUserKey{}).(*uuid.UUID); ok {
+		*user = userID
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
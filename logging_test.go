@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRedactPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/share/eyJhbGciOi.abc.def", "/api/share/[redacted]"},
+		{"/api/v1/share/eyJhbGciOi.abc.def", "/api/v1/share/[redacted]"},
+		{"/api/v2/playback/eyJhbGciOi.abc.def", "/api/v2/playback/[redacted]"},
+		{"/api/playback/tok", "/api/playback/[redacted]"},
+		{"/api/share/", "/api/share/"},
+		{"/api/videos/3f2504e0-4f89-11d3-9a0c-0305e82c3301/share", "/api/videos/3f2504e0-4f89-11d3-9a0c-0305e82c3301/share"},
+		{"/api/videos", "/api/videos"},
+		{"/app/share/tok", "/app/share/tok"},
+	}
+	for _, tt := range tests {
+		if got := redactPath(tt.path); got != tt.want {
+			t.Errorf("redactPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -2,17 +2,30 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"google.golang.org/grpc"
 )
 
 type apiConfig struct {
@@ -21,88 +34,486 @@ type apiConfig struct {
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
-	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
 	port             string
+	// s3Client makes requests in S3_REGION, buckets has the clients for
+	// the buckets videos are stored in
 	s3Client         *s3.Client
+	buckets          *bucketResolver
+	s3ObjectSettings s3ObjectSettings
+	s3Uploads        *s3Uploads
+	s3ImportBuckets  []string
+	tempStore        *tempstore.Store
+	notifier         *notify.Notifier
+	uploadLimits     uploadLimits
+	storageQuotas    map[string]storageQuota
+	logger           *slog.Logger
+	tombstoneWake    chan struct{}
+	fixity           fixitySettings
+	sandbox          bool
+	inflight         *inflightWork
+	publicURL        string
+	oauthProviders   map[string]*auth.OAuthProvider
+	videoDelivery    string
+	// videoVersionLimit is how many versions of each video are kept
+	videoVersionLimit int
+	orphans           orphanSettings
+	media             mediaTools
+	// orphanScanning is held while the bucket is scanned for orphans
+	orphanScanning *sync.Mutex
+	// transcriber is nil if videos aren't transcribed
+	transcriber           transcriber
+	transcriptionLanguage string
+	// moderator is nil if videos aren't moderated
+	moderator               moderator
+	moderationMinConfidence float64
+	// virusScanner is nil if uploads aren't scanned
+	virusScanner virusScanner
+	// codecPolicy is what happens to videos browsers can't play
+	codecPolicy string
+	// ingestClient fetches files from the URLs videos are ingested from
+	ingestClient *http.Client
+	watchFolder  watchFolderSettings
+	// s3Accelerate sends uploads and client downloads through the bucket's
+	// Transfer Acceleration endpoint
+	s3Accelerate bool
+	// archive is when and how unwatched videos are archived
+	archive archiveSettings
+	// restoreWake has the restore worker check on restores now
+	restoreWake chan struct{}
+	// cdn is nil if no CDN cache is invalidated
+	cdn     cdnInvalidator
+	hotlink hotlinkSettings
+	cors    corsSettings
+	// trustedProxies are the proxies whose X-Request-Start and
+	// X-Forwarded-For are believed
+	trustedProxies []netip.Prefix
+	unlockLimits   unlockLimits
+	// jobQueue is nil if jobs run in the process that starts them
+	jobQueue jobQueue
+	// workerConcurrency is how many jobs "tubely worker" runs at once
+	workerConcurrency int
+	// instanceID names this process as the owner of the jobs it runs
+	instanceID string
+	// events are where video events are published
+	events []eventPublisher
 }
 
 func main() {
 	godotenv.Load(".env")
 
+	// "tubely check" compares the database with the bucket instead of serving
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	// "tubely upload" is a client of a server, it needs none of the settings below
+	if command == "upload" {
+		os.Exit(runUploadCommand(os.Args[2:]))
+	}
+	if command != "" && command != "check" && command != "worker" {
+		log.Fatalf("Unknown command %q, usage: tubely [check [-plan] [-json] | upload [flags] FILE | worker]", command)
+	}
+	// the check can run next to a live server, so it leaves its state alone
+	serving := command == ""
+
+	// sandbox mode runs the whole API without AWS or ffmpeg, for frontend work
+	sandbox := os.Getenv("TUBELY_SANDBOX") == "1"
+	if sandbox {
+		applySandboxDefaults()
+		sandboxMedia = true
+	}
+
+	logger, err := newLogger(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		log.Fatalf("Invalid logging config: %v", err)
+	}
+	// route the standard log package through it too
+	slog.SetDefault(logger)
+
+	// report every missing setting at once rather than one per restart
+	if missing := missingSettings(sandbox); len(missing) > 0 {
+		log.Fatalf("Missing required settings: %s. Set them in the environment or .env, see .env.example", strings.Join(missing, ", "))
+	}
+
 	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	if sandbox {
+		pathToDB, err = resetSandboxDB()
+		if err != nil {
+			log.Fatalf("Couldn't reset sandbox database: %v", err)
+		}
 	}
 
-	db, err := database.NewClient(pathToDB)
+	// Postgres lets several instances share the database, SQLite is the default
+	var db database.Client
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL != "" && !sandbox {
+		pool, err := parsePoolSettings(
+			os.Getenv("DB_MAX_OPEN_CONNS"),
+			os.Getenv("DB_MAX_IDLE_CONNS"),
+			os.Getenv("DB_CONN_MAX_LIFETIME"),
+		)
+		if err != nil {
+			log.Fatalf("Invalid database pool settings: %v", err)
+		}
+		db, err = database.NewPostgresClient(databaseURL, pool)
+		if err != nil {
+			log.Fatalf("Couldn't connect to database: %v", err)
+		}
+	} else {
+		db, err = database.NewClient(pathToDB)
+		if err != nil {
+			log.Fatalf("Couldn't connect to database: %v", err)
+		}
+	}
+	schemaVersion, err := db.SchemaVersion()
 	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+		log.Fatalf("Couldn't read database schema version: %v", err)
+	}
+	log.Printf("Database schema at version %d", schemaVersion)
+	if sandbox {
+		if err := seedSandbox(db); err != nil {
+			log.Fatalf("Couldn't seed sandbox data: %v", err)
+		}
+	}
+	// with a job queue, jobs run in workers, which take over the jobs of
+	// workers that stopped
+	jobQueue, err := parseJobQueueSettings(os.Getenv("JOB_QUEUE_URL"), os.Getenv("WORKER_CONCURRENCY"), os.Getenv("S3_REGION"))
+	if err != nil {
+		log.Fatalf("Invalid job queue settings: %v", err)
+	}
+	if sandbox && jobQueue.URL != "" {
+		log.Printf("Sandbox mode runs jobs in process, ignoring JOB_QUEUE_URL")
+		jobQueue.URL = ""
+		jobQueue.Backend = ""
+	}
+	if command == "worker" && jobQueue.URL == "" {
+		log.Fatalf("tubely worker runs jobs from the queue at JOB_QUEUE_URL, which isn't set")
+	}
+	if serving {
+		released, err := db.ReleasePendingIdempotencyKeys()
+		if err != nil {
+			log.Fatalf("Couldn't clean up idempotency keys: %v", err)
+		} else if released > 0 {
+			log.Printf("Released %d idempotency keys of interrupted requests", released)
+		}
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// admins are managed by config so nobody can grant themselves the role
+	adminEmails := parseAdminEmails(os.Getenv("ADMIN_EMAILS"))
+	admins, err := db.SyncAdmins(adminEmails)
+	if err != nil {
+		log.Fatalf("Couldn't set up admins: %v", err)
+	} else if admins < len(adminEmails) {
+		log.Printf("%d of %d ADMIN_EMAILS have no account yet, restart once they sign up", len(adminEmails)-admins, len(adminEmails))
 	}
 
+	jwtSecret := os.Getenv("JWT_SECRET")
 	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	filepathRoot := os.Getenv("FILEPATH_ROOT")
+	assetsRoot := os.Getenv("ASSETS_ROOT")
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
+	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+	port := os.Getenv("PORT")
+	// the gRPC API is off unless it's given a port of its own
+	grpcPort := os.Getenv("GRPC_PORT")
+
+	s3ObjectSettings, err := parseS3ObjectSettings(
+		os.Getenv("S3_OBJECT_ACL"),
+		os.Getenv("S3_EXPECTED_BUCKET_OWNER"),
+		os.Getenv("S3_OBJECT_OWNERSHIP"),
+	)
+	if err != nil {
+		log.Fatalf("Invalid S3 object settings: %v", err)
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	s3Uploads, err := parseS3UploadSettings(
+		os.Getenv("S3_UPLOAD_PART_SIZE"),
+		os.Getenv("S3_UPLOAD_CONCURRENCY"),
+		os.Getenv("S3_UPLOAD_GLOBAL_CONCURRENCY"),
+		os.Getenv("S3_UPLOAD_BANDWIDTH"),
+		os.Getenv("S3_UPLOAD_GLOBAL_BANDWIDTH"),
+	)
+	if err != nil {
+		log.Fatalf("Invalid S3 upload settings: %v", err)
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	bucketSettings, err := parseBucketSettings(s3Bucket, s3Region, os.Getenv("S3_BUCKETS"), os.Getenv("S3_SHARDING"))
+	if err != nil {
+		log.Fatalf("Invalid S3 buckets: %v", err)
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	s3Accelerate, err := parseTransferAcceleration(os.Getenv("S3_TRANSFER_ACCELERATION"), bucketSettings.names())
+	if err != nil {
+		log.Fatalf("Invalid S3 transfer acceleration setting: %v", err)
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	s3ImportBuckets, err := parseS3ImportBuckets(os.Getenv("S3_IMPORT_BUCKETS"), bucketSettings.names())
+	if err != nil {
+		log.Fatalf("Invalid S3 import buckets: %v", err)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	uploadLimits, err := parseUploadLimits(
+		os.Getenv("MAX_UPLOAD_SIZE"),
+		os.Getenv("MULTIPART_MEMORY"),
+		os.Getenv("UPLOAD_TIER_LIMITS"),
+		os.Getenv("ALLOWED_MEDIA_TYPES"),
+	)
+	if err != nil {
+		log.Fatalf("Invalid upload limits: %v", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	storageQuotas, err := parseStorageQuotas(os.Getenv("STORAGE_TIER_QUOTAS"), os.Getenv("STORAGE_TIER_GRACE"))
+	if err != nil {
+		log.Fatalf("Invalid storage quotas: %v", err)
 	}
 
-	// use config.LoadDefaultConfig to auto load the default AWS SDK config as args we give an empty Context and pass config.WithRegion(s3Region)
-	ctx := context.Background()
-	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(s3Region))
+	shutdownTimeout, err := parseShutdownTimeout(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil {
+		log.Fatalf("Invalid shutdown timeout: %v", err)
+	}
+
+	fixity, err := parseFixitySettings(os.Getenv("FIXITY_INTERVAL"), os.Getenv("FIXITY_MODE"))
+	if err != nil {
+		log.Fatalf("Invalid fixity settings: %v", err)
+	}
+
+	orphans, err := parseOrphanSettings(os.Getenv("ORPHAN_SCAN_INTERVAL"), os.Getenv("ORPHAN_SCAN_MODE"))
+	if err != nil {
+		log.Fatalf("Invalid orphan scan settings: %v", err)
+	}
+
+	cdnDistributionID, err := parseCDNDistributionID(os.Getenv("CLOUDFRONT_DISTRIBUTION_ID"))
+	if err != nil {
+		log.Fatalf("Invalid CloudFront distribution: %v", err)
+	}
+
+	archive, err := parseArchiveSettings(os.Getenv("ARCHIVE_AFTER_DAYS"), os.Getenv("ARCHIVE_STORAGE_CLASS"), os.Getenv("ARCHIVE_RESTORE_TIER"))
+	if err != nil {
+		log.Fatalf("Invalid archive settings: %v", err)
+	}
+
+	media, err := parseMediaTools(os.Getenv("FFPROBE_PATH"), os.Getenv("FFMPEG_PATH"), os.Getenv("FFPROBE_TIMEOUT"))
+	if err != nil {
+		log.Fatalf("Invalid media tool settings: %v", err)
+	}
+
+	transcription, err := parseTranscription(
+		os.Getenv("TRANSCRIPTION"),
+		os.Getenv("WHISPER_PATH"),
+		os.Getenv("WHISPER_MODEL"),
+		os.Getenv("TRANSCRIPTION_LANGUAGE"),
+	)
+	if err != nil {
+		log.Fatalf("Invalid transcription settings: %v", err)
+	}
+
+	codecPolicy, err := parseCodecPolicy(os.Getenv("INCOMPATIBLE_CODECS"))
+	if err != nil {
+		log.Fatalf("Invalid codec policy: %v", err)
+	}
+
+	virusScan, err := parseVirusScan(os.Getenv("VIRUS_SCAN"))
+	if err != nil {
+		log.Fatalf("Invalid virus scan settings: %v", err)
+	}
+	clamdAddress := os.Getenv("CLAMD_ADDRESS")
+	if clamdAddress == "" {
+		clamdAddress = defaultClamdAddress
+	}
+
+	moderation, err := parseModeration(
+		os.Getenv("MODERATION"),
+		os.Getenv("MODERATION_COMMAND"),
+		os.Getenv("MODERATION_MIN_CONFIDENCE"),
+	)
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		log.Fatalf("Invalid moderation settings: %v", err)
 	}
-	s3Client := s3.NewFromConfig(awsCfg)
+
+	watchFolder, err := parseWatchFolder(
+		os.Getenv("WATCH_FOLDER"),
+		os.Getenv("WATCH_FOLDER_OWNER"),
+		os.Getenv("WATCH_FOLDER_INTERVAL"),
+	)
+	if err != nil {
+		log.Fatalf("Invalid watch folder settings: %v", err)
+	}
+
+	publicURL, err := parsePublicURL(os.Getenv("PUBLIC_URL"), port)
+	if err != nil {
+		log.Fatalf("Invalid public URL: %v", err)
+	}
+	hotlink, err := parseHotlinkSettings(os.Getenv("HOTLINK_ALLOWED_ORIGINS"), os.Getenv("HOTLINK_ALLOW_NO_REFERER"), os.Getenv("HOTLINK_URL_EXPIRY"), publicURL)
+	if err != nil {
+		log.Fatalf("Invalid hotlink protection settings: %v", err)
+	}
+	cors, err := parseCORSSettings(os.Getenv("CORS_ALLOWED_ORIGINS"), os.Getenv("CORS_ALLOWED_METHODS"), os.Getenv("CORS_ALLOWED_HEADERS"), os.Getenv("CORS_EXPOSED_HEADERS"))
+	if err != nil {
+		log.Fatalf("Invalid CORS settings: %v", err)
+	}
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
+	events, err := parseEventSettings(
+		os.Getenv("EVENTS_SNS_TOPIC_ARN"),
+		os.Getenv("EVENTS_EVENTBRIDGE_BUS"),
+		os.Getenv("EVENTS_SOURCE"),
+		os.Getenv("EVENTS_KAFKA_REST_URL"),
+		os.Getenv("EVENTS_KAFKA_TOPIC"),
+		os.Getenv("EVENTS_LOG"),
+		s3Region,
+	)
+	if err != nil {
+		log.Fatalf("Invalid event settings: %v", err)
+	}
+
+	oauthProviders, err := parseOAuthProviders(os.Getenv, publicURL)
+	if err != nil {
+		log.Fatalf("Invalid OAuth settings: %v", err)
+	}
+
+	videoDelivery, err := parseVideoDelivery(os.Getenv("VIDEO_DELIVERY"))
+	if err != nil {
+		log.Fatalf("Invalid video delivery: %v", err)
+	}
+
+	videoVersionLimit, err := parseVideoVersionLimit(os.Getenv("VIDEO_VERSION_LIMIT"))
+	if err != nil {
+		log.Fatalf("Invalid video version limit: %v", err)
+	}
+
+	tempVolumes, err := parseTempVolumes(os.Getenv("TEMP_VOLUMES"))
+	if err != nil {
+		log.Fatalf("Invalid TEMP_VOLUMES: %v", err)
+	}
+	tempStore, err := tempstore.New(tempVolumes)
+	if err != nil {
+		log.Fatalf("Couldn't set up temp storage: %v", err)
+	}
+	if serving || command == "worker" {
+		removed, err := tempStore.CleanupStale()
+		if err != nil {
+			log.Printf("Couldn't clean up stale temp files: %v", err)
+		} else if removed > 0 {
+			log.Printf("Removed %d stale temp files", removed)
+		}
+	}
+	expvar.Publish("temp_volumes", expvar.Func(func() any { return tempStore.Usage() }))
+
+	// Slack allows roughly one message per second per webhook, stay well under
+	notifier := notify.New(30, 5)
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't set up tracing: %v", err)
+	}
+
+	ctx := context.Background()
+	// S3 calls are retried by withS3Retry, which also bounds each attempt.
+	// Leaving the SDK's own retries on would multiply the attempts.
+	s3Options := func(o *s3.Options) {
+		o.RetryMaxAttempts = 1
+		o.APIOptions = append(o.APIOptions, traceAWSCalls)
+	}
+	var s3Client *s3.Client
+	var awsCfg aws.Config
+	var sandboxStore *blobstore.Memory
+	if sandbox {
+		sandboxStore = blobstore.NewMemory()
+		s3Client = newSandboxS3Client(sandboxStore, s3Region, port, s3Options)
+	} else {
+		// use config.LoadDefaultConfig to auto load the default AWS SDK config as args we give an empty Context and pass config.WithRegion(s3Region)
+		awsCfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatalf("unable to load SDK config, %v", err)
+		}
+		s3Client = s3.NewFromConfig(awsCfg, s3Options)
+	}
+	buckets := newBucketResolver(bucketSettings, func(region string) *s3.Client {
+		// the sandbox's buckets are all in the one in-memory store
+		if sandbox || region == s3Region {
+			return s3Client
+		}
+		return s3.NewFromConfig(awsCfg, s3Options, func(o *s3.Options) { o.Region = region })
+	})
 
 	// debug print
-	log.Printf("S3 configured: bucket=%s region=%s", s3Bucket, s3Region)
+	if sandbox {
+		log.Printf("Sandbox mode: objects are kept in memory and served at %s, media processing is faked", sandboxS3Endpoint(port))
+	} else {
+		log.Printf("S3 configured: bucket=%s region=%s", s3Bucket, s3Region)
+		if bucketSettings.Sharding != "" {
+			log.Printf("Videos sharded by %s across %d buckets", bucketSettings.Sharding, len(bucketSettings.Buckets))
+		}
+	}
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		db:                db,
+		jwtSecret:         jwtSecret,
+		platform:          platform,
+		filepathRoot:      filepathRoot,
+		assetsRoot:        assetsRoot,
+		s3Region:          s3Region,
+		s3CfDistribution:  s3CfDistribution,
+		port:              port,
+		s3Client:          s3Client,
+		buckets:           buckets,
+		s3ObjectSettings:  s3ObjectSettings,
+		s3Uploads:         newS3Uploads(s3Uploads),
+		s3ImportBuckets:   s3ImportBuckets,
+		tempStore:         tempStore,
+		notifier:          notifier,
+		uploadLimits:      uploadLimits,
+		storageQuotas:     storageQuotas,
+		logger:            logger,
+		tombstoneWake:     make(chan struct{}, 1),
+		fixity:            fixity,
+		sandbox:           sandbox,
+		inflight:          newInflightWork(),
+		publicURL:         publicURL,
+		oauthProviders:    oauthProviders,
+		videoDelivery:     videoDelivery,
+		videoVersionLimit: videoVersionLimit,
+		orphans:           orphans,
+		media:             media,
+		orphanScanning:    &sync.Mutex{},
+	}
+	cfg.transcriber = cfg.newTranscriber(transcription, awsCfg)
+	cfg.transcriptionLanguage = transcription.Language
+	cfg.moderator = cfg.newModerator(moderation, awsCfg)
+	cfg.moderationMinConfidence = moderation.MinConfidence
+	cfg.virusScanner = cfg.newVirusScanner(virusScan, clamdAddress)
+	cfg.codecPolicy = codecPolicy
+	// sandbox mode runs on a developer's machine, where the files to
+	// ingest are likely served locally too
+	cfg.ingestClient = newIngestHTTPClient(sandbox)
+	cfg.watchFolder = watchFolder
+	// the sandbox bucket has no acceleration endpoint
+	cfg.s3Accelerate = s3Accelerate && !sandbox
+	cfg.archive = archive
+	cfg.restoreWake = make(chan struct{}, 1)
+	cfg.cdn = cfg.newCDNInvalidator(cdnDistributionID, awsCfg)
+	cfg.hotlink = hotlink
+	cfg.cors = cors
+	cfg.trustedProxies = trustedProxies
+	cfg.unlockLimits = newUnlockLimits()
+	cfg.jobQueue = newJobQueue(jobQueue, awsCfg)
+	cfg.workerConcurrency = jobQueue.Concurrency
+	cfg.instanceID = newInstanceID()
+	cfg.events = cfg.newEventPublishers(events, awsCfg)
+
+	if command == "check" {
+		os.Exit(cfg.runCheckCommand(ctx, os.Args[2:]))
+	}
+
+	err = cfg.validateStartup(ctx)
+	if err != nil {
+		log.Fatalf("Startup checks failed:\n%v", err)
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -110,33 +521,221 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
-	mux := http.NewServeMux()
+	// "tubely worker" runs jobs from the queue instead of serving the API
+	if command == "worker" {
+		stopping, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go cfg.runJobLeaseRenewer(cfg.inflight.ctx)
+		cfg.runWorker(stopping)
+		<-stopping.Done()
+		// a second signal exits right away
+		stop()
+		cfg.shutdown(nil, shutdownTimeout)
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Couldn't flush traces: %v", err)
+		}
+		log.Printf("Worker stopped")
+		return
+	}
+
+	mux := newVersionedMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
+	if sandbox {
+		mux.Handle(sandboxS3Path+"/", http.StripPrefix(sandboxS3Path, blobstore.ReadOnly(sandboxStore)))
+	}
+
+	mux.HandleFunc("GET /embed/{videoID}", cfg.handlerEmbed)
+
+	mux.HandleFunc("GET /api/openapi.json", cfg.handlerOpenAPI)
+	mux.HandleFunc("GET /api/oembed", cfg.handlerOEmbed)
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/oauth/providers", cfg.handlerOAuthProviders)
+	mux.HandleFunc("GET /api/oauth/{provider}/login", cfg.handlerOAuthLogin)
+	mux.HandleFunc("GET /api/oauth/{provider}/callback", cfg.handlerOAuthCallback)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("GET /api/quota", cfg.handlerQuotaGet)
+	mux.HandleFunc("GET /api/users/{userID}/feed", cfg.handlerUserFeed)
+
+	mux.HandleFunc("POST /api/videos", cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerVideoMetaCreate)))
+	mux.HandleFunc("POST /api/videos/precheck", cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerUploadPrecheck)))
+	mux.HandleFunc("POST /api/videos/batch", cfg.honorRequestStart(cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerUploadBatch))))
+	mux.HandleFunc("POST /api/videos/import/zip", cfg.honorRequestStart(cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerUploadZip))))
+	mux.HandleFunc("POST /api/videos/{videoID}/import/s3", cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.idempotent(cfg.handlerImportS3))))
+	mux.HandleFunc("POST /api/videos/{videoID}/ingest", cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.idempotent(cfg.handlerIngestURL))))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.honorRequestStart(cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerUploadThumbnail))))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", traceRoute(cfg.honorRequestStart(cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.idempotent(cfg.handlerUploadVideo))))))
+	mux.HandleFunc("GET /api/videos", cfg.acceptAPIKey(cfg.handlerVideosRetrieve))
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
+	mux.HandleFunc("GET /api/triggers/videos/new", cfg.handlerTriggerNewVideos)
+	mux.HandleFunc("GET /api/triggers/videos/ready", cfg.handlerTriggerReadyVideos)
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.acceptAPIKey(cfg.handlerVideoGet))
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerVideoMetaUpdate)))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.acceptAPIKey(cfg.requireRole(auth.RoleCreator, cfg.handlerVideoMetaDelete)))
+	mux.HandleFunc("GET /api/videos/{videoID}/renditions", cfg.handlerRenditionsGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.handlerVideoVersionsGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{version}/restore", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoVersionRestore))
+	mux.HandleFunc("GET /api/videos/{videoID}/archive", cfg.handlerVideoArchiveGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/archive/restore", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoArchiveRestore))
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.blockHotlinks(cfg.handlerVideoStream))
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.blockHotlinks(cfg.handlerVideoDownload))
+	mux.HandleFunc("POST /api/videos/{videoID}/views", cfg.handlerVideoViewCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/stats", cfg.handlerVideoStatsGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/analytics", cfg.handlerVideoAnalyticsGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/duplicates", cfg.handlerVideoDuplicatesGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail/from-frame", cfg.requireRole(auth.RoleCreator, cfg.handlerThumbnailFromFrame))
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.acceptAPIKey(cfg.handlerJobGet))
+	mux.HandleFunc("POST /api/videos/{videoID}/extract-audio", cfg.requireRole(auth.RoleCreator, cfg.handlerExtractAudio))
+	mux.HandleFunc("POST /api/videos/{videoID}/reprocess", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoReprocess))
+	mux.HandleFunc("POST /api/videos/{videoID}/share", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoShare))
+	mux.HandleFunc("GET /api/share/{token}", cfg.handlerShareResolve)
+	mux.HandleFunc("POST /api/videos/{videoID}/playback-token", cfg.handlerPlaybackTokenCreate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/password", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoPasswordSet))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/password", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoPasswordDelete))
+	mux.HandleFunc("POST /api/videos/{videoID}/unlock", cfg.handlerVideoUnlock)
+	mux.HandleFunc("GET /api/playback/{token}", cfg.blockHotlinks(cfg.handlerPlayback))
+	mux.HandleFunc("GET /api/videos/{videoID}/localizations", cfg.handlerVideoLocalizationsGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/localizations/{language}", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoLocalizationPut))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/localizations/{language}", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoLocalizationDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.handlerVideoCaptionsGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/captions/{language}", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoCaptionPut))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoCaptionDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/clips", cfg.handlerVideoClipsGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/clips", cfg.requireRole(auth.RoleCreator, cfg.handlerCreateClip))
+	mux.HandleFunc("PATCH /api/videos/{videoID}/chapters", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoChaptersPatch))
+	mux.HandleFunc("GET /api/videos/{videoID}/transcript", cfg.handlerVideoTranscriptGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/transcribe", cfg.requireRole(auth.RoleCreator, cfg.handlerTranscribeVideo))
+	mux.HandleFunc("GET /api/videos/{videoID}/tags", cfg.handlerVideoTagsGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/tags", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoTagsAdd))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/tags/{tag}", cfg.requireRole(auth.RoleCreator, cfg.handlerVideoTagRemove))
+
+	mux.HandleFunc("POST /api/api-keys", cfg.requireRole(auth.RoleCreator, cfg.handlerAPIKeyCreate))
+	mux.HandleFunc("GET /api/api-keys", cfg.handlerAPIKeysRetrieve)
+	mux.HandleFunc("DELETE /api/api-keys/{keyID}", cfg.handlerAPIKeyRevoke)
+
+	mux.HandleFunc("POST /api/playlists", cfg.requireRole(auth.RoleCreator, cfg.handlerPlaylistCreate))
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsRetrieve)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}", cfg.requireRole(auth.RoleCreator, cfg.handlerPlaylistUpdate))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.requireRole(auth.RoleCreator, cfg.handlerPlaylistDelete))
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.requireRole(auth.RoleCreator, cfg.handlerPlaylistVideoAdd))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.requireRole(auth.RoleCreator, cfg.handlerPlaylistVideoRemove))
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/order", cfg.requireRole(auth.RoleCreator, cfg.handlerPlaylistReorder))
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/integrations", cfg.requireRole(auth.RoleCreator, cfg.handlerIntegrationCreate))
+	mux.HandleFunc("GET /api/integrations", cfg.handlerIntegrationsRetrieve)
+	mux.HandleFunc("DELETE /api/integrations/{integrationID}", cfg.requireRole(auth.RoleCreator, cfg.handlerIntegrationDelete))
+
+	mux.HandleFunc("POST /api/organizations", cfg.requireRole(auth.RoleCreator, cfg.handlerOrganizationCreate))
+	mux.HandleFunc("GET /api/organizations/{orgID}", cfg.handlerOrganizationGet)
+	mux.HandleFunc("POST /api/organizations/{orgID}/members", cfg.requireRole(auth.RoleCreator, cfg.handlerOrganizationMemberAdd))
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/members/{userID}", cfg.requireRole(auth.RoleCreator, cfg.handlerOrganizationMemberRemove))
+	mux.HandleFunc("GET /api/organizations/{orgID}/upload-policy", cfg.handlerUploadPolicyGet)
+	mux.HandleFunc("PUT /api/organizations/{orgID}/upload-policy", cfg.requireRole(auth.RoleCreator, cfg.handlerUploadPolicyUpdate))
+
+	mux.HandleFunc("POST /api/admin/impersonations", cfg.requireRole(auth.RoleAdmin, cfg.handlerImpersonationStart))
+	mux.HandleFunc("GET /api/admin/audit-log", cfg.requireRole(auth.RoleAdmin, cfg.handlerAuditLogGet))
+	mux.HandleFunc("GET /api/admin/users", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUsersGet))
+	mux.HandleFunc("GET /api/admin/users/{userID}/usage", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserUsageGet))
+	mux.HandleFunc("PUT /api/admin/users/{userID}/role", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserRoleUpdate))
+	mux.HandleFunc("PUT /api/admin/users/{userID}/storage-region", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminUserStorageRegionUpdate))
+	mux.HandleFunc("GET /api/admin/videos", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminVideosGet))
+	mux.HandleFunc("GET /api/admin/videos/duplicates", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminDuplicatesGet))
+	mux.HandleFunc("DELETE /api/admin/videos/{videoID}", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminVideoDelete))
+	mux.HandleFunc("GET /api/admin/videos/{videoID}/moderation", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminModerationGet))
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/moderation", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminModerationReview))
+	mux.HandleFunc("POST /api/admin/storage/orphans", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminOrphansScan))
+	mux.HandleFunc("GET /api/admin/storage/check", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminStorageCheck))
+	mux.HandleFunc("GET /api/admin/jobs", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminJobsGet))
+	mux.HandleFunc("GET /api/admin/dead-letters", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminDeadLettersGet))
+	mux.HandleFunc("POST /api/admin/dead-letters/{jobID}/requeue", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminDeadLetterRequeue))
+	mux.HandleFunc("DELETE /api/admin/dead-letters/{jobID}", cfg.requireRole(auth.RoleAdmin, cfg.handlerAdminDeadLetterDiscard))
+	mux.HandleFunc("GET /api/admin/debug/vars", cfg.requireRole(auth.RoleAdmin, expvar.Handler().ServeHTTP))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
+	// background workers stop as soon as a shutdown starts, anything they
+	// didn't get to is picked up after the restart
+	stopping, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go cfg.backfillBlurhashes(stopping)
+	go cfg.runRetentionSweeper(stopping)
+	go cfg.runTombstoneWorker(stopping)
+	go cfg.runPendingObjectCollector(stopping)
+	if cfg.jobQueue != nil {
+		go cfg.runStagedUploadSweeper(stopping)
+	}
+	if fixity.Interval > 0 {
+		go cfg.runFixityChecker(stopping)
+	}
+	if orphans.Interval > 0 {
+		go cfg.runOrphanCollector(stopping)
+	}
+	if watchFolder.Dir != "" {
+		go cfg.runWatchFolder(stopping)
+	}
+	if archive.After > 0 {
+		go cfg.runArchiver(stopping)
+	}
+	// restores already asked for finish even with archiving turned off
+	go cfg.runRestoreWorker(stopping)
+	go cfg.runJobLeaseRenewer(cfg.inflight.ctx)
+	go cfg.runJobQueue(stopping)
+
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: cfg.withRequestID(cfg.logRequests(cfg.handleCORS(cfg.recoverPanics(cfg.impersonationAudit(mux))))),
+		// requests are canceled if they outlast the shutdown timeout
+		BaseContext: func(net.Listener) context.Context { return cfg.inflight.ctx },
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving on: http://localhost:%s/app/\n", port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var grpcSrv *grpc.Server
+	if grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Couldn't listen for gRPC: %v", err)
+		}
+		grpcSrv = cfg.newGRPCServer()
+		go func() {
+			log.Printf("Serving gRPC on: localhost:%s\n", grpcPort)
+			serveErr <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	select {
+	case err = <-serveErr:
+		shutdownTracing(context.Background())
+		log.Fatal(err)
+	case <-stopping.Done():
+	}
+	// a second signal exits right away
+	stop()
+
+	if grpcSrv != nil {
+		// calls in flight are waited for with the HTTP requests, and
+		// canceled with them if they take too long
+		go grpcSrv.GracefulStop()
+	}
+	cfg.shutdown(srv, shutdownTimeout)
+	if grpcSrv != nil {
+		grpcSrv.Stop()
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Printf("Couldn't flush traces: %v", err)
+	}
+	log.Printf("Server stopped")
 }
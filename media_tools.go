@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// minMediaToolsVersion is the oldest ffmpeg release, by major version,
+// that Tubely's arguments and ffprobe's JSON output are known to work with
+const minMediaToolsVersion = 4
+
+// errMediaToolsUnavailable is returned when ffprobe or ffmpeg can't be run
+// at all, as opposed to failing on a file.
+var errMediaToolsUnavailable = errors.New("media tools are unavailable")
+
+// errorCodeProcessingUnavailable marks failures of media tools that can't
+// run, so clients know to retry the same file later
+const errorCodeProcessingUnavailable = "processing_unavailable"
+
+// mediaTools are the ffprobe and ffmpeg binaries media processing runs.
+type mediaTools struct {
+	FFprobe string
+	FFmpeg  string
+	// ProbeTimeout bounds every ffprobe run
+	ProbeTimeout time.Duration
+}
+
+// mediaToolsVersion matches the version line of both tools, such as
+// "ffprobe version 6.1.1-3ubuntu5" or "ffmpeg version n7.0". Builds from
+// git report "N-113684-g…" and carry no release number.
+var mediaToolsVersion = regexp.MustCompile(`^\S+ version n?(\d+)\.`)
+
+// check makes sure both tools run and are recent enough. Sandbox mode
+// fakes them, so they needn't be installed there.
+func (m mediaTools) check(ctx context.Context) error {
+	if sandboxMedia {
+		return nil
+	}
+	var errs []error
+	for _, tool := range []struct{ name, path, setting string }{
+		{"ffprobe", m.FFprobe, "FFPROBE_PATH"},
+		{"ffmpeg", m.FFmpeg, "FFMPEG_PATH"},
+	} {
+		if _, err := exec.LookPath(tool.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s not found at %s: install ffmpeg (which includes it), set %s to where it is, or set TUBELY_SANDBOX=1 to try Tubely without it", tool.name, tool.path, tool.setting))
+			continue
+		}
+		out, err := exec.CommandContext(ctx, tool.path, "-version").Output()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s at %s doesn't run: %w", tool.name, tool.path, err))
+			continue
+		}
+		firstLine, _, _ := bytes.Cut(out, []byte("\n"))
+		match := mediaToolsVersion.FindSubmatch(firstLine)
+		if match == nil {
+			// a git build, assumed to be recent
+			continue
+		}
+		if major, _ := strconv.Atoi(string(match[1])); major < minMediaToolsVersion {
+			errs = append(errs, fmt.Errorf("%s at %s is version %s, Tubely needs %d or newer", tool.name, tool.path, match[1], minMediaToolsVersion))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// toolFailure is a tool that ran and failed, with what it printed, kept so
+// it can be shown apart from the error, as dead letters do.
+type toolFailure struct {
+	tool   string
+	err    error
+	output string
+}
+
+func (e *toolFailure) Error() string {
+	return fmt.Sprintf("%s failed: %v: %s", e.tool, e.err, e.output)
+}
+
+func (e *toolFailure) Unwrap() error {
+	return e.err
+}
+
+// toolOutput returns what the tool behind err printed, "" if err isn't a
+// tool's failure.
+func toolOutput(err error) string {
+	var failure *toolFailure
+	if errors.As(err, &failure) {
+		return failure.output
+	}
+	return ""
+}
+
+// toolError wraps the error of running a media tool, marking it as
+// errMediaToolsUnavailable if the binary couldn't be started.
+func toolError(path string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%w: %s: %v", errMediaToolsUnavailable, path, err)
+	}
+	return err
+}
+
+// mediaIngestError reports a media tool failure during ingest: a 503 if
+// the tools are unavailable, since the upload can succeed once they're
+// back, a 500 otherwise.
+func mediaIngestError(message string, err error) *ingestError {
+	if errors.Is(err, errMediaToolsUnavailable) {
+		return &ingestError{http.StatusServiceUnavailable, "Video processing is unavailable right now, try again later", err}
+	}
+	return &ingestError{http.StatusInternalServerError, message, err}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	moderationRekognition = "rekognition"
+	moderationCommand     = "command"
+	// defaultModerationMinConfidence is how sure moderation has to be of a
+	// label, from 0 to 100, for it to hold a video
+	defaultModerationMinConfidence = 80
+)
+
+// moderationSettings choose how videos are moderated, if at all.
+type moderationSettings struct {
+	// Provider is rekognition, command or "" for no moderation
+	Provider string
+	// Command is the local model run on frames with the command provider
+	Command       string
+	MinConfidence float64
+}
+
+// moderator looks for unsafe content, such as nudity or violence, in a
+// frame of a video.
+type moderator interface {
+	// provider names the moderator in logs
+	provider() string
+	// check makes sure the moderator can be used, at startup
+	check(ctx context.Context) error
+	// moderate returns the labels found in the JPEG at framePath, with
+	// their confidence but no timestamp
+	moderate(ctx context.Context, framePath string) ([]database.ModerationLabel, error)
+}
+
+// newModerator returns the moderator settings choose, nil if moderation is
+// off. Sandbox mode fakes Rekognition; a command runs as it would anywhere.
+func (cfg *apiConfig) newModerator(settings moderationSettings, awsCfg aws.Config) moderator {
+	switch {
+	case settings.Provider == "":
+		return nil
+	case settings.Provider == moderationCommand:
+		return commandModerator{path: settings.Command}
+	case cfg.sandbox:
+		return sandboxModerator{}
+	default:
+		client := rekognition.NewFromConfig(awsCfg, func(o *rekognition.Options) {
+			o.Region = cfg.s3Region
+			o.APIOptions = append(o.APIOptions, traceAWSCalls)
+		})
+		return rekognitionModerator{client: client}
+	}
+}
+
+// commandModerator runs a local model on frames. It's called with the
+// frame's path as its only argument and prints a JSON array of labels:
+//
+//	[{"name": "Explicit Nudity", "parent_name": "", "confidence": 97.5}]
+//
+// An empty array means it found nothing.
+type commandModerator struct {
+	path string
+}
+
+func (m commandModerator) provider() string {
+	return moderationCommand
+}
+
+func (m commandModerator) check(ctx context.Context) error {
+	if _, err := exec.LookPath(m.path); err != nil {
+		return fmt.Errorf("moderation command not found at %s: set MODERATION_COMMAND to a model that can be run, or unset MODERATION", m.path)
+	}
+	return nil
+}
+
+func (m commandModerator) moderate(ctx context.Context, framePath string) ([]database.ModerationLabel, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, m.path, framePath)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	killProcessGroup(cmd)
+	if err := cmd.Run(); err != nil {
+		if err := toolError(m.path, err); errors.Is(err, errMediaToolsUnavailable) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &toolFailure{"moderation command", err, stderr.String()}
+	}
+	var labels []database.ModerationLabel
+	if err := json.Unmarshal(stdout.Bytes(), &labels); err != nil {
+		return nil, fmt.Errorf("couldn't parse moderation command output: %w", err)
+	}
+	return labels, nil
+}
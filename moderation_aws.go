@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// rekognitionModerator sends frames to Amazon Rekognition's
+// DetectModerationLabels with the server's AWS credentials, which need the
+// rekognition:DetectModerationLabels permission.
+type rekognitionModerator struct {
+	client *rekognition.Client
+}
+
+func (m rekognitionModerator) provider() string {
+	return moderationRekognition
+}
+
+// check makes sure there are credentials to call Rekognition with. Every
+// Rekognition call that would prove more needs an image or a permission
+// moderation doesn't otherwise use.
+func (m rekognitionModerator) check(ctx context.Context) error {
+	credentials := m.client.Options().Credentials
+	if credentials == nil {
+		return errors.New("can't use Amazon Rekognition: no AWS credentials")
+	}
+	if _, err := credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("can't use Amazon Rekognition: %w", err)
+	}
+	return nil
+}
+
+func (m rekognitionModerator) moderate(ctx context.Context, framePath string) ([]database.ModerationLabel, error) {
+	frame, err := os.ReadFile(framePath)
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.client.DetectModerationLabels(ctx, &rekognition.DetectModerationLabelsInput{
+		Image: &types.Image{Bytes: frame},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't moderate frame: %w", err)
+	}
+	labels := make([]database.ModerationLabel, 0, len(out.ModerationLabels))
+	for _, label := range out.ModerationLabels {
+		labels = append(labels, database.ModerationLabel{
+			Name:       aws.ToString(label.Name),
+			ParentName: aws.ToString(label.ParentName),
+			Confidence: float64(aws.ToFloat32(label.Confidence)),
+		})
+	}
+	return labels, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/google/uuid"
+)
+
+// notifyUser posts event to the user's subscribed integrations in the
+// background, so a slow webhook never holds up the request that caused it.
+// Video events reach integrations through the event bus instead, see
+// publishEvent.
+func (cfg *apiConfig) notifyUser(userID uuid.UUID, event notify.Event) {
+	cfg.goBackground(func() {
+		if err := notifyIntegrations(cfg.inflight.ctx, cfg.db, cfg.notifier, userID, event); err != nil {
+			log.Printf("Couldn't load integrations for user %s: %v", userID, err)
+		}
+	})
+}
+
+// notifyIntegrations posts event to each of the user's integrations
+// subscribed to it. Integrations that can't be reached are logged and
+// skipped.
+func notifyIntegrations(ctx context.Context, db database.Client, notifier *notify.Notifier, userID uuid.UUID, event notify.Event) error {
+	integrations, err := db.GetIntegrationsForEvent(userID, event.Type)
+	if err != nil {
+		return err
+	}
+	for _, integration := range integrations {
+		ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		err := notifier.Send(ctx, notify.Target{
+			Kind:       integration.Kind,
+			WebhookURL: integration.WebhookURL,
+			Template:   integration.Template,
+		}, event)
+		cancel()
+		if err != nil {
+			log.Printf("Couldn't send %s to integration %s: %v", event.Type, integration.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"image"
+	_ "image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultEmbedWidth is how wide the player is embedded unless the
+	// consumer asks for less
+	defaultEmbedWidth = 640
+	// defaultAspectRatio is assumed for videos whose size isn't known
+	defaultAspectRatio = 16.0 / 9.0
+)
+
+// embedPage is the player the oEmbed iframe loads. Its meta tags let
+// sites that read Open Graph instead of oEmbed unfurl the video too.
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Video.Title}}</title>
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Video.Title}}">
+<meta property="og:type" content="video.other">
+<meta property="og:title" content="{{.Video.Title}}">
+<meta property="og:description" content="{{.Video.Description}}">
+<meta property="og:url" content="{{.PageURL}}">
+{{if .Video.ThumbnailURL}}<meta property="og:image" content="{{.Video.ThumbnailURL}}">
+{{end}}<meta property="og:video" content="{{.Video.VideoURL}}">
+<meta name="twitter:card" content="player">
+<meta name="twitter:player" content="{{.PageURL}}">
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+video { display: block; width: 100%; height: 100%; }
+</style>
+</head>
+<body>
+<video controls playsinline preload="metadata" src="{{.Video.VideoURL}}"{{if .Video.ThumbnailURL}} poster="{{.Video.ThumbnailURL}}"{{end}}>
+{{range .Video.Captions}}<track kind="subtitles" srclang="{{.Language}}" label="{{.Label}}" src="{{.URL}}">
+{{end}}</video>
+</body>
+</html>
+`))
+
+// embedPageURL is where video's player is, the link that unfurls.
+func (cfg *apiConfig) embedPageURL(videoID uuid.UUID) string {
+	return cfg.publicURL + "/embed/" + videoID.String()
+}
+
+// videoIDFromURL returns the video a link to this server names: its embed
+// page or its API URL.
+func (cfg *apiConfig) videoIDFromURL(rawURL string) (uuid.UUID, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	self, err := url.Parse(cfg.publicURL)
+	if err != nil || !strings.EqualFold(u.Host, self.Host) {
+		return uuid.Nil, false
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 {
+		return uuid.Nil, false
+	}
+	if parent := segments[len(segments)-2]; parent != "embed" && parent != "videos" {
+		return uuid.Nil, false
+	}
+	videoID, err := uuid.Parse(segments[len(segments)-1])
+	return videoID, err == nil
+}
+
+// embeddableVideo gets a video anyone may play embedded in another site,
+// presented and localized for r. Private and password protected videos
+// can't be, as a page embedding them has no credentials to send.
+func (cfg *apiConfig) embeddableVideo(w http.ResponseWriter, r *http.Request, videoID uuid.UUID) (database.Video, bool) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || !canView(video, uuid.Nil) || video.VideoURL == nil {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if respondIfArchived(w, video) {
+		return database.Video{}, false
+	}
+	video, err = cfg.presentVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate playback URL", err)
+		return database.Video{}, false
+	}
+	video, err = cfg.localizeVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't localize video", err)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// embedSize is the size to embed video's player at: defaultEmbedWidth
+// wide, or less to fit maxWidth and maxHeight when they're over 0, at the
+// video's aspect ratio.
+func embedSize(video database.Video, maxWidth, maxHeight int) (width, height int) {
+	aspect := defaultAspectRatio
+	if video.AspectRatio != nil && *video.AspectRatio > 0 {
+		aspect = *video.AspectRatio
+	}
+	width = defaultEmbedWidth
+	if maxWidth > 0 {
+		width = min(width, maxWidth)
+	}
+	height = int(math.Round(float64(width) / aspect))
+	if maxHeight > 0 && height > maxHeight {
+		height = maxHeight
+		width = int(math.Round(float64(height) * aspect))
+	}
+	return width, height
+}
+
+// thumbnailSize reads the size of video's thumbnail from its file, false
+// if it has none or it can't be read.
+func (cfg *apiConfig) thumbnailSize(video database.Video) (width, height int, ok bool) {
+	if video.ThumbnailURL == nil {
+		return 0, 0, false
+	}
+	assetPath, ok := cfg.assetPathFromURL(*video.ThumbnailURL)
+	if !ok {
+		return 0, 0, false
+	}
+	file, err := os.Open(cfg.getAssetDiskPath(assetPath))
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
+
+// handlerOEmbed describes a video link for sites that unfurl links with
+// oEmbed, with an iframe of the video's embed page. Only JSON is served.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Type            string `json:"type"`
+		Version         string `json:"version"`
+		Title           string `json:"title"`
+		ProviderName    string `json:"provider_name"`
+		ProviderURL     string `json:"provider_url"`
+		HTML            string `json:"html"`
+		Width           int    `json:"width"`
+		Height          int    `json:"height"`
+		ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+		ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+		ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	}
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		respondWithError(w, http.StatusNotImplemented, "Only the json format is supported", nil)
+		return
+	}
+	videoID, ok := cfg.videoIDFromURL(query.Get("url"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "url isn't a link to a video", nil)
+		return
+	}
+	maxSize := map[string]int{}
+	for _, name := range []string{"maxwidth", "maxheight"} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				respondWithError(w, http.StatusBadRequest, name+" must be a positive number of pixels", err)
+				return
+			}
+			maxSize[name] = n
+		}
+	}
+
+	video, ok := cfg.embeddableVideo(w, r, videoID)
+	if !ok {
+		return
+	}
+	width, height := embedSize(video, maxSize["maxwidth"], maxSize["maxheight"])
+	resp := response{
+		Type:         "video",
+		Version:      "1.0",
+		Title:        video.Title,
+		ProviderName: "Tubely",
+		ProviderURL:  cfg.publicURL,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0" allow="autoplay; fullscreen; picture-in-picture" allowfullscreen></iframe>`,
+			html.EscapeString(cfg.embedPageURL(video.ID)), width, height, html.EscapeString(video.Title)),
+		Width:  width,
+		Height: height,
+	}
+	// a thumbnail is only described with its size
+	if thumbWidth, thumbHeight, ok := cfg.thumbnailSize(video); ok {
+		resp.ThumbnailURL = *video.ThumbnailURL
+		resp.ThumbnailWidth = thumbWidth
+		resp.ThumbnailHeight = thumbHeight
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerEmbed serves the page embedding a video's player, for iframes.
+// With HOTLINK_ALLOWED_ORIGINS only those sites may frame it.
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, ok := cfg.embeddableVideo(w, r, videoID)
+	if !ok {
+		return
+	}
+
+	pageURL := cfg.embedPageURL(video.ID)
+	if len(cfg.hotlink.AllowedOrigins) > 0 {
+		w.Header().Set("Content-Security-Policy", "frame-ancestors "+strings.Join(cfg.hotlink.AllowedOrigins, " "))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language")
+	err = embedPage.Execute(w, struct {
+		Video     database.Video
+		PageURL   string
+		OEmbedURL string
+	}{
+		Video:     video,
+		PageURL:   pageURL,
+		OEmbedURL: cfg.publicURL + "/api/v1/oembed?" + url.Values{"url": {pageURL}}.Encode(),
+	})
+	if err != nil {
+		loggerFrom(r.Context()).Error("couldn't render embed page", "video_id", video.ID, "error", err)
+	}
+}
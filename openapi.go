@@ -0,0 +1,653 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// openAPIAuth is how an operation authenticates.
+type openAPIAuth int
+
+const (
+	// authNone is a public endpoint
+	authNone openAPIAuth = iota
+	// authOptional is public, but shows signed in users more, such as
+	// their own private videos
+	authOptional
+	// authBearer takes an access token
+	authBearer
+	// authBearerOrAPIKey takes an access token or an API key
+	authBearerOrAPIKey
+	// authRefresh takes a refresh token as the bearer token
+	authRefresh
+)
+
+// openAPIParam is a query parameter.
+type openAPIParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// openAPIFormField is a field of a multipart/form-data request body.
+type openAPIFormField struct {
+	Name        string
+	Description string
+	File        bool
+	Required    bool
+}
+
+// openAPIOperation describes an endpoint for the OpenAPI document. Body
+// and Response are values of the Go types the handler decodes and
+// responds with, whose schemas are generated from their JSON encoding.
+type openAPIOperation struct {
+	Method string
+	// Path is relative to the API's base path, such as /videos/{videoID}
+	Path    string
+	Summary string
+	Tag     string
+	Auth    openAPIAuth
+	Query   []openAPIParam
+	Body    any
+	Form    []openAPIFormField
+	// Status is the success status, 200 if unset
+	Status int
+	// Response is nil for a response without a body
+	Response any
+	// ResponseType is the media type of a response that isn't JSON
+	ResponseType string
+	// Errors are the error statuses the endpoint responds with, besides
+	// the ones every endpoint can
+	Errors []int
+	// Idempotent endpoints accept an Idempotency-Key header
+	Idempotent bool
+}
+
+// Request and response bodies that handlers declare inline, restated here
+// so their schemas can be generated.
+type (
+	openAPICredentials struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	openAPITokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	openAPILoginResponse struct {
+		database.User
+		openAPITokens
+	}
+	openAPIOAuthProvider struct {
+		Name     string `json:"name"`
+		LoginURL string `json:"login_url"`
+	}
+	openAPIAPIKeyCreate struct {
+		Name string `json:"name"`
+	}
+	openAPIAPIKeyCreated struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+	openAPIVideoCreate struct {
+		Title           string   `json:"title"`
+		Description     string   `json:"description,omitempty"`
+		Visibility      string   `json:"visibility,omitempty"`
+		DefaultLanguage string   `json:"default_language,omitempty"`
+		Tags            []string `json:"tags,omitempty"`
+	}
+	openAPIVideoUpdate struct {
+		Title           *string `json:"title,omitempty"`
+		Description     *string `json:"description,omitempty"`
+		Visibility      *string `json:"visibility,omitempty"`
+		DefaultLanguage *string `json:"default_language,omitempty"`
+	}
+	openAPIPrecheck struct {
+		SHA256      string `json:"sha256"`
+		Size        int64  `json:"size"`
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Visibility  string `json:"visibility,omitempty"`
+		Filename    string `json:"filename,omitempty"`
+	}
+	openAPIPrecheckResult struct {
+		Exists bool            `json:"exists"`
+		Video  *database.Video `json:"video,omitempty"`
+	}
+	openAPIUploadResult struct {
+		Message  string `json:"message"`
+		VideoURL string `json:"video_url"`
+	}
+	openAPIZipResult struct {
+		Created int            `json:"created"`
+		Failed  int            `json:"failed"`
+		Skipped int            `json:"skipped"`
+		Results []importResult `json:"results"`
+	}
+	openAPIBatchResult struct {
+		Created int            `json:"created"`
+		Failed  int            `json:"failed"`
+		Skipped int            `json:"skipped"`
+		Results []importResult `json:"results"`
+		Stopped string         `json:"stopped,omitempty"`
+	}
+	openAPIImportS3 struct {
+		URL     string `json:"url,omitempty"`
+		Bucket  string `json:"bucket,omitempty"`
+		Key     string `json:"key,omitempty"`
+		Profile string `json:"profile,omitempty"`
+	}
+	openAPIIngestURL struct {
+		URL               string `json:"url"`
+		Profile           string `json:"profile,omitempty"`
+		NormalizeLoudness bool   `json:"normalize_loudness,omitempty"`
+	}
+	openAPIVideoVersion struct {
+		database.VideoVersion
+		Current bool `json:"current"`
+	}
+	openAPIVideoAnalytics struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Windows []struct {
+			Window string     `json:"window"`
+			Since  *time.Time `json:"since"`
+			database.VideoActivity
+		} `json:"windows"`
+	}
+	openAPIExpiry struct {
+		ExpiresInSeconds int `json:"expires_in_seconds,omitempty"`
+	}
+	openAPIExpiringLink struct {
+		Token     string    `json:"token"`
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	openAPILocalizationPut struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	openAPIClipCreate struct {
+		Start       *float64 `json:"start"`
+		End         *float64 `json:"end"`
+		Title       string   `json:"title,omitempty"`
+		Description string   `json:"description,omitempty"`
+	}
+	openAPIChaptersPatch struct {
+		Chapters []database.Chapter `json:"chapters"`
+	}
+	openAPITranscribe struct {
+		Language string `json:"language,omitempty"`
+	}
+	openAPITags struct {
+		Tags []string `json:"tags"`
+	}
+	openAPIExtractAudio struct {
+		Format string `json:"format,omitempty"`
+	}
+	openAPIThumbnailFromFrame struct {
+		Timestamp *float64 `json:"timestamp,omitempty"`
+	}
+	openAPIVideoPassword struct {
+		Password string `json:"password"`
+	}
+	openAPIVideoAccess struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	openAPIDuplicate struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		Title     string    `json:"title"`
+		CreatedAt time.Time `json:"created_at"`
+		Distance  float64   `json:"distance"`
+	}
+	openAPIOEmbed struct {
+		Type            string `json:"type"`
+		Version         string `json:"version"`
+		Title           string `json:"title"`
+		ProviderName    string `json:"provider_name"`
+		ProviderURL     string `json:"provider_url"`
+		HTML            string `json:"html"`
+		Width           int    `json:"width"`
+		Height          int    `json:"height"`
+		ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+		ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+		ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	}
+)
+
+// openAPISchemaNames names the schemas of types whose Go names aren't
+// what clients should see.
+var openAPISchemaNames = map[reflect.Type]string{
+	reflect.TypeFor[errorResponse]():         "Error",
+	reflect.TypeFor[tooLargeResponse]():      "TooLargeError",
+	reflect.TypeFor[quotaResponse]():         "QuotaExceededError",
+	reflect.TypeFor[openAPILoginResponse]():  "Login",
+	reflect.TypeFor[openAPIAPIKeyCreated]():  "CreatedAPIKey",
+	reflect.TypeFor[openAPIUploadResult]():   "UploadResult",
+	reflect.TypeFor[openAPIPrecheckResult](): "PrecheckResult",
+	reflect.TypeFor[openAPIZipResult]():      "ZipImportResult",
+	reflect.TypeFor[openAPIExpiringLink]():   "ExpiringLink",
+}
+
+const videoPath = "/videos/{videoID}"
+
+// openAPIOperations are the endpoints the OpenAPI document describes: the
+// video, thumbnail and auth APIs.
+var openAPIOperations = []openAPIOperation{
+	{Method: "POST", Path: "/login", Tag: "auth", Summary: "Log in with an email and password", Body: openAPICredentials{}, Response: openAPILoginResponse{}, Errors: []int{401}},
+	{Method: "POST", Path: "/refresh", Tag: "auth", Summary: "Trade a refresh token for new tokens", Auth: authRefresh, Response: openAPITokens{}, Errors: []int{401}},
+	{Method: "POST", Path: "/revoke", Tag: "auth", Summary: "Revoke a refresh token", Auth: authRefresh, Status: 204},
+	{Method: "POST", Path: "/users", Tag: "auth", Summary: "Sign up", Body: openAPICredentials{}, Status: 201, Response: database.User{}},
+	{Method: "GET", Path: "/oauth/providers", Tag: "auth", Summary: "List the OAuth providers users can log in with", Response: []openAPIOAuthProvider{}},
+	{Method: "GET", Path: "/oauth/{provider}/login", Tag: "auth", Summary: "Start logging in with an OAuth provider, redirecting to it", Status: 302, Errors: []int{404}},
+	{Method: "GET", Path: "/oauth/{provider}/callback", Tag: "auth", Summary: "Finish an OAuth login, redirecting to the app with tokens", Status: 302},
+	{Method: "POST", Path: "/api-keys", Tag: "auth", Summary: "Create an API key", Auth: authBearer, Body: openAPIAPIKeyCreate{}, Status: 201, Response: openAPIAPIKeyCreated{}},
+	{Method: "GET", Path: "/api-keys", Tag: "auth", Summary: "List the user's API keys", Auth: authBearer, Response: []database.APIKey{}},
+	{Method: "DELETE", Path: "/api-keys/{keyID}", Tag: "auth", Summary: "Revoke an API key", Auth: authBearer, Status: 204, Errors: []int{404}},
+
+	{Method: "POST", Path: "/videos", Tag: "videos", Summary: "Create a video without a file", Auth: authBearerOrAPIKey, Body: openAPIVideoCreate{}, Status: 201, Response: database.Video{}},
+	{Method: "GET", Path: "/videos", Tag: "videos", Summary: "List the user's videos, or another user's public ones, newest first", Auth: authBearerOrAPIKey, Query: []openAPIParam{
+		{Name: "user_id", Type: "string", Description: "List this user's public videos instead"},
+		{Name: "tag", Type: "string", Description: "Only list videos with this tag"},
+		{Name: "limit", Type: "integer", Description: "The page size"},
+		{Name: "cursor", Type: "string", Description: "The X-Next-Cursor header of the page before"},
+	}, Response: []database.Video{}},
+	{Method: "GET", Path: "/videos/search", Tag: "videos", Summary: "Search the videos the user can see", Auth: authBearer, Query: []openAPIParam{
+		{Name: "q", Type: "string", Description: "The search terms"},
+	}, Response: []database.Video{}},
+	{Method: "GET", Path: "/oembed", Tag: "videos", Summary: "Describe a video link for embedding, per oEmbed", Query: []openAPIParam{
+		{Name: "url", Type: "string", Description: "A video's embed page or API URL"},
+		{Name: "maxwidth", Type: "integer"},
+		{Name: "maxheight", Type: "integer"},
+		{Name: "format", Type: "string", Description: "Only json is supported"},
+	}, Response: openAPIOEmbed{}, Errors: []int{404, 501}},
+	{Method: "GET", Path: "/users/{userID}/feed", Tag: "videos", Summary: "Get an RSS feed of a user's latest public videos", ResponseType: "application/rss+xml", Errors: []int{404}},
+	{Method: "POST", Path: "/videos/precheck", Tag: "videos", Summary: "Create a video from a file the server already stores, if it does", Auth: authBearerOrAPIKey, Body: openAPIPrecheck{}, Response: openAPIPrecheckResult{}},
+	{Method: "POST", Path: "/videos/batch", Tag: "videos", Summary: "Create a video for every file in the form", Auth: authBearerOrAPIKey, Form: []openAPIFormField{
+		{Name: "visibility"},
+		{Name: "profile", Description: "The processing profile"},
+		{Name: "normalize_loudness", Description: "true to normalize the audio's loudness"},
+		{Name: "manifest", Description: "A JSON array of {filename, title, description, tags} for the files"},
+		{Name: "video", File: true, Required: true, Description: "A file, repeated for each one after the other fields"},
+	}, Response: openAPIBatchResult{}, Errors: []int{413}},
+	{Method: "POST", Path: "/videos/import/zip", Tag: "videos", Summary: "Create a video for every file in a zip archive", Auth: authBearerOrAPIKey, Form: []openAPIFormField{
+		{Name: "archive", File: true, Required: true},
+		{Name: "visibility"},
+		{Name: "profile", Description: "The processing profile"},
+	}, Response: openAPIZipResult{}, Errors: []int{413}},
+	{Method: "GET", Path: videoPath, Tag: "videos", Summary: "Get a video", Auth: authOptional, Response: database.Video{}, Errors: []int{404}},
+	{Method: "PATCH", Path: videoPath, Tag: "videos", Summary: "Change a video's details", Auth: authBearerOrAPIKey, Body: openAPIVideoUpdate{}, Response: database.Video{}, Errors: []int{404}},
+	{Method: "DELETE", Path: videoPath, Tag: "videos", Summary: "Delete a video and its files", Auth: authBearerOrAPIKey, Status: 204, Errors: []int{404}},
+	{Method: "POST", Path: "/video_upload/{videoID}", Tag: "videos", Summary: "Upload a video's file, replacing any it had. With a job queue, responds 202 with the job processing it", Auth: authBearerOrAPIKey, Idempotent: true, Form: []openAPIFormField{
+		{Name: "video", File: true, Required: true, Description: "The file, last in the form"},
+		{Name: "profile", Description: "The processing profile"},
+		{Name: "normalize_loudness", Description: "true to normalize the audio's loudness"},
+	}, Response: openAPIUploadResult{}, Errors: []int{404, 409, 413, 422, 503, 507}},
+	{Method: "POST", Path: videoPath + "/ingest", Tag: "videos", Summary: "Download a video's file from a URL, as a job", Auth: authBearerOrAPIKey, Idempotent: true, Body: openAPIIngestURL{}, Status: 202, Response: database.Job{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/import/s3", Tag: "videos", Summary: "Copy a video's file from an S3 bucket", Auth: authBearerOrAPIKey, Idempotent: true, Body: openAPIImportS3{}, Response: openAPIUploadResult{}, Errors: []int{404, 413, 501}},
+	{Method: "GET", Path: videoPath + "/stream", Tag: "videos", Summary: "Stream a video's file, with range requests", Auth: authOptional, ResponseType: "video/mp4", Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/download", Tag: "videos", Summary: "Download a video's file as an attachment", Auth: authOptional, ResponseType: "application/octet-stream", Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/renditions", Tag: "videos", Summary: "List a video's renditions", Auth: authOptional, Response: []database.Rendition{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/extract-audio", Tag: "videos", Summary: "Extract a video's audio as a rendition", Auth: authBearer, Body: openAPIExtractAudio{}, Status: 201, Response: database.Rendition{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/reprocess", Tag: "videos", Summary: "Run a video's failed job again", Auth: authBearer, Status: 202, Response: database.Job{}, Errors: []int{404, 409}},
+	{Method: "GET", Path: videoPath + "/versions", Tag: "videos", Summary: "List a video's file versions", Auth: authBearer, Response: []openAPIVideoVersion{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/versions/{version}/restore", Tag: "videos", Summary: "Make an earlier version the video's file", Auth: authBearer, Response: database.Video{}, Errors: []int{404, 409}},
+	{Method: "GET", Path: videoPath + "/archive", Tag: "videos", Summary: "Get whether a video's file is archived, and its restore's progress", Auth: authBearer, Response: archiveStatusResponse{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/archive/restore", Tag: "videos", Summary: "Restore an archived video's file", Auth: authBearer, Status: 202, Response: archiveStatusResponse{}, Errors: []int{404, 409}},
+	{Method: "POST", Path: videoPath + "/views", Tag: "videos", Summary: "Count a view of a video", Auth: authOptional, Status: 204, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/stats", Tag: "videos", Summary: "Get a video's daily views", Auth: authBearer, Query: []openAPIParam{
+		{Name: "days", Type: "integer", Description: "How many days back to report"},
+	}, Response: database.VideoViewStats{}, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/analytics", Tag: "videos", Summary: "Get a video's activity over recent windows", Auth: authBearer, Response: openAPIVideoAnalytics{}, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/duplicates", Tag: "videos", Summary: "List the user's other videos that look the same as a video", Auth: authBearer, Response: []openAPIDuplicate{}, Errors: []int{404, 409}},
+	{Method: "POST", Path: videoPath + "/share", Tag: "videos", Summary: "Create a link that shows a private video to anyone", Auth: authBearer, Body: openAPIExpiry{}, Status: 201, Response: openAPIExpiringLink{}, Errors: []int{404}},
+	{Method: "GET", Path: "/share/{token}", Tag: "videos", Summary: "Get the video a share link is for", Response: database.Video{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/playback-token", Tag: "videos", Summary: "Create a short-lived link to a video's file", Auth: authOptional, Body: openAPIExpiry{}, Status: 201, Response: openAPIExpiringLink{}, Errors: []int{404}},
+	{Method: "PUT", Path: videoPath + "/password", Tag: "videos", Summary: "Require a password to see a video", Auth: authBearer, Body: openAPIVideoPassword{}, Status: 204, Errors: []int{404}},
+	{Method: "DELETE", Path: videoPath + "/password", Tag: "videos", Summary: "Remove a video's password", Auth: authBearer, Status: 204, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/unlock", Tag: "videos", Summary: "Exchange a video's password for a token that opens it", Body: openAPIVideoPassword{}, Response: openAPIVideoAccess{}, Errors: []int{401, 404, 429}},
+	{Method: "GET", Path: "/playback/{token}", Tag: "videos", Summary: "Play the file a playback token is for", ResponseType: "video/mp4", Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/localizations", Tag: "videos", Summary: "List a video's translated titles and descriptions", Auth: authOptional, Response: []database.Localization{}, Errors: []int{404}},
+	{Method: "PUT", Path: videoPath + "/localizations/{language}", Tag: "videos", Summary: "Set a video's title and description in a language", Auth: authBearer, Body: openAPILocalizationPut{}, Response: database.Localization{}, Errors: []int{404}},
+	{Method: "DELETE", Path: videoPath + "/localizations/{language}", Tag: "videos", Summary: "Remove a video's translation", Auth: authBearer, Status: 204, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/captions", Tag: "videos", Summary: "List a video's caption tracks", Auth: authOptional, Response: []database.Caption{}, Errors: []int{404}},
+	{Method: "PUT", Path: videoPath + "/captions/{language}", Tag: "videos", Summary: "Upload a video's captions in a language", Auth: authBearer, Form: []openAPIFormField{
+		{Name: "captions", File: true, Required: true, Description: "A WebVTT or SRT file"},
+		{Name: "label", Description: "The track's label in players"},
+	}, Response: database.Caption{}, Errors: []int{404}},
+	{Method: "DELETE", Path: videoPath + "/captions/{language}", Tag: "videos", Summary: "Remove a video's captions in a language", Auth: authBearer, Status: 204, Errors: []int{404}},
+	{Method: "PATCH", Path: videoPath + "/chapters", Tag: "videos", Summary: "Replace a video's chapters", Auth: authBearer, Body: openAPIChaptersPatch{}, Response: []database.Chapter{}, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/clips", Tag: "videos", Summary: "List the clips cut from a video", Auth: authOptional, Response: []database.Video{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/clips", Tag: "videos", Summary: "Cut a clip from a video, as a job", Auth: authBearer, Body: openAPIClipCreate{}, Status: 202, Response: database.Job{}, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/transcript", Tag: "videos", Summary: "Get a video's transcript", Auth: authOptional, Response: database.Transcript{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/transcribe", Tag: "videos", Summary: "Transcribe a video, as a job", Auth: authBearer, Body: openAPITranscribe{}, Status: 202, Response: database.Job{}, Errors: []int{404}},
+	{Method: "GET", Path: videoPath + "/tags", Tag: "videos", Summary: "List a video's tags", Auth: authOptional, Response: []string{}, Errors: []int{404}},
+	{Method: "POST", Path: videoPath + "/tags", Tag: "videos", Summary: "Tag a video", Auth: authBearer, Body: openAPITags{}, Response: []string{}, Errors: []int{404}},
+	{Method: "DELETE", Path: videoPath + "/tags/{tag}", Tag: "videos", Summary: "Remove a tag from a video", Auth: authBearer, Status: 204, Errors: []int{404}},
+	{Method: "GET", Path: "/jobs/{jobID}", Tag: "videos", Summary: "Get a job's status", Auth: authBearerOrAPIKey, Response: database.Job{}, Errors: []int{404}},
+
+	{Method: "POST", Path: "/thumbnail_upload/{videoID}", Tag: "thumbnails", Summary: "Upload a video's thumbnail", Auth: authBearerOrAPIKey, Form: []openAPIFormField{
+		{Name: "thumbnail", File: true, Required: true, Description: "A JPEG or PNG image"},
+	}, Response: database.Video{}, Errors: []int{404, 413}},
+	{Method: "POST", Path: videoPath + "/thumbnail/from-frame", Tag: "thumbnails", Summary: "Make a frame of the video its thumbnail, as a job", Auth: authBearer, Body: openAPIThumbnailFromFrame{}, Status: 202, Response: database.Job{}, Errors: []int{404}},
+}
+
+// openAPIDocument is the OpenAPI document without its servers, which
+// depend on the API version it's fetched as. It's built once, on first
+// use.
+var openAPIDocument = sync.OnceValue(buildOpenAPIDocument)
+
+// handlerOpenAPI serves the OpenAPI document for the API version the
+// request is made in.
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc := map[string]any{}
+	for k, v := range openAPIDocument() {
+		doc[k] = v
+	}
+	doc["servers"] = []map[string]any{{"url": apiPath(r, "")}}
+	respondWithJSON(w, http.StatusOK, doc)
+}
+
+func buildOpenAPIDocument() map[string]any {
+	schemas := openAPISchemas{components: map[string]any{}}
+	errorSchema := schemas.of(reflect.TypeFor[errorResponse]())
+	codeSchema := schemas.components["Error"].(map[string]any)["properties"].(map[string]any)["code"].(map[string]any)
+	codeSchema["enum"] = errorCodes
+	codeSchema["description"] = "Set on failures clients need to tell apart from others with the same status"
+	tooLarge := schemas.of(reflect.TypeFor[tooLargeResponse]())
+	quotaExceeded := schemas.of(reflect.TypeFor[quotaResponse]())
+
+	paths := map[string]map[string]any{}
+	for _, op := range openAPIOperations {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": openAPIOperationID(op),
+		}
+
+		var params []map[string]any
+		for _, name := range openAPIPathParams(op.Path) {
+			params = append(params, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]any{"type": q.Type},
+			})
+		}
+		if op.Idempotent {
+			params = append(params, map[string]any{
+				"name": "Idempotency-Key", "in": "header",
+				"description": "Replays the first response to requests with the same key for 24 hours",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		switch {
+		case op.Body != nil:
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(op.Body))},
+				},
+			}
+		case op.Form != nil:
+			properties := map[string]any{}
+			var required []string
+			for _, f := range op.Form {
+				field := map[string]any{"type": "string"}
+				if f.File {
+					field["format"] = "binary"
+				}
+				if f.Description != "" {
+					field["description"] = f.Description
+				}
+				properties[f.Name] = field
+				if f.Required {
+					required = append(required, f.Name)
+				}
+			}
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"multipart/form-data": map[string]any{"schema": map[string]any{
+						"type": "object", "properties": properties, "required": required,
+					}},
+				},
+			}
+		}
+
+		switch op.Auth {
+		case authBearer, authRefresh:
+			operation["security"] = []map[string][]string{{"bearer": {}}}
+		case authBearerOrAPIKey:
+			operation["security"] = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+		case authOptional:
+			operation["security"] = []map[string][]string{{}, {"bearer": {}}}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.Response != nil:
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(op.Response))},
+			}
+		case op.ResponseType != "":
+			success["content"] = map[string]any{
+				op.ResponseType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}
+		}
+		responses := map[string]any{fmt.Sprint(status): success}
+
+		errorStatuses := append([]int{http.StatusBadRequest, http.StatusInternalServerError}, op.Errors...)
+		if op.Auth == authBearer || op.Auth == authBearerOrAPIKey || op.Auth == authRefresh {
+			errorStatuses = append(errorStatuses, http.StatusUnauthorized, http.StatusForbidden)
+		}
+		for _, code := range errorStatuses {
+			schema := errorSchema
+			if code == http.StatusRequestEntityTooLarge {
+				schema = map[string]any{"oneOf": []any{tooLarge, quotaExceeded}}
+			}
+			responses[fmt.Sprint(code)] = map[string]any{
+				"description": http.StatusText(code),
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schema},
+				},
+			}
+		}
+		operation["responses"] = responses
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]any{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Tubely API",
+			"version": fmt.Sprint(latestAPIVersion),
+			"description": "Every error response has an Error body. Its request_id identifies the request in the server's logs, " +
+				"and its code, when set, tells apart failures that share a status.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{
+					"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+					"description": "An access token from /login, or a refresh token for /refresh and /revoke",
+				},
+				"apiKey": map[string]any{
+					"type": "apiKey", "in": "header", "name": "Authorization",
+					"description": `An API key, sent as "ApiKey <key>"`,
+				},
+			},
+		},
+	}
+}
+
+// openAPIOperationID names an operation, such as postVideosVideoIDIngest.
+func openAPIOperationID(op openAPIOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	upper := true
+	for _, c := range op.Path {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// openAPIPathParams returns the names of the {parameters} in path.
+func openAPIPathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			names = append(names, strings.TrimSuffix(name, "}"))
+		}
+	}
+	return names
+}
+
+// openAPISchemas generates schemas from Go types as encoding/json encodes
+// them. Named struct types become components, referred to by name.
+type openAPISchemas struct {
+	components map[string]any
+}
+
+func (s openAPISchemas) of(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[uuid.UUID]():
+		return map[string]any{"type": "string", "format": "uuid"}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.of(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		name := openAPISchemaName(t)
+		if name == "" {
+			return s.object(t)
+		}
+		if _, ok := s.components[name]; !ok {
+			// set before generating the fields, for types that refer to
+			// themselves
+			s.components[name] = map[string]any{}
+			s.components[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object generates the schema of a struct, flattening embedded structs as
+// encoding/json does.
+func (s openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := f.Type
+			if f.Anonymous && name == "" {
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					addFields(ft)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = s.of(ft)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	sort.Strings(required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPISchemaName is the component name of a struct type, "" for
+// anonymous structs.
+func openAPISchemaName(t reflect.Type) string {
+	if name, ok := openAPISchemaNames[t]; ok {
+		return name
+	}
+	name := strings.TrimPrefix(t.Name(), "openAPI")
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
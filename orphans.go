@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// orphanGracePeriod spares objects of uploads that may still be in
+	// progress, which are stored before the database points at them
+	orphanGracePeriod = 24 * time.Hour
+
+	orphanReport = "report"
+	orphanDelete = "delete"
+)
+
+var errOrphanScanRunning = errors.New("an orphaned object scan is already running")
+
+// orphanPrefixes are where Tubely stores video and audio objects; nothing
+// outside them is ever touched.
+var orphanPrefixes = []string{"videos/", "audio/"}
+
+// orphanSettings control the scheduled orphaned object collection.
+type orphanSettings struct {
+	// Interval is how often the bucket is scanned, zero turns scans off
+	Interval time.Duration
+	Mode     string
+}
+
+// orphanedObject is an object in a bucket no video knows about.
+type orphanedObject struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	// Deleted is whether it was removed, or only reported
+	Deleted bool `json:"deleted"`
+	// Error is why deleting it failed
+	Error string `json:"error,omitempty"`
+}
+
+// orphanScan is the outcome of one pass over the buckets.
+type orphanScan struct {
+	Scanned int              `json:"scanned"`
+	Orphans []orphanedObject `json:"orphans"`
+	// OrphanedBytes adds up the orphans' sizes
+	OrphanedBytes int64 `json:"orphaned_bytes"`
+}
+
+// runOrphanCollector scans the buckets for orphaned objects every
+// cfg.orphans.Interval until ctx is done.
+func (cfg *apiConfig) runOrphanCollector(ctx context.Context) {
+	ticker := time.NewTicker(cfg.orphans.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		logger := loggerFrom(ctx)
+		scan, err := cfg.collectOrphans(ctx, cfg.orphans.Mode == orphanDelete)
+		if err != nil {
+			logger.Error("orphaned object scan failed", "error", err)
+			continue
+		}
+		for _, orphan := range scan.Orphans {
+			logger.Warn("orphaned S3 object",
+				"key", orphan.Key,
+				"bytes", orphan.Size,
+				"last_modified", orphan.LastModified,
+				"deleted", orphan.Deleted,
+			)
+		}
+		logger.Info("orphaned object scan complete",
+			"scanned", scan.Scanned,
+			"orphans", len(scan.Orphans),
+			"orphaned_bytes", scan.OrphanedBytes,
+		)
+	}
+}
+
+// collectOrphans lists the buckets' video and audio objects and finds those no
+// video, rendition, kept version or pending deletion refers to, deleting
+// them if del is set. Objects newer than the grace period are left alone.
+// Only one scan runs at a time; a second one fails.
+func (cfg *apiConfig) collectOrphans(ctx context.Context, del bool) (orphanScan, error) {
+	if !cfg.orphanScanning.TryLock() {
+		return orphanScan{}, errOrphanScanRunning
+	}
+	defer cfg.orphanScanning.Unlock()
+
+	// references are read before listing, so an object the listing finds
+	// that was committed since is newer than the grace period
+	inUse, err := cfg.objectKeysInUse()
+	if err != nil {
+		return orphanScan{}, err
+	}
+	cutoff := time.Now().Add(-orphanGracePeriod)
+
+	scan := orphanScan{Orphans: []orphanedObject{}}
+	for _, bucket := range cfg.buckets.names() {
+		for _, prefix := range orphanPrefixes {
+			if err := cfg.collectOrphansUnder(ctx, bucket, prefix, inUse, cutoff, del, &scan); err != nil {
+				return orphanScan{}, err
+			}
+		}
+	}
+	return scan, nil
+}
+
+// collectOrphansUnder adds the orphans among the objects under prefix in
+// bucket to scan.
+func (cfg *apiConfig) collectOrphansUnder(ctx context.Context, bucket, prefix string, inUse map[string]bool, cutoff time.Time, del bool, scan *orphanScan) error {
+	pages := s3.NewListObjectsV2Paginator(cfg.buckets.client(bucket), &s3.ListObjectsV2Input{
+		Bucket:              aws.String(bucket),
+		Prefix:              aws.String(prefix),
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	})
+	for pages.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := withS3Retry(ctx, s3DefaultRetry, "ListObjectsV2 "+prefix, func(ctx context.Context) error {
+			var err error
+			page, err = pages.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			scan.Scanned++
+			key := aws.ToString(obj.Key)
+			modified := aws.ToTime(obj.LastModified)
+			if inUse[bucket+"/"+key] || modified.After(cutoff) {
+				continue
+			}
+			// something may have started using it since the references were read
+			used, err := cfg.db.ObjectURLInUse(cfg.getObjectURL(bucket, key))
+			if err != nil {
+				return err
+			}
+			if used {
+				continue
+			}
+			orphan := orphanedObject{
+				Bucket:       bucket,
+				Key:          key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: modified,
+			}
+			if del {
+				if err := cfg.releaseObject(ctx, bucket, key); err != nil {
+					orphan.Error = err.Error()
+				} else {
+					orphan.Deleted = true
+				}
+			}
+			scan.Orphans = append(scan.Orphans, orphan)
+			scan.OrphanedBytes += orphan.Size
+		}
+	}
+	return nil
+}
+
+// objectKeysInUse returns the objects in Tubely's buckets that are
+// referred to, including those of deleted videos still being cleaned up,
+// as bucket/key.
+func (cfg *apiConfig) objectKeysInUse() (map[string]bool, error) {
+	urls, err := cfg.db.GetObjectURLsInUse()
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, url := range urls {
+		if bucket, key, ok := cfg.objectFromURL(url); ok {
+			keys[bucket+"/"+key] = true
+		}
+	}
+	tombstones, err := cfg.db.GetAllTombstones()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tombstones {
+		for _, key := range t.ObjectKeys {
+			keys[cfg.buckets.orDefault(t.StorageBucket)+"/"+key] = true
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// pageCursor marks the last item of a page in (timestamp, id) order. Most
+// feeds order by created_at; the trigger feeds also use ready_at.
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (c pageCursor) encode() string {
+	raw := fmt.Sprintf("%d|%s", c.CreatedAt.Unix(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	return pageCursor{CreatedAt: time.Unix(unix, 0).UTC(), ID: id}, nil
+}
+
+// parsePageSize reads the `limit` query parameter, falling back to the
+// default and rejecting values outside 1..maxPageSize.
+func parsePageSize(r *http.Request) (int, error) {
+	limitString := r.URL.Query().Get("limit")
+	if limitString == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(limitString)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return limit, nil
+}
+
+// setNextPageHeaders advertises the cursor for the following page, both as
+// a plain header and as an RFC 8288 Link so generic clients can follow it.
+func setNextPageHeaders(w http.ResponseWriter, r *http.Request, cursor string) {
+	w.Header().Set("X-Next-Cursor", cursor)
+	next := *r.URL
+	q := next.Query()
+	q.Set("cursor", cursor)
+	next.RawQuery = q.Encode()
+	w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// partialProbeWindow is how much of each end of a file a partial probe
+// reads. The ftyp and moov boxes sit at the start of faststart files and
+// at the end of the rest, and are far smaller than this in practice.
+const partialProbeWindow = 4 << 20 // 4 MB
+
+// rangeFetcher reads length bytes at offset from a remote file.
+type rangeFetcher func(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+
+// partialProbe probes a remote file of the given size without downloading
+// all of it. The first and last partialProbeWindow bytes are written into a
+// sparse temp file of the full size, which ffprobe reads like the real
+// thing as long as it never needs the middle.
+func (cfg *apiConfig) partialProbe(ctx context.Context, size int64, fetch rangeFetcher) (probeResult, error) {
+	if size <= 0 {
+		return probeResult{}, errors.New("file is empty")
+	}
+
+	ranges := [][2]int64{{0, min(size, partialProbeWindow)}}
+	if size > partialProbeWindow {
+		tailStart := max(size-partialProbeWindow, partialProbeWindow)
+		ranges = append(ranges, [2]int64{tailStart, size - tailStart})
+	}
+
+	tempFile, err := cfg.tempStore.Create(2*partialProbeWindow, "probe-*.mp4")
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer tempFile.Release()
+
+	// the hole in the middle takes no disk space
+	if err := tempFile.Truncate(size); err != nil {
+		return probeResult{}, err
+	}
+	for _, rg := range ranges {
+		body, err := fetch(ctx, rg[0], rg[1])
+		if err != nil {
+			return probeResult{}, fmt.Errorf("couldn't read bytes %d-%d: %w", rg[0], rg[0]+rg[1]-1, err)
+		}
+		_, err = io.Copy(io.NewOffsetWriter(tempFile, rg[0]), io.LimitReader(body, rg[1]))
+		body.Close()
+		if err != nil {
+			return probeResult{}, fmt.Errorf("couldn't read bytes %d-%d: %w", rg[0], rg[0]+rg[1]-1, err)
+		}
+	}
+
+	return cfg.media.probeVideo(ctx, tempFile.Name())
+}
+
+// headObject reads an object's metadata. bucket can be another bucket
+// than Tubely's, e.g. an import source.
+func (cfg *apiConfig) headObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error) {
+	var head *s3.HeadObjectOutput
+	err := withS3Retry(ctx, s3DefaultRetry, "HeadObject "+key, func(ctx context.Context) error {
+		var err error
+		head, err = cfg.buckets.client(bucket).HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ExpectedBucketOwner: cfg.expectedOwnerOf(bucket),
+		})
+		return err
+	})
+	return head, err
+}
+
+// probeObject runs a partial probe of an S3 object of the given size and
+// checks it's a usable MP4, so an import can be turned down before the
+// whole object is downloaded, copied or processed. An unusable video is
+// reported as an *ingestError.
+func (cfg *apiConfig) probeObject(ctx context.Context, bucket, key string, size int64) (probeResult, error) {
+	probe, err := cfg.partialProbe(ctx, size, func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		// read the range inside the attempt, a dropped connection mid-body is worth retrying too
+		var buf bytes.Buffer
+		err := withS3Retry(ctx, s3DefaultRetry, "GetObject "+key, func(ctx context.Context) error {
+			buf.Reset()
+			out, err := cfg.buckets.client(bucket).GetObject(ctx, &s3.GetObjectInput{
+				Bucket:              aws.String(bucket),
+				Key:                 aws.String(key),
+				Range:               aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+				ExpectedBucketOwner: cfg.expectedOwnerOf(bucket),
+			})
+			if err != nil {
+				return err
+			}
+			defer out.Body.Close()
+			_, err = io.Copy(&buf, out.Body)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(&buf), nil
+	})
+	if err != nil {
+		return probeResult{}, err
+	}
+	if err := probe.validateMP4(); err != nil {
+		return probeResult{}, &ingestError{http.StatusBadRequest, "Invalid video: " + err.Error(), err}
+	}
+	return probe, nil
+}
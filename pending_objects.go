@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	pendingObjectGCInterval = 10 * time.Minute
+	// pendingObjectGracePeriod is longer than any ingest takes between
+	// storing its first object and pointing the video at it, so only the
+	// objects of uploads that died are collected
+	pendingObjectGracePeriod = 6 * time.Hour
+	pendingObjectBatchSize   = 100
+)
+
+// putPendingObject stores body under key for video, in the video's
+// bucket and tagged as the video's, aspect being the file's aspect ratio
+// if it's a video file. The object is recorded as pending first. Until the video is
+// pointed at it by commitVideoObjects the record stays, and the collector
+// deletes the object once it's stale. A failed upload leaves its record
+// too, S3 may have stored it anyway.
+func (cfg *apiConfig) putPendingObject(ctx context.Context, video database.Video, aspect, key string, body io.ReadSeeker, contentType, sha256Hex string) error {
+	bucket := cfg.videoBucket(video)
+	if err := cfg.db.CreatePendingObject(bucket, key, video.ID); err != nil {
+		return err
+	}
+	return cfg.putObject(ctx, bucket, key, body, contentType, sha256Hex, newObjectTags(video, aspect))
+}
+
+// copyPendingObject is putPendingObject for an object copied from another
+// bucket.
+func (cfg *apiConfig) copyPendingObject(ctx context.Context, video database.Video, aspect, srcBucket, srcKey string, size int64, key, contentType string) error {
+	bucket := cfg.videoBucket(video)
+	if err := cfg.db.CreatePendingObject(bucket, key, video.ID); err != nil {
+		return err
+	}
+	return cfg.copyObject(ctx, srcBucket, srcKey, size, bucket, key, contentType, newObjectTags(video, aspect))
+}
+
+// discardPendingObjects deletes pending objects in bucket whose ingest
+// failed. An object that couldn't be deleted keeps its record for the
+// collector to retry. Failures are logged.
+func (cfg *apiConfig) discardPendingObjects(ctx context.Context, bucket string, keys []string) {
+	logger := loggerFrom(ctx)
+	for _, key := range keys {
+		if err := cfg.removeObject(ctx, bucket, key); err != nil {
+			logger.Error("couldn't delete S3 object", "bucket", bucket, "key", key, "error", err)
+			continue
+		}
+		if err := cfg.db.DeletePendingObject(key); err != nil {
+			logger.Error("couldn't forget pending object", "key", key, "error", err)
+		}
+	}
+}
+
+// runPendingObjectCollector deletes the objects of uploads that never
+// committed every pendingObjectGCInterval until ctx is done.
+func (cfg *apiConfig) runPendingObjectCollector(ctx context.Context) {
+	ticker := time.NewTicker(pendingObjectGCInterval)
+	defer ticker.Stop()
+	for {
+		cfg.collectPendingObjects(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectPendingObjects works through the pending objects older than the
+// grace period. Objects something refers to after all are only forgotten,
+// the others are deleted as well.
+func (cfg *apiConfig) collectPendingObjects(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	for ctx.Err() == nil {
+		pending, err := cfg.db.GetStalePendingObjects(time.Now().Add(-pendingObjectGracePeriod), pendingObjectBatchSize)
+		if err != nil {
+			logger.Error("couldn't look up pending objects", "error", err)
+			return
+		}
+		for _, p := range pending {
+			if err := cfg.releaseObject(ctx, cfg.buckets.orDefault(p.Bucket), p.ObjectKey); err != nil {
+				// left for the next run, which starts with it again
+				logger.Error("couldn't collect pending object", "key", p.ObjectKey, "video_id", p.VideoID, "error", err)
+				return
+			}
+			if err := cfg.db.DeletePendingObject(p.ObjectKey); err != nil {
+				logger.Error("couldn't forget pending object", "key", p.ObjectKey, "error", err)
+				return
+			}
+			logger.Info("cleared stale pending object", "key", p.ObjectKey, "video_id", p.VideoID, "pending_since", p.CreatedAt)
+		}
+		if len(pending) < pendingObjectBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killProcessGroup only kills cmd itself where process groups aren't
+// available, giving up on its output a second later.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = time.Second
+}
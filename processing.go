@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// frameRateMode controls what happens to high frame rate (slow-motion) sources.
+type frameRateMode string
+
+const (
+	frameRatePreserve  frameRateMode = "preserve"
+	frameRateConform60 frameRateMode = "conform_60"
+	frameRateConform30 frameRateMode = "conform_30"
+	frameRateSlowMo    frameRateMode = "slowmo"
+)
+
+// sources at or above this rate are treated as 120/240 fps slow-motion captures
+const highFrameRateThreshold = 100.0
+
+// slow-motion renditions play back at this rate
+const slowMoPlaybackRate = 30.0
+
+type processingProfile struct {
+	HighFrameRate frameRateMode
+	// NormalizeLoudness is asked for per upload rather than by a profile
+	NormalizeLoudness bool
+}
+
+var processingProfiles = map[string]processingProfile{
+	"default": {HighFrameRate: frameRatePreserve},
+	"smooth":  {HighFrameRate: frameRateConform60},
+	"compact": {HighFrameRate: frameRateConform30},
+	"slowmo":  {HighFrameRate: frameRateSlowMo},
+}
+
+func getProcessingProfile(name string) (processingProfile, error) {
+	if name == "" {
+		name = "default"
+	}
+	profile, ok := processingProfiles[name]
+	if !ok {
+		return processingProfile{}, fmt.Errorf("unknown processing profile %q", name)
+	}
+	return profile, nil
+}
+
+// conformRate is the output frame rate for the conform modes, or 0.
+func (m frameRateMode) conformRate() float64 {
+	switch m {
+	case frameRateConform60:
+		return 60
+	case frameRateConform30:
+		return 30
+	}
+	return 0
+}
+
+// frameRateModeFor picks the handling for a source with the given frame rate.
+func (p processingProfile) frameRateModeFor(fps float64) frameRateMode {
+	if fps < highFrameRateThreshold {
+		return frameRatePreserve
+	}
+	return p.HighFrameRate
+}
+
+// metadataArgs keeps container metadata through an ffmpeg pass. Spherical
+// (360°) boxes are only written by the mp4 muxer in "unofficial" mode.
+func metadataArgs(projection string) []string {
+	args := []string{"-map_metadata", "0"}
+	if projection != "" {
+		args = append(args, "-strict", "unofficial")
+	}
+	return args
+}
+
+// conformFrameRate re-encodes the video at the target rate by dropping
+// frames, keeping the audio as-is. The caller owns the returned file.
+func (m mediaTools) conformFrameRate(ctx context.Context, inputPath string, fps float64, projection string) (string, error) {
+	args := []string{"-r", strconv.FormatFloat(fps, 'f', -1, 64), "-c:a", "copy"}
+	args = append(args, metadataArgs(projection)...)
+	return m.runFFmpeg(ctx, inputPath, args)
+}
+
+// transcodeForBrowsers re-encodes the main video stream as H.264 if
+// video is set, and the audio as AAC if audio is, copying whatever isn't
+// re-encoded. Other video streams, such as cover art, are dropped. The
+// caller owns the returned file.
+func (m mediaTools) transcodeForBrowsers(ctx context.Context, inputPath string, video, audio bool, projection string) (string, error) {
+	args := []string{"-map", "0:v:0", "-map", "0:a?"}
+	if video {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p")
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	if audio {
+		args = append(args, "-c:a", "aac", "-b:a", defaultAudioBitrate)
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args, metadataArgs(projection)...)
+	return m.runFFmpeg(ctx, inputPath, args)
+}
+
+// createSlowMotionRendition stretches every captured frame to
+// slowMoPlaybackRate, so a 240 fps source plays back 8x slower. The audio
+// can't be stretched sensibly that far and is dropped.
+func (m mediaTools) createSlowMotionRendition(ctx context.Context, inputPath string, sourceFPS float64, projection string) (string, error) {
+	factor := sourceFPS / slowMoPlaybackRate
+	args := []string{
+		"-vf", fmt.Sprintf("setpts=%s*PTS", strconv.FormatFloat(factor, 'f', 4, 64)),
+		"-r", strconv.FormatFloat(slowMoPlaybackRate, 'f', -1, 64),
+		"-an",
+	}
+	args = append(args, metadataArgs(projection)...)
+	return m.runFFmpeg(ctx, inputPath, args)
+}
+
+// runFFmpeg runs ffmpeg on inputPath with args, writing an MP4 to a fresh
+// temp file next to the input so large outputs stay on the same temp
+// volume. ffmpeg is killed, with anything it started, if ctx is done.
+func (m mediaTools) runFFmpeg(ctx context.Context, inputPath string, args []string) (string, error) {
+	return m.runFFmpegAs(ctx, inputPath, append(args, "-movflags", "faststart"), "mp4", ".mp4")
+}
+
+// runFFmpegAs is runFFmpeg writing the container ffmpeg calls muxer, to a
+// file ending in ext.
+func (m mediaTools) runFFmpegAs(ctx context.Context, inputPath string, args []string, muxer, ext string) (string, error) {
+	if sandboxMedia {
+		return sandboxTranscode(inputPath, ext)
+	}
+	out, err := os.CreateTemp(filepath.Dir(inputPath), "tubely-processed-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	outputPath := out.Name()
+	out.Close()
+
+	args = append([]string{"-i", inputPath}, args...)
+	args = append(args, "-f", muxer, "-y", outputPath)
+	cmd := exec.CommandContext(ctx, m.FFmpeg, args...)
+	killProcessGroup(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		if err := toolError(m.FFmpeg, err); errors.Is(err, errMediaToolsUnavailable) {
+			return "", err
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", &toolFailure{"ffmpeg", err, string(output)}
+	}
+	return outputPath, nil
+}
+
+// Loudness normalization follows EBU R128, aiming at the integrated
+// loudness streaming services and podcast apps use rather than R128's
+// broadcast -23 LUFS.
+const (
+	loudnessTarget      = -16.0 // LUFS
+	loudnessTruePeak    = -1.5  // dBTP
+	loudnessRange       = 11.0  // LU
+	defaultAudioBitrate = "192k"
+)
+
+// loudnormFilter is ffmpeg's single-pass EBU R128 normalization, resampled
+// back to sampleRate since the filter works at 192 kHz.
+func loudnormFilter(sampleRate string) string {
+	if sampleRate == "" || sampleRate == "0" {
+		sampleRate = "48000"
+	}
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g,aresample=%s", loudnessTarget, loudnessTruePeak, loudnessRange, sampleRate)
+}
+
+// audioEncoders are the ffmpeg encoders audio is re-encoded with after
+// normalization, by the codec ffprobe found.
+var audioEncoders = map[string]string{
+	"aac":    "aac",
+	"mp3":    "libmp3lame",
+	"vorbis": "libvorbis",
+	"opus":   "libopus",
+}
+
+// normalizeLoudness normalizes the loudness of a video's audio, copying
+// the picture as it is. The caller owns the returned file.
+func (m mediaTools) normalizeLoudness(ctx context.Context, inputPath string, audio probeStream, projection string) (string, error) {
+	args := []string{"-c:v", "copy", "-af", loudnormFilter(audio.SampleRate), "-c:a", "aac", "-b:a", defaultAudioBitrate}
+	args = append(args, metadataArgs(projection)...)
+	return m.runFFmpeg(ctx, inputPath, args)
+}
+
+// normalizeAudioLoudness normalizes the loudness of an audio file,
+// re-encoding it with the same codec, at bitrate bits per second if known,
+// in the same container. The caller owns the returned file.
+func (m mediaTools) normalizeAudioLoudness(ctx context.Context, inputPath string, format audioFormat, audio probeStream, bitrate int64) (string, error) {
+	encoder, ok := audioEncoders[audio.CodecName]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", errUnsupportedAudioCodec, audio.CodecName)
+	}
+	rate := defaultAudioBitrate
+	if bitrate > 0 {
+		rate = strconv.FormatInt(bitrate, 10)
+	}
+	args := []string{"-vn", "-af", loudnormFilter(audio.SampleRate), "-c:a", encoder, "-b:a", rate, "-map_metadata", "0"}
+	return m.runFFmpegAs(ctx, inputPath, args, format.Muxer, format.Ext)
+}
+
+// errUnsupportedAudioCodec is returned for audio Tubely can't re-encode.
+var errUnsupportedAudioCodec = errors.New("can't re-encode this audio codec")
+
+// thumbnails taken from a video frame are scaled down to at most this width
+const frameThumbnailMaxWidth = 1280
+
+var errFrameOutOfRange = errors.New("timestamp is past the end of the video")
+
+// cutClip writes the part of input from start to end seconds to
+// outputPath as an MP4. It's re-encoded rather than copied so the cut
+// falls exactly where asked instead of on the nearest keyframe. Like
+// extractFrame it seeks before -i, so input can be a presigned URL.
+func (m mediaTools) cutClip(ctx context.Context, input string, start, end float64, outputPath string) error {
+	if sandboxMedia {
+		return sandboxClip(ctx, input, outputPath)
+	}
+	args := []string{
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", input,
+		"-t", strconv.FormatFloat(end-start, 'f', 3, 64),
+		// the first video stream, and the first audio one if there is one
+		"-map", "0:v:0", "-map", "0:a:0?",
+		// the source's chapters don't line up with the clip
+		"-map_metadata", "0", "-map_chapters", "-1",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20",
+		"-c:a", "aac", "-b:a", defaultAudioBitrate,
+		"-movflags", "faststart",
+		"-f", "mp4",
+		"-y", outputPath,
+	}
+	cmd := exec.CommandContext(ctx, m.FFmpeg, args...)
+	killProcessGroup(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		if err := toolError(m.FFmpeg, err); errors.Is(err, errMediaToolsUnavailable) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &toolFailure{"ffmpeg", err, string(output)}
+	}
+	return nil
+}
+
+// extractFrame writes the frame at `at` seconds into input as a JPEG.
+// Seeking before -i lets ffmpeg use range requests, so input can be a
+// presigned URL without downloading the whole video.
+func (m mediaTools) extractFrame(ctx context.Context, input string, at float64, outputPath string) error {
+	if sandboxMedia {
+		return sandboxFrame(outputPath)
+	}
+	args := []string{
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", frameThumbnailMaxWidth),
+		"-q:v", "2",
+		"-f", "image2",
+		"-y", outputPath,
+	}
+	cmd := exec.CommandContext(ctx, m.FFmpeg, args...)
+	killProcessGroup(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		if err := toolError(m.FFmpeg, err); errors.Is(err, errMediaToolsUnavailable) {
+			return err
+		}
+		return &toolFailure{"ffmpeg", err, string(output)}
+	}
+	// seeking past the end isn't an ffmpeg error, it just writes nothing
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() == 0 {
+		os.Remove(outputPath)
+		return errFrameOutOfRange
+	}
+	return nil
+}
+
+// extractRepresentativeFrame writes the frame of the window seconds of
+// input from start that best represents them as a JPEG. ffmpeg's
+// thumbnail filter compares samples spread over the window and keeps the
+// one closest to their average colors, which passes over fades, black
+// frames and flashes.
+func (m mediaTools) extractRepresentativeFrame(ctx context.Context, input string, start, window float64, samples int, outputPath string) error {
+	if sandboxMedia {
+		return sandboxFrame(outputPath)
+	}
+	args := []string{
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", input,
+		"-t", strconv.FormatFloat(window, 'f', 3, 64),
+		"-vf", fmt.Sprintf("fps=%s,thumbnail=%d,scale='min(%d,iw)':-2",
+			strconv.FormatFloat(float64(samples)/window, 'f', 6, 64), samples, frameThumbnailMaxWidth),
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-f", "image2",
+		"-y", outputPath,
+	}
+	cmd := exec.CommandContext(ctx, m.FFmpeg, args...)
+	killProcessGroup(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		if err := toolError(m.FFmpeg, err); errors.Is(err, errMediaToolsUnavailable) {
+			return err
+		}
+		return &toolFailure{"ffmpeg", err, string(output)}
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() == 0 {
+		os.Remove(outputPath)
+		return errFrameOutOfRange
+	}
+	return nil
+}
+
+// errNoAudioStream is returned when audio is extracted from a file that has
+// none.
+var errNoAudioStream = errors.New("video has no audio")
+
+// extractAudio writes the first audio stream of input to outputPath,
+// encoded with codecArgs. Like extractFrame, input can be a presigned URL.
+func (m mediaTools) extractAudio(ctx context.Context, input string, codecArgs []string, outputPath string) error {
+	if sandboxMedia {
+		return sandboxAudio(outputPath)
+	}
+	args := []string{"-i", input, "-map", "0:a:0", "-vn", "-map_metadata", "0"}
+	args = append(args, codecArgs...)
+	args = append(args, "-y", outputPath)
+	cmd := exec.CommandContext(ctx, m.FFmpeg, args...)
+	killProcessGroup(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		if err := toolError(m.FFmpeg, err); errors.Is(err, errMediaToolsUnavailable) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if bytes.Contains(output, []byte("matches no streams")) {
+			return errNoAudioStream
+		}
+		return &toolFailure{"ffmpeg", err, string(output)}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/google/uuid"
+)
+
+// users are warned as their storage use crosses each of these percentages
+var quotaAlertThresholds = []int{80, 90, 100}
+
+type quotaStatus struct {
+	Limited      bool  `json:"limited"`
+	UsedBytes    int64 `json:"used_bytes"`
+	QuotaBytes   int64 `json:"quota_bytes,omitempty"`
+	GraceBytes   int64 `json:"grace_bytes,omitempty"`
+	UsagePercent int   `json:"usage_percent,omitempty"`
+}
+
+// allows reports whether the hard limit leaves room for another size bytes.
+func (s quotaStatus) allows(size int64) bool {
+	return !s.Limited || s.UsedBytes+size <= s.QuotaBytes+s.GraceBytes
+}
+
+// alertLevel is the highest threshold the current usage has crossed, or 0.
+func (s quotaStatus) alertLevel() int {
+	level := 0
+	for _, threshold := range quotaAlertThresholds {
+		if s.Limited && s.UsagePercent >= threshold {
+			level = threshold
+		}
+	}
+	return level
+}
+
+func (cfg *apiConfig) storageQuotaStatus(userID uuid.UUID) (quotaStatus, error) {
+	used, err := cfg.db.GetStorageUsage(userID)
+	if err != nil {
+		return quotaStatus{}, err
+	}
+	status := quotaStatus{UsedBytes: used}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return status, err
+	}
+	quota, ok := cfg.storageQuotas[user.Tier]
+	if !ok {
+		return status, nil
+	}
+	status.Limited = true
+	status.QuotaBytes = quota.Limit
+	status.GraceBytes = quota.Grace
+	if quota.Limit > 0 {
+		status.UsagePercent = int(used * 100 / quota.Limit)
+	}
+	return status, nil
+}
+
+// checkStorageQuota responds with a 413 and returns false if storing size
+// more bytes would take the user past their hard limit.
+func (cfg *apiConfig) checkStorageQuota(w http.ResponseWriter, userID uuid.UUID, size int64) bool {
+	status, err := cfg.storageQuotaStatus(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return false
+	}
+	if !status.allows(size) {
+		respondWithQuotaExceeded(w, status)
+		return false
+	}
+	return true
+}
+
+// updateQuotaAlerts warns the user's integrations the first time their
+// usage crosses each alert threshold. Dropping back below a threshold
+// re-arms it.
+func (cfg *apiConfig) updateQuotaAlerts(ctx context.Context, userID uuid.UUID) {
+	logger := loggerFrom(ctx).With("user_id", userID)
+	status, err := cfg.storageQuotaStatus(userID)
+	if err != nil {
+		logger.Error("couldn't check storage quota", "error", err)
+		return
+	}
+	notified, err := cfg.db.GetQuotaAlertLevel(userID)
+	if err != nil {
+		logger.Error("couldn't get quota alert level", "error", err)
+		return
+	}
+
+	level := status.alertLevel()
+	if level == notified {
+		return
+	}
+	if err := cfg.db.SetQuotaAlertLevel(userID, level); err != nil {
+		logger.Error("couldn't save quota alert level", "error", err)
+		return
+	}
+	if level > notified {
+		cfg.notifyUser(userID, notify.Event{
+			Type:         notify.EventQuotaWarning,
+			UsagePercent: status.UsagePercent,
+			UsedBytes:    status.UsedBytes,
+			QuotaBytes:   status.QuotaBytes,
+		})
+	}
+}
+
+func (cfg *apiConfig) handlerQuotaGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	status, err := cfg.storageQuotaStatus(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, status)
+}
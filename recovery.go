@@ -0,0 +1,40 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"runtime/debug"
+)
+
+// panicsRecovered counts the handler panics recoverPanics turned into
+// 500s, published with the other expvars at /api/admin/debug/vars.
+var panicsRecovered = expvar.NewInt("http_panics_recovered")
+
+// recoverPanics answers a request whose handler panicked with a 500
+// carrying its request ID, and logs the panic with its stack, so one bad
+// request fails alone instead of taking the server down. If the handler
+// had already started its response the connection is cut instead, so the
+// client doesn't take a truncated body for a whole one.
+func (cfg *apiConfig) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// handlers abort on purpose with ErrAbortHandler, which
+			// net/http handles quietly
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			panicsRecovered.Add(1)
+			loggerFrom(r.Context()).Error("handler panicked", "panic", p, "stack", string(debug.Stack()))
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			respondWithError(w, http.StatusInternalServerError, "Internal server error", nil)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
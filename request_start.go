@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxRequestStartAge caps how far back X-Request-Start is believed,
+	// and so how long after expiry a token is still accepted for an upload
+	maxRequestStartAge = time.Hour
+	// startedTokenTTL is how long a token reissued for an upload that
+	// started while it was valid lasts
+	startedTokenTTL = 15 * time.Minute
+)
+
+// requestStart returns when a proxy in front of the server began receiving
+// r, from an X-Request-Start header of the form "t=<unix seconds>", with
+// an optional fraction as nginx's $msec gives. It reports false when
+// there's no usable header. Only call it for requests from a trusted
+// proxy, a client can send any time it likes.
+func requestStart(r *http.Request) (time.Time, bool) {
+	value, ok := strings.CutPrefix(r.Header.Get("X-Request-Start"), "t=")
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	whole, frac := math.Modf(seconds)
+	started := time.Unix(int64(whole), int64(frac*1e9))
+
+	now := time.Now()
+	if started.After(now) || now.Sub(started) > maxRequestStartAge {
+		return time.Time{}, false
+	}
+	return started, true
+}
+
+// honorRequestStart lets an upload through whose access token expired
+// while a proxy was still receiving it, like nginx buffering the body
+// before passing the request on. If the token was valid when the request
+// started, it's swapped for a short-lived copy so the handler accepts it.
+// X-Request-Start is dropped from requests that don't come from one of
+// TRUSTED_PROXIES, so a client can't renew an expired token by claiming
+// an earlier start.
+func (cfg *apiConfig) honorRequestStart(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.fromTrustedProxy(r) {
+			r.Header.Del("X-Request-Start")
+			next(w, r)
+			return
+		}
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		if _, err := auth.ParseAccessToken(token, cfg.jwtSecret); err == nil {
+			next(w, r)
+			return
+		}
+		started, ok := requestStart(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		claims, err := auth.ParseAccessTokenAt(token, cfg.jwtSecret, started)
+		// impersonation tokens are short-lived on purpose
+		if err != nil || claims.ImpersonatorID != uuid.Nil {
+			next(w, r)
+			return
+		}
+
+		reissued, err := auth.ReissueAccessToken(claims, cfg.jwtSecret, startedTokenTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+			return
+		}
+		loggerFrom(r.Context()).Info("accepting token that expired during the request",
+			"user_id", claims.UserID,
+			"request_start", started,
+			"expired_at", claims.ExpiresAt,
+		)
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+reissued)
+		next(w, r)
+	}
+}
+
+// fromTrustedProxy reports whether r was sent by one of TRUSTED_PROXIES.
+func (cfg *apiConfig) fromTrustedProxy(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, proxy := range cfg.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClientIP is the address of the client behind r: the last one
+// in X-Forwarded-For for a request from a trusted proxy, which the proxy
+// added itself, or else r's own.
+func (cfg *apiConfig) forwardedClientIP(r *http.Request) string {
+	if !cfg.fromTrustedProxy(r) {
+		return clientIP(r)
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return clientIP(r)
+	}
+	hops := strings.Split(forwarded[len(forwarded)-1], ",")
+	if addr, err := netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1])); err == nil {
+		return addr.Unmap().String()
+	}
+	return clientIP(r)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestRequestStart(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		header string
+		want   time.Time
+		ok     bool
+	}{
+		{"no header", "", time.Time{}, false},
+		{"seconds", fmt.Sprintf("t=%d", now.Add(-time.Minute).Unix()), time.Unix(now.Add(-time.Minute).Unix(), 0), true},
+		{"fraction", fmt.Sprintf("t=%d.5", now.Add(-time.Minute).Unix()), time.Unix(now.Add(-time.Minute).Unix(), 5e8), true},
+		{"missing t=", fmt.Sprintf("%d", now.Add(-time.Minute).Unix()), time.Time{}, false},
+		{"not a number", "t=soon", time.Time{}, false},
+		{"in the future", fmt.Sprintf("t=%d", now.Add(time.Minute).Unix()), time.Time{}, false},
+		{"too far back", fmt.Sprintf("t=%d", now.Add(-maxRequestStartAge-time.Minute).Unix()), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-Start", tt.header)
+			}
+			got, ok := requestStart(r)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("requestStart(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHonorRequestStartTrustsOnlyProxies(t *testing.T) {
+	const secret = "secret"
+	expired, err := auth.MakeJWT(uuid.New(), auth.RoleCreator, secret, -5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		jwtSecret:      secret,
+		trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		reissued   bool
+	}{
+		{"trusted proxy", "10.1.2.3:4567", true},
+		{"client", "203.0.113.7:4567", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("Authorization", "Bearer "+expired)
+			r.Header.Set("X-Request-Start", fmt.Sprintf("t=%d", time.Now().Add(-30*time.Minute).Unix()))
+
+			var seen *http.Request
+			cfg.honorRequestStart(func(w http.ResponseWriter, r *http.Request) { seen = r })(httptest.NewRecorder(), r)
+			if seen == nil {
+				t.Fatal("handler wasn't called")
+			}
+			token, err := auth.GetBearerToken(seen.Header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = auth.ParseAccessToken(token, secret)
+			if reissued := err == nil; reissued != tt.reissued {
+				t.Errorf("token reissued = %v, want %v", reissued, tt.reissued)
+			}
+			if !tt.reissued && seen.Header.Get("X-Request-Start") != "" {
+				t.Error("X-Request-Start from a client was passed on")
+			}
+		})
+	}
+}
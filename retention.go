@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const retentionSweepInterval = time.Hour
+
+// runRetentionSweeper deletes videos whose retention period has run out,
+// checking every retentionSweepInterval until ctx is done.
+func (cfg *apiConfig) runRetentionSweeper(ctx context.Context) {
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+	for {
+		cfg.sweepExpiredVideos(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) sweepExpiredVideos(ctx context.Context) {
+	for {
+		videos, err := cfg.db.GetExpiredVideos(100)
+		if err != nil {
+			log.Printf("Couldn't look up expired videos: %v", err)
+			return
+		}
+		if len(videos) == 0 {
+			return
+		}
+		for _, video := range videos {
+			if err := cfg.deleteExpiredVideo(ctx, video); err != nil {
+				log.Printf("Couldn't delete expired video %s: %v", video.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// deleteExpiredVideo removes the video, its stored objects are cleaned up
+// by the tombstone worker.
+func (cfg *apiConfig) deleteExpiredVideo(ctx context.Context, video database.Video) error {
+	if err := cfg.deleteVideo(video); err != nil {
+		return err
+	}
+	log.Printf("Deleted video %s at the end of its retention period", video.ID)
+	// no one asked for this deletion, so the system is the actor
+	err := cfg.db.CreateAuditEntry(database.CreateAuditEntryParams{
+		UserID:    video.UserID,
+		Action:    database.AuditVideoRemoved,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+		Detail:    "retention period ended",
+	})
+	if err != nil {
+		log.Printf("Couldn't audit deletion of expired video %s: %v", video.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// accessRole is the role claim for a user's access tokens. Admins come
+// from ADMIN_EMAILS rather than the stored role.
+func accessRole(user database.User) auth.Role {
+	if user.IsAdmin {
+		return auth.RoleAdmin
+	}
+	role, err := auth.ParseRole(user.Role)
+	if err != nil {
+		return auth.RoleViewer
+	}
+	return role
+}
+
+// requireRole guards a route: the request needs an access token whose
+// role includes role. Tokens issued before the user's role last changed
+// are refused, so a downgrade applies right away rather than once the old
+// token expires. Handlers still check ownership themselves.
+func (cfg *apiConfig) requireRole(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		claims, err := auth.ParseAccessToken(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+
+		user, err := cfg.db.GetUser(claims.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user == nil {
+			respondWithError(w, http.StatusUnauthorized, "User not found", nil)
+			return
+		}
+		// both times are in whole seconds, so a token from the same second
+		// as the change may carry the old role
+		if user.RoleChangedAt != nil && !claims.IssuedAt.After(*user.RoleChangedAt) {
+			respondWithError(w, http.StatusUnauthorized, "Your role has changed, refresh your token or log in again", nil)
+			return
+		}
+
+		if !claims.Role.Includes(role) {
+			respondWithError(w, http.StatusForbidden, "This needs the "+string(role)+" role", nil)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// s3ImportSource is an object in another bucket to import a video from.
+type s3ImportSource struct {
+	Bucket string
+	Key    string
+}
+
+// parseS3URL extracts the object an S3 URL, such as a presigned GET URL,
+// points to. Virtual-hosted (bucket.s3.region.amazonaws.com/key) and path
+// style (s3.region.amazonaws.com/bucket/key) URLs are understood. region
+// is "" for the global endpoint.
+func parseS3URL(rawURL string) (src s3ImportSource, region string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return s3ImportSource{}, "", err
+	}
+	if u.Scheme != "https" {
+		return s3ImportSource{}, "", errors.New("URL must use https")
+	}
+	host, ok := strings.CutSuffix(u.Hostname(), ".amazonaws.com")
+	if !ok {
+		return s3ImportSource{}, "", errors.New("URL isn't an S3 URL")
+	}
+
+	// the labels before the last "s3" are the bucket, the ones after the
+	// region; bucket names can contain "s3" labels too
+	labels := strings.Split(host, ".")
+	endpoint := -1
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == "s3" || strings.HasPrefix(labels[i], "s3-") {
+			endpoint = i
+			break
+		}
+	}
+	if endpoint < 0 {
+		return s3ImportSource{}, "", errors.New("URL isn't an S3 URL")
+	}
+	region = strings.TrimPrefix(labels[endpoint], "s3-")
+	for _, label := range labels[endpoint+1:] {
+		if label != "dualstack" {
+			region = label
+		}
+	}
+	switch region {
+	case "s3", "accelerate", "external-1":
+		// endpoints that aren't tied to a single region
+		region = ""
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+	src.Bucket = strings.Join(labels[:endpoint], ".")
+	if src.Bucket == "" {
+		src.Bucket, path, _ = strings.Cut(path, "/")
+	}
+	src.Key = path
+	if src.Bucket == "" || src.Key == "" {
+		return s3ImportSource{}, "", errors.New("URL doesn't name an object")
+	}
+	return src, region, nil
+}
+
+// checkPresignedURL makes sure rawURL grants read access to its object on
+// its own, since the copy uses Tubely's credentials rather than the URL's:
+// without this, anyone could name any object in an allowed bucket. A
+// presigned URL is only signed for GET, so the first byte is read rather
+// than the object's headers. Redirects aren't followed. Errors are
+// *ingestError.
+func (cfg *apiConfig) checkPresignedURL(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return &ingestError{http.StatusBadRequest, "Invalid S3 URL", err}
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	client := *cfg.ingestClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &ingestError{http.StatusBadGateway, "Couldn't reach the S3 URL", err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return nil
+	case http.StatusNotFound:
+		return &ingestError{http.StatusNotFound, "Source object not found", nil}
+	default:
+		err := fmt.Errorf("S3 responded with %s", resp.Status)
+		return &ingestError{http.StatusForbidden, "The URL doesn't grant access to the object, it may have expired", err}
+	}
+}
+
+// importS3Object copies a video from another bucket into Tubely's and
+// points video at it. The source is probed with ranged reads first, so
+// nothing is copied for a file that isn't a usable video. There's no local
+// copy to process, so profiles that would change the video are refused.
+// Errors are *ingestError.
+func (cfg *apiConfig) importS3Object(ctx context.Context, video database.Video, src s3ImportSource, size int64, profile processingProfile) (database.Video, error) {
+	logger := loggerFrom(ctx)
+	if video.ArchiveStatus != "" {
+		return database.Video{}, &ingestError{http.StatusConflict, "This video is archived, restore it before replacing its file", errVideoArchived}
+	}
+	if video.VideoURL != nil && video.MediaKind != database.MediaKindVideo {
+		return database.Video{}, &ingestError{http.StatusConflict, "This video holds audio, so only audio can replace it", nil}
+	}
+
+	probe, err := cfg.probeObject(ctx, src.Bucket, src.Key, size)
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		return database.Video{}, err
+	}
+	if errors.Is(err, errMediaToolsUnavailable) {
+		return database.Video{}, mediaIngestError("Failed to probe video", err)
+	}
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusBadGateway, "Couldn't read the source object", err}
+	}
+
+	sourceFPS := probe.frameRate()
+	fpsMode := profile.frameRateModeFor(sourceFPS)
+	if fpsMode.conformRate() > 0 || fpsMode == frameRateSlowMo {
+		msg := fmt.Sprintf("This video needs processing (%s frame rate handling), upload it instead of importing it", fpsMode)
+		return database.Video{}, &ingestError{http.StatusUnprocessableEntity, msg, nil}
+	}
+
+	version, err := cfg.nextVideoVersion(video)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't reserve a version", err}
+	}
+	key := versionObjectKey(video.ID, version, "")
+	err = cfg.copyPendingObject(ctx, video, probe.aspectRatio(), src.Bucket, src.Key, size, key, "video/mp4")
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusBadGateway, "Failed to copy the source object", err}
+	}
+	logger.Info("imported video from S3",
+		"source_bucket", src.Bucket,
+		"source_key", src.Key,
+		"key", key,
+		"bytes", size,
+	)
+
+	video.OriginalFilename = cleanFilename(src.Key)
+	video.MediaKind = database.MediaKindVideo
+	video.LoudnessTarget = nil
+	return cfg.commitVideoObjects(ctx, video, version, probe, []database.CreateRenditionParams{{
+		VideoID:         video.ID,
+		Kind:            "primary",
+		VideoURL:        cfg.getObjectURL(cfg.videoBucket(video), key),
+		FrameRate:       sourceFPS,
+		SourceFrameRate: sourceFPS,
+		FrameRateMode:   string(fpsMode),
+		Size:            size,
+	}}, []string{key})
+}
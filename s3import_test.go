@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    s3ImportSource
+		region  string
+		wantErr bool
+	}{
+		{
+			name:   "virtual-hosted presigned",
+			url:    "https://media.s3.us-east-1.amazonaws.com/raw/clip.mp4?X-Amz-Signature=abc&X-Amz-Expires=3600",
+			want:   s3ImportSource{Bucket: "media", Key: "raw/clip.mp4"},
+			region: "us-east-1",
+		},
+		{
+			name:   "path style",
+			url:    "https://s3.eu-west-2.amazonaws.com/media/raw/clip.mp4",
+			want:   s3ImportSource{Bucket: "media", Key: "raw/clip.mp4"},
+			region: "eu-west-2",
+		},
+		{
+			name: "global endpoint",
+			url:  "https://media.s3.amazonaws.com/clip.mp4",
+			want: s3ImportSource{Bucket: "media", Key: "clip.mp4"},
+		},
+		{
+			name:   "legacy dash region",
+			url:    "https://media.s3-us-west-2.amazonaws.com/clip.mp4",
+			want:   s3ImportSource{Bucket: "media", Key: "clip.mp4"},
+			region: "us-west-2",
+		},
+		{
+			name:   "dualstack",
+			url:    "https://media.s3.dualstack.us-east-2.amazonaws.com/clip.mp4",
+			want:   s3ImportSource{Bucket: "media", Key: "clip.mp4"},
+			region: "us-east-2",
+		},
+		{
+			name:   "bucket with dots and an s3 label",
+			url:    "https://my.s3.backups.s3.us-east-1.amazonaws.com/clip.mp4",
+			want:   s3ImportSource{Bucket: "my.s3.backups", Key: "clip.mp4"},
+			region: "us-east-1",
+		},
+		{
+			name: "accelerate endpoint",
+			url:  "https://media.s3-accelerate.amazonaws.com/clip.mp4",
+			want: s3ImportSource{Bucket: "media", Key: "clip.mp4"},
+		},
+		{name: "http", url: "http://media.s3.us-east-1.amazonaws.com/clip.mp4", wantErr: true},
+		{name: "not AWS", url: "https://media.s3.us-east-1.example.com/clip.mp4", wantErr: true},
+		{name: "AWS but not S3", url: "https://ec2.us-east-1.amazonaws.com/clip.mp4", wantErr: true},
+		{name: "suffix lookalike", url: "https://media.s3.evil-amazonaws.com/clip.mp4", wantErr: true},
+		{name: "no key", url: "https://media.s3.us-east-1.amazonaws.com/", wantErr: true},
+		{name: "path style without key", url: "https://s3.us-east-1.amazonaws.com/media", wantErr: true},
+		{name: "unparsable", url: "https://media.s3.amazonaws.com/%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, region, err := parseS3URL(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseS3URL(%q) = %+v, %q, want an error", tt.url, src, region)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseS3URL(%q): %v", tt.url, err)
+			}
+			if src != tt.want || region != tt.region {
+				t.Errorf("parseS3URL(%q) = %+v, %q, want %+v, %q", tt.url, src, region, tt.want, tt.region)
+			}
+		})
+	}
+}
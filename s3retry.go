@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// s3RetryPolicy controls how S3 calls are retried. Each attempt gets its
+// own timeout, so one hung connection can't use up the caller's whole
+// deadline, while the caller's context still bounds the total.
+type s3RetryPolicy struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	AttemptTimeout time.Duration
+}
+
+var (
+	// s3DefaultRetry suits small requests such as deletes and heads
+	s3DefaultRetry = s3RetryPolicy{
+		MaxAttempts:    4,
+		BaseDelay:      200 * time.Millisecond,
+		MaxDelay:       5 * time.Second,
+		AttemptTimeout: 30 * time.Second,
+	}
+	// s3UploadRetry gives each attempt long enough to send a large video
+	s3UploadRetry = s3RetryPolicy{
+		MaxAttempts:    4,
+		BaseDelay:      time.Second,
+		MaxDelay:       20 * time.Second,
+		AttemptTimeout: 10 * time.Minute,
+	}
+)
+
+var s3Retryables = retry.IsErrorRetryables(retry.DefaultRetryables)
+
+// withS3Retry runs op until it succeeds, fails with an error that isn't
+// worth retrying, or runs out of attempts. Between attempts it waits an
+// exponentially growing, fully jittered delay. op must be safe to repeat,
+// e.g. by rewinding any request body.
+func withS3Retry(ctx context.Context, policy s3RetryPolicy, name string, op func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.AttemptTimeout)
+		err = op(attemptCtx)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+		if !timedOut && s3Retryables.IsErrorRetryable(err) != aws.TrueTernary {
+			break
+		}
+
+		delay := min(policy.MaxDelay, policy.BaseDelay<<(attempt-1))
+		delay = rand.N(delay + 1)
+		loggerFrom(ctx).Warn("S3 call failed, retrying",
+			"operation", name,
+			"attempt", attempt,
+			"max_attempts", policy.MaxAttempts,
+			"delay", delay,
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", name, errors.Join(err, ctx.Err()))
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
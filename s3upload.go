@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/throttle"
+)
+
+// s3Uploads are the limits every file sent to S3 shares, so one large
+// upload can't take the instance's whole network.
+type s3Uploads struct {
+	s3UploadSettings
+	// slots holds a token for each request sending a file to S3
+	slots chan struct{}
+	// bandwidth is shared by every upload, nil if uncapped
+	bandwidth *throttle.Limiter
+}
+
+func newS3Uploads(settings s3UploadSettings) *s3Uploads {
+	return &s3Uploads{
+		s3UploadSettings: settings,
+		slots:            make(chan struct{}, settings.GlobalConcurrency),
+		bandwidth:        throttle.New(settings.GlobalBandwidth),
+	}
+}
+
+// acquire waits for a free request slot, or for ctx to be done.
+func (u *s3Uploads) acquire(ctx context.Context) error {
+	select {
+	case u.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (u *s3Uploads) release() {
+	<-u.slots
+}
+
+// body throttles r to the global cap and the upload's own limit.
+func (u *s3Uploads) body(ctx context.Context, r io.ReadSeeker, limit *throttle.Limiter) io.ReadSeeker {
+	if limit == nil && u.bandwidth == nil {
+		return r
+	}
+	return throttle.NewReadSeeker(ctx, r, limit, u.bandwidth)
+}
+
+// putObjectParts uploads size bytes of body to key in bucket as a
+// multipart upload, sending up to the configured number of parts at once.
+// Each part carries its SHA-256 for S3 to check; a whole-object digest
+// can't be checked for multipart uploads.
+func (cfg *apiConfig) putObjectParts(ctx context.Context, bucket, key string, body io.ReaderAt, size int64, contentType string, tags objectTags, limit *throttle.Limiter) error {
+	var upload *s3.CreateMultipartUploadOutput
+	err := withS3Retry(ctx, s3DefaultRetry, "CreateMultipartUpload "+key, func(ctx context.Context) error {
+		var err error
+		upload, err = cfg.buckets.client(bucket).CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ContentType:         aws.String(contentType),
+			Tagging:             aws.String(tags.encode()),
+			ChecksumAlgorithm:   types.ChecksumAlgorithmSha256,
+			ACL:                 cfg.s3ObjectSettings.ACL,
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// aborted on shutdown if the upload can't finish
+	cfg.inflight.trackMultipart(*upload.UploadId, bucket, key)
+	defer cfg.inflight.untrackMultipart(*upload.UploadId)
+
+	partSize := cfg.s3Uploads.PartSize
+	parts := make([]types.CompletedPart, (size+partSize-1)/partSize)
+
+	// the first part to fail stops the rest
+	partsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var partErr error
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(cfg.s3Uploads.Concurrency, len(parts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				offset := int64(i) * partSize
+				section := io.NewSectionReader(body, offset, min(partSize, size-offset))
+				part, err := cfg.putObjectPart(partsCtx, bucket, key, *upload.UploadId, int32(i+1), section, limit)
+				if err != nil {
+					mu.Lock()
+					if partErr == nil {
+						partErr = err
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				parts[i] = part
+			}
+		}()
+	}
+feed:
+	for i := range parts {
+		select {
+		case next <- i:
+		case <-partsCtx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	err = partErr
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = withS3Retry(ctx, s3DefaultRetry, "CompleteMultipartUpload "+key, func(ctx context.Context) error {
+			_, err := cfg.buckets.client(bucket).CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+				Bucket:              aws.String(bucket),
+				Key:                 aws.String(key),
+				UploadId:            upload.UploadId,
+				MultipartUpload:     &types.CompletedMultipartUpload{Parts: parts},
+				ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			})
+			return err
+		})
+	}
+	if err != nil {
+		cfg.abortMultipartUpload(ctx, bucket, key, *upload.UploadId)
+		return err
+	}
+	return nil
+}
+
+// putObjectPart sends one part of a multipart upload, retrying it from its
+// start, while holding one of the global request slots.
+func (cfg *apiConfig) putObjectPart(ctx context.Context, bucket, key, uploadID string, partNumber int32, section *io.SectionReader, limit *throttle.Limiter) (types.CompletedPart, error) {
+	// hashed up front, unthrottled, so the SDK doesn't read the part twice
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, section); err != nil {
+		return types.CompletedPart{}, fmt.Errorf("couldn't read part %d of %s: %w", partNumber, key, err)
+	}
+	sum := hasher.Sum(nil)
+
+	if err := cfg.s3Uploads.acquire(ctx); err != nil {
+		return types.CompletedPart{}, err
+	}
+	defer cfg.s3Uploads.release()
+
+	var out *s3.UploadPartOutput
+	err := withS3Retry(ctx, s3UploadRetry, fmt.Sprintf("UploadPart %s part %d", key, partNumber), func(ctx context.Context) error {
+		if _, err := section.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var err error
+		out, err = cfg.buckets.client(bucket).UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			UploadId:            aws.String(uploadID),
+			PartNumber:          aws.Int32(partNumber),
+			Body:                cfg.s3Uploads.body(ctx, section, limit),
+			ContentLength:       aws.Int64(section.Size()),
+			ChecksumAlgorithm:   types.ChecksumAlgorithmSha256,
+			ChecksumSHA256:      aws.String(base64.StdEncoding.EncodeToString(sum)),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		}, withPayloadSHA256(hex.EncodeToString(sum)), cfg.accelerated)
+		return err
+	})
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+	return types.CompletedPart{
+		ETag:           out.ETag,
+		PartNumber:     aws.Int32(partNumber),
+		ChecksumSHA256: out.ChecksumSHA256,
+	}, nil
+}
+
+// withPayloadSHA256 has a request signed with a body digest computed
+// beforehand. Over plain HTTP the SDK would otherwise read the body to
+// hash it before sending it, which a throttled body makes twice as slow.
+func withPayloadSHA256(sha256Hex string) func(*s3.Options) {
+	return s3.WithAPIOptions(func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("PresetPayloadSHA256",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				return next.HandleFinalize(v4.SetPayloadHash(ctx, sha256Hex), in)
+			}), middleware.Before)
+	})
+}
+
+// abortMultipartUpload discards an unfinished multipart upload, whose
+// parts are billed until it's aborted. Failures are logged.
+func (cfg *apiConfig) abortMultipartUpload(ctx context.Context, bucket, key, uploadID string) {
+	_, err := cfg.buckets.client(bucket).AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(key),
+		UploadId:            aws.String(uploadID),
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't abort multipart upload", "bucket", bucket, "key", key, "error", err)
+	}
+}
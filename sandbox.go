@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// sandboxS3Path is where the in-memory bucket is served in sandbox mode,
+// so browsers can play the videos stored in it.
+const sandboxS3Path = "/sandbox/s3"
+
+// sandboxMedia is set in sandbox mode (TUBELY_SANDBOX=1) and replaces
+// ffprobe and ffmpeg with instant fakes, so they needn't be installed.
+var sandboxMedia bool
+
+// sandboxDefaults fill in the settings sandbox mode needs but doesn't use
+// for anything real, so it runs without a .env file.
+var sandboxDefaults = map[string]string{
+	"JWT_SECRET":    "tubely-sandbox-secret",
+	"PLATFORM":      "dev",
+	"FILEPATH_ROOT": "./app",
+	"ASSETS_ROOT":   "./assets",
+	"S3_BUCKET":     "tubely-sandbox",
+	"S3_REGION":     "us-east-1",
+	"S3_CF_DISTRO":  "sandbox",
+	"PORT":          "8091",
+	"ADMIN_EMAILS":  "admin@tubely.dev",
+}
+
+func applySandboxDefaults() {
+	for name, value := range sandboxDefaults {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// resetSandboxDB returns the path of a fresh database for a sandbox run.
+// Stored objects only live in memory, so data from an earlier run would
+// point at videos that are gone.
+// It gets a directory of its own, as the temp store clears out files
+// named like its own at startup.
+func resetSandboxDB() (string, error) {
+	dir := filepath.Join(os.TempDir(), "tubely-sandbox")
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tubely.db"), nil
+}
+
+// newSandboxS3Client returns an S3 client backed by store. Requests never
+// leave the process, but presigned URLs point at the server's own
+// sandboxS3Path, where the store is served read-only.
+func newSandboxS3Client(store *blobstore.Memory, region, port string, optFns ...func(*s3.Options)) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       region,
+		Credentials:  credentials.NewStaticCredentialsProvider("sandbox", "sandbox", ""),
+		BaseEndpoint: aws.String(sandboxS3Endpoint(port)),
+		UsePathStyle: true,
+		HTTPClient:   &http.Client{Transport: blobstore.Transport(http.StripPrefix(sandboxS3Path, store))},
+	}, optFns...)
+}
+
+func sandboxS3Endpoint(port string) string {
+	return fmt.Sprintf("http://localhost:%s%s", port, sandboxS3Path)
+}
+
+// sandboxProbeJSON is what sandbox mode reports for every video: a 16:9,
+// 30 fps clip with two chapters, which every processing profile stores as
+// it is.
+const sandboxProbeJSON = `{
+	"streams": [
+		{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "30/1", "r_frame_rate": "30/1"},
+		{"codec_type": "audio", "codec_name": "aac", "sample_rate": "48000"}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.000000", "bit_rate": "4000000"},
+	"chapters": [
+		{"start_time": "0.000000", "tags": {"title": "Intro"}},
+		{"start_time": "4.000000", "tags": {"title": "Main part"}}
+	]
+}`
+
+// sandboxAudioProbeJSON is what sandbox mode reports for every audio
+// upload: a 10 minute, 128 kbit/s MP3.
+const sandboxAudioProbeJSON = `{
+	"streams": [
+		{"codec_type": "audio", "codec_name": "mp3", "sample_rate": "44100"}
+	],
+	"format": {"format_name": "mp3", "duration": "600.000000", "bit_rate": "128000"}
+}`
+
+func sandboxProbe(probeJSON string) (probeResult, error) {
+	var probe probeResult
+	err := json.Unmarshal([]byte(probeJSON), &probe)
+	return probe, err
+}
+
+// sandboxTranscode stands in for an ffmpeg run by copying the input as it
+// is, next to it like runFFmpeg's output.
+func sandboxTranscode(inputPath, ext string) (string, error) {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(inputPath), "tubely-processed-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// sandboxFrame stands in for a frame grab with a plain placeholder image.
+func sandboxFrame(outputPath string) error {
+	img := image.NewRGBA(image.Rect(0, 0, frameThumbnailMaxWidth, frameThumbnailMaxWidth*9/16))
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			img.Set(x, y, color.RGBA{R: 40, G: 44, B: uint8(60 + y*120/img.Rect.Max.Y), A: 255})
+		}
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return jpeg.Encode(out, img, nil)
+}
+
+// sandboxAudio stands in for extracting a video's audio with a placeholder
+// file.
+func sandboxAudio(outputPath string) error {
+	return os.WriteFile(outputPath, make([]byte, 4096), 0o600)
+}
+
+// sandboxClip stands in for cutting a clip with a copy of the whole
+// input, which the sandbox serves from its own in-memory bucket.
+func sandboxClip(ctx context.Context, input, outputPath string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, input, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't read clip source: %s", resp.Status)
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// sandboxTranscriber stands in for every transcription provider with a
+// fixed transcript captioned across the whole video.
+type sandboxTranscriber struct{}
+
+func (sandboxTranscriber) provider() string {
+	return "sandbox"
+}
+
+func (sandboxTranscriber) check(ctx context.Context) error {
+	return nil
+}
+
+func (sandboxTranscriber) transcribe(ctx context.Context, src transcriptionSource) (transcription, error) {
+	language := src.Language
+	if language == "" {
+		language = "en"
+	}
+	end := 10.0
+	if src.Duration != nil && *src.Duration > 0 {
+		end = *src.Duration
+	}
+	text := "This is a sandbox transcript."
+	vtt := fmt.Sprintf("WEBVTT\n\n00:00:00.000 --> %02d:%02d:%06.3f\n%s\n", int(end)/3600, int(end)/60%60, end-float64(int(end)/60*60), text)
+	return transcription{Language: language, Text: text, VTT: []byte(vtt)}, nil
+}
+
+// sandboxModerator stands in for Rekognition, finding nothing in any
+// frame. MODERATION=command runs a real model even in the sandbox.
+type sandboxModerator struct{}
+
+func (sandboxModerator) provider() string {
+	return "sandbox"
+}
+
+func (sandboxModerator) check(ctx context.Context) error {
+	return nil
+}
+
+func (sandboxModerator) moderate(ctx context.Context, framePath string) ([]database.ModerationLabel, error) {
+	return []database.ModerationLabel{}, nil
+}
+
+// sandboxEICAR is the standard antivirus test file, which real scanners
+// detect as Eicar-Test-Signature.
+var sandboxEICAR = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+// sandboxVirusScanner stands in for clamd, finding only the EICAR test
+// file, anywhere in an upload.
+type sandboxVirusScanner struct{}
+
+func (sandboxVirusScanner) check(ctx context.Context) error {
+	return nil
+}
+
+func (sandboxVirusScanner) scan(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, sandboxEICAR) {
+		return &virusFoundError{Signature: "Eicar-Test-Signature"}
+	}
+	return nil
+}
+
+// sandboxSampleURL is where the course's sample assets are hosted. Seeded
+// videos point at them since the sandbox can't make playable MP4s itself.
+const sandboxSampleURL = "https://storage.googleapis.com/qvault-webapp-dynamic-assets/course_assets/"
+
+// sandboxPassword is the password of every seeded account.
+const sandboxPassword = "password"
+
+// seedSandbox creates demo accounts and videos: a regular user with a few
+// videos in different states, a read-only viewer, and an admin (listed in
+// ADMIN_EMAILS by default).
+func seedSandbox(db database.Client) error {
+	hashed, err := auth.HashPassword(sandboxPassword)
+	if err != nil {
+		return err
+	}
+	demo, err := db.CreateUser(database.CreateUserParams{Email: "demo@tubely.dev", Password: hashed})
+	if err != nil {
+		return err
+	}
+	if _, err := db.CreateUser(database.CreateUserParams{Email: "admin@tubely.dev", Password: hashed}); err != nil {
+		return err
+	}
+	viewer, err := db.CreateUser(database.CreateUserParams{Email: "viewer@tubely.dev", Password: hashed})
+	if err != nil {
+		return err
+	}
+	if err := db.SetUserRole(viewer.ID, string(auth.RoleViewer)); err != nil {
+		return err
+	}
+
+	videos := []struct {
+		title, description, visibility, sample, thumbnail string
+		tags                                              []string
+	}{
+		{"Boots in landscape", "A ready, public video.", visibilityPublic, "boots-video-horizontal.mp4", "boots-image-horizontal.png", []string{"demo", "landscape"}},
+		{"Boots in portrait", "A ready, unlisted video.", visibilityUnlisted, "boots-video-vertical.mp4", "boots-image-vertical.png", []string{"demo", "portrait"}},
+		{"Draft", "A video waiting for its upload.", visibilityPrivate, "", "", nil},
+	}
+	for _, v := range videos {
+		video, err := db.CreateVideo(database.CreateVideoParams{
+			Title:       v.title,
+			Description: v.description,
+			UserID:      demo.ID,
+			Visibility:  v.visibility,
+		})
+		if err != nil {
+			return err
+		}
+		if err := db.AddVideoTags(video.ID, v.tags); err != nil {
+			return err
+		}
+		if v.sample == "" {
+			continue
+		}
+
+		videoURL := sandboxSampleURL + v.sample
+		thumbnailURL := sandboxSampleURL + v.thumbnail
+		video.VideoURL = &videoURL
+		video.ThumbnailURL = &thumbnailURL
+		if err := db.UpdateVideo(video); err != nil {
+			return err
+		}
+		_, err = db.CreateRendition(database.CreateRenditionParams{
+			VideoID:         video.ID,
+			Kind:            "primary",
+			VideoURL:        videoURL,
+			FrameRate:       30,
+			SourceFrameRate: 30,
+			FrameRateMode:   string(frameRatePreserve),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sandboxCDN stands in for CloudFront, which has nothing cached for the
+// sandbox bucket to invalidate.
+type sandboxCDN struct{}
+
+func (sandboxCDN) invalidate(ctx context.Context, paths []string) error {
+	return nil
+}
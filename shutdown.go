@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	defaultShutdownTimeout = 2 * time.Minute
+	// once the shutdown timeout has passed, work that's still running is
+	// canceled and gets this long to clean up after itself
+	shutdownCancelGrace = 15 * time.Second
+)
+
+// inflightWork tracks what has to finish, or be stopped, before the server
+// exits: background work started by requests and open multipart uploads.
+type inflightWork struct {
+	// ctx is the base of every request and background job, canceled once
+	// the server gives up waiting for them
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// multipart maps the IDs of unfinished multipart uploads to where
+	// they're going
+	multipart map[string]multipartTarget
+}
+
+// multipartTarget is the object a multipart upload is writing.
+type multipartTarget struct {
+	Bucket string
+	Key    string
+}
+
+func newInflightWork() *inflightWork {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &inflightWork{
+		ctx:       ctx,
+		cancel:    cancel,
+		multipart: map[string]multipartTarget{},
+	}
+}
+
+// goBackground runs fn in a goroutine the server waits for on shutdown.
+func (cfg *apiConfig) goBackground(fn func()) {
+	cfg.inflight.wg.Add(1)
+	go func() {
+		defer cfg.inflight.wg.Done()
+		fn()
+	}()
+}
+
+func (w *inflightWork) trackMultipart(uploadID, bucket, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.multipart[uploadID] = multipartTarget{Bucket: bucket, Key: key}
+}
+
+func (w *inflightWork) untrackMultipart(uploadID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.multipart, uploadID)
+}
+
+// wait waits for background work until ctx is done, reporting whether it
+// all finished.
+func (w *inflightWork) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdown stops srv accepting requests and waits up to timeout for the
+// ones in flight, such as uploads, and for background work. A worker has
+// no srv, only jobs. Whatever is still running then is canceled.
+// Multipart uploads left unfinished are aborted so their parts aren't
+// billed, and this process's temp files are removed.
+func (cfg *apiConfig) shutdown(srv *http.Server, timeout time.Duration) {
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	drained := shutdownServer(ctx, srv) && cfg.inflight.wait(ctx)
+	if !drained {
+		log.Printf("Shutdown timeout passed, canceling work still in flight")
+	}
+	// canceled even after a clean drain, so nothing started late lingers
+	cfg.inflight.cancel()
+	if !drained {
+		graceCtx, cancel := context.WithTimeout(context.Background(), shutdownCancelGrace)
+		defer cancel()
+		stopped := shutdownServer(graceCtx, srv) && cfg.inflight.wait(graceCtx)
+		if !stopped {
+			log.Printf("Some requests or jobs didn't stop in time")
+		}
+	}
+
+	abortCtx, abortCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer abortCancel()
+	cfg.abortMultipartUploads(abortCtx)
+
+	removed, err := cfg.tempStore.Close()
+	if err != nil {
+		log.Printf("Couldn't clean up temp files: %v", err)
+	} else if removed > 0 {
+		log.Printf("Removed %d temp files", removed)
+	}
+}
+
+// shutdownServer stops srv, if any, reporting whether its requests
+// finished before ctx was done.
+func shutdownServer(ctx context.Context, srv *http.Server) bool {
+	return srv == nil || srv.Shutdown(ctx) == nil
+}
+
+// abortMultipartUploads aborts the multipart uploads that never finished.
+func (cfg *apiConfig) abortMultipartUploads(ctx context.Context) {
+	cfg.inflight.mu.Lock()
+	pending := maps.Clone(cfg.inflight.multipart)
+	cfg.inflight.mu.Unlock()
+
+	for uploadID, target := range pending {
+		key := target.Key
+		err := withS3Retry(ctx, s3DefaultRetry, "AbortMultipartUpload "+key, func(ctx context.Context) error {
+			_, err := cfg.buckets.client(target.Bucket).AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:              aws.String(target.Bucket),
+				Key:                 aws.String(key),
+				UploadId:            aws.String(uploadID),
+				ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			})
+			return err
+		})
+		if err != nil {
+			log.Printf("Couldn't abort multipart upload of %s: %v", key, err)
+			continue
+		}
+		cfg.inflight.untrackMultipart(uploadID)
+		log.Printf("Aborted unfinished multipart upload of %s", key)
+	}
+}
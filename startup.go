@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// requiredSettings are the environment variables the server can't start
+// without.
+var requiredSettings = []string{
+	"DB_PATH",
+	"JWT_SECRET",
+	"PLATFORM",
+	"FILEPATH_ROOT",
+	"ASSETS_ROOT",
+	"S3_BUCKET",
+	"S3_REGION",
+	"S3_CF_DISTRO",
+	"PORT",
+}
+
+// missingSettings lists the required settings that are unset or blank.
+// Sandbox mode makes its own database, and DATABASE_URL replaces the SQLite
+// file, so DB_PATH isn't needed then.
+func missingSettings(sandbox bool) []string {
+	var missing []string
+	for _, name := range requiredSettings {
+		if name == "DB_PATH" && (sandbox || os.Getenv("DATABASE_URL") != "") {
+			continue
+		}
+		if strings.TrimSpace(os.Getenv(name)) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// startupCheckTimeout bounds the S3 checks, so an unreachable endpoint
+// fails startup instead of hanging it.
+const startupCheckTimeout = 30 * time.Second
+
+// validateStartup checks what every upload depends on but that nothing
+// exercises until the first one arrives: the media tools, the bucket, its
+// Transfer Acceleration if it's used, and the transcriber, moderator, virus
+// scanner, watch folder and job queue if there are any. All the problems
+// found are reported together.
+func (cfg *apiConfig) validateStartup(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startupCheckTimeout)
+	defer cancel()
+
+	errs := []error{cfg.media.check(ctx)}
+	if cfg.transcriber != nil {
+		errs = append(errs, cfg.transcriber.check(ctx))
+	}
+	if cfg.moderator != nil {
+		errs = append(errs, cfg.moderator.check(ctx))
+	}
+	if cfg.virusScanner != nil {
+		errs = append(errs, cfg.virusScanner.check(ctx))
+	}
+	if cfg.watchFolder.Dir != "" {
+		errs = append(errs, cfg.checkWatchFolder())
+	}
+	if cfg.jobQueue != nil {
+		if _, err := cfg.jobQueue.stats(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("couldn't reach the job queue, %s: %w", cfg.jobQueue.name(), err))
+		}
+	}
+	for _, bucket := range cfg.buckets.Buckets {
+		if err := cfg.checkBucket(ctx, bucket); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, cfg.validateBucketOwnership(ctx, bucket.Name))
+		if cfg.s3Accelerate {
+			errs = append(errs, cfg.checkTransferAcceleration(ctx, bucket.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkBucket makes sure one of the buckets videos are stored in exists,
+// is in the region it's configured with and can be reached with the
+// configured credentials.
+func (cfg *apiConfig) checkBucket(ctx context.Context, bucket storageBucket) error {
+	// S3_BUCKET is in S3_REGION, the others have theirs in S3_BUCKETS
+	setting := "S3_BUCKET"
+	fixRegion := func(region string) string { return "set S3_REGION=" + region }
+	if bucket.Name != cfg.buckets.defaultBucket() {
+		setting = "S3_BUCKETS"
+		fixRegion = func(region string) string { return "list it in S3_BUCKETS as " + bucket.Name + ":" + region }
+	}
+	err := withS3Retry(ctx, s3DefaultRetry, "HeadBucket", func(ctx context.Context) error {
+		_, err := cfg.buckets.client(bucket.Name).HeadBucket(ctx, &s3.HeadBucketInput{
+			Bucket:              aws.String(bucket.Name),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusMovedPermanently:
+			region := respErr.Response.Header.Get("X-Amz-Bucket-Region")
+			if region == "" {
+				return fmt.Errorf("bucket %s isn't in region %s: %s", bucket.Name, bucket.Region, fixRegion("<its region>"))
+			}
+			return fmt.Errorf("bucket %s is in region %s, not %s: %s", bucket.Name, region, bucket.Region, fixRegion(region))
+		case http.StatusForbidden:
+			return fmt.Errorf("access to bucket %s was denied: check the AWS credentials allow s3:ListBucket on it, and that S3_EXPECTED_BUCKET_OWNER, if set, is the owning account", bucket.Name)
+		case http.StatusNotFound:
+			return fmt.Errorf("bucket %s doesn't exist: create it, or fix %s", bucket.Name, setting)
+		}
+	}
+	return fmt.Errorf("couldn't reach bucket %s in %s: check the AWS credentials (aws configure) and network access to S3: %w", bucket.Name, bucket.Region, err)
+}
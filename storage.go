@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/throttle"
+	"github.com/google/uuid"
+)
+
+// s3ObjectSettings are applied to every object request so buckets owned by
+// another account, or with specific ACL requirements, can be used.
+type s3ObjectSettings struct {
+	// ACL is the canned ACL set on new objects, empty to leave it unset
+	ACL types.ObjectCannedACL
+	// ExpectedBucketOwner is the account ID that must own the bucket
+	ExpectedBucketOwner string
+	// ObjectOwnership is the bucket setting the deployment expects, checked
+	// at startup; empty to skip the check
+	ObjectOwnership types.ObjectOwnership
+}
+
+var awsAccountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+func parseS3ObjectSettings(acl, expectedOwner, ownership string) (s3ObjectSettings, error) {
+	settings := s3ObjectSettings{
+		ACL:                 types.ObjectCannedACL(acl),
+		ExpectedBucketOwner: expectedOwner,
+		ObjectOwnership:     types.ObjectOwnership(ownership),
+	}
+	if acl != "" && !slices.Contains(settings.ACL.Values(), settings.ACL) {
+		return s3ObjectSettings{}, fmt.Errorf("S3_OBJECT_ACL must be one of %v", settings.ACL.Values())
+	}
+	if expectedOwner != "" && !awsAccountIDPattern.MatchString(expectedOwner) {
+		return s3ObjectSettings{}, errors.New("S3_EXPECTED_BUCKET_OWNER must be a 12 digit AWS account ID")
+	}
+	if ownership != "" && !slices.Contains(settings.ObjectOwnership.Values(), settings.ObjectOwnership) {
+		return s3ObjectSettings{}, fmt.Errorf("S3_OBJECT_OWNERSHIP must be one of %v", settings.ObjectOwnership.Values())
+	}
+	return settings, nil
+}
+
+// expectedBucketOwner returns the owner to send with requests, nil if unset.
+func (s s3ObjectSettings) expectedBucketOwner() *string {
+	if s.ExpectedBucketOwner == "" {
+		return nil
+	}
+	return aws.String(s.ExpectedBucketOwner)
+}
+
+// expectedOwnerOf returns the owner to send with requests to bucket. The
+// setting only describes Tubely's own buckets, others are left unchecked.
+func (cfg *apiConfig) expectedOwnerOf(bucket string) *string {
+	if _, ok := cfg.buckets.lookup(bucket); !ok {
+		return nil
+	}
+	return cfg.s3ObjectSettings.expectedBucketOwner()
+}
+
+// validateBucketOwnership checks the bucket's object ownership setting
+// against the configured expectations, so a mismatch fails at startup
+// rather than on the first upload. It only calls S3 when ACL or ownership
+// settings are configured.
+func (cfg *apiConfig) validateBucketOwnership(ctx context.Context, bucket string) error {
+	settings := cfg.s3ObjectSettings
+	if settings.ACL == "" && settings.ObjectOwnership == "" {
+		return nil
+	}
+
+	// buckets without ownership controls behave like ObjectWriter
+	actual := types.ObjectOwnershipObjectWriter
+	var out *s3.GetBucketOwnershipControlsOutput
+	err := withS3Retry(ctx, s3DefaultRetry, "GetBucketOwnershipControls", func(ctx context.Context) error {
+		var err error
+		out, err = cfg.buckets.client(bucket).GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{
+			Bucket:              aws.String(bucket),
+			ExpectedBucketOwner: settings.expectedBucketOwner(),
+		})
+		return err
+	})
+	var apiErr smithy.APIError
+	switch {
+	case err == nil:
+		if out.OwnershipControls != nil && len(out.OwnershipControls.Rules) > 0 {
+			actual = out.OwnershipControls.Rules[0].ObjectOwnership
+		}
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "OwnershipControlsNotFoundError":
+	default:
+		return fmt.Errorf("couldn't read ownership controls for bucket %s: %w", bucket, err)
+	}
+
+	if settings.ObjectOwnership != "" && settings.ObjectOwnership != actual {
+		return fmt.Errorf("bucket %s uses object ownership %s, but S3_OBJECT_OWNERSHIP is %s", bucket, actual, settings.ObjectOwnership)
+	}
+	// with ACLs disabled S3 rejects every ACL except bucket-owner-full-control
+	if actual == types.ObjectOwnershipBucketOwnerEnforced &&
+		settings.ACL != "" && settings.ACL != types.ObjectCannedACLBucketOwnerFullControl {
+		return fmt.Errorf("bucket %s has ACLs disabled (BucketOwnerEnforced), so S3_OBJECT_ACL %s would be rejected", bucket, settings.ACL)
+	}
+	return nil
+}
+
+// objectTags are the S3 tags every stored object carries, so lifecycle
+// rules, cost allocation and incident forensics can tell what an object
+// is without asking the database.
+type objectTags struct {
+	VideoID uuid.UUID
+	UserID  uuid.UUID
+	// Aspect is the aspect ratio of a video file, as aspectRatio names
+	// it, "" for objects without a picture
+	Aspect string
+}
+
+// newObjectTags returns the tags for an object of video, aspect being its
+// picture's aspect ratio if it has one.
+func newObjectTags(video database.Video, aspect string) objectTags {
+	return objectTags{VideoID: video.ID, UserID: video.UserID, Aspect: aspect}
+}
+
+// encode returns the tags as the URL query S3 expects, with upload_date
+// the day the object is stored, in UTC.
+func (t objectTags) encode() string {
+	tags := url.Values{}
+	tags.Set("video_id", t.VideoID.String())
+	tags.Set("user_id", t.UserID.String())
+	if t.Aspect != "" {
+		tags.Set("aspect", t.Aspect)
+	}
+	tags.Set("upload_date", time.Now().UTC().Format(time.DateOnly))
+	return tags.Encode()
+}
+
+// putObject uploads body to key in bucket, tagged with tags, retrying transient failures from the
+// start of body. If sha256Hex is set, S3 checks the bytes it received
+// against it and rejects the upload on a mismatch. Files larger than a
+// part go up in parallel parts instead, each checked by S3 on its own.
+// Either way the upload is held to the configured bandwidth caps.
+func (cfg *apiConfig) putObject(ctx context.Context, bucket, key string, body io.ReadSeeker, contentType, sha256Hex string, tags objectTags) error {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	limit := throttle.New(cfg.s3Uploads.Bandwidth)
+	if readerAt, ok := body.(io.ReaderAt); ok && size > cfg.s3Uploads.PartSize {
+		return cfg.putObjectParts(ctx, bucket, key, readerAt, size, contentType, tags, limit)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(key),
+		Body:                cfg.s3Uploads.body(ctx, body, limit),
+		ContentLength:       aws.Int64(size),
+		ContentType:         aws.String(contentType),
+		Tagging:             aws.String(tags.encode()),
+		ACL:                 cfg.s3ObjectSettings.ACL,
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	}
+	var optFns []func(*s3.Options)
+	if sha256Hex != "" {
+		sum, err := hex.DecodeString(sha256Hex)
+		if err != nil {
+			return fmt.Errorf("invalid SHA-256 for %s: %w", key, err)
+		}
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
+		optFns = append(optFns, withPayloadSHA256(sha256Hex))
+	}
+	if err := cfg.s3Uploads.acquire(ctx); err != nil {
+		return err
+	}
+	defer cfg.s3Uploads.release()
+	return withS3Retry(ctx, s3UploadRetry, "PutObject "+key, func(ctx context.Context) error {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := cfg.buckets.client(bucket).PutObject(ctx, input, append(optFns, cfg.accelerated)...)
+		return err
+	})
+}
+
+// presignGetObject returns a URL the server's own tools, such as ffmpeg,
+// can fetch key in bucket from until it expires.
+func (cfg *apiConfig) presignGetObject(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return cfg.presign(ctx, s3.NewPresignClient(cfg.buckets.client(bucket)), bucket, key, expires)
+}
+
+// presignClientGetObject is presignGetObject for URLs handed to clients,
+// which go through the Transfer Acceleration endpoint when it's on.
+func (cfg *apiConfig) presignClientGetObject(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return cfg.presign(ctx, s3.NewPresignClient(cfg.buckets.client(bucket), s3.WithPresignClientFromClientOptions(cfg.accelerated)), bucket, key, cfg.clientURLExpiry(expires))
+}
+
+func (cfg *apiConfig) presign(ctx context.Context, presignClient *s3.PresignClient, bucket, key string, expires time.Duration) (string, error) {
+	req, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(key),
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// accelerated sends a request through the bucket's Transfer Acceleration
+// endpoint, for clients or uploads far from its region, when it's on.
+func (cfg *apiConfig) accelerated(o *s3.Options) {
+	o.UseAccelerate = cfg.s3Accelerate
+}
+
+// checkTransferAcceleration makes sure bucket has Transfer Acceleration
+// enabled, without which its endpoint refuses requests.
+func (cfg *apiConfig) checkTransferAcceleration(ctx context.Context, bucket string) error {
+	var out *s3.GetBucketAccelerateConfigurationOutput
+	err := withS3Retry(ctx, s3DefaultRetry, "GetBucketAccelerateConfiguration", func(ctx context.Context) error {
+		var err error
+		out, err = cfg.buckets.client(bucket).GetBucketAccelerateConfiguration(ctx, &s3.GetBucketAccelerateConfigurationInput{
+			Bucket:              aws.String(bucket),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't read transfer acceleration setting of bucket %s: %w", bucket, err)
+	}
+	if out.Status != types.BucketAccelerateStatusEnabled {
+		return fmt.Errorf("bucket %s doesn't have transfer acceleration enabled, but S3_TRANSFER_ACCELERATION is on", bucket)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) removeObject(ctx context.Context, bucket, key string) error {
+	err := withS3Retry(ctx, s3DefaultRetry, "DeleteObject "+key, func(ctx context.Context) error {
+		_, err := cfg.buckets.client(bucket).DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Info("deleted S3 object", "bucket", bucket, "key", key)
+	return nil
+}
+
+// releaseObjects deletes objects in bucket that are no longer referenced
+// by any video or rendition. Deduplicated uploads can share an object
+// between videos, so a replaced or deleted video must not remove one
+// that's still in use. Failures are logged. The CDN's copies of all of
+// them are invalidated, since they were replaced either way.
+func (cfg *apiConfig) releaseObjects(ctx context.Context, bucket string, keys []string) {
+	paths := []string{}
+	for _, key := range keys {
+		if err := cfg.releaseObject(ctx, bucket, key); err != nil {
+			loggerFrom(ctx).Error("couldn't release S3 object", "bucket", bucket, "key", key, "error", err)
+		}
+		paths = append(paths, objectCDNPath(key))
+	}
+	cfg.invalidateCDNLater(ctx, paths)
+}
+
+// releaseObject deletes key from bucket unless a video or rendition still
+// uses it.
+func (cfg *apiConfig) releaseObject(ctx context.Context, bucket, key string) error {
+	inUse, err := cfg.db.ObjectURLInUse(cfg.getObjectURL(bucket, key))
+	if err != nil {
+		return fmt.Errorf("couldn't check references: %w", err)
+	}
+	if inUse {
+		return nil
+	}
+	if err := cfg.removeObject(ctx, bucket, key); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteContentObjectsByKey(key); err != nil {
+		return fmt.Errorf("couldn't forget content object: %w", err)
+	}
+	return nil
+}
+
+const (
+	// CopyObject handles objects up to 5 GB, bigger ones are copied in parts
+	maxSingleCopySize = 5 << 30
+	copyPartSize      = 512 << 20 // 512 MB, so up to 5 TB fits in 10,000 parts
+)
+
+// copyObject copies an object of the given size from another bucket to key
+// in bucket without the bytes passing through the server. The copy gets
+// tags rather than the source's.
+func (cfg *apiConfig) copyObject(ctx context.Context, srcBucket, srcKey string, size int64, bucket, key, contentType string, tags objectTags) error {
+	copySource := srcBucket + "/" + url.PathEscape(srcKey)
+	if size <= maxSingleCopySize {
+		return withS3Retry(ctx, s3UploadRetry, "CopyObject "+key, func(ctx context.Context) error {
+			_, err := cfg.buckets.client(bucket).CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:            aws.String(bucket),
+				Key:               aws.String(key),
+				CopySource:        aws.String(copySource),
+				ContentType:       aws.String(contentType),
+				MetadataDirective: types.MetadataDirectiveReplace,
+				TaggingDirective:  types.TaggingDirectiveReplace,
+				Tagging:           aws.String(tags.encode()),
+				// have S3 store a whole-object checksum for fixity checks
+				ChecksumAlgorithm:   types.ChecksumAlgorithmSha256,
+				ACL:                 cfg.s3ObjectSettings.ACL,
+				ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			})
+			return err
+		})
+	}
+
+	return cfg.copyObjectParts(ctx, copySource, size, &s3.CreateMultipartUploadInput{
+		Bucket:              aws.String(bucket),
+		Key:                 aws.String(key),
+		ContentType:         aws.String(contentType),
+		Tagging:             aws.String(tags.encode()),
+		ACL:                 cfg.s3ObjectSettings.ACL,
+		ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+	})
+}
+
+// copyObjectParts copies size bytes from copySource, a bucket/key, as the
+// multipart upload create starts, for copies CopyObject can't make.
+func (cfg *apiConfig) copyObjectParts(ctx context.Context, copySource string, size int64, create *s3.CreateMultipartUploadInput) error {
+	bucket, key := aws.ToString(create.Bucket), aws.ToString(create.Key)
+	var upload *s3.CreateMultipartUploadOutput
+	err := withS3Retry(ctx, s3DefaultRetry, "CreateMultipartUpload "+key, func(ctx context.Context) error {
+		var err error
+		upload, err = cfg.buckets.client(bucket).CreateMultipartUpload(ctx, create)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// aborted on shutdown if the copy can't finish
+	cfg.inflight.trackMultipart(*upload.UploadId, bucket, key)
+	defer cfg.inflight.untrackMultipart(*upload.UploadId)
+
+	parts := []types.CompletedPart{}
+	for offset := int64(0); offset < size; offset += copyPartSize {
+		partNumber := int32(len(parts) + 1)
+		end := min(offset+copyPartSize, size) - 1
+		var part *s3.UploadPartCopyOutput
+		err = withS3Retry(ctx, s3UploadRetry, fmt.Sprintf("UploadPartCopy %s part %d", key, partNumber), func(ctx context.Context) error {
+			var err error
+			part, err = cfg.buckets.client(bucket).UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:              aws.String(bucket),
+				Key:                 aws.String(key),
+				UploadId:            upload.UploadId,
+				PartNumber:          aws.Int32(partNumber),
+				CopySource:          aws.String(copySource),
+				CopySourceRange:     aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+				ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			})
+			return err
+		})
+		if err != nil {
+			break
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+	}
+	if err == nil {
+		err = withS3Retry(ctx, s3DefaultRetry, "CompleteMultipartUpload "+key, func(ctx context.Context) error {
+			_, err := cfg.buckets.client(bucket).CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+				Bucket:              aws.String(bucket),
+				Key:                 aws.String(key),
+				UploadId:            upload.UploadId,
+				MultipartUpload:     &types.CompletedMultipartUpload{Parts: parts},
+				ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			})
+			return err
+		})
+	}
+	if err != nil {
+		cfg.abortMultipartUpload(ctx, bucket, key, *upload.UploadId)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"image"
+	_ "image/jpeg"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blurhash"
+)
+
+// thumbnailBlurhash hashes the thumbnail stored at assetDiskPath, with more
+// components along its longer side. A placeholder is only cosmetic, so
+// failures are logged and leave the video without one.
+func thumbnailBlurhash(ctx context.Context, assetDiskPath string) *string {
+	file, err := os.Open(assetDiskPath)
+	if err != nil {
+		loggerFrom(ctx).Warn("couldn't open thumbnail to hash", "path", assetDiskPath, "error", err)
+		return nil
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		loggerFrom(ctx).Warn("couldn't decode thumbnail to hash", "path", assetDiskPath, "error", err)
+		return nil
+	}
+	xComponents, yComponents := 4, 3
+	if img.Bounds().Dy() > img.Bounds().Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	hash, err := blurhash.Encode(img, xComponents, yComponents)
+	if err != nil {
+		loggerFrom(ctx).Warn("couldn't hash thumbnail", "path", assetDiskPath, "error", err)
+		return nil
+	}
+	return &hash
+}
+
+// backfillBlurhashes hashes the thumbnails stored before BlurHashes were,
+// once at startup. Thumbnails that aren't local assets, like the sandbox's
+// samples, are skipped.
+func (cfg *apiConfig) backfillBlurhashes(ctx context.Context) {
+	videos, err := cfg.db.GetVideosMissingBlurhash()
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't list thumbnails to hash", "error", err)
+		return
+	}
+	hashed := 0
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			return
+		}
+		assetPath, ok := cfg.assetPathFromURL(*video.ThumbnailURL)
+		if !ok {
+			continue
+		}
+		hash := thumbnailBlurhash(ctx, cfg.getAssetDiskPath(assetPath))
+		if hash == nil {
+			continue
+		}
+		if err := cfg.db.SetThumbnailBlurhash(video.ID, *video.ThumbnailURL, *hash); err != nil {
+			loggerFrom(ctx).Error("couldn't save thumbnail blurhash", "video_id", video.ID, "error", err)
+			continue
+		}
+		hashed++
+	}
+	if hashed > 0 {
+		loggerFrom(ctx).Info("hashed existing thumbnails", "count", hashed)
+	}
+}
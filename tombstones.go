@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	tombstonePollInterval = 30 * time.Second
+	tombstoneBatchSize    = 50
+	// failed steps are retried after 30s, doubling up to an hour
+	tombstoneBaseBackoff = 30 * time.Second
+	tombstoneMaxBackoff  = time.Hour
+)
+
+// tombstoneStep is one part of cleaning up after a deleted video. Steps
+// are retried until they succeed, so they must be safe to run again.
+type tombstoneStep struct {
+	name string
+	run  func(ctx context.Context, t database.Tombstone) error
+}
+
+// tombstoneSteps are run in order for every deleted video. The video's
+// search index entry goes with its row, so it needs no step of its own.
+func (cfg *apiConfig) tombstoneSteps() []tombstoneStep {
+	return []tombstoneStep{
+		{"objects", cfg.releaseTombstoneObjects},
+		{"assets", cfg.removeTombstoneAssets},
+		{"cdn", cfg.invalidateTombstonePaths},
+	}
+}
+
+// deleteVideo deletes a video in two phases: the database forgets it
+// right away, leaving a tombstone listing what it stored, and the
+// tombstone worker then removes the files. A failed S3 delete is retried
+// rather than leaving an orphaned object behind.
+func (cfg *apiConfig) deleteVideo(video database.Video) error {
+	bucket := cfg.videoBucket(video)
+	keys := []string{}
+	if video.VideoURL != nil {
+		if key, ok := cfg.keyInBucket(bucket, *video.VideoURL); ok {
+			keys = append(keys, key)
+		}
+	}
+	renditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		return err
+	}
+	for _, rendition := range renditions {
+		if key, ok := cfg.keyInBucket(bucket, rendition.VideoURL); ok && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		for _, key := range cfg.versionObjectKeys(bucket, version) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	captions, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		return err
+	}
+	for _, caption := range captions {
+		if key, ok := cfg.keyInBucket(bucket, caption.URL); ok && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	assets := []string{}
+	if video.ThumbnailURL != nil {
+		if assetPath, ok := cfg.assetPathFromURL(*video.ThumbnailURL); ok {
+			assets = append(assets, assetPath)
+		}
+	}
+
+	if err := cfg.db.CreateTombstone(video, keys, assets); err != nil {
+		return err
+	}
+	cfg.wakeTombstoneWorker()
+	cfg.publishEvent(context.Background(), newVideoEvent(eventVideoDeleted, video))
+	return nil
+}
+
+// releaseTombstoneObjects deletes the video's S3 objects, from the bucket
+// it was stored in, that no other video shares.
+func (cfg *apiConfig) releaseTombstoneObjects(ctx context.Context, t database.Tombstone) error {
+	for _, key := range t.ObjectKeys {
+		if err := cfg.releaseObject(ctx, cfg.buckets.orDefault(t.StorageBucket), key); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) removeTombstoneAssets(ctx context.Context, t database.Tombstone) error {
+	for _, assetPath := range t.AssetPaths {
+		err := os.Remove(cfg.getAssetDiskPath(assetPath))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateTombstonePaths drops the CDN's copies of the video's objects
+// and assets, so it stops being served once it's deleted.
+func (cfg *apiConfig) invalidateTombstonePaths(ctx context.Context, t database.Tombstone) error {
+	paths := []string{}
+	for _, key := range t.ObjectKeys {
+		paths = append(paths, objectCDNPath(key))
+	}
+	for _, assetPath := range t.AssetPaths {
+		paths = append(paths, assetCDNPath(assetPath))
+	}
+	return cfg.invalidateCDN(ctx, paths)
+}
+
+// wakeTombstoneWorker has the worker check for work now rather than at its
+// next poll.
+func (cfg *apiConfig) wakeTombstoneWorker() {
+	select {
+	case cfg.tombstoneWake <- struct{}{}:
+	default:
+	}
+}
+
+// runTombstoneWorker cleans up after deleted videos until ctx is done.
+func (cfg *apiConfig) runTombstoneWorker(ctx context.Context) {
+	ticker := time.NewTicker(tombstonePollInterval)
+	defer ticker.Stop()
+	for {
+		cfg.processTombstones(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.tombstoneWake:
+		}
+	}
+}
+
+func (cfg *apiConfig) processTombstones(ctx context.Context) {
+	logger := loggerFrom(ctx)
+	for ctx.Err() == nil {
+		tombstones, err := cfg.db.GetDueTombstones(tombstoneBatchSize)
+		if err != nil {
+			logger.Error("couldn't look up deleted videos to clean up", "error", err)
+			return
+		}
+		if len(tombstones) == 0 {
+			return
+		}
+		for _, t := range tombstones {
+			if err := cfg.processTombstone(ctx, t); err != nil {
+				logger.Error("couldn't record cleanup progress", "video_id", t.VideoID, "error", err)
+				return
+			}
+		}
+	}
+}
+
+// processTombstone runs t's remaining steps in order, stopping at the
+// first failure and scheduling a retry of it. Only failures to record
+// progress are returned.
+func (cfg *apiConfig) processTombstone(ctx context.Context, t database.Tombstone) error {
+	logger := loggerFrom(ctx).With("video_id", t.VideoID)
+	steps := cfg.tombstoneSteps()
+	for t.Step < len(steps) {
+		step := steps[t.Step]
+		if err := step.run(ctx, t); err != nil {
+			t.Attempts++
+			delay := min(tombstoneMaxBackoff, tombstoneBaseBackoff<<min(t.Attempts-1, 16))
+			logger.Warn("video cleanup step failed, will retry",
+				"step", step.name,
+				"attempt", t.Attempts,
+				"retry_in", delay,
+				"error", err,
+			)
+			return cfg.db.RetryTombstone(t.VideoID, t.Attempts, time.Now().Add(delay), step.name+": "+err.Error())
+		}
+		t.Step++
+		if err := cfg.db.AdvanceTombstone(t.VideoID, t.Step); err != nil {
+			return err
+		}
+	}
+	logger.Info("cleaned up deleted video")
+	return cfg.db.DeleteTombstone(t.VideoID)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/transcribe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+)
+
+const (
+	transcriptionWhisper = "whisper"
+	transcriptionAWS     = "aws"
+)
+
+// transcriptionSettings choose how videos are transcribed, if at all.
+type transcriptionSettings struct {
+	// Provider is whisper, aws or "" for no transcription
+	Provider     string
+	WhisperPath  string
+	WhisperModel string
+	// Language is what videos are assumed to be spoken in, "" to detect it
+	Language string
+}
+
+// transcriptionSource is a video's file as a transcriber reads it.
+type transcriptionSource struct {
+	// URL is presigned, for tools that read the file over HTTP
+	URL      string
+	Bucket   string
+	Key      string
+	Duration *float64
+	// Language is a language tag, "" to detect it
+	Language string
+}
+
+// transcription is what a transcriber heard in a video.
+type transcription struct {
+	// Language is a language tag, "" if the transcriber didn't say
+	Language string
+	Text     string
+	// VTT is the text as WebVTT captions
+	VTT []byte
+}
+
+// transcriber turns speech in a video into text. Transcription can take
+// about as long as the video, so it runs in transcribe jobs.
+type transcriber interface {
+	// provider names the transcriber in stored transcripts
+	provider() string
+	// check makes sure the transcriber can be used, at startup
+	check(ctx context.Context) error
+	transcribe(ctx context.Context, src transcriptionSource) (transcription, error)
+}
+
+// newTranscriber returns the transcriber settings choose, nil if
+// transcription is off. Sandbox mode fakes every provider.
+func (cfg *apiConfig) newTranscriber(settings transcriptionSettings, awsCfg aws.Config) transcriber {
+	switch {
+	case settings.Provider == "":
+		return nil
+	case cfg.sandbox:
+		return sandboxTranscriber{}
+	case settings.Provider == transcriptionAWS:
+		client := transcribe.NewFromConfig(awsCfg, func(o *transcribe.Options) {
+			o.Region = cfg.s3Region
+			o.APIOptions = append(o.APIOptions, traceAWSCalls)
+		})
+		return awsTranscriber{
+			region:        cfg.s3Region,
+			client:        client,
+			s3Client:      cfg.s3Client,
+			expectedOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+			buckets:       cfg.buckets,
+		}
+	default:
+		return whisperTranscriber{
+			path:      settings.WhisperPath,
+			model:     settings.WhisperModel,
+			media:     cfg.media,
+			tempStore: cfg.tempStore,
+		}
+	}
+}
+
+// whisperAudioArgs encode audio the way Whisper resamples it anyway: 16 kHz
+// mono PCM, which keeps the file it reads small.
+var whisperAudioArgs = []string{"-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le"}
+
+// whisperTranscriber runs OpenAI's Whisper command line tool on the
+// server, on audio ffmpeg extracts from the video.
+type whisperTranscriber struct {
+	path      string
+	model     string
+	media     mediaTools
+	tempStore *tempstore.Store
+}
+
+func (t whisperTranscriber) provider() string {
+	return transcriptionWhisper
+}
+
+func (t whisperTranscriber) check(ctx context.Context) error {
+	if _, err := exec.LookPath(t.path); err != nil {
+		return fmt.Errorf("whisper not found at %s: install openai-whisper, set WHISPER_PATH to where it is, or unset TRANSCRIPTION", t.path)
+	}
+	return nil
+}
+
+func (t whisperTranscriber) transcribe(ctx context.Context, src transcriptionSource) (transcription, error) {
+	var estimate int64
+	if src.Duration != nil {
+		// 16 bit samples at 16 kHz
+		estimate = int64(*src.Duration * 32_000)
+	}
+	audio, err := t.tempStore.Create(estimate, "transcribe-*.wav")
+	if err != nil {
+		return transcription{}, fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer audio.Release()
+	if err := t.media.extractAudio(ctx, src.URL, whisperAudioArgs, audio.Name()); err != nil {
+		return transcription{}, fmt.Errorf("couldn't extract audio: %w", err)
+	}
+
+	// whisper names its output after the input, next to the temp file it's
+	// cleaned up with if the server dies first
+	base := strings.TrimSuffix(audio.Name(), ".wav")
+	defer func() {
+		for _, ext := range []string{".vtt", ".srt", ".txt", ".tsv", ".json"} {
+			os.Remove(base + ext)
+		}
+	}()
+	args := []string{audio.Name(), "--model", t.model, "--output_format", "all", "--output_dir", filepath.Dir(audio.Name()), "--verbose", "False"}
+	if src.Language != "" {
+		// whisper knows languages, not regional variants
+		language, _, _ := strings.Cut(src.Language, "-")
+		args = append(args, "--language", language)
+	}
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	killProcessGroup(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if err := toolError(t.path, err); errors.Is(err, errMediaToolsUnavailable) {
+			return transcription{}, err
+		}
+		if ctx.Err() != nil {
+			return transcription{}, ctx.Err()
+		}
+		return transcription{}, &toolFailure{"whisper", err, string(output)}
+	}
+
+	vtt, err := os.ReadFile(base + ".vtt")
+	if err != nil {
+		return transcription{}, fmt.Errorf("couldn't read whisper's captions: %w", err)
+	}
+	data, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return transcription{}, fmt.Errorf("couldn't read whisper's transcript: %w", err)
+	}
+	var result struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return transcription{}, fmt.Errorf("couldn't parse whisper's transcript: %w", err)
+	}
+	return transcription{
+		Language: result.Language,
+		Text:     strings.TrimSpace(result.Text),
+		VTT:      vtt,
+	}, nil
+}
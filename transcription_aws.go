@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/transcribe"
+	"github.com/aws/aws-sdk-go-v2/service/transcribe/types"
+)
+
+const (
+	// awsTranscribePollInterval is how often a running job is checked on
+	awsTranscribePollInterval = 15 * time.Second
+	// awsTranscribeOutputPrefix is where Transcribe writes its results in
+	// the bucket, until they're read and deleted
+	awsTranscribeOutputPrefix = "transcribe/"
+)
+
+// awsTranscriber runs Amazon Transcribe jobs on videos where they are in
+// the bucket, and reads the results back from it. The server's AWS
+// credentials need the transcribe:StartTranscriptionJob,
+// GetTranscriptionJob and DeleteTranscriptionJob permissions on top of S3
+// access to the bucket.
+type awsTranscriber struct {
+	region        string
+	client        *transcribe.Client
+	s3Client      *s3.Client
+	expectedOwner *string
+	// buckets gives the region and client of the bucket a video is in
+	buckets *bucketResolver
+}
+
+func (t awsTranscriber) provider() string {
+	return transcriptionAWS
+}
+
+// check makes sure the credentials can use Transcribe in the region.
+func (t awsTranscriber) check(ctx context.Context) error {
+	_, err := t.client.ListTranscriptionJobs(ctx, &transcribe.ListTranscriptionJobsInput{MaxResults: aws.Int32(1)}, t.inRegion)
+	if err != nil {
+		return fmt.Errorf("can't use Amazon Transcribe in %s: %w", t.region, err)
+	}
+	return nil
+}
+
+func (t awsTranscriber) transcribe(ctx context.Context, src transcriptionSource) (transcription, error) {
+	// Transcribe only reads media from, and writes to, buckets in the
+	// region the job runs in
+	t.region, t.s3Client = t.buckets.region(src.Bucket), t.buckets.client(src.Bucket)
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return transcription{}, err
+	}
+	name := fmt.Sprintf("tubely-%x", randomBytes)
+
+	start := &transcribe.StartTranscriptionJobInput{
+		TranscriptionJobName: aws.String(name),
+		Media:                &types.Media{MediaFileUri: aws.String("s3://" + src.Bucket + "/" + src.Key)},
+		OutputBucketName:     aws.String(src.Bucket),
+		OutputKey:            aws.String(awsTranscribeOutputPrefix + name + "/"),
+		Subtitles:            &types.Subtitles{Formats: []types.SubtitleFormat{types.SubtitleFormatVtt}},
+	}
+	if language, region, ok := strings.Cut(src.Language, "-"); ok {
+		// Transcribe wants codes such as en-US
+		start.LanguageCode = types.LanguageCode(language + "-" + strings.ToUpper(region))
+	} else {
+		// a language alone isn't specific enough for it, so it's detected
+		start.IdentifyLanguage = aws.Bool(true)
+	}
+	if _, err := t.client.StartTranscriptionJob(ctx, start, t.inRegion); err != nil {
+		return transcription{}, fmt.Errorf("couldn't start transcription job: %w", err)
+	}
+	defer func() {
+		// finished or not, the job is of no more use
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		_, err := t.client.DeleteTranscriptionJob(cleanupCtx, &transcribe.DeleteTranscriptionJobInput{TranscriptionJobName: aws.String(name)}, t.inRegion)
+		if err != nil {
+			loggerFrom(ctx).Warn("couldn't delete transcription job", "job", name, "error", err)
+		}
+	}()
+
+	job, err := t.wait(ctx, name)
+	if err != nil {
+		return transcription{}, err
+	}
+
+	var keys []string
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		for _, key := range keys {
+			_, err := t.s3Client.DeleteObject(cleanupCtx, &s3.DeleteObjectInput{
+				Bucket:              aws.String(src.Bucket),
+				Key:                 aws.String(key),
+				ExpectedBucketOwner: t.expectedOwner,
+			})
+			if err != nil {
+				loggerFrom(ctx).Warn("couldn't delete transcription output", "key", key, "error", err)
+			}
+		}
+	}()
+	if job.Transcript == nil {
+		return transcription{}, errors.New("transcription job made no transcript")
+	}
+	transcriptKey, err := awsOutputKey(aws.ToString(job.Transcript.TranscriptFileUri), src.Bucket)
+	if err != nil {
+		return transcription{}, err
+	}
+	keys = append(keys, transcriptKey)
+	if job.Subtitles == nil || len(job.Subtitles.SubtitleFileUris) == 0 {
+		return transcription{}, errors.New("transcription job made no captions")
+	}
+	captionsKey, err := awsOutputKey(job.Subtitles.SubtitleFileUris[0], src.Bucket)
+	if err != nil {
+		return transcription{}, err
+	}
+	keys = append(keys, captionsKey)
+
+	data, err := t.readObject(ctx, src.Bucket, transcriptKey)
+	if err != nil {
+		return transcription{}, fmt.Errorf("couldn't read transcript: %w", err)
+	}
+	var result struct {
+		Results struct {
+			Transcripts []struct {
+				Transcript string `json:"transcript"`
+			} `json:"transcripts"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return transcription{}, fmt.Errorf("couldn't parse transcript: %w", err)
+	}
+	texts := make([]string, 0, len(result.Results.Transcripts))
+	for _, transcript := range result.Results.Transcripts {
+		texts = append(texts, transcript.Transcript)
+	}
+	vtt, err := t.readObject(ctx, src.Bucket, captionsKey)
+	if err != nil {
+		return transcription{}, fmt.Errorf("couldn't read captions: %w", err)
+	}
+
+	return transcription{
+		Language: string(job.LanguageCode),
+		Text:     strings.TrimSpace(strings.Join(texts, "\n")),
+		VTT:      vtt,
+	}, nil
+}
+
+// wait polls the job until it finishes, returning an error if it failed.
+func (t awsTranscriber) wait(ctx context.Context, name string) (*types.TranscriptionJob, error) {
+	ticker := time.NewTicker(awsTranscribePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		out, err := t.client.GetTranscriptionJob(ctx, &transcribe.GetTranscriptionJobInput{TranscriptionJobName: aws.String(name)}, t.inRegion)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check on transcription job: %w", err)
+		}
+		job := out.TranscriptionJob
+		if job == nil {
+			continue
+		}
+		switch job.TranscriptionJobStatus {
+		case types.TranscriptionJobStatusCompleted:
+			return job, nil
+		case types.TranscriptionJobStatusFailed:
+			return nil, fmt.Errorf("transcription job failed: %s", aws.ToString(job.FailureReason))
+		}
+	}
+}
+
+func (t awsTranscriber) readObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	var data []byte
+	err := withS3Retry(ctx, s3DefaultRetry, "GetObject "+key, func(ctx context.Context) error {
+		out, err := t.s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:              aws.String(bucket),
+			Key:                 aws.String(key),
+			ExpectedBucketOwner: t.expectedOwner,
+		})
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+		data, err = io.ReadAll(out.Body)
+		return err
+	})
+	return data, err
+}
+
+// awsOutputKey returns the key of a result Transcribe reports by its S3
+// URL, such as https://s3.us-east-1.amazonaws.com/bucket/transcribe/….
+func awsOutputKey(rawURL, bucket string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid transcription output URL %q: %w", rawURL, err)
+	}
+	key, ok := strings.CutPrefix(u.Path, "/"+bucket+"/")
+	if !ok || !strings.HasPrefix(key, awsTranscribeOutputPrefix) {
+		return "", fmt.Errorf("transcription output %q isn't where it was asked to be", rawURL)
+	}
+	return key, nil
+}
+
+// inRegion sends a Transcribe call to the region of the bucket the
+// transcriber works in.
+func (t awsTranscriber) inRegion(o *transcribe.Options) {
+	o.Region = t.region
+}
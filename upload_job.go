@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tempstore"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindIngestUpload = "ingest_upload"
+	// uploadIngestTimeout bounds downloading a staged upload and
+	// processing it
+	uploadIngestTimeout = 2 * time.Hour
+	// stagedUploadPrefix is where uploads wait in S3 for a worker to
+	// process them. It's outside the prefixes the orphan collector sweeps.
+	stagedUploadPrefix = "uploads/"
+	// stagedUploadRetention is how long a staged upload no job will run
+	// again is kept, for its dead letter to be requeued
+	stagedUploadRetention     = 7 * 24 * time.Hour
+	stagedUploadSweepInterval = time.Hour
+)
+
+// uploadIngestParams are what an ingest_upload job runs with: the staged
+// file, what the upload said about it, and the options resolved against
+// the owner's upload policy when it was received.
+type uploadIngestParams struct {
+	Bucket            string `json:"bucket"`
+	Key               string `json:"key"`
+	MediaType         string `json:"media_type"`
+	SHA256            string `json:"sha256"`
+	Size              int64  `json:"size"`
+	Filename          string `json:"filename,omitempty"`
+	Profile           string `json:"profile,omitempty"`
+	NormalizeLoudness bool   `json:"normalize_loudness,omitempty"`
+}
+
+// queueUpload hands a received upload to the workers, with JOB_QUEUE_URL
+// set, instead of processing it on the server that received it. The
+// response points at the job that processes it.
+func (cfg *apiConfig) queueUpload(w http.ResponseWriter, r *http.Request, video database.Video, file io.ReadSeeker, src ingestSource, opts uploadOptions) {
+	job, err := cfg.stageUpload(r.Context(), video, file, src, opts)
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithIngestError(w, ingestErr)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		UserID:    video.UserID,
+		Action:    database.AuditUploadQueued,
+		VideoID:   video.ID,
+		ObjectKey: cfg.jobInputKey(job),
+		Detail:    src.Filename,
+	})
+	cfg.startJob(r.Context(), job)
+
+	w.Header().Set("Location", apiPath(r, "/jobs/"+job.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// stageUpload stores file, the upload src describes, in video's bucket,
+// where any worker can fetch it, and creates the job that processes it,
+// for the caller to start. Errors are *ingestError.
+func (cfg *apiConfig) stageUpload(ctx context.Context, video database.Video, file io.ReadSeeker, src ingestSource, opts uploadOptions) (database.Job, error) {
+	// turn down what the worker would, before anything is staged
+	if err := checkReplaceable(video, mediaKindOf(src.MediaType)); err != nil {
+		return database.Job{}, err
+	}
+
+	bucket := cfg.videoBucket(video)
+	key := stagedUploadPrefix + video.ID.String() + "/" + uuid.NewString()
+	_, stageSpan := tracer.Start(ctx, "stage upload")
+	err := cfg.putObject(ctx, bucket, key, file, src.MediaType, src.SHA256, newObjectTags(video, ""))
+	endSpan(stageSpan, err)
+	if err != nil {
+		return database.Job{}, &ingestError{http.StatusBadGateway, "Couldn't stage the upload for processing", err}
+	}
+
+	jobParams, err := json.Marshal(uploadIngestParams{
+		Bucket:            bucket,
+		Key:               key,
+		MediaType:         src.MediaType,
+		SHA256:            src.SHA256,
+		Size:              src.Size,
+		Filename:          src.Filename,
+		Profile:           opts.Profile,
+		NormalizeLoudness: opts.NormalizeLoudness,
+	})
+	if err == nil {
+		var job database.Job
+		job, err = cfg.db.CreateJob(video.UserID, video.ID, jobKindIngestUpload, string(jobParams))
+		if err == nil {
+			return job, nil
+		}
+	}
+	if err := cfg.removeObject(context.WithoutCancel(ctx), bucket, key); err != nil {
+		loggerFrom(ctx).Warn("couldn't remove staged upload", "key", key, "error", err)
+	}
+	return database.Job{}, &ingestError{http.StatusInternalServerError, "Couldn't create job", err}
+}
+
+// ingestFileOrQueue ingests file, the upload src describes, as video's
+// file, or with JOB_QUEUE_URL set stages it and starts the job that
+// ingests it on a worker, returning the job's ID. It's for uploads of
+// several files, which report each one in their response. Errors are
+// *ingestError.
+func (cfg *apiConfig) ingestFileOrQueue(ctx context.Context, video database.Video, file *tempstore.File, src ingestSource, opts uploadOptions, profile processingProfile) (*uuid.UUID, error) {
+	if cfg.jobQueue == nil {
+		_, err := cfg.ingestVideo(ctx, video, src, profile)
+		return nil, err
+	}
+	job, err := cfg.stageUpload(ctx, video, file, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.startJob(ctx, job)
+	return &job.ID, nil
+}
+
+func (cfg *apiConfig) runIngestUploadJob(ctx context.Context, job database.Job) error {
+	var params uploadIngestParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return fmt.Errorf("invalid job parameters: %w", err)
+	}
+	return cfg.ingestStagedUpload(ctx, job.VideoID, params)
+}
+
+// ingestStagedUpload downloads an upload staged by stageUpload, checks it
+// is the file that was received, and ingests it as the video's file. The
+// staged copy is removed once it's ingested; a job that fails for good
+// keeps it, for the dead letter to be requeued, until its dead letter is
+// discarded or the sweeper finds it past stagedUploadRetention.
+func (cfg *apiConfig) ingestStagedUpload(ctx context.Context, videoID uuid.UUID, params uploadIngestParams) error {
+	logger := loggerFrom(ctx)
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		if err := cfg.removeObject(ctx, params.Bucket, params.Key); err != nil {
+			logger.Warn("couldn't remove staged upload", "key", params.Key, "error", err)
+		}
+		return errors.New("video was deleted")
+	}
+
+	tempFile, err := cfg.tempStore.Create(params.Size, "upload-*.mp4")
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return errors.New("not enough temporary storage for the file, try again later")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't create temp file: %w", err)
+	}
+	defer tempFile.Release()
+
+	var out *s3.GetObjectOutput
+	err = withS3Retry(ctx, s3DefaultRetry, "GetObject "+params.Key, func(ctx context.Context) error {
+		var err error
+		out, err = cfg.buckets.client(params.Bucket).GetObject(ctx, &s3.GetObjectInput{
+			Bucket:              aws.String(params.Bucket),
+			Key:                 aws.String(params.Key),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't fetch staged upload: %w", err)
+	}
+	defer out.Body.Close()
+	hash := sha256.New()
+	started := time.Now()
+	written, err := io.Copy(io.MultiWriter(tempFile, hash), io.LimitReader(out.Body, params.Size+1))
+	if errors.Is(err, tempstore.ErrNoCapacity) {
+		return errors.New("not enough temporary storage for the file, try again later")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't download staged upload: %w", err)
+	}
+	if written != params.Size || hex.EncodeToString(hash.Sum(nil)) != params.SHA256 {
+		return fmt.Errorf("staged upload %s doesn't match the file received", params.Key)
+	}
+	logger.Info("staged upload downloaded", "video_id", videoID, "bytes", written, "duration", time.Since(started))
+
+	profile, err := getProcessingProfile(params.Profile)
+	if err != nil {
+		return err
+	}
+	profile.NormalizeLoudness = params.NormalizeLoudness
+	_, err = cfg.ingestVideo(ctx, video, ingestSource{
+		Path:      tempFile.Name(),
+		MediaType: params.MediaType,
+		SHA256:    params.SHA256,
+		Size:      written,
+		Filename:  params.Filename,
+	}, profile)
+	if err != nil {
+		return err
+	}
+	if err := cfg.removeObject(ctx, params.Bucket, params.Key); err != nil {
+		logger.Warn("couldn't remove staged upload", "key", params.Key, "error", err)
+	}
+	return nil
+}
+
+// removeStagedUpload deletes the staged copy of the upload an
+// ingest_upload job was to ingest. Failures are logged, the sweeper gets
+// to it later.
+func (cfg *apiConfig) removeStagedUpload(ctx context.Context, job database.Job) {
+	var params uploadIngestParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil || params.Key == "" {
+		return
+	}
+	if err := cfg.removeObject(ctx, params.Bucket, params.Key); err != nil {
+		loggerFrom(ctx).Warn("couldn't remove staged upload", "key", params.Key, "error", err)
+	}
+}
+
+// runStagedUploadSweeper deletes staged uploads left behind by jobs that
+// won't run again every stagedUploadSweepInterval until ctx is done.
+func (cfg *apiConfig) runStagedUploadSweeper(ctx context.Context) {
+	ticker := time.NewTicker(stagedUploadSweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.sweepStagedUploads(ctx); err != nil && ctx.Err() == nil {
+			loggerFrom(ctx).Error("staged upload sweep failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepStagedUploads deletes the staged uploads in every bucket older than
+// stagedUploadRetention, unless a job that's pending, running or due a
+// retry still needs them. Those of dead letters nobody requeued in that
+// time go too.
+func (cfg *apiConfig) sweepStagedUploads(ctx context.Context) error {
+	jobs, err := cfg.db.GetJobsByStatus(database.JobPending, database.JobRunning, database.JobFailed)
+	if err != nil {
+		return err
+	}
+	needed := map[string]bool{}
+	for _, job := range jobs {
+		if job.Kind != jobKindIngestUpload || (job.Status == database.JobFailed && jobRetryAt(job).IsZero()) {
+			continue
+		}
+		var params uploadIngestParams
+		if err := json.Unmarshal([]byte(job.Params), &params); err == nil {
+			needed[params.Bucket+"/"+params.Key] = true
+		}
+	}
+	cutoff := time.Now().Add(-stagedUploadRetention)
+
+	logger := loggerFrom(ctx)
+	for _, bucket := range cfg.buckets.names() {
+		pages := s3.NewListObjectsV2Paginator(cfg.buckets.client(bucket), &s3.ListObjectsV2Input{
+			Bucket:              aws.String(bucket),
+			Prefix:              aws.String(stagedUploadPrefix),
+			ExpectedBucketOwner: cfg.s3ObjectSettings.expectedBucketOwner(),
+		})
+		for pages.HasMorePages() {
+			var page *s3.ListObjectsV2Output
+			err := withS3Retry(ctx, s3DefaultRetry, "ListObjectsV2 "+stagedUploadPrefix, func(ctx context.Context) error {
+				var err error
+				page, err = pages.NextPage(ctx)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				modified := aws.ToTime(obj.LastModified)
+				if needed[bucket+"/"+key] || modified.After(cutoff) {
+					continue
+				}
+				if err := cfg.removeObject(ctx, bucket, key); err != nil {
+					return err
+				}
+				logger.Info("swept stale staged upload", "bucket", bucket, "key", key, "last_modified", modified)
+			}
+		}
+	}
+	return nil
+}
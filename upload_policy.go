@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// uploadOptions are the settings a new upload is created with: what the
+// request asked for, filled in from the uploader's organization policy.
+type uploadOptions struct {
+	Visibility    string
+	Profile       string
+	Tags          []string
+	RetentionDays int
+	// NormalizeLoudness asks for the upload's audio to be normalized, see
+	// processingProfile
+	NormalizeLoudness bool
+}
+
+// uploadPolicyFor returns the upload policy of the user's organization,
+// or an empty one if the user isn't in an organization.
+func (cfg *apiConfig) uploadPolicyFor(userID uuid.UUID) (database.UploadPolicy, error) {
+	member, err := cfg.db.GetMembership(userID)
+	if err != nil {
+		return database.UploadPolicy{}, err
+	}
+	if member == nil {
+		return database.UploadPolicy{}, nil
+	}
+	return cfg.db.GetUploadPolicy(member.OrganizationID)
+}
+
+// mergeUploadOptions fills the options the request left empty from policy
+// and validates the result. Policy tags are added to the requested ones
+// rather than replaced by them. Errors are safe to show to the client.
+func mergeUploadOptions(requested uploadOptions, policy database.UploadPolicy) (uploadOptions, error) {
+	opts := requested
+	if opts.Visibility == "" {
+		opts.Visibility = policy.Visibility
+	}
+	if opts.Visibility == "" {
+		opts.Visibility = visibilityPublic
+	}
+	if !validVisibility(opts.Visibility) {
+		return uploadOptions{}, errors.New("visibility must be public, unlisted or private")
+	}
+
+	if opts.Profile == "" {
+		opts.Profile = policy.Profile
+	}
+	if _, err := getProcessingProfile(opts.Profile); err != nil {
+		return uploadOptions{}, err
+	}
+
+	opts.Tags = nil
+	seen := map[string]bool{}
+	for _, tag := range append(append([]string{}, policy.Tags...), requested.Tags...) {
+		normalized, err := normalizeTag(tag)
+		if err != nil {
+			return uploadOptions{}, err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			opts.Tags = append(opts.Tags, normalized)
+		}
+	}
+
+	if opts.RetentionDays == 0 {
+		opts.RetentionDays = policy.RetentionDays
+	}
+	return opts, nil
+}
+
+// resolveUploadOptions merges requested with the user's organization policy.
+// A validation problem comes back as an *ingestError with a 400 status.
+func (cfg *apiConfig) resolveUploadOptions(userID uuid.UUID, requested uploadOptions) (uploadOptions, error) {
+	policy, err := cfg.uploadPolicyFor(userID)
+	if err != nil {
+		return uploadOptions{}, &ingestError{http.StatusInternalServerError, "Couldn't load upload policy", err}
+	}
+	opts, err := mergeUploadOptions(requested, policy)
+	if err != nil {
+		return uploadOptions{}, &ingestError{http.StatusBadRequest, err.Error(), err}
+	}
+	return opts, nil
+}
+
+// applyUploadOptions applies the parts of opts that live outside the video
+// row to a freshly created video.
+func (cfg *apiConfig) applyUploadOptions(video database.Video, opts uploadOptions) (database.Video, error) {
+	if len(opts.Tags) > 0 {
+		if err := cfg.db.AddVideoTags(video.ID, opts.Tags); err != nil {
+			return database.Video{}, err
+		}
+	}
+	if opts.RetentionDays > 0 {
+		expiresAt := video.CreatedAt.Add(time.Duration(opts.RetentionDays) * 24 * time.Hour)
+		if err := cfg.db.SetVideoExpiry(video.ID, expiresAt); err != nil {
+			return database.Video{}, err
+		}
+		video.ExpiresAt = &expiresAt
+	}
+	return video, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// defaultVideoVersionLimit is how many versions of a video are kept,
+// counting the current one, unless VIDEO_VERSION_LIMIT says otherwise.
+const defaultVideoVersionLimit = 5
+
+// versionObjectKey is where version n of a video's file is stored. suffix
+// tells a version's extra renditions apart, e.g. "_slowmo".
+func versionObjectKey(videoID uuid.UUID, version int, suffix string) string {
+	return fmt.Sprintf("videos/%s/v%d%s.mp4", videoID, version, suffix)
+}
+
+// nextVideoVersion reserves the version number of a new upload of video.
+// A file uploaded before versions were kept becomes a version first, so
+// replacing it doesn't lose it.
+func (cfg *apiConfig) nextVideoVersion(video database.Video) (int, error) {
+	if video.VideoURL != nil && video.Version == 0 {
+		renditions, err := cfg.db.GetRenditions(video.ID)
+		if err != nil {
+			return 0, err
+		}
+		version := database.VideoVersion{
+			VideoID:          video.ID,
+			Projection:       video.Projection,
+			Width:            video.Width,
+			Height:           video.Height,
+			Duration:         video.Duration,
+			Bitrate:          video.Bitrate,
+			LoudnessTarget:   video.LoudnessTarget,
+			OriginalFilename: video.OriginalFilename,
+		}
+		for _, rendition := range renditions {
+			version.Renditions = append(version.Renditions, rendition.CreateRenditionParams)
+		}
+		if len(version.Renditions) == 0 {
+			version.Renditions = []database.CreateRenditionParams{{
+				VideoID:  video.ID,
+				Kind:     "primary",
+				VideoURL: *video.VideoURL,
+			}}
+		}
+		version.Version, err = cfg.db.ReserveVideoVersion(video.ID)
+		if err != nil {
+			return 0, err
+		}
+		if err := cfg.db.SaveVideoVersion(version); err != nil {
+			return 0, err
+		}
+		if err := cfg.db.SetCurrentVersion(video.ID, version.Version); err != nil {
+			return 0, err
+		}
+	}
+	return cfg.db.ReserveVideoVersion(video.ID)
+}
+
+// saveCurrentVersion records the renditions video was just pointed at as
+// the given version, and makes it the current one.
+func (cfg *apiConfig) saveCurrentVersion(video database.Video, version int, renditions []database.CreateRenditionParams) (database.Video, error) {
+	err := cfg.db.SaveVideoVersion(videoVersion(video, version, renditions))
+	if err != nil {
+		return database.Video{}, err
+	}
+	if err := cfg.db.SetCurrentVersion(video.ID, version); err != nil {
+		return database.Video{}, err
+	}
+	video.Version = version
+	return video, nil
+}
+
+// videoVersion is video's file, with renditions, as the given version.
+func videoVersion(video database.Video, version int, renditions []database.CreateRenditionParams) database.VideoVersion {
+	return database.VideoVersion{
+		VideoID:          video.ID,
+		Version:          version,
+		Projection:       video.Projection,
+		Width:            video.Width,
+		Height:           video.Height,
+		Duration:         video.Duration,
+		Bitrate:          video.Bitrate,
+		LoudnessTarget:   video.LoudnessTarget,
+		OriginalFilename: video.OriginalFilename,
+		Renditions:       renditions,
+	}
+}
+
+// pruneVideoVersions forgets the oldest versions of a video beyond the
+// limit, never the current one, and releases their objects.
+func (cfg *apiConfig) pruneVideoVersions(ctx context.Context, video database.Video, current int) {
+	logger := loggerFrom(ctx)
+	videoID, bucket := video.ID, cfg.videoBucket(video)
+	versions, err := cfg.db.GetVideoVersions(videoID)
+	if err != nil {
+		logger.Error("couldn't list video versions", "video_id", videoID, "error", err)
+		return
+	}
+
+	kept := 1 // the current version
+	for _, version := range versions {
+		if version.Version == current {
+			continue
+		}
+		if kept < cfg.videoVersionLimit {
+			kept++
+			continue
+		}
+		if err := cfg.db.DeleteVideoVersion(videoID, version.Version); err != nil {
+			logger.Error("couldn't delete video version", "video_id", videoID, "version", version.Version, "error", err)
+			continue
+		}
+		cfg.releaseObjects(ctx, bucket, cfg.versionObjectKeys(bucket, version))
+		logger.Info("pruned video version", "version", version.Version)
+	}
+}
+
+// versionObjectKeys are the objects in bucket, the video's, behind a
+// version.
+func (cfg *apiConfig) versionObjectKeys(bucket string, version database.VideoVersion) []string {
+	keys := []string{}
+	for _, rendition := range version.Renditions {
+		if key, ok := cfg.keyInBucket(bucket, rendition.VideoURL); ok && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// restoreVideoVersion makes a kept version the video's file again. The
+// file it replaces stays as a version of its own.
+func (cfg *apiConfig) restoreVideoVersion(video database.Video, version database.VideoVersion) (database.Video, error) {
+	videoURL := version.Renditions[0].VideoURL
+	video.VideoURL = &videoURL
+	video.Projection = version.Projection
+	video.SetSize(version.Width, version.Height)
+	video.Duration = version.Duration
+	video.Bitrate = version.Bitrate
+	video.LoudnessTarget = version.LoudnessTarget
+	video.OriginalFilename = version.OriginalFilename
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return database.Video{}, err
+	}
+	if err := cfg.db.DeleteRenditions(video.ID); err != nil {
+		return database.Video{}, err
+	}
+	for _, rendition := range version.Renditions {
+		if _, err := cfg.db.CreateRendition(rendition); err != nil {
+			return database.Video{}, err
+		}
+	}
+	if err := cfg.db.SetCurrentVersion(video.ID, version.Version); err != nil {
+		return database.Video{}, err
+	}
+	video.Version = version.Version
+	return video, nil
+}
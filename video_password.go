@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// videoAccessExpiry is how long a password opens a video for, about a
+	// review session
+	videoAccessExpiry = 2 * time.Hour
+	maxVideoPassword  = 256
+	// videoAccessHeader carries a video access token; players that can't
+	// send headers put it in the videoAccessParam query parameter instead
+	videoAccessHeader = "X-Video-Access-Token"
+	videoAccessParam  = "video_access_token"
+)
+
+// errorCodePasswordAttemptsExceeded marks a 429 for too many password
+// guesses, which unlike other 429s is about the video or client, not the
+// request rate
+const errorCodePasswordAttemptsExceeded = "password_attempts_exceeded"
+
+// unlockLimits cap password guesses, which are slow to check on purpose:
+// a client gets 5 guesses at a video, then one a minute. Across clients a
+// video gets 20, then one every 10 seconds, and so does a client across
+// videos.
+type unlockLimits struct {
+	perVideoClient *attemptLimiter
+	perVideo       *attemptLimiter
+	perClient      *attemptLimiter
+}
+
+func newUnlockLimits() unlockLimits {
+	return unlockLimits{
+		perVideoClient: newAttemptLimiter(5, time.Minute),
+		perVideo:       newAttemptLimiter(20, 10*time.Second),
+		perClient:      newAttemptLimiter(20, 10*time.Second),
+	}
+}
+
+// videoPasswordKey identifies the video's current password in the access
+// tokens minted with it, without giving its hash away.
+func videoPasswordKey(video database.Video) string {
+	sum := sha256.Sum256([]byte(video.PasswordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// videoAccessToken is the video access token r carries, "" if none.
+func videoAccessToken(r *http.Request) string {
+	if token := r.Header.Get(videoAccessHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get(videoAccessParam)
+}
+
+// canAccess reports whether the request may see video: canView for its
+// viewer, or for a password protected video a video access token minted
+// with the current password. The token opens a private video too, so
+// reviewers don't need an account.
+func (cfg *apiConfig) canAccess(r *http.Request, video database.Video, viewerID uuid.UUID) bool {
+	if canView(video, viewerID) {
+		return true
+	}
+	if video.PasswordHash == "" || moderationHeld(video) {
+		return false
+	}
+	videoID, key, err := auth.ValidateVideoAccessToken(videoAccessToken(r), cfg.jwtSecret)
+	return err == nil && videoID == video.ID && key == videoPasswordKey(video)
+}
+
+// withVideoAccess adds the request's video access token to a URL that
+// plays video, for players that reach it without the token's header.
+func withVideoAccess(r *http.Request, video database.Video, playURL string) string {
+	token := videoAccessToken(r)
+	if video.PasswordHash == "" || token == "" {
+		return playURL
+	}
+	return playURL + "?" + url.Values{videoAccessParam: {token}}.Encode()
+}
+
+// handlerVideoPasswordSet puts a password on the video. Anyone but the
+// owner then needs it, exchanged at /unlock for an access token, to see
+// the video, which drops out of public listings.
+func (cfg *apiConfig) handlerVideoPasswordSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" || len(params.Password) > maxVideoPassword {
+		respondWithError(w, http.StatusBadRequest, "password must be 1 to 256 bytes", nil)
+		return
+	}
+
+	hash, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	if err := cfg.db.SetVideoPassword(videoID, hash); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set password", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:  database.AuditPasswordChanged,
+		VideoID: videoID,
+		Detail:  "set",
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoPasswordDelete takes the video's password off, ending every
+// access token minted with it.
+func (cfg *apiConfig) handlerVideoPasswordDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.SetVideoPassword(videoID, ""); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove password", err)
+		return
+	}
+	cfg.audit(r, database.CreateAuditEntryParams{
+		Action:  database.AuditPasswordChanged,
+		VideoID: videoID,
+		Detail:  "removed",
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoUnlock exchanges a video's password for a short-lived token
+// that opens it, sent back in the X-Video-Access-Token header or the
+// video_access_token query parameter.
+func (cfg *apiConfig) handlerVideoUnlock(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// videos without a password, or held by moderation, can't be unlocked,
+	// and look like they don't exist
+	if video.ID == uuid.Nil || video.PasswordHash == "" || moderationHeld(video) {
+		respondWithErrorCode(w, http.StatusNotFound, errorCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	// every guess costs an argon2 hash, so guesses are limited per client
+	// and video, per video and per client
+	client := cfg.forwardedClientIP(r)
+	for _, limit := range []struct {
+		limiter *attemptLimiter
+		key     string
+	}{
+		{cfg.unlockLimits.perVideoClient, video.ID.String() + " " + client},
+		{cfg.unlockLimits.perVideo, video.ID.String()},
+		{cfg.unlockLimits.perClient, client},
+	} {
+		if ok, wait := limit.limiter.allow(limit.key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithErrorCode(w, http.StatusTooManyRequests, errorCodePasswordAttemptsExceeded, "Too many password attempts, try again later", nil)
+			return
+		}
+	}
+	match, err := auth.CheckPasswordHash(params.Password, video.PasswordHash)
+	if err != nil || !match {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	token, err := auth.MakeVideoAccessToken(video.ID, videoPasswordKey(video), cfg.jwtSecret, videoAccessExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Token:     token,
+		ExpiresAt: time.Now().UTC().Add(videoAccessExpiry),
+	})
+}
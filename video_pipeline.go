@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ingestSource is a local file ready to go through the video pipeline.
+type ingestSource struct {
+	Path      string
+	MediaType string
+	SHA256    string
+	Size      int64
+	// Filename is the name the file was uploaded with, if known
+	Filename string
+}
+
+// ingestError carries the HTTP status and client-facing message for a
+// pipeline failure, so every entry point reports errors the same way.
+type ingestError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *ingestError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *ingestError) Unwrap() error {
+	return e.Err
+}
+
+// errorCodeProbeFailed marks uploads ffprobe couldn't read at all
+const errorCodeProbeFailed = "probe_failed"
+
+var (
+	// errProbeFailed is behind the ingestError for a file ffprobe couldn't
+	// read
+	errProbeFailed = errors.New("couldn't probe file")
+	// errUnsupportedMedia is behind the ingestError for a file that isn't
+	// media Tubely takes, like an MP4 without video or with codecs
+	// browsers can't play
+	errUnsupportedMedia = errors.New("unsupported media")
+)
+
+// respondWithIngestError reports a pipeline failure, with an error code
+// for the ones clients handle differently.
+func respondWithIngestError(w http.ResponseWriter, e *ingestError) {
+	respondWithErrorCode(w, e.Status, e.code(), e.Message, e.Err)
+}
+
+// code is the error code e is reported with, "" if it has none.
+func (e *ingestError) code() string {
+	var infected *virusFoundError
+	switch {
+	case errors.As(e.Err, &infected):
+		return errorCodeMalwareDetected
+	case errors.Is(e.Err, errVideoArchived):
+		return errorCodeVideoArchived
+	case errors.Is(e.Err, errMediaToolsUnavailable):
+		return errorCodeProcessingUnavailable
+	case errors.Is(e.Err, errProbeFailed):
+		return errorCodeProbeFailed
+	case errors.Is(e.Err, errUnsupportedMedia):
+		return errorCodeUnsupportedMediaType
+	}
+	return ""
+}
+
+// ingestVideo scans src for viruses, probes and processes it according to
+// profile, stores the results in S3 and points video at them, cleaning up
+// whatever the video referenced before. Errors are *ingestError. The owner's integrations are
+// told how it went.
+func (cfg *apiConfig) ingestVideo(ctx context.Context, video database.Video, src ingestSource, profile processingProfile) (database.Video, error) {
+	var processed database.Video
+	var err error
+	kind := mediaKindOf(src.MediaType)
+	cfg.publishUploaded(ctx, video, kind)
+	scanErr := cfg.scanUpload(ctx, src.Path)
+	replaceErr := checkReplaceable(video, kind)
+	switch {
+	case scanErr != nil:
+		err = scanErr
+	case replaceErr != nil:
+		err = replaceErr
+	case kind == database.MediaKindAudio:
+		processed, err = cfg.processAudio(ctx, video, src, profile)
+	default:
+		processed, err = cfg.processVideo(ctx, video, src, profile)
+	}
+	cfg.reportIngest(ctx, video, err)
+	return processed, err
+}
+
+// mediaKindOf is the kind of file uploaded as mediaType.
+func mediaKindOf(mediaType string) string {
+	if slices.Contains(audioUploadTypes, mediaType) {
+		return database.MediaKindAudio
+	}
+	return database.MediaKindVideo
+}
+
+// checkReplaceable refuses a new file of kind for video if the video can't
+// take it. Errors are *ingestError.
+func checkReplaceable(video database.Video, kind string) error {
+	switch {
+	case video.ArchiveStatus != "":
+		// the archived file would be left behind, archived, as a version
+		return &ingestError{http.StatusConflict, "This video is archived, restore it before replacing its file", errVideoArchived}
+	case video.VideoURL != nil && video.MediaKind != kind:
+		// versions of one video are all the same kind of file
+		msg := fmt.Sprintf("This video holds %s, so only %s can replace it", video.MediaKind, video.MediaKind)
+		return &ingestError{http.StatusConflict, msg, nil}
+	}
+	return nil
+}
+
+// reportIngest publishes how an ingest of video went, err being its
+// outcome. After a successful one it checks the owner's storage quota and
+// queues the new file's transcription, moderation, fingerprinting and, if
+// the video has none, thumbnail.
+func (cfg *apiConfig) reportIngest(ctx context.Context, video database.Video, err error) {
+	if err != nil {
+		cfg.publishProcessingFailed(ctx, video, err)
+		return
+	}
+	cfg.publishReady(ctx, video)
+	cfg.updateQuotaAlerts(ctx, video.UserID)
+	cfg.queueTranscription(ctx, video)
+	cfg.queueModeration(ctx, video)
+	cfg.queueFingerprint(ctx, video)
+	cfg.queueAutoThumbnail(ctx, video)
+}
+
+func (cfg *apiConfig) processVideo(ctx context.Context, video database.Video, src ingestSource, profile processingProfile) (database.Video, error) {
+	logger := loggerFrom(ctx)
+	previous := video
+	video.OriginalFilename = src.Filename
+	video.MediaKind = database.MediaKindVideo
+	video.LoudnessTarget = nil
+
+	// probe the file once and derive everything we need from the result
+	_, probeSpan := tracer.Start(ctx, "ffprobe")
+	probe, err := cfg.media.probeVideo(ctx, src.Path)
+	endSpan(probeSpan, err)
+	if err != nil {
+		return database.Video{}, mediaIngestError("Failed to probe video", fmt.Errorf("%w: %w", errProbeFailed, err))
+	}
+	if err := probe.validateMP4(); err != nil {
+		return database.Video{}, &ingestError{http.StatusBadRequest, "Invalid video: " + err.Error(), fmt.Errorf("%w: %w", errUnsupportedMedia, err)}
+	}
+
+	logger.Debug("probed video", "aspect_ratio", probe.aspectRatio(), "duration_seconds", probe.duration())
+
+	// decide how to treat high frame rate sources based on the profile
+	projection := probe.projection()
+	sourceFPS := probe.frameRate()
+	fpsMode := profile.frameRateModeFor(sourceFPS)
+	outputFPS := sourceFPS
+	logger.Debug("frame rate handling", "fps", sourceFPS, "mode", fpsMode)
+
+	uploadPath := src.Path
+	if videoCodec, audioCodec := probe.incompatibleCodecs(); videoCodec != "" || audioCodec != "" {
+		if cfg.codecPolicy == codecPolicyReject {
+			msg := fmt.Sprintf("Invalid video: browsers can't play %s, upload H.264 or H.265 video with AAC audio", describeCodecs(videoCodec, audioCodec))
+			return database.Video{}, &ingestError{http.StatusBadRequest, msg, errUnsupportedMedia}
+		}
+		transcodeStarted := time.Now()
+		_, transcodeSpan := tracer.Start(ctx, "transcode for browsers")
+		transcodedPath, err := cfg.media.transcodeForBrowsers(ctx, uploadPath, videoCodec != "", audioCodec != "", projection)
+		endSpan(transcodeSpan, err)
+		if err != nil {
+			return database.Video{}, mediaIngestError("Failed to transcode video", err)
+		}
+		defer os.Remove(transcodedPath)
+		uploadPath = transcodedPath
+		logger.Info("transcoded for browsers", "video_codec", videoCodec, "audio_codec", audioCodec, "duration", time.Since(transcodeStarted))
+	}
+	if rate := fpsMode.conformRate(); rate > 0 {
+		conformStarted := time.Now()
+		_, conformSpan := tracer.Start(ctx, "conform frame rate", trace.WithAttributes(attribute.Float64("fps", rate)))
+		conformedPath, err := cfg.media.conformFrameRate(ctx, uploadPath, rate, projection)
+		endSpan(conformSpan, err)
+		if err != nil {
+			return database.Video{}, mediaIngestError("Failed to conform frame rate", err)
+		}
+		defer os.Remove(conformedPath)
+		uploadPath = conformedPath
+		outputFPS = rate
+		logger.Info("conformed frame rate", "fps", rate, "duration", time.Since(conformStarted))
+	}
+
+	if audio, ok := probe.audioStream(); ok && profile.NormalizeLoudness {
+		normalizeStarted := time.Now()
+		_, normalizeSpan := tracer.Start(ctx, "normalize loudness")
+		normalizedPath, err := cfg.media.normalizeLoudness(ctx, uploadPath, audio, projection)
+		endSpan(normalizeSpan, err)
+		if err != nil {
+			return database.Video{}, mediaIngestError("Failed to normalize loudness", err)
+		}
+		defer os.Remove(normalizedPath)
+		uploadPath = normalizedPath
+		target := loudnessTarget
+		video.LoudnessTarget = &target
+		logger.Info("normalized loudness", "lufs", target, "duration", time.Since(normalizeStarted))
+	}
+
+	// an untouched source this user already uploaded can reuse the stored object
+	var existing *database.ContentObject
+	if uploadPath == src.Path && src.SHA256 != "" {
+		existing, err = cfg.reusableContent(video, src.SHA256, src.Size)
+		if err != nil {
+			logger.Warn("couldn't look up content hash", "sha256", src.SHA256, "error", err)
+			existing = nil
+		}
+	}
+
+	version, err := cfg.nextVideoVersion(previous)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't reserve a version", err}
+	}
+
+	// every upload is a new version, the earlier ones are kept under their own keys
+	s3Key := versionObjectKey(video.ID, version, "")
+
+	// only objects uploaded here are cleaned up on failure, a reused one belongs to other videos too
+	bucket := cfg.videoBucket(video)
+	newKeys := []string{}
+	var uploadSize int64
+	var uploadSHA256 string
+	if existing != nil {
+		s3Key = existing.ObjectKey
+		uploadSize = existing.Size
+		uploadSHA256 = existing.SHA256
+		logger.Info("reusing identical stored content", "key", s3Key, "bytes", uploadSize)
+	} else {
+		uploadFile, err := os.Open(uploadPath)
+		if err != nil {
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to open processed file", err}
+		}
+		defer uploadFile.Close()
+		info, err := uploadFile.Stat()
+		if err != nil {
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to read processed file", err}
+		}
+		uploadSize = info.Size()
+
+		// the source hash was taken as it streamed in, a processed file needs its own
+		uploadSHA256 = src.SHA256
+		if uploadPath != src.Path {
+			uploadSHA256, err = hashFile(uploadFile)
+			if err != nil {
+				return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to hash processed file", err}
+			}
+		}
+
+		uploadStarted := time.Now()
+		err = cfg.putPendingObject(ctx, video, probe.aspectRatio(), s3Key, uploadFile, src.MediaType, uploadSHA256)
+		if err != nil {
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to upload file to S3", err}
+		}
+		newKeys = append(newKeys, s3Key)
+
+		logger.Info("uploaded video to S3",
+			"bucket", bucket,
+			"key", s3Key,
+			"bytes", uploadSize,
+			"duration", time.Since(uploadStarted),
+		)
+	}
+
+	renditions := []database.CreateRenditionParams{{
+		VideoID:         video.ID,
+		Kind:            "primary",
+		VideoURL:        cfg.getObjectURL(bucket, s3Key),
+		FrameRate:       outputFPS,
+		SourceFrameRate: sourceFPS,
+		FrameRateMode:   string(fpsMode),
+		Size:            uploadSize,
+		SHA256:          uploadSHA256,
+	}}
+
+	if fpsMode == frameRateSlowMo {
+		slowMoPath, err := cfg.media.createSlowMotionRendition(ctx, src.Path, sourceFPS, projection)
+		if err != nil {
+			cfg.discardPendingObjects(ctx, bucket, newKeys)
+			return database.Video{}, mediaIngestError("Failed to create slow-motion rendition", err)
+		}
+		defer os.Remove(slowMoPath)
+
+		slowMoFile, err := os.Open(slowMoPath)
+		if err != nil {
+			cfg.discardPendingObjects(ctx, bucket, newKeys)
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to open slow-motion rendition", err}
+		}
+		defer slowMoFile.Close()
+		slowMoInfo, err := slowMoFile.Stat()
+		if err != nil {
+			cfg.discardPendingObjects(ctx, bucket, newKeys)
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to read slow-motion rendition", err}
+		}
+
+		slowMoKey := versionObjectKey(video.ID, version, "_slowmo")
+		slowMoSHA256, err := hashFile(slowMoFile)
+		if err != nil {
+			cfg.discardPendingObjects(ctx, bucket, newKeys)
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to hash slow-motion rendition", err}
+		}
+		err = cfg.putPendingObject(ctx, video, probe.aspectRatio(), slowMoKey, slowMoFile, src.MediaType, slowMoSHA256)
+		if err != nil {
+			cfg.discardPendingObjects(ctx, bucket, newKeys)
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to upload slow-motion rendition", err}
+		}
+		newKeys = append(newKeys, slowMoKey)
+		renditions = append(renditions, database.CreateRenditionParams{
+			VideoID:         video.ID,
+			Kind:            "slowmo",
+			VideoURL:        cfg.getObjectURL(bucket, slowMoKey),
+			FrameRate:       slowMoPlaybackRate,
+			SourceFrameRate: sourceFPS,
+			FrameRateMode:   string(fpsMode),
+			Size:            slowMoInfo.Size(),
+			SHA256:          slowMoSHA256,
+		})
+	}
+
+	video, err = cfg.commitVideoObjects(ctx, video, version, probe, renditions, newKeys)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	// untouched sources can be reused by a later upload of the same bytes
+	if uploadPath == src.Path && existing == nil {
+		err = cfg.db.SaveContentObject(database.CreateContentObjectParams{
+			UserID:      video.UserID,
+			SHA256:      src.SHA256,
+			Size:        src.Size,
+			Bucket:      bucket,
+			ObjectKey:   s3Key,
+			ContentType: src.MediaType,
+			Projection:  video.Projection,
+			FrameRate:   sourceFPS,
+		})
+		if err != nil {
+			logger.Warn("couldn't record content hash", "key", s3Key, "error", err)
+		}
+	}
+
+	return video, nil
+}
+
+// commitVideoObjects points video at newly stored objects as the given
+// version: the first rendition becomes its video URL, the renditions
+// replace the old ones and probe, the source's, gives its duration and bit
+// rate, and for a video file its projection and size. Chapters in the
+// file become the video's if it has none. The old objects are released
+// afterwards unless a kept version still uses them, and versions beyond
+// the limit are pruned.
+// newKeys are the pending objects stored for this video alone, deleted if
+// the database can't be updated. Errors are *ingestError.
+func (cfg *apiConfig) commitVideoObjects(ctx context.Context, video database.Video, version int, probe probeResult, renditions []database.CreateRenditionParams, newKeys []string) (_ database.Video, err error) {
+	_, span := tracer.Start(ctx, "update database")
+	defer func() { endSpan(span, err) }()
+
+	// remember the previous objects so they can be cleaned up once the new ones are live
+	bucket := cfg.videoBucket(video)
+	oldKeys := []string{}
+	if video.VideoURL != nil {
+		if key, ok := cfg.keyInBucket(bucket, *video.VideoURL); ok {
+			oldKeys = append(oldKeys, key)
+		}
+	}
+	oldRenditions, err := cfg.db.GetRenditions(video.ID)
+	if err != nil {
+		cfg.discardPendingObjects(ctx, bucket, newKeys)
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't get existing renditions", err}
+	}
+	for _, rendition := range oldRenditions {
+		if key, ok := cfg.keyInBucket(bucket, rendition.VideoURL); ok && !slices.Contains(oldKeys, key) {
+			oldKeys = append(oldKeys, key)
+		}
+	}
+
+	videoURL := renditions[0].VideoURL
+	video.VideoURL = &videoURL
+	video.Projection = nil
+	if projection := probe.projection(); projection != "" {
+		video.Projection = &projection
+	}
+	video.SetSize(nil, nil)
+	if width, height := probe.displaySize(); height > 0 && video.MediaKind == database.MediaKindVideo {
+		video.SetSize(&width, &height)
+	}
+	video.Duration, video.Bitrate = nil, nil
+	if duration := probe.duration(); duration > 0 {
+		video.Duration = &duration
+	}
+	if bitrate := probe.bitrate(); bitrate > 0 {
+		video.Bitrate = &bitrate
+	}
+	// the new objects stop being pending as the video, its renditions and
+	// its new version point at them, all at once, so a failure part way
+	// can't leave the video without renditions or version
+	err = cfg.db.CommitVideoObjects(video, videoVersion(video, version, renditions), newKeys)
+	if err != nil {
+		// the new objects are unreferenced, don't leak them
+		cfg.discardPendingObjects(ctx, bucket, newKeys)
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Failed to update video in database", err}
+	}
+	video.Version = version
+
+	// chapters embedded in the file are kept, unless the owner set some
+	if chapters := probe.chapters(); len(chapters) > 0 {
+		if _, err := cfg.db.SetChaptersIfNone(video.ID, chapters); err != nil {
+			loggerFrom(ctx).Warn("couldn't save the file's chapters", "error", err)
+		}
+	}
+
+	// the DB now points at the new objects, so the old ones can go
+	cfg.releaseObjects(ctx, bucket, oldKeys)
+	cfg.pruneVideoVersions(ctx, video, version)
+
+	return video, nil
+}
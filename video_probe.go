@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// probeResult is the subset of `ffprobe -show_streams -show_format
+// -show_chapters` output we care about.
+type probeResult struct {
+	Streams  []probeStream  `json:"streams"`
+	Format   probeFormat    `json:"format"`
+	Chapters []probeChapter `json:"chapters"`
+}
+
+// probeChapter is a chapter marker embedded in the file, such as an MP4
+// chapter track.
+type probeChapter struct {
+	StartTime string `json:"start_time"`
+	Tags      struct {
+		Title string `json:"title"`
+	} `json:"tags"`
+}
+
+type probeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type probeStream struct {
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	SampleRate   string `json:"sample_rate"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	RFrameRate   string `json:"r_frame_rate"`
+	Tags         struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []probeSideData `json:"side_data_list"`
+}
+
+type probeSideData struct {
+	SideDataType string `json:"side_data_type"`
+	Projection   string `json:"projection"`
+	// Rotation is set for the "Display Matrix" side data, in degrees
+	// counterclockwise
+	Rotation float64 `json:"rotation"`
+}
+
+// probeVideo runs ffprobe on filePath. A corrupt file can make ffprobe
+// hang, so it's killed, along with anything it started, once ctx is done
+// or the probe timeout passes.
+func (m mediaTools) probeVideo(ctx context.Context, filePath string) (probeResult, error) {
+	if sandboxMedia {
+		return sandboxProbe(sandboxProbeJSON)
+	}
+	return m.probe(ctx, filePath)
+}
+
+// probeAudio is probeVideo for an audio upload.
+func (m mediaTools) probeAudio(ctx context.Context, filePath string) (probeResult, error) {
+	if sandboxMedia {
+		return sandboxProbe(sandboxAudioProbeJSON)
+	}
+	return m.probe(ctx, filePath)
+}
+
+func (m mediaTools) probe(ctx context.Context, filePath string) (probeResult, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, m.ProbeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(probeCtx, m.FFprobe, "-v", "error", "-print_format", "json", "-show_streams", "-show_format", "-show_chapters", filePath)
+	killProcessGroup(cmd)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return probeResult{}, ctx.Err()
+		}
+		if probeCtx.Err() != nil {
+			return probeResult{}, fmt.Errorf("ffprobe didn't finish within %s", m.ProbeTimeout)
+		}
+		if err := toolError(m.FFprobe, err); errors.Is(err, errMediaToolsUnavailable) {
+			return probeResult{}, err
+		}
+		return probeResult{}, &toolFailure{"ffprobe", err, string(bytes.TrimSpace(stderr.Bytes()))}
+	}
+
+	var probe probeResult
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return probeResult{}, err
+	}
+	return probe, nil
+}
+
+// videoStreams returns the streams that carry actual picture data.
+func (p probeResult) videoStreams() []probeStream {
+	streams := []probeStream{}
+	for _, s := range p.Streams {
+		if s.CodecType != "video" {
+			continue
+		}
+		if s.Width <= 0 || s.Height <= 0 {
+			continue
+		}
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+// rotation returns how far the stream is turned clockwise when displayed,
+// in degrees from 0 to 359. Older ffmpeg versions report it as a rotate
+// tag, newer ones only in the display matrix side data, counterclockwise.
+func (s probeStream) rotation() int {
+	degrees := 0
+	if rotate, err := strconv.Atoi(s.Tags.Rotate); err == nil {
+		degrees = rotate
+	} else {
+		for _, sd := range s.SideDataList {
+			if sd.SideDataType == "Display Matrix" {
+				degrees = -int(math.Round(sd.Rotation))
+				break
+			}
+		}
+	}
+	return (degrees%360 + 360) % 360
+}
+
+// displaySize returns the width and height of the first video stream as
+// it's displayed, with any rotation applied, or zeros if there's none.
+func (p probeResult) displaySize() (width, height int) {
+	for _, s := range p.videoStreams() {
+		if s.rotation()%180 == 90 {
+			return s.Height, s.Width
+		}
+		return s.Width, s.Height
+	}
+	return 0, 0
+}
+
+// aspectRatio returns "16:9", "4:3", "1:1", "9:16", "3:4" or "other", as
+// the video is displayed.
+func (p probeResult) aspectRatio() string {
+	w, h := p.displaySize()
+	if h > 0 {
+		ar := float64(w) / float64(h)
+
+		if ar > 1.6 && ar < 1.85 {
+			return "16:9"
+		}
+		if ar > 1.28 && ar < 1.36 {
+			return "4:3"
+		}
+		if ar > 0.95 && ar < 1.05 {
+			return "1:1"
+		}
+		if ar > 0.53 && ar < 0.62 {
+			return "9:16"
+		}
+		if ar > 0.73 && ar < 0.82 {
+			return "3:4"
+		}
+	}
+	// no match found
+	return "other"
+}
+
+// projection reports the spherical projection ("equirectangular", "cubemap",
+// ...) from the spatial media metadata, or "" for a regular flat video.
+func (p probeResult) projection() string {
+	for _, s := range p.videoStreams() {
+		for _, sd := range s.SideDataList {
+			if sd.SideDataType == "Spherical Mapping" && sd.Projection != "" {
+				return sd.Projection
+			}
+		}
+	}
+	return ""
+}
+
+// frameRate returns the frames per second of the first video stream, or 0
+// if it can't be determined.
+func (p probeResult) frameRate() float64 {
+	for _, s := range p.videoStreams() {
+		if fps := parseFrameRate(s.AvgFrameRate); fps > 0 {
+			return fps
+		}
+		return parseFrameRate(s.RFrameRate)
+	}
+	return 0
+}
+
+// parseFrameRate parses ffprobe's rational rates such as "30000/1001".
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// bitrate is the container's overall bit rate in bits per second, or 0 if
+// unknown.
+func (p probeResult) bitrate() int64 {
+	b, err := strconv.ParseInt(p.Format.BitRate, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return b
+}
+
+// duration is the container's duration in seconds, or 0 if unknown.
+func (p probeResult) duration() float64 {
+	d, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// validateMP4 checks the probe found an MP4/QuickTime file with a picture
+// and a known duration.
+func (p probeResult) validateMP4() error {
+	if !slices.Contains(strings.Split(p.Format.FormatName, ","), "mp4") {
+		return fmt.Errorf("not an MP4 file (format %q)", p.Format.FormatName)
+	}
+	if len(p.videoStreams()) == 0 {
+		return errors.New("no video stream")
+	}
+	if p.duration() <= 0 {
+		return errors.New("unknown duration")
+	}
+	return nil
+}
+
+// browserVideoCodecs and browserAudioCodecs are the codecs, by ffprobe's
+// names, that browsers play in an MP4. Other codecs make valid MP4s that
+// won't play.
+var (
+	browserVideoCodecs = []string{"h264", "hevc"}
+	browserAudioCodecs = []string{"aac"}
+)
+
+// incompatibleCodecs returns the codec of the main video stream if
+// browsers can't play it, and the first such codec among the audio
+// streams, "" for either that's fine.
+func (p probeResult) incompatibleCodecs() (videoCodec, audioCodec string) {
+	if streams := p.videoStreams(); len(streams) > 0 && !slices.Contains(browserVideoCodecs, streams[0].CodecName) {
+		videoCodec = streams[0].CodecName
+	}
+	for _, s := range p.Streams {
+		if s.CodecType == "audio" && !slices.Contains(browserAudioCodecs, s.CodecName) {
+			audioCodec = s.CodecName
+			break
+		}
+	}
+	return videoCodec, audioCodec
+}
+
+// describeCodecs names the codecs incompatibleCodecs found, for messages.
+func describeCodecs(videoCodec, audioCodec string) string {
+	switch {
+	case videoCodec == "":
+		return audioCodec + " audio"
+	case audioCodec == "":
+		return videoCodec + " video"
+	}
+	return videoCodec + " video with " + audioCodec + " audio"
+}
+
+// audioFormat describes an audio container Tubely stores.
+type audioFormat struct {
+	Ext         string
+	ContentType string
+	// Muxer is ffmpeg's name for the container
+	Muxer string
+}
+
+// audioFormats are the audio containers accepted, by ffprobe format name.
+var audioFormats = map[string]audioFormat{
+	"mp3": {".mp3", "audio/mpeg", "mp3"},
+	"aac": {".aac", "audio/aac", "adts"},
+	"ogg": {".ogg", "audio/ogg", "ogg"},
+}
+
+// audioUploadTypes are the content types audio can be uploaded as.
+var audioUploadTypes = []string{"audio/mpeg", "audio/aac", "audio/ogg"}
+
+// validateAudio checks the probe found an audio file in one of the
+// accepted containers with a known duration, and returns its format.
+// Whatever the upload claimed to be, the container ffprobe found decides.
+func (p probeResult) validateAudio() (audioFormat, error) {
+	var format audioFormat
+	found := false
+	for _, name := range strings.Split(p.Format.FormatName, ",") {
+		if format, found = audioFormats[name]; found {
+			break
+		}
+	}
+	if !found {
+		return audioFormat{}, fmt.Errorf("not an MP3, AAC or Ogg file (format %q)", p.Format.FormatName)
+	}
+	if !slices.ContainsFunc(p.Streams, func(s probeStream) bool { return s.CodecType == "audio" }) {
+		return audioFormat{}, errors.New("no audio stream")
+	}
+	if p.duration() <= 0 {
+		return audioFormat{}, errors.New("unknown duration")
+	}
+	return format, nil
+}
+
+// audioStream returns the first audio stream, if there is one.
+func (p probeResult) audioStream() (probeStream, bool) {
+	for _, s := range p.Streams {
+		if s.CodecType == "audio" {
+			return s, true
+		}
+	}
+	return probeStream{}, false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// viewDedupWindow is how long repeat plays by the same viewer count as
+// one view, so seeking, reloads and retries don't inflate the count.
+const viewDedupWindow = 30 * time.Minute
+
+// viewerKey identifies who is watching, for deduplicating views. Logged
+// in viewers are their user ID. Anonymous ones are told apart by address
+// and user agent, keyed with the JWT secret so neither is stored.
+func (cfg *apiConfig) viewerKey(r *http.Request, viewerID uuid.UUID) string {
+	if viewerID != uuid.Nil {
+		return "user:" + viewerID.String()
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	mac.Write([]byte(clientIP(r) + "\n" + r.UserAgent()))
+	return "anon:" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// isPlaybackStart reports whether a streaming request starts playback,
+// rather than continuing it: players fetch the start of the file first and
+// then ask for later ranges as they play and seek.
+func isPlaybackStart(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	spec := r.Header.Get("Range")
+	return spec == "" || strings.HasPrefix(spec, "bytes=0-")
+}
+
+// recordView counts a view of video. Failing to count one shouldn't stop
+// playback, so errors are only logged.
+func (cfg *apiConfig) recordView(r *http.Request, video database.Video, viewerID uuid.UUID) {
+	_, err := cfg.db.RecordView(video.ID, cfg.viewerKey(r, viewerID), viewDedupWindow)
+	if err != nil {
+		loggerFrom(r.Context()).Error("couldn't record view", "video_id", video.ID, "error", err)
+	}
+}
+
+// recordBytesServed adds bytes sent of video to its traffic. Like views,
+// errors are only logged.
+func (cfg *apiConfig) recordBytesServed(r *http.Request, video database.Video, bytes int64) {
+	if bytes == 0 {
+		return
+	}
+	if err := cfg.db.AddBytesServed(video.ID, time.Now(), bytes); err != nil {
+		loggerFrom(r.Context()).Error("couldn't record bytes served", "video_id", video.ID, "error", err)
+	}
+}
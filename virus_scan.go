@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	virusScanClamAV = "clamav"
+	// defaultClamdAddress is where Debian and Ubuntu's clamav-daemon listens
+	defaultClamdAddress = "unix:/var/run/clamav/clamd.ctl"
+	// clamdChunkSize is how much of a file goes in each INSTREAM chunk
+	clamdChunkSize = 64 << 10
+	// clamdPingTimeout bounds the startup check
+	clamdPingTimeout = 5 * time.Second
+	// errorCodeMalwareDetected marks responses to uploads a virus scan
+	// rejected, so clients can tell them from files that are merely invalid
+	errorCodeMalwareDetected = "malware_detected"
+)
+
+// virusFoundError is returned for a file a virus scan found malware in.
+type virusFoundError struct {
+	// Signature is the name of what the scanner found, such as
+	// Eicar-Test-Signature
+	Signature string
+}
+
+func (e *virusFoundError) Error() string {
+	return "virus scan found " + e.Signature
+}
+
+// virusScanner checks uploaded files for malware before they're stored.
+type virusScanner interface {
+	// check makes sure the scanner can be reached, at startup
+	check(ctx context.Context) error
+	// scan returns a *virusFoundError if the file at path is infected
+	scan(ctx context.Context, path string) error
+}
+
+// newVirusScanner returns the scanner VIRUS_SCAN chooses, nil if uploads
+// aren't scanned. Sandbox mode fakes clamd.
+func (cfg *apiConfig) newVirusScanner(provider, clamdAddress string) virusScanner {
+	switch {
+	case provider == "":
+		return nil
+	case cfg.sandbox:
+		return sandboxVirusScanner{}
+	default:
+		network, address := splitClamdAddress(clamdAddress)
+		return clamdScanner{network: network, address: address}
+	}
+}
+
+// splitClamdAddress splits CLAMD_ADDRESS, such as unix:/run/clamd.sock or
+// tcp:127.0.0.1:3310, into a network and an address to dial.
+func splitClamdAddress(address string) (string, string) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", path
+	}
+	if hostPort, ok := strings.CutPrefix(address, "tcp:"); ok {
+		return "tcp", hostPort
+	}
+	if strings.HasPrefix(address, "/") {
+		return "unix", address
+	}
+	return "tcp", address
+}
+
+// clamdScanner streams files to a ClamAV daemon with its INSTREAM command,
+// so clamd needn't share a filesystem with Tubely. clamd refuses streams
+// over its StreamMaxLength, 25 MB by default, which has to be raised to
+// the largest upload allowed.
+type clamdScanner struct {
+	network string
+	address string
+}
+
+func (s clamdScanner) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, clamdPingTimeout)
+	defer cancel()
+	reply, err := s.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return fmt.Errorf("can't reach clamd at %s: %w", s.address, err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd at %s answered %q to PING", s.address, reply)
+	}
+	return nil
+}
+
+func (s clamdScanner) scan(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reply, err := s.command(ctx, "zINSTREAM\x00", file)
+	if err != nil {
+		return fmt.Errorf("clamd scan failed: %w", err)
+	}
+	// the reply is "stream: OK", "stream: <signature> FOUND" or
+	// "<reason> ERROR"
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &virusFoundError{Signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamd scan failed: %s", reply)
+	}
+}
+
+// command sends cmd to clamd, followed by body in INSTREAM chunks if it
+// isn't nil, and returns the reply.
+func (s clamdScanner) command(ctx context.Context, cmd string, body io.Reader) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// a cancelled upload stops the scan too
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := sendClamdCommand(conn, cmd, body); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		// clamd hangs up on streams over its limit, after saying so
+		if reply, readErr := readClamdReply(conn); readErr == nil {
+			return reply, nil
+		}
+		return "", err
+	}
+	reply, err := readClamdReply(conn)
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return reply, err
+}
+
+// sendClamdCommand writes cmd, then body as INSTREAM chunks, each prefixed
+// with its length, ending with an empty one.
+func sendClamdCommand(conn io.Writer, cmd string, body io.Reader) error {
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString(cmd); err != nil {
+		return err
+	}
+	if body != nil {
+		chunk := make([]byte, clamdChunkSize)
+		for {
+			n, err := io.ReadFull(body, chunk)
+			if n > 0 {
+				if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+					return err
+				}
+				if _, err := w.Write(chunk[:n]); err != nil {
+					return err
+				}
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// readClamdReply reads a reply to a z-prefixed command, which ends in a
+// NUL byte.
+func readClamdReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(r).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// scanUpload runs the virus scan, if there is one, on a file about to be
+// stored. Infected files are rejected with errorCodeMalwareDetected and
+// the signature is logged. A scan that can't run rejects the file too:
+// it's only stored once it's known to be clean.
+func (cfg *apiConfig) scanUpload(ctx context.Context, path string) error {
+	if cfg.virusScanner == nil {
+		return nil
+	}
+	started := time.Now()
+	_, span := tracer.Start(ctx, "virus scan")
+	err := cfg.virusScanner.scan(ctx, path)
+	endSpan(span, err)
+
+	var infected *virusFoundError
+	if errors.As(err, &infected) {
+		loggerFrom(ctx).Warn("virus scan rejected upload", "signature", infected.Signature)
+		return &ingestError{http.StatusUnprocessableEntity, "Upload rejected: the file contains malware", err}
+	}
+	if err != nil {
+		return &ingestError{http.StatusServiceUnavailable, "Couldn't scan the upload for viruses, try again later", err}
+	}
+	loggerFrom(ctx).Debug("virus scan passed", "duration", time.Since(started))
+	return nil
+}
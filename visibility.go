@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// public videos show up in listings for everyone
+	visibilityPublic = "public"
+	// unlisted videos are reachable by anyone who knows the ID
+	visibilityUnlisted = "unlisted"
+	// private videos are only visible to their owner, via presigned URLs
+	visibilityPrivate = "private"
+)
+
+const privateURLExpiry = 15 * time.Minute
+
+func validVisibility(visibility string) bool {
+	switch visibility {
+	case visibilityPublic, visibilityUnlisted, visibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// optionalViewerID returns the user behind the request's JWT, or uuid.Nil
+// for anonymous requests and invalid tokens.
+func (cfg *apiConfig) optionalViewerID(r *http.Request) uuid.UUID {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
+
+// canView reports whether viewerID may see a video fetched by its ID.
+// Videos moderation holds are only visible to their owner, like private
+// ones; admins see them through the admin endpoints. Others need a
+// password protected video's password too, see canAccess.
+func canView(video database.Video, viewerID uuid.UUID) bool {
+	if video.Visibility != visibilityPrivate && !moderationHeld(video) && video.PasswordHash == "" {
+		return true
+	}
+	return viewerID != uuid.Nil && video.UserID == viewerID
+}
+
+// objectsHidden reports whether clients only get presigned URLs to the
+// video's objects, which expire, rather than their permanent ones: for
+// private and password protected videos.
+func objectsHidden(video database.Video) bool {
+	return video.Visibility == visibilityPrivate || video.PasswordHash != ""
+}
+
+// moderationHeld reports whether moderation flagged the video and an admin
+// hasn't approved it.
+func moderationHeld(video database.Video) bool {
+	return video.ModerationStatus == database.ModerationPendingReview || video.ModerationStatus == database.ModerationRejected
+}
+
+// presentVideo prepares a video for a response. Private objects aren't
+// publicly readable, so their URL is swapped for a short-lived presigned
+// one, as is that of password protected videos.
+// With VIDEO_DELIVERY=proxy no object is, so other videos get the URL of
+// the streaming endpoint instead, or with HOTLINK_URL_EXPIRY one of a
+// playback token that expires. The video's captions and chapters are
+// added too.
+func (cfg *apiConfig) presentVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	captions, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	chapters, err := cfg.db.GetChapters(video.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	return cfg.presentVideoWith(ctx, video, captions, chapters)
+}
+
+// presentVideoWith is presentVideo with the video's captions and chapters
+// already loaded.
+func (cfg *apiConfig) presentVideoWith(ctx context.Context, video database.Video, captions []database.Caption, chapters []database.Chapter) (database.Video, error) {
+	captions, err := cfg.presentCaptions(ctx, video, captions)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.Captions = captions
+	video.Chapters = chapters
+	if chapters == nil {
+		video.Chapters = []database.Chapter{}
+	}
+
+	if video.VideoURL == nil {
+		return video, nil
+	}
+	bucket, key, ok := cfg.objectFromURL(*video.VideoURL)
+	if !ok {
+		return video, nil
+	}
+	if !objectsHidden(video) {
+		if cfg.videoDelivery == deliveryProxy && cfg.hotlink.URLExpiry > 0 {
+			// anyone can see the video, so the token names no viewer
+			token, err := auth.MakePlaybackToken(video.ID, uuid.Nil, cfg.jwtSecret, cfg.hotlink.URLExpiry)
+			if err != nil {
+				return database.Video{}, err
+			}
+			playbackURL := fmt.Sprintf("%s/api/v1/playback/%s", cfg.publicURL, token)
+			video.VideoURL = &playbackURL
+		} else if cfg.videoDelivery == deliveryProxy {
+			streamURL := fmt.Sprintf("%s/api/v1/videos/%s/stream", cfg.publicURL, video.ID)
+			video.VideoURL = &streamURL
+		} else if cfg.s3Accelerate {
+			objectURL := cfg.clientObjectURL(bucket, key)
+			video.VideoURL = &objectURL
+		}
+		return video, nil
+	}
+	presigned, err := cfg.presignClientGetObject(ctx, bucket, key, privateURLExpiry)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.VideoURL = &presigned
+	return video, nil
+}
+
+// presentVideos drops videos viewerID can't see and presents the rest,
+// loading their captions and chapters at once.
+func (cfg *apiConfig) presentVideos(ctx context.Context, videos []database.Video, viewerID uuid.UUID) ([]database.Video, error) {
+	ids := []uuid.UUID{}
+	for _, video := range videos {
+		if canView(video, viewerID) {
+			ids = append(ids, video.ID)
+		}
+	}
+	captions, err := cfg.db.GetCaptionsForVideos(ids)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := cfg.db.GetChaptersForVideos(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	presented := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		if !canView(video, viewerID) {
+			continue
+		}
+		video, err := cfg.presentVideoWith(ctx, video, captions[video.ID], chapters[video.ID])
+		if err != nil {
+			return nil, err
+		}
+		presented = append(presented, video)
+	}
+	return presented, nil
+}
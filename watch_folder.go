@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// watched files are moved into these subdirectories once ingested
+	watchFolderProcessed = "processed"
+	watchFolderFailed    = "failed"
+	// watchFolderTimeout bounds ingesting one file
+	watchFolderTimeout = 2 * time.Hour
+)
+
+// watch folders hold exports rather than uploads, so go by extension
+var watchFolderExtensions = map[string]string{
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+	".mp3": "audio/mpeg",
+	".aac": "audio/aac",
+	".ogg": "audio/ogg",
+}
+
+// watchFolderSidecar is the metadata read from a JSON file next to a
+// watched file, named like it with a .json extension. Every field is
+// optional; the title defaults to the file's name.
+type watchFolderSidecar struct {
+	Title             string   `json:"title"`
+	Description       string   `json:"description"`
+	Visibility        string   `json:"visibility"`
+	Profile           string   `json:"profile"`
+	Tags              []string `json:"tags"`
+	RetentionDays     int      `json:"retention_days"`
+	NormalizeLoudness bool     `json:"normalize_loudness"`
+}
+
+// watchedFile is what a scan saw of a file, to tell when it's done being
+// written.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchFolderScan is what the scans so far have learned about the files
+// in the watch folder.
+type watchFolderScan struct {
+	// seen is the files found by the last scan that are yet to be ingested
+	seen map[string]watchedFile
+	// stuck is the files that were ingested but couldn't be moved aside.
+	// They're left alone unless they change, so they aren't ingested again.
+	stuck map[string]watchedFile
+}
+
+// runWatchFolder scans the watch folder every interval until ctx is done,
+// ingesting the files that stopped changing since the scan before. Files
+// still being copied in are left for a later scan.
+func (cfg *apiConfig) runWatchFolder(ctx context.Context) {
+	logger := cfg.logger.With("watch_folder", cfg.watchFolder.Dir)
+	ctx = withLogger(ctx, logger)
+	scan := watchFolderScan{seen: map[string]watchedFile{}, stuck: map[string]watchedFile{}}
+	ticker := time.NewTicker(cfg.watchFolder.Interval)
+	defer ticker.Stop()
+	for {
+		cfg.scanWatchFolder(ctx, scan)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanWatchFolder ingests the files whose size and modification time
+// match what the last scan saw of them, and records the rest for the next.
+func (cfg *apiConfig) scanWatchFolder(ctx context.Context, scan watchFolderScan) {
+	logger := loggerFrom(ctx)
+	entries, err := os.ReadDir(cfg.watchFolder.Dir)
+	if err != nil {
+		logger.Error("couldn't list watch folder", "error", err)
+		return
+	}
+
+	current := map[string]watchedFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if _, ok := watchFolderExtensions[strings.ToLower(filepath.Ext(name))]; !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			logger.Error("couldn't stat watched file", "filename", name, "error", err)
+			continue
+		}
+		current[name] = watchedFile{size: info.Size(), modTime: info.ModTime()}
+	}
+	for name, file := range scan.stuck {
+		if current[name] == file {
+			delete(current, name)
+		} else {
+			delete(scan.stuck, name)
+		}
+	}
+
+	for name, file := range current {
+		if ctx.Err() != nil {
+			return
+		}
+		if previous, ok := scan.seen[name]; !ok || previous != file {
+			continue
+		}
+		delete(current, name)
+		if !cfg.ingestWatchedFile(ctx, name) {
+			scan.stuck[name] = file
+		}
+	}
+	clear(scan.seen)
+	for name, file := range current {
+		scan.seen[name] = file
+	}
+}
+
+// ingestWatchedFile creates a video for the file and ingests it, then
+// moves it and its sidecar aside, into processed or, along with a note of
+// what went wrong, into failed. It reports whether the file was moved.
+func (cfg *apiConfig) ingestWatchedFile(ctx context.Context, name string) bool {
+	logger := loggerFrom(ctx).With("filename", name)
+	ctx = withLogger(ctx, logger)
+	ctx, cancel := context.WithTimeout(ctx, watchFolderTimeout)
+	defer cancel()
+
+	started := time.Now()
+	videoID, err := cfg.ingestWatchedFileAs(ctx, name)
+	dest := watchFolderProcessed
+	if err != nil {
+		logger.Error("couldn't ingest watched file", "error", err)
+		dest = watchFolderFailed
+	} else {
+		logger.Info("ingested watched file", "video_id", videoID, "duration", time.Since(started))
+	}
+
+	sidecar := watchFolderSidecarName(name)
+	moved, moveErr := moveWatchedFile(cfg.watchFolder.Dir, dest, name)
+	if moveErr != nil {
+		logger.Error("couldn't move watched file aside, it's skipped until it changes", "error", moveErr)
+		return false
+	}
+	if _, err := moveWatchedFile(cfg.watchFolder.Dir, dest, sidecar); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("couldn't move sidecar aside", "sidecar", sidecar, "error", err)
+	}
+	if err != nil {
+		if noteErr := os.WriteFile(moved+".error.txt", []byte(watchFolderErrorMessage(err)+"\n"), 0o644); noteErr != nil {
+			logger.Warn("couldn't write error note", "error", noteErr)
+		}
+	}
+	return true
+}
+
+// ingestWatchedFileAs does the work of ingestWatchedFile, returning the
+// new video's ID. A video that couldn't be ingested is removed again.
+func (cfg *apiConfig) ingestWatchedFileAs(ctx context.Context, name string) (uuid.UUID, error) {
+	owner, err := cfg.db.GetUserByEmail(cfg.watchFolder.Owner)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't get owner: %w", err)
+	}
+	if owner.ID == uuid.Nil {
+		return uuid.Nil, fmt.Errorf("owner %s doesn't exist", cfg.watchFolder.Owner)
+	}
+
+	sidecar := watchFolderSidecar{}
+	data, err := os.ReadFile(filepath.Join(cfg.watchFolder.Dir, watchFolderSidecarName(name)))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return uuid.Nil, fmt.Errorf("couldn't read sidecar: %w", err)
+	default:
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			return uuid.Nil, fmt.Errorf("invalid sidecar: %w", err)
+		}
+	}
+
+	opts, err := cfg.resolveUploadOptions(owner.ID, uploadOptions{
+		Visibility:        sidecar.Visibility,
+		Profile:           sidecar.Profile,
+		Tags:              sidecar.Tags,
+		RetentionDays:     sidecar.RetentionDays,
+		NormalizeLoudness: sidecar.NormalizeLoudness,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	profile, err := getProcessingProfile(opts.Profile)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	profile.NormalizeLoudness = opts.NormalizeLoudness
+
+	path := filepath.Join(cfg.watchFolder.Dir, name)
+	mediaType := watchFolderExtensions[strings.ToLower(filepath.Ext(name))]
+	file, err := os.Open(path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	typeLimit, ok := cfg.uploadLimits.maxUploadSizeForType(owner.Tier, mediaType)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("file type %s isn't accepted, accepted types are %s", mediaType, cfg.uploadLimits.acceptedMediaTypes())
+	}
+	if info.Size() > typeLimit {
+		return uuid.Nil, fmt.Errorf("file is %d bytes, over the limit of %d for %s", info.Size(), typeLimit, mediaType)
+	}
+	status, err := cfg.storageQuotaStatus(owner.ID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't check storage quota: %w", err)
+	}
+	if !status.allows(info.Size()) {
+		return uuid.Nil, errors.New("file would put the owner over their storage quota")
+	}
+	sha, err := hashFile(file)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	title := strings.TrimSpace(sidecar.Title)
+	if title == "" {
+		title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	bucket, err := cfg.newVideoBucket(owner.ID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't pick a bucket: %w", err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:         title,
+		Description:   sidecar.Description,
+		UserID:        owner.ID,
+		Visibility:    opts.Visibility,
+		StorageBucket: bucket,
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't create video: %w", err)
+	}
+	videoID := video.ID
+	video, err = cfg.applyUploadOptions(video, opts)
+	if err == nil {
+		video, err = cfg.ingestVideo(ctx, video, ingestSource{
+			Path:      path,
+			MediaType: mediaType,
+			SHA256:    sha,
+			Size:      info.Size(),
+			Filename:  cleanFilename(name),
+		}, profile)
+	}
+	if err != nil {
+		// don't leave an empty draft behind for a file that didn't make it
+		if delErr := cfg.db.DeleteVideo(videoID); delErr != nil {
+			loggerFrom(ctx).Error("couldn't remove draft video", "video_id", videoID, "error", delErr)
+		}
+		return uuid.Nil, err
+	}
+
+	// no one made this upload, so the system is the actor
+	err = cfg.db.CreateAuditEntry(database.CreateAuditEntryParams{
+		UserID:    owner.ID,
+		Action:    database.AuditVideoUploaded,
+		VideoID:   video.ID,
+		ObjectKey: cfg.videoObjectKey(video),
+		Detail:    "from watch folder",
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("couldn't audit watch folder upload", "video_id", videoID, "error", err)
+	}
+	return videoID, nil
+}
+
+// checkWatchFolder makes sure the watch folder is a directory and the
+// user its files are ingested for exists.
+func (cfg *apiConfig) checkWatchFolder() error {
+	info, err := os.Stat(cfg.watchFolder.Dir)
+	if err != nil {
+		return fmt.Errorf("can't use watch folder: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("watch folder %s isn't a directory", cfg.watchFolder.Dir)
+	}
+	owner, err := cfg.db.GetUserByEmail(cfg.watchFolder.Owner)
+	if err != nil {
+		return fmt.Errorf("couldn't look up watch folder owner: %w", err)
+	}
+	if owner.ID == uuid.Nil {
+		return fmt.Errorf("watch folder owner %s doesn't exist", cfg.watchFolder.Owner)
+	}
+	return nil
+}
+
+// watchFolderSidecarName is the name of the sidecar for the file name.
+func watchFolderSidecarName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+}
+
+// watchFolderErrorMessage is what's written in the note next to a failed
+// file, the client-facing message for pipeline errors.
+func watchFolderErrorMessage(err error) string {
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		return ingestErr.Message
+	}
+	return err.Error()
+}
+
+// moveWatchedFile moves name from dir into its subdirectory sub, adding a
+// number to the name if one like it is already there, and returns where it
+// went.
+func moveWatchedFile(dir, sub, name string) (string, error) {
+	if _, err := os.Lstat(filepath.Join(dir, name)); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(name)
+	dest := filepath.Join(dir, sub, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dest); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		dest = filepath.Join(dir, sub, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+	return dest, os.Rename(filepath.Join(dir, name), dest)
+}